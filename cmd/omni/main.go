@@ -55,7 +55,11 @@ func main() {
 	}
 
 	// 4. Output
-	watContent := c.GenerateWAT()
+	watContent, err := c.GenerateWAT()
+	if err != nil {
+		fmt.Printf("Compiler error: %v\n", err)
+		os.Exit(1)
+	}
 	
 	outputDir := "output"
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
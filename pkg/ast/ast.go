@@ -2,6 +2,8 @@ package ast
 
 import (
 	"bytes"
+	"reflect"
+	"strings"
 	"omniScript/pkg/token"
 )
 
@@ -64,6 +66,7 @@ type EnumStatement struct {
 	Token   token.Token // token.ENUM
 	Name    *Identifier
 	Members []*EnumMember
+	Doc     string // Preceding /** */ comment
 }
 
 func (es *EnumStatement) statementNode()       {}
@@ -88,6 +91,7 @@ type InterfaceStatement struct {
 	Token   token.Token // token.INTERFACE
 	Name    *Identifier
 	Methods []*MethodSignature
+	Doc     string // Preceding /** */ comment
 }
 
 func (is *InterfaceStatement) statementNode()       {}
@@ -124,6 +128,26 @@ func (il *IntegerLiteral) expressionNode()      {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
 
+// FloatLiteral 浮点数 (number, f64)
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (fl *FloatLiteral) expressionNode()      {}
+func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FloatLiteral) String() string       { return fl.Token.Literal }
+
+// BigIntLiteral 64 位整数 (123n)
+type BigIntLiteral struct {
+	Token token.Token
+	Value int64
+}
+
+func (bl *BigIntLiteral) expressionNode()      {}
+func (bl *BigIntLiteral) TokenLiteral() string { return bl.Token.Literal }
+func (bl *BigIntLiteral) String() string       { return bl.Token.Literal }
+
 // StringLiteral 字符串
 type StringLiteral struct {
 	Token token.Token
@@ -134,9 +158,31 @@ func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
 func (sl *StringLiteral) String() string       { return "\"" + sl.Token.Literal + "\"" }
 
+// TemplateLiteral 模板字符串 `a ${b} c`；Quasis 比 Expressions 多一个
+type TemplateLiteral struct {
+	Token       token.Token // token.TEMPLATE
+	Quasis      []string    // String parts with escapes already processed
+	Expressions []Expression
+}
+
+func (tl *TemplateLiteral) expressionNode()      {}
+func (tl *TemplateLiteral) TokenLiteral() string { return tl.Token.Literal }
+func (tl *TemplateLiteral) String() string       { return "`" + tl.Token.Literal + "`" }
+
+// TaggedTemplateExpression 带标签的模板 tag`a ${b}`
+type TaggedTemplateExpression struct {
+	Token token.Token // token.TEMPLATE
+	Tag   Expression
+	Quasi *TemplateLiteral
+}
+
+func (tt *TaggedTemplateExpression) expressionNode()      {}
+func (tt *TaggedTemplateExpression) TokenLiteral() string { return tt.Token.Literal }
+func (tt *TaggedTemplateExpression) String() string       { return tt.Tag.String() + tt.Quasi.String() }
+
 // PrefixExpression 前缀表达式
 type PrefixExpression struct {
-	Token    token.Token // !, -
+	Token    token.Token // !, -, ++, --
 	Operator string
 	Right    Expression
 }
@@ -152,6 +198,24 @@ func (pe *PrefixExpression) String() string {
 	return out.String()
 }
 
+// PostfixExpression 后缀表达式 (i++, i--)
+type PostfixExpression struct {
+	Token    token.Token // ++, --
+	Left     Expression
+	Operator string
+}
+
+func (pe *PostfixExpression) expressionNode()      {}
+func (pe *PostfixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PostfixExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("(")
+	out.WriteString(pe.Left.String())
+	out.WriteString(pe.Operator)
+	out.WriteString(")")
+	return out.String()
+}
+
 // InfixExpression 中缀表达式
 type InfixExpression struct {
 	Token    token.Token // +, -, *, /
@@ -182,19 +246,56 @@ func (b *Boolean) expressionNode()      {}
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
 func (b *Boolean) String() string       { return b.Token.Literal }
 
+// NullLiteral null (0 指针)
+type NullLiteral struct {
+	Token token.Token
+}
+
+func (nl *NullLiteral) expressionNode()      {}
+func (nl *NullLiteral) TokenLiteral() string { return nl.Token.Literal }
+func (nl *NullLiteral) String() string       { return "null" }
+
+// UndefinedLiteral undefined (静态哨兵对象)
+type UndefinedLiteral struct {
+	Token token.Token
+}
+
+func (ul *UndefinedLiteral) expressionNode()      {}
+func (ul *UndefinedLiteral) TokenLiteral() string { return ul.Token.Literal }
+func (ul *UndefinedLiteral) String() string       { return "undefined" }
+
+// ConditionalExpression cond ? a : b
+type ConditionalExpression struct {
+	Token       token.Token // '?'
+	Condition   Expression
+	Consequence Expression
+	Alternative Expression
+}
+
+func (ce *ConditionalExpression) expressionNode()      {}
+func (ce *ConditionalExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *ConditionalExpression) String() string {
+	return "(" + ce.Condition.String() + " ? " + ce.Consequence.String() + " : " + ce.Alternative.String() + ")"
+}
+
 // LetStatement Let语句
 type LetStatement struct {
-	Token token.Token // token.LET
-	Name  *Identifier
-	Value Expression
-	Type  string // Added for type annotation
+	Token   token.Token // token.LET
+	Name    *Identifier
+	Pattern Expression // 解构声明 (ObjectPattern / ArrayPattern)，此时 Name 为 nil
+	Value   Expression
+	Type    string // Added for type annotation
 }
 
 func (ls *LetStatement) statementNode()       {}
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
-	out.WriteString(ls.TokenLiteral() + " " + ls.Name.String())
+	if ls.Pattern != nil {
+		out.WriteString(ls.TokenLiteral() + " " + ls.Pattern.String())
+	} else {
+		out.WriteString(ls.TokenLiteral() + " " + ls.Name.String())
+	}
 	if ls.Type != "" {
 		out.WriteString(": " + ls.Type)
 	}
@@ -280,30 +381,114 @@ func (ie *IfExpression) String() string {
 
 // FieldDefinition represents "name: type"
 type FieldDefinition struct {
-	Token token.Token // Added
-	Name  *Identifier
-	Type  string
-	Value Expression
+	Token   token.Token // Added
+	Name    *Identifier
+	Type    string
+	Value   Expression
+	Pattern Expression // 解构参数：Name 为隐藏的参数名，函数体开头展开为 let Pattern = Name
+	Rest    bool       // 剩余参数 ...args，多余的实参收集到数组中
+	Optional bool      // 可选参数 name?: T，缺省时为 undefined；参数的默认值存放在 Value 中
+	Access   string     // 字段 / 构造函数参数属性的访问修饰符: "public"、"private"、"protected"，未写时为空
+	Readonly bool       // readonly 字段只能在声明它的类的构造函数中赋值
+	Static   bool       // static 字段属于类本身，存放在模块级的全局变量中
 }
 
 func (fd *FieldDefinition) String() string {
-	return fd.Name.String() + ": " + fd.Type
+	out := fd.Name.String()
+	if fd.Rest {
+		out = "..." + out
+	}
+	if fd.Readonly {
+		out = "readonly " + out
+	}
+	if fd.Static {
+		out = "static " + out
+	}
+	if fd.Access != "" {
+		out = fd.Access + " " + out
+	}
+	if fd.Optional {
+		out += "?"
+	}
+	out += ": " + fd.Type
+	if fd.Value != nil {
+		out += " = " + fd.Value.String()
+	}
+	return out
 }
 
+// SymbolIteratorMethod 是类的 [Symbol.iterator]() 方法在 AST 和方法表中的名字
+const SymbolIteratorMethod = "@@iterator"
+
 // FunctionLiteral 函数字面量
 type FunctionLiteral struct {
 	Token      token.Token // 'fn'
 	Parameters []*FieldDefinition // Parameters are fields (name: type)
 	Body       *BlockStatement
-	Name       string // Optional name
-	ReturnType string // Optional return type
+	Name       string           // Optional name
+	ReturnType string           // Optional return type
+	IsArrow    bool             // (x) => x * 2
+	Async      bool             // async function / async (x) => ...
+	Generator  bool             // function* / *method()
+	Access     string           // 方法的访问修饰符: "public"、"private"、"protected"，未写时为空
+	Static     bool             // static 方法没有 this，通过类名调用
+	Accessor   string           // 访问器 get name() / set name(v) 为 "get" / "set"
+	Abstract   bool             // abstract 方法只有签名，Body 为空块，由子类实现
+	TypeParams []*TypeParameter // 泛型函数 function id<T>(x: T): T
+	Doc        string           // Preceding /** */ comment
+}
+
+// TypeParameter 泛型类型参数 T / T extends Constraint
+type TypeParameter struct {
+	Name       string
+	Constraint string // Optional extends constraint
+}
+
+func (tp *TypeParameter) String() string {
+	if tp.Constraint != "" {
+		return tp.Name + " extends " + tp.Constraint
+	}
+	return tp.Name
+}
+
+// typeParamsString 返回类型参数列表的源码形式，例如 "<T, U extends Comparable>"
+func typeParamsString(params []*TypeParameter) string {
+	if len(params) == 0 {
+		return ""
+	}
+	names := make([]string, len(params))
+	for i, tp := range params {
+		names[i] = tp.String()
+	}
+	return "<" + strings.Join(names, ", ") + ">"
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
-	out.WriteString(fl.TokenLiteral())
+	if fl.Access != "" {
+		out.WriteString(fl.Access + " ")
+	}
+	if fl.Static {
+		out.WriteString("static ")
+	}
+	if fl.Abstract {
+		out.WriteString("abstract ")
+	}
+	if fl.Accessor != "" {
+		out.WriteString(fl.Accessor + " ")
+	}
+	if fl.Async {
+		out.WriteString("async ")
+	}
+	if !fl.IsArrow {
+		out.WriteString(fl.TokenLiteral())
+	}
+	if fl.Generator {
+		out.WriteString("*")
+	}
+	out.WriteString(typeParamsString(fl.TypeParams))
 	out.WriteString("(")
 	for i, p := range fl.Parameters {
 		out.WriteString(p.String())
@@ -312,6 +497,9 @@ func (fl *FunctionLiteral) String() string {
 		}
 	}
 	out.WriteString(") ")
+	if fl.IsArrow {
+		out.WriteString("=> ")
+	}
 	out.WriteString(fl.Body.String())
 	return out.String()
 }
@@ -321,6 +509,8 @@ type CallExpression struct {
 	Token     token.Token // '('
 	Function  Expression  // Identifier or FunctionLiteral
 	Arguments []Expression
+	Optional  bool     // fn?.(args)
+	TypeArgs  []string // pick<string>(...)
 }
 
 func (ce *CallExpression) expressionNode()      {}
@@ -328,6 +518,12 @@ func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString(ce.Function.String())
+	if len(ce.TypeArgs) > 0 {
+		out.WriteString("<" + strings.Join(ce.TypeArgs, ", ") + ">")
+	}
+	if ce.Optional {
+		out.WriteString("?.")
+	}
 	out.WriteString("(")
 	for i, a := range ce.Arguments {
 		out.WriteString(a.String())
@@ -362,9 +558,10 @@ func (al *ArrayLiteral) String() string {
 
 // IndexExpression 索引表达式
 type IndexExpression struct {
-	Token token.Token // '['
-	Left  Expression
-	Index Expression
+	Token    token.Token // '['
+	Left     Expression
+	Index    Expression
+	Optional bool // arr?.[i]
 }
 
 func (ie *IndexExpression) expressionNode()      {}
@@ -373,6 +570,9 @@ func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
 	out.WriteString(ie.Left.String())
+	if ie.Optional {
+		out.WriteString("?.")
+	}
 	out.WriteString("[")
 	out.WriteString(ie.Index.String())
 	out.WriteString("])")
@@ -383,6 +583,7 @@ func (ie *IndexExpression) String() string {
 type MapLiteral struct {
 	Token token.Token // '{'
 	Pairs map[Expression]Expression
+	Keys  []Expression // 源码顺序的键；展开项 (...m) 以 *SpreadElement 出现在这里
 }
 
 func (ml *MapLiteral) expressionNode()      {}
@@ -390,10 +591,12 @@ func (ml *MapLiteral) TokenLiteral() string { return ml.Token.Literal }
 func (ml *MapLiteral) String() string {
 	var out bytes.Buffer
 	out.WriteString("{")
-	for key, value := range ml.Pairs {
+	for _, key := range ml.Keys {
 		out.WriteString(key.String())
-		out.WriteString(":")
-		out.WriteString(value.String())
+		if value, ok := ml.Pairs[key]; ok {
+			out.WriteString(":")
+			out.WriteString(value.String())
+		}
 		out.WriteString(",")
 	}
 	out.WriteString("}")
@@ -418,6 +621,35 @@ func (ws *WhileStatement) String() string {
 	return out.String()
 }
 
+// DoWhileStatement do { ... } while (cond)，循环体至少执行一次
+type DoWhileStatement struct {
+	Token     token.Token // token.DO
+	Body      *BlockStatement
+	Condition Expression
+}
+
+func (ds *DoWhileStatement) statementNode()       {}
+func (ds *DoWhileStatement) TokenLiteral() string { return ds.Token.Literal }
+func (ds *DoWhileStatement) String() string {
+	return "do " + ds.Body.String() + " while (" + ds.Condition.String() + ")"
+}
+
+// SequenceExpression 逗号表达式 a, b, c：依次求值，结果为最后一个表达式 (用于 for 的初始化与更新部分)
+type SequenceExpression struct {
+	Token       token.Token // ','
+	Expressions []Expression
+}
+
+func (se *SequenceExpression) expressionNode()      {}
+func (se *SequenceExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SequenceExpression) String() string {
+	var parts []string
+	for _, e := range se.Expressions {
+		parts = append(parts, e.String())
+	}
+	return strings.Join(parts, ", ")
+}
+
 // ForStatement For语句
 type ForStatement struct {
 	Token     token.Token // token.FOR
@@ -448,6 +680,32 @@ func (fs *ForStatement) String() string {
 	return out.String()
 }
 
+// ForOfStatement for (const x of iterable) / for (const k in map)
+type ForOfStatement struct {
+	Token       token.Token   // token.FOR
+	Declaration *LetStatement // 循环变量 (Name 或 Pattern)，没有 Value
+	Iterable    Expression
+	Body        *BlockStatement
+	In          bool // for...in: Map 的键 / 数组的下标
+}
+
+func (fs *ForOfStatement) statementNode()       {}
+func (fs *ForOfStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForOfStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString("for (")
+	out.WriteString(strings.TrimSuffix(fs.Declaration.String(), ";"))
+	if fs.In {
+		out.WriteString(" in ")
+	} else {
+		out.WriteString(" of ")
+	}
+	out.WriteString(fs.Iterable.String())
+	out.WriteString(") ")
+	out.WriteString(fs.Body.String())
+	return out.String()
+}
+
 // DeclareStatement Declare语句
 type DeclareStatement struct {
 	Token      token.Token // token.DECLARE
@@ -469,14 +727,21 @@ type ClassStatement struct {
 	SuperClass *Identifier // Optional extends
 	Implements []*Identifier // Optional implements
 	Parent     *Identifier // For Parser compatibility
+	Abstract   bool   // abstract class: cannot be instantiated with new
+	TypeParams []*TypeParameter   // 泛型类 class Box<T>
+	Doc        string // Preceding /** */ comment
 }
 
 func (cs *ClassStatement) statementNode()       {}
 func (cs *ClassStatement) TokenLiteral() string { return cs.Token.Literal }
 func (cs *ClassStatement) String() string {
 	var out bytes.Buffer
+	if cs.Abstract {
+		out.WriteString("abstract ")
+	}
 	out.WriteString("class ")
 	out.WriteString(cs.Name.String())
+	out.WriteString(typeParamsString(cs.TypeParams))
 	out.WriteString(" { ... }")
 	return out.String()
 }
@@ -485,12 +750,16 @@ func (cs *ClassStatement) String() string {
 type NewExpression struct {
 	Token     token.Token // token.NEW
 	Class     *Identifier
+	TypeArgs  []string // new Promise<int>(...)
 	Arguments []Expression
 }
 
 func (ne *NewExpression) expressionNode()      {}
 func (ne *NewExpression) TokenLiteral() string { return ne.Token.Literal }
 func (ne *NewExpression) String() string {
+	if len(ne.TypeArgs) > 0 {
+		return "new " + ne.Class.String() + "<" + strings.Join(ne.TypeArgs, ", ") + ">"
+	}
 	return "new " + ne.Class.String()
 }
 
@@ -507,22 +776,27 @@ func (te *ThisExpression) String() string {
 
 // MemberExpression represents obj.prop
 type MemberExpression struct {
-	Token    token.Token // token.DOT
+	Token    token.Token // token.DOT or token.OPTIONAL_CHAIN
 	Object   Expression
 	Property *Identifier
+	Optional bool // obj?.prop
 }
 
 func (me *MemberExpression) expressionNode()      {}
 func (me *MemberExpression) TokenLiteral() string { return me.Token.Literal }
 func (me *MemberExpression) String() string {
+	if me.Optional {
+		return me.Object.String() + "?." + me.Property.String()
+	}
 	return me.Object.String() + "." + me.Property.String()
 }
 
 // AssignmentExpression represents left = right
 type AssignmentExpression struct {
-	Token token.Token // token.ASSIGN
-	Left  Expression
-	Value Expression
+	Token    token.Token // token.ASSIGN
+	Left     Expression
+	Value    Expression
+	Compound bool // a op= b：Value 为 a op b，其中的 a 与 Left 是同一个节点
 }
 
 func (ae *AssignmentExpression) expressionNode()      {}
@@ -531,6 +805,113 @@ func (ae *AssignmentExpression) String() string {
 	return ae.Left.String() + " = " + ae.Value.String()
 }
 
+// ObjectPattern 对象解构模式 {a, b: renamed, c = 1}
+type ObjectPattern struct {
+	Token      token.Token // '{'
+	Properties []*PatternProperty
+}
+
+// PatternProperty 对象模式中的一项：Key 为属性名，Target 为绑定目标 (标识符或嵌套模式)
+type PatternProperty struct {
+	Key     *Identifier
+	Target  Expression
+	Default Expression
+}
+
+func (op *ObjectPattern) expressionNode()      {}
+func (op *ObjectPattern) TokenLiteral() string { return op.Token.Literal }
+func (op *ObjectPattern) String() string {
+	var props []string
+	for _, prop := range op.Properties {
+		s := prop.Key.String()
+		if ident, ok := prop.Target.(*Identifier); !ok || ident.Value != prop.Key.Value {
+			s += ": " + prop.Target.String()
+		}
+		if prop.Default != nil {
+			s += " = " + prop.Default.String()
+		}
+		props = append(props, s)
+	}
+	return "{" + strings.Join(props, ", ") + "}"
+}
+
+// ArrayPattern 数组解构模式 [x, , y = 2, ...rest]；跳过的位置为 nil
+type ArrayPattern struct {
+	Token    token.Token // '['
+	Elements []*PatternElement
+	Rest     Expression // ...rest
+}
+
+// PatternElement 数组模式中的一个位置
+type PatternElement struct {
+	Target  Expression
+	Default Expression
+}
+
+func (ap *ArrayPattern) expressionNode()      {}
+func (ap *ArrayPattern) TokenLiteral() string { return ap.Token.Literal }
+func (ap *ArrayPattern) String() string {
+	var elems []string
+	for _, el := range ap.Elements {
+		switch {
+		case el == nil:
+			elems = append(elems, "")
+		case el.Default != nil:
+			elems = append(elems, el.Target.String()+" = "+el.Default.String())
+		default:
+			elems = append(elems, el.Target.String())
+		}
+	}
+	if ap.Rest != nil {
+		elems = append(elems, "..."+ap.Rest.String())
+	}
+	return "[" + strings.Join(elems, ", ") + "]"
+}
+
+// SpreadElement 展开表达式 ...expr，用于数组字面量、Map 字面量和调用实参
+type SpreadElement struct {
+	Token    token.Token // '...'
+	Argument Expression
+}
+
+func (se *SpreadElement) expressionNode()      {}
+func (se *SpreadElement) TokenLiteral() string { return se.Token.Literal }
+func (se *SpreadElement) String() string {
+	return "..." + se.Argument.String()
+}
+
+// AwaitExpression await expr：挂起所在的 async 函数，直到 Promise 完成
+type AwaitExpression struct {
+	Token    token.Token // token.AWAIT
+	Argument Expression
+}
+
+func (ae *AwaitExpression) expressionNode()      {}
+func (ae *AwaitExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AwaitExpression) String() string {
+	return "await " + ae.Argument.String()
+}
+
+// YieldExpression yield expr / yield* iterable：挂起所在的生成器，把值交给 next() 的调用者
+type YieldExpression struct {
+	Token    token.Token // token.YIELD
+	Argument Expression  // nil for a bare yield
+	Delegate bool        // yield*
+}
+
+func (ye *YieldExpression) expressionNode()      {}
+func (ye *YieldExpression) TokenLiteral() string { return ye.Token.Literal }
+func (ye *YieldExpression) String() string {
+	out := "yield"
+	if ye.Delegate {
+		out += "*"
+	}
+	if ye.Argument != nil {
+		out += " " + ye.Argument.String()
+	}
+	return out
+}
+
 // SuperExpression represents 'super'
 type SuperExpression struct {
 	Token token.Token // token.SUPER
@@ -669,3 +1050,304 @@ func (ts *ThrowStatement) TokenLiteral() string { return ts.Token.Literal }
 func (ts *ThrowStatement) String() string {
 	return "throw " + ts.Value.String() + ";"
 }
+
+// BreakStatement represents break; or break label;
+type BreakStatement struct {
+	Token token.Token // token.BREAK
+	Label string
+}
+
+func (bs *BreakStatement) statementNode()       {}
+func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BreakStatement) String() string {
+	if bs.Label != "" {
+		return "break " + bs.Label + ";"
+	}
+	return "break;"
+}
+
+// ContinueStatement represents continue; or continue label;
+type ContinueStatement struct {
+	Token token.Token // token.CONTINUE
+	Label string
+}
+
+func (cs *ContinueStatement) statementNode()       {}
+func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ContinueStatement) String() string {
+	if cs.Label != "" {
+		return "continue " + cs.Label + ";"
+	}
+	return "continue;"
+}
+
+// LabeledStatement represents label: statement
+type LabeledStatement struct {
+	Token     token.Token // the label identifier
+	Label     string
+	Statement Statement
+}
+
+func (ls *LabeledStatement) statementNode()       {}
+func (ls *LabeledStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LabeledStatement) String() string {
+	return ls.Label + ": " + ls.Statement.String()
+}
+
+// SwitchStatement represents switch (expr) { case a: ... default: ... }
+type SwitchStatement struct {
+	Token        token.Token // token.SWITCH
+	Discriminant Expression
+	Cases        []*SwitchCase
+}
+
+// SwitchCase is one case clause; Test is nil for default
+type SwitchCase struct {
+	Token token.Token // token.CASE or token.DEFAULT
+	Test  Expression
+	Body  *BlockStatement
+}
+
+func (ss *SwitchStatement) statementNode()       {}
+func (ss *SwitchStatement) TokenLiteral() string { return ss.Token.Literal }
+func (ss *SwitchStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString("switch (")
+	out.WriteString(ss.Discriminant.String())
+	out.WriteString(") {")
+	for _, sc := range ss.Cases {
+		if sc.Test != nil {
+			out.WriteString(" case " + sc.Test.String() + ": ")
+		} else {
+			out.WriteString(" default: ")
+		}
+		for _, s := range sc.Body.Statements {
+			out.WriteString(s.String())
+		}
+	}
+	out.WriteString(" }")
+	return out.String()
+}
+
+// Inspect 深度优先遍历 AST。f 返回 false 时不再进入该节点的子节点
+func Inspect(node Node, f func(Node) bool) {
+	if node == nil || reflect.ValueOf(node).IsNil() || !f(node) {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		for _, s := range n.Statements {
+			Inspect(s, f)
+		}
+	case *EnumStatement:
+		for _, m := range n.Members {
+			if m.Value != nil {
+				Inspect(m.Value, f)
+			}
+		}
+	case *PrefixExpression:
+		Inspect(n.Right, f)
+	case *PostfixExpression:
+		Inspect(n.Left, f)
+	case *InfixExpression:
+		Inspect(n.Left, f)
+		Inspect(n.Right, f)
+	case *ConditionalExpression:
+		Inspect(n.Condition, f)
+		Inspect(n.Consequence, f)
+		Inspect(n.Alternative, f)
+	case *LetStatement:
+		Inspect(n.Name, f)
+		Inspect(n.Pattern, f)
+		Inspect(n.Value, f)
+	case *ObjectPattern:
+		// Keys are property names, not variable references
+		for _, prop := range n.Properties {
+			Inspect(prop.Target, f)
+			Inspect(prop.Default, f)
+		}
+	case *ArrayPattern:
+		for _, el := range n.Elements {
+			if el != nil {
+				Inspect(el.Target, f)
+				Inspect(el.Default, f)
+			}
+		}
+		Inspect(n.Rest, f)
+	case *ReturnStatement:
+		Inspect(n.ReturnValue, f)
+	case *ExpressionStatement:
+		Inspect(n.Expression, f)
+	case *BlockStatement:
+		for _, s := range n.Statements {
+			Inspect(s, f)
+		}
+	case *IfExpression:
+		Inspect(n.Condition, f)
+		Inspect(n.Consequence, f)
+		Inspect(n.Alternative, f)
+	case *FunctionLiteral:
+		for _, p := range n.Parameters {
+			Inspect(p.Name, f)
+			Inspect(p.Value, f)
+		}
+		Inspect(n.Body, f)
+	case *CallExpression:
+		Inspect(n.Function, f)
+		for _, a := range n.Arguments {
+			Inspect(a, f)
+		}
+	case *ArrayLiteral:
+		for _, e := range n.Elements {
+			Inspect(e, f)
+		}
+	case *IndexExpression:
+		Inspect(n.Left, f)
+		Inspect(n.Index, f)
+	case *MapLiteral:
+		for _, k := range n.Keys {
+			Inspect(k, f)
+			Inspect(n.Pairs[k], f)
+		}
+	case *SpreadElement:
+		Inspect(n.Argument, f)
+	case *AwaitExpression:
+		Inspect(n.Argument, f)
+	case *YieldExpression:
+		Inspect(n.Argument, f)
+	case *WhileStatement:
+		Inspect(n.Condition, f)
+		Inspect(n.Body, f)
+	case *DoWhileStatement:
+		Inspect(n.Body, f)
+		Inspect(n.Condition, f)
+	case *SequenceExpression:
+		for _, e := range n.Expressions {
+			Inspect(e, f)
+		}
+	case *ForStatement:
+		Inspect(n.Init, f)
+		Inspect(n.Condition, f)
+		Inspect(n.Update, f)
+		Inspect(n.Body, f)
+	case *ForOfStatement:
+		Inspect(n.Declaration, f)
+		Inspect(n.Iterable, f)
+		Inspect(n.Body, f)
+	case *DeclareStatement:
+		Inspect(n.Statement, f)
+	case *ClassStatement:
+		for _, fd := range n.Fields {
+			Inspect(fd.Value, f)
+		}
+		for _, m := range n.Methods {
+			Inspect(m, f)
+		}
+	case *NewExpression:
+		Inspect(n.Class, f)
+		for _, a := range n.Arguments {
+			Inspect(a, f)
+		}
+	case *MemberExpression:
+		Inspect(n.Object, f)
+		Inspect(n.Property, f)
+	case *AssignmentExpression:
+		Inspect(n.Left, f)
+		Inspect(n.Value, f)
+	case *SpawnStatement:
+		Inspect(n.Call, f)
+	case *ExportStatement:
+		Inspect(n.Statement, f)
+	case *TypeAliasStatement:
+		Inspect(n.Name, f)
+	case *TryStatement:
+		Inspect(n.Body, f)
+		Inspect(n.Catch, f)
+		Inspect(n.Finally, f)
+	case *ThrowStatement:
+		Inspect(n.Value, f)
+	case *LabeledStatement:
+		Inspect(n.Statement, f)
+	case *TemplateLiteral:
+		for _, e := range n.Expressions {
+			Inspect(e, f)
+		}
+	case *TaggedTemplateExpression:
+		Inspect(n.Tag, f)
+		Inspect(n.Quasi, f)
+	case *SwitchStatement:
+		Inspect(n.Discriminant, f)
+		for _, sc := range n.Cases {
+			Inspect(sc.Test, f)
+			Inspect(sc.Body, f)
+		}
+	}
+}
+
+// Clone 深拷贝 AST：泛型的每个实例得到自己的一份声明，替换其中的类型、被编译器改写都不会影响其他实例
+func Clone(node Node) Node {
+	if node == nil || reflect.ValueOf(node).IsNil() {
+		return node
+	}
+	return cloneValue(reflect.ValueOf(node), make(map[clonedPointer]reflect.Value)).Interface().(Node)
+}
+
+// clonedPointer 标识已拷贝的指针，同一节点被引用多次时拷贝后仍然共享
+type clonedPointer struct {
+	addr uintptr
+	typ  reflect.Type
+}
+
+func cloneValue(v reflect.Value, seen map[clonedPointer]reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		key := clonedPointer{v.Pointer(), v.Type()}
+		if copied, ok := seen[key]; ok {
+			return copied
+		}
+		copied := reflect.New(v.Elem().Type())
+		seen[key] = copied
+		copied.Elem().Set(cloneValue(v.Elem(), seen))
+		return copied
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		copied := reflect.New(v.Type()).Elem()
+		copied.Set(cloneValue(v.Elem(), seen))
+		return copied
+	case reflect.Struct:
+		copied := reflect.New(v.Type()).Elem()
+		copied.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if copied.Field(i).CanSet() {
+				copied.Field(i).Set(cloneValue(v.Field(i), seen))
+			}
+		}
+		return copied
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		copied := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			copied.Index(i).Set(cloneValue(v.Index(i), seen))
+		}
+		return copied
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		copied := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			copied.SetMapIndex(cloneValue(iter.Key(), seen), cloneValue(iter.Value(), seen))
+		}
+		return copied
+	}
+	return v
+}
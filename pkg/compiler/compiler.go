@@ -3,41 +3,71 @@ package compiler
 import (
 	"bytes"
 	"fmt"
+	"math"
+	"sort"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 	"os"
 	"path/filepath"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
 	"omniScript/pkg/ast"
 	"omniScript/pkg/lexer"
 	"omniScript/pkg/parser"
+	"omniScript/pkg/token"
 )
 
 type DataType string
 
 const (
-	TypeInt     DataType = "int"
-	TypeString  DataType = "string"
-	TypeVoid    DataType = "void"
-	TypeBool    DataType = "bool"
-	TypeArray   DataType = "array"
-	TypeMap     DataType = "map"
-	TypeHost    DataType = "host"
-	TypeUnion   DataType = "union"
-	TypeUnknown DataType = "unknown"
+	TypeInt       DataType = "int"
+	TypeString    DataType = "string"
+	TypeVoid      DataType = "void"
+	TypeBool      DataType = "bool"
+	TypeFloat     DataType = "float"  // number (f64)
+	TypeBigInt    DataType = "bigint" // 123n (i64)
+	TypeArray     DataType = "array"
+	TypeMap       DataType = "map"
+	TypeHost      DataType = "host"
+	TypeUnion     DataType = "union"
+	TypeFunc      DataType = "function"  // Closure object
+	TypeNull      DataType = "null"      // 空指针 0
+	TypeUndefined DataType = "undefined" // 哨兵指针 undefinedPtr
+	TypeUnknown   DataType = "unknown"
 )
 
 const (
-	TypeID_Unknown = 0
-	TypeID_Int     = 1
-	TypeID_String  = 2
-	TypeID_Bool    = 3
-	TypeID_Array   = 4
-	TypeID_Map     = 5
-	TypeID_Host    = 6
-	TypeID_Union   = 7
+	TypeID_Unknown       = 0
+	TypeID_Int           = 1
+	TypeID_String        = 2
+	TypeID_Bool          = 3
+	TypeID_Array         = 4
+	TypeID_Map           = 5
+	TypeID_Host          = 6
+	TypeID_Union         = 7
+	TypeID_Float         = 8  // Boxed f64 (leaf for GC)
+	TypeID_Closure       = 9  // Closure object [table index, env] (GC traces env)
+	TypeID_Undefined     = 10 // undefined sentinel (static, see undefinedPtr)
+	TypeID_Null          = 11 // Reported by $typeof for the null pointer
+	TypeID_Promise       = 12 // Promise [state, reactions, value] (GC traces reactions)
+	TypeID_IterResult    = 13 // IteratorResult [value, done] with a non-reference value (leaf for GC)
+	TypeID_IterResultRef = 14 // IteratorResult whose value is a reference (GC traces it)
 )
 
+// null 是空指针 0，undefined 是数据段中的静态哨兵对象 undefinedPtr (类型头位于 undefinedPtr-4)
+// 两者的内容分别是字符串 "null" 和 "undefined"，因此可以为空的字符串直接打印也与 JS 一致
+// 字符串常量从哨兵之后开始分配
+const undefinedPtr = 12
+
+// 静态数据 (字符串常量、父类表) 必须在 staticDataLimit 之前结束：宿主把堆指针存在 1020，影子栈从 1024 开始
+const staticDataLimit = 1020
+
+// firstClassTypeID 是第一个类的 TypeID，更小的都是内置类型 (见 TypeID_*)
+const firstClassTypeID = 15
+
 
 
 const stdLibWAT = `
@@ -500,7 +530,7 @@ const stdLibWAT = `
   local.set $ptr
   
   ;; Initialize header
-  ;; Offset 0: next_allocated (dummy 0 for now)
+  ;; Offset 0: vtable base of class instances (set by new, 0 otherwise)
   local.get $ptr
   i32.const 0
   i32.store
@@ -550,9 +580,17 @@ const stdLibWAT = `
 )
 
 (func $unbox_value (param $ptr i32) (result i32)
-  ;; Simply return the value at ptr
+  ;; Return the value at ptr; class instances (TypeID >= 15) are not boxed
   ;; Caller must ensure type check
   local.get $ptr
+  call $get_type_id
+  i32.const 15
+  i32.ge_u
+  if
+    local.get $ptr
+    return
+  end
+  local.get $ptr
   i32.load
 )
 
@@ -885,6 +923,13 @@ const stdLibWAT = `
   ;; This function implements runtime check for Union types (boxed).
   ;; Input: pointer to boxed value (TypeUnion)
   ;; Output: TypeID (i32)
+  ;; null (0) has no header
+  local.get $val
+  i32.eqz
+  if
+    i32.const 11
+    return
+  end
   local.get $val
   call $get_type_id
 )
@@ -1629,6 +1674,34 @@ const stdLibExtraWAT = `
   i32.load8_u
 )
 
+(func $utf8_next (param $str i32) (param $pos i32) (result i32)
+  ;; Byte offset of the character after the one starting at pos (for...of over strings)
+  (local $b i32)
+  local.get $str
+  local.get $pos
+  i32.add
+  i32.load8_u
+  local.set $b
+  local.get $pos
+  i32.const 4
+  i32.const 3
+  i32.const 2
+  i32.const 1
+  local.get $b
+  i32.const 0xC0
+  i32.ge_u
+  select
+  local.get $b
+  i32.const 0xE0
+  i32.ge_u
+  select
+  local.get $b
+  i32.const 0xF0
+  i32.ge_u
+  select
+  i32.add
+)
+
 
 
 (func $array_push (param $arr i32) (param $val i32)
@@ -1828,6 +1901,79 @@ const stdLibExtraWAT = `
   i32.load
 )
 
+(func $array_slice (param $arr i32) (param $start i32) (result i32)
+  ;; New array with the elements from start to the end (destructuring rest)
+  (local $result i32)
+  (local $len i32)
+  (local $i i32)
+  local.get $arr
+  i32.load
+  local.set $len
+  local.get $start
+  local.set $i
+  local.get $len
+  local.get $start
+  i32.sub
+  i32.const 0
+  local.get $len
+  local.get $start
+  i32.gt_s
+  select
+  call $array_new
+  local.set $result
+  (block $done
+    (loop $copy
+      local.get $i
+      local.get $len
+      i32.ge_s
+      br_if $done
+      local.get $result
+      local.get $arr
+      local.get $i
+      call $array_get
+      call $array_push
+      local.get $i
+      i32.const 1
+      i32.add
+      local.set $i
+      br $copy
+    )
+  )
+  local.get $result
+)
+
+(func $array_push_all (param $dst i32) (param $src i32)
+  ;; Append every element of src to dst (array spread [...src])
+  (local $len i32)
+  (local $i i32)
+  local.get $src
+  call $is_nullish
+  if
+    return
+  end
+  local.get $src
+  i32.load
+  local.set $len
+  (block $done
+    (loop $copy
+      local.get $i
+      local.get $len
+      i32.ge_s
+      br_if $done
+      local.get $dst
+      local.get $src
+      local.get $i
+      call $array_get
+      call $array_push
+      local.get $i
+      i32.const 1
+      i32.add
+      local.set $i
+      br $copy
+    )
+  )
+)
+
 (func $string_equals (param $s1 i32) (param $s2 i32) (result i32)
   (local $len1 i32)
   (local $len2 i32)
@@ -2013,6 +2159,111 @@ const stdLibExtraWAT = `
   i32.const 0
 )
 
+;; Whether the map has the key (map_get cannot tell a missing key from a stored 0)
+(func $map_has (param $map i32) (param $key i32) (result i32)
+  (local $entry i32)
+  local.get $map
+  i32.const 8
+  i32.add
+  i32.load
+  local.get $key
+  call $hash_string
+  local.get $map
+  i32.load
+  i32.const 1
+  i32.sub
+  i32.and
+  i32.const 4
+  i32.mul
+  i32.add
+  i32.load
+  local.set $entry
+  (block $not_found
+    (loop $search
+      local.get $entry
+      i32.eqz
+      br_if $not_found
+      local.get $entry
+      i32.load
+      local.get $key
+      call $string_equals
+      if
+        i32.const 1
+        return
+      end
+      local.get $entry
+      i32.const 8
+      i32.add
+      i32.load
+      local.set $entry
+      br $search
+    )
+  )
+  i32.const 0
+)
+
+(func $map_assign (param $dst i32) (param $src i32)
+  ;; Copy every entry of src into dst (object spread {...src})
+  (local $cap i32)
+  (local $buckets i32)
+  (local $i i32)
+  (local $entry i32)
+  local.get $src
+  call $is_nullish
+  if
+    return
+  end
+  local.get $src
+  i32.load
+  local.set $cap
+  local.get $src
+  i32.const 8
+  i32.add
+  i32.load
+  local.set $buckets
+  (block $done
+    (loop $bucket
+      local.get $i
+      local.get $cap
+      i32.ge_u
+      br_if $done
+      local.get $buckets
+      local.get $i
+      i32.const 4
+      i32.mul
+      i32.add
+      i32.load
+      local.set $entry
+      (block $next_bucket
+        (loop $walk
+          local.get $entry
+          i32.eqz
+          br_if $next_bucket
+          local.get $dst
+          local.get $entry
+          i32.load ;; key
+          local.get $entry
+          i32.const 4
+          i32.add
+          i32.load ;; value
+          call $map_set
+          local.get $entry
+          i32.const 8
+          i32.add
+          i32.load
+          local.set $entry
+          br $walk
+        )
+      )
+      local.get $i
+      i32.const 1
+      i32.add
+      local.set $i
+      br $bucket
+    )
+  )
+)
+
 (func $int_to_string (param $val i32) (result i32)
   (local $ptr i32)
   (local $len i32)
@@ -2164,3510 +2415,12309 @@ const stdLibExtraWAT = `
 )
 `
 
-type Symbol struct {
-	Index   int
-	Type    DataType
-	IsParam bool
-	ShadowIndex int // Index in the shadow stack (-1 if not tracked)
-}
-
-// FunctionScope represents a function being compiled
-type FunctionScope struct {
-	Name         string
-	Instructions []string
-	Symbols      map[string]Symbol
-	NextLocalID  int
-	ParamCount   int
-	ParamTypes   []DataType
-	ShadowStackSize int // Number of pointer locals tracked
-}
+// stdLibBigIntWAT: i64 (bigint) 转字符串
+const stdLibBigIntWAT = `
+(func $i64tos (param $val i64) (result i32)
+  (local $ptr i32)
+  (local $len i32)
+  (local $i i32)
+  (local $is_neg i32)
+  (local $mag i64)
+  (local $temp i64)
 
-type ModuleScope struct {
-	Path            string
-	Prefix          string
-	SymbolAliases   map[string]string // Local alias -> Mangled Global Name
-	Exports         map[string]string // Exported Name -> Mangled Global Name
-}
+  ;; Magnitude as unsigned (also correct for the minimum value)
+  local.get $val
+  i64.const 0
+  i64.lt_s
+  local.set $is_neg
+  local.get $val
+  local.set $mag
+  local.get $is_neg
+  if
+    i64.const 0
+    local.get $val
+    i64.sub
+    local.set $mag
+  end
 
-type ClassSymbol struct {
-	Name       string
-	Size       int
-	Fields     map[string]int      // Name -> Offset
-	FieldTypes map[string]DataType // Name -> Type
-	Methods    map[string]string   // Name -> MangledName
-	Parent     string              // Parent class name (empty if none)
-	TypeID     int                 // Unique Type ID for GC
-}
+  ;; Count digits (at least one)
+  local.get $mag
+  local.set $temp
+  (loop $count
+    local.get $len
+    i32.const 1
+    i32.add
+    local.set $len
+    local.get $temp
+    i64.const 10
+    i64.div_u
+    local.tee $temp
+    i64.eqz
+    i32.eqz
+    br_if $count
+  )
+  local.get $len
+  local.get $is_neg
+  i32.add
+  local.set $len
 
-type InterfaceSymbol struct {
-	Name    string
-	Methods map[string]*ast.MethodSignature
-}
+  ;; Allocate string (len + 1)
+  local.get $len
+  i32.const 1
+  i32.add
+  i32.const 0 ;; TypeID 0
+  call $malloc
+  local.set $ptr
 
-func NewFunctionScope(name string) *FunctionScope {
-	return &FunctionScope{
-		Name:         name,
-		Instructions: []string{},
-		Symbols:      make(map[string]Symbol),
-		NextLocalID:  0,
-		ParamCount:   0,
-		ParamTypes:   []DataType{},
-	}
-}
+  ;; Null terminate
+  local.get $ptr
+  local.get $len
+  i32.add
+  i32.const 0
+  i32.store8
 
-type FunctionSignature struct {
-	ParamTypes []DataType
-	ReturnType DataType
-}
+  local.get $is_neg
+  if
+    local.get $ptr
+    i32.const 45 ;; '-'
+    i32.store8
+  end
 
-// Compiler converts AST to WAT (WebAssembly Text Format)
-type Compiler struct {
-	functions      []*FunctionScope
-	current        *FunctionScope
-	imports        []string
-	stringPool     map[string]int // String literal -> memory offset
-	nextDataOffset int            // Next available memory offset
-	classes        map[string]ClassSymbol // Class name -> Symbol
-	interfaces     map[string]InterfaceSymbol // Interface name -> Symbol
-	currentClass   string                 // Current class being compiled
-	nextTypeID     int                    // Next available Type ID (start from 2)
-	importedFuncs  map[string]*ast.ImportStatement // Imported functions
-	definedFuncs   map[string]FunctionSignature    // Functions defined in source
-	enums          map[string]map[string]int       // Enum definitions
-	typeAliases    map[string]string               // Type aliases (Name -> TargetType)
+  ;; Fill digits backwards
+  local.get $len
+  local.set $i
+  (loop $digit
+    local.get $i
+    i32.const 1
+    i32.sub
+    local.set $i
+    local.get $ptr
+    local.get $i
+    i32.add
+    local.get $mag
+    i64.const 10
+    i64.rem_u
+    i32.wrap_i64
+    i32.const 48
+    i32.add
+    i32.store8
+    local.get $mag
+    i64.const 10
+    i64.div_u
+    local.tee $mag
+    i64.eqz
+    i32.eqz
+    br_if $digit
+  )
+  local.get $ptr
+)
+`
 
-	// Type checking state
-	stackType DataType
-	
-	// Target platform ("wasi" or "browser")
-	target string
+// stdLibFloatWAT: f64 (number) 运行时支持：装箱与转字符串
+const stdLibFloatWAT = `
+(func $box_f64 (param $val f64) (result i32)
+  (local $ptr i32)
+  ;; 8 bytes payload, TypeID 8 (Float, leaf for GC)
+  i32.const 8
+  i32.const 8
+  call $malloc
+  local.set $ptr
+  local.get $ptr
+  local.get $val
+  f64.store
+  local.get $ptr
+)
 
-	// Module System
-	moduleStack   []*ModuleScope
-	currentModule *ModuleScope
-	loadedModules map[string]*ModuleScope // Path -> Scope (with Exports)
-	baseDir       string                  // Base directory for resolving imports
-	
-	// Task Scheduler
-	funcIDs       map[string]int          // Function Name -> Unique ID (for Scheduler)
-	nextFuncID    int
-}
+(func $unbox_f64 (param $ptr i32) (result f64)
+  ;; Missing values (null pointer) read as 0
+  local.get $ptr
+  i32.eqz
+  if
+    f64.const 0
+    return
+  end
+  local.get $ptr
+  f64.load
+)
 
-func New(target string) *Compiler {
-	c := &Compiler{
-		functions:      []*FunctionScope{},
-		imports:        []string{},
-		stringPool:     make(map[string]int),
-		nextDataOffset: 0,
-		classes:        make(map[string]ClassSymbol),
-		interfaces:     make(map[string]InterfaceSymbol),
-		nextTypeID:     10, // 0-9 reserved. 0=String, 1=Array, 2=Map, 10+=Classes
-		importedFuncs:  make(map[string]*ast.ImportStatement),
-		definedFuncs:   make(map[string]FunctionSignature),
-		enums:          make(map[string]map[string]int),
-		typeAliases:    make(map[string]string),
-		target:         target,
-		loadedModules:  make(map[string]*ModuleScope),
-		moduleStack:    []*ModuleScope{},
-		baseDir:        ".", // Default to current directory
-		funcIDs:        make(map[string]int),
-		nextFuncID:     1, // 0 reserved
-	}
-	
-	// Create main module scope
-	mainScope := &ModuleScope{
-		Path:          "main",
-		Prefix:        "",
-		SymbolAliases: make(map[string]string),
-		Exports:       make(map[string]string),
-	}
-	c.currentModule = mainScope
-	c.moduleStack = append(c.moduleStack, mainScope)
-	
-	// Add Host Interop Imports
-	c.imports = append(c.imports, `(import "env" "host_get_global" (func $host_get_global (param i32) (result i32)))`)
-	c.imports = append(c.imports, `(import "env" "host_get" (func $host_get (param i32) (param i32) (result i32)))`)
-	c.imports = append(c.imports, `(import "env" "host_set" (func $host_set (param i32) (param i32) (param i32)))`)
-	c.imports = append(c.imports, `(import "env" "host_call" (func $host_call (param i32) (param i32) (param i32) (param i32) (result i32)))`)
-	c.imports = append(c.imports, `(import "env" "host_from_int" (func $host_from_int (param i32) (result i32)))`)
-	c.imports = append(c.imports, `(import "env" "host_from_string" (func $host_from_string (param i32) (result i32)))`)
-	c.imports = append(c.imports, `(import "env" "host_to_int" (func $host_to_int (param i32) (result i32)))`)
-	c.imports = append(c.imports, `(import "env" "thread_spawn" (func $thread_spawn (param i32 i32) (result i32)))`)
+(func $pow10 (param $n i32) (result f64)
+  (local $r f64)
+  f64.const 1
+  local.set $r
+  (block $done
+    (loop $mul
+      local.get $n
+      i32.const 0
+      i32.le_s
+      br_if $done
+      local.get $r
+      f64.const 10
+      f64.mul
+      local.set $r
+      local.get $n
+      i32.const 1
+      i32.sub
+      local.set $n
+      br $mul
+    )
+  )
+  local.get $r
+)
 
-	if target == "wasi" {
-		c.imports = append(c.imports, `(import "wasi_snapshot_preview1" "environ_sizes_get" (func $environ_sizes_get (param i32 i32) (result i32)))`)
-		c.imports = append(c.imports, `(import "wasi_snapshot_preview1" "environ_get" (func $environ_get (param i32 i32) (result i32)))`)
-	}
-	
-	return c
-}
+;; $ftos formats a number like JS, with up to 15 significant digits
+;; (%.15g style): 5 -> "5", 0.25 -> "0.25", 1e21 -> "1e+21", 1.5e-7 -> "1.5e-7"
+(func $ftos (param $val f64) (result i32)
+  (local $buf i32)
+  (local $pos i32)
+  (local $abs f64)
+  (local $p f64)
+  (local $scaled f64)
+  (local $e10 i32)
+  (local $k i32)
+  (local $digits i64)
+  (local $i i32)
+  (local $ndig i32)
+  (local $exp i32)
+  (local $bias i32)
 
-func (c *Compiler) SetMainModulePath(path string) {
-	absPath, _ := filepath.Abs(path)
-	c.currentModule.Path = absPath
-	c.loadedModules[absPath] = c.currentModule
-}
+  ;; [0, 32): output, [32, 48): digit scratch
+  i32.const 48
+  i32.const 0
+  call $malloc
+  local.set $buf
 
-func (c *Compiler) emitBoxValue(typeVal DataType) {
-	// Stack: [value]
-	// Emit box_value(value, type_id)
-	
-	// Convert typeVal to TypeID
-	var typeID int
-	switch typeVal {
-	case TypeInt:
-		typeID = TypeID_Int
-	case TypeString:
-		typeID = TypeID_String
-	case TypeBool:
-		typeID = TypeID_Bool
-	case TypeArray:
-		typeID = TypeID_Array
-	case TypeMap:
-		typeID = TypeID_Map
-	case TypeHost:
-		typeID = TypeID_Host
-	default:
-		typeID = TypeID_Unknown
-	}
-	
-	c.emit(fmt.Sprintf("i32.const %d", typeID))
-	c.emit("call $box_value")
-}
+  ;; NaN
+  local.get $val
+  local.get $val
+  f64.ne
+  if
+    local.get $buf
+    i32.const 78 ;; 'N'
+    i32.store8
+    local.get $buf
+    i32.const 97 ;; 'a'
+    i32.store8 offset=1
+    local.get $buf
+    i32.const 78 ;; 'N'
+    i32.store8 offset=2
+    local.get $buf
+    i32.const 0
+    i32.store8 offset=3
+    local.get $buf
+    return
+  end
 
-func (c *Compiler) resolveType(typeName string) DataType {
-	if strings.Contains(typeName, "|") {
-		return TypeUnion
-	}
+  ;; Sign
+  local.get $val
+  f64.abs
+  local.set $abs
+  local.get $val
+  f64.const 0
+  f64.lt
+  if
+    local.get $buf
+    i32.const 45 ;; '-'
+    i32.store8
+    i32.const 1
+    local.set $pos
+  end
 
-	visited := make(map[string]bool)
-	current := typeName
-	for {
-		if visited[current] {
-			return TypeUnknown // Cycle detected
-		}
-		visited[current] = true
+  ;; Infinity
+  local.get $abs
+  f64.const inf
+  f64.eq
+  if
+    local.get $buf
+    local.get $pos
+    i32.add
+    local.tee $i
+    i32.const 73 ;; 'I'
+    i32.store8
+    local.get $i
+    i32.const 110 ;; 'n'
+    i32.store8 offset=1
+    local.get $i
+    i32.const 102 ;; 'f'
+    i32.store8 offset=2
+    local.get $i
+    i32.const 105 ;; 'i'
+    i32.store8 offset=3
+    local.get $i
+    i32.const 110 ;; 'n'
+    i32.store8 offset=4
+    local.get $i
+    i32.const 105 ;; 'i'
+    i32.store8 offset=5
+    local.get $i
+    i32.const 116 ;; 't'
+    i32.store8 offset=6
+    local.get $i
+    i32.const 121 ;; 'y'
+    i32.store8 offset=7
+    local.get $i
+    i32.const 0
+    i32.store8 offset=8
+    local.get $buf
+    return
+  end
 
-		switch current {
-		case "int":
-			return TypeInt
-		case "string":
-			return TypeString
-		case "bool":
-			return TypeBool
-		case "void":
-			return TypeVoid
-		case "array":
-			return TypeArray
-		case "map":
-			return TypeMap
-		}
+  ;; Zero
+  local.get $abs
+  f64.const 0
+  f64.eq
+  if
+    local.get $buf
+    i32.const 48 ;; '0'
+    i32.store8
+    local.get $buf
+    i32.const 0
+    i32.store8 offset=1
+    local.get $buf
+    return
+  end
 
-		if strings.HasPrefix(current, "Array<") {
-			return TypeArray
-		}
-		if strings.HasPrefix(current, "Map<") {
-			return TypeMap
-		}
+  ;; Subnormals and other tiny values: 10^-e10 below would overflow to inf,
+  ;; so scale into the normal range first and correct e10 afterwards
+  local.get $abs
+  f64.const 1e-300
+  f64.lt
+  if
+    local.get $abs
+    f64.const 1e300
+    f64.mul
+    local.set $abs
+    i32.const -300
+    local.set $bias
+  end
 
-		if alias, ok := c.typeAliases[current]; ok {
-			current = alias
-			continue
-		}
+  ;; Decimal exponent: 10^e10 <= abs < 10^(e10+1)
+  f64.const 10
+  local.set $p
+  local.get $abs
+  f64.const 1
+  f64.ge
+  if
+    i32.const 0
+    local.set $e10
+    (block $up_done
+      (loop $up
+        local.get $abs
+        local.get $p
+        f64.lt
+        br_if $up_done
+        local.get $p
+        f64.const 10
+        f64.mul
+        local.set $p
+        local.get $e10
+        i32.const 1
+        i32.add
+        local.set $e10
+        br $up
+      )
+    )
+  else
+    i32.const -1
+    local.set $e10
+    (block $down_done
+      (loop $down
+        local.get $abs
+        local.get $p
+        f64.mul
+        f64.const 1
+        f64.ge
+        br_if $down_done
+        local.get $p
+        f64.const 10
+        f64.mul
+        local.set $p
+        local.get $e10
+        i32.const 1
+        i32.sub
+        local.set $e10
+        br $down
+      )
+    )
+  end
 
-		// Not a basic type or alias, assume Class/Interface (pointer) -> TypeInt
-		return TypeInt
-	}
-}
+  ;; Scale to 15 significant digits: scaled = abs * 10^(14 - e10)
+  local.get $abs
+  local.set $scaled
+  i32.const 14
+  local.get $e10
+  i32.sub
+  local.set $k
+  (block $scale_done
+    (loop $scale
+      local.get $k
+      i32.const 22
+      i32.gt_s
+      if
+        local.get $scaled
+        f64.const 1e22
+        f64.mul
+        local.set $scaled
+        local.get $k
+        i32.const 22
+        i32.sub
+        local.set $k
+        br $scale
+      end
+      local.get $k
+      i32.const -22
+      i32.lt_s
+      if
+        local.get $scaled
+        f64.const 1e22
+        f64.div
+        local.set $scaled
+        local.get $k
+        i32.const 22
+        i32.add
+        local.set $k
+        br $scale
+      end
+      br $scale_done
+    )
+  )
+  local.get $k
+  i32.const 0
+  i32.ge_s
+  if
+    local.get $scaled
+    local.get $k
+    call $pow10
+    f64.mul
+    local.set $scaled
+  else
+    local.get $scaled
+    i32.const 0
+    local.get $k
+    i32.sub
+    call $pow10
+    f64.div
+    local.set $scaled
+  end
+  local.get $scaled
+  f64.nearest
+  i64.trunc_f64_u
+  local.set $digits
+
+  ;; Rounding may carry into a 16th digit (e.g. 9.999...)
+  local.get $digits
+  i64.const 1000000000000000
+  i64.ge_u
+  if
+    local.get $digits
+    i64.const 10
+    i64.div_u
+    local.set $digits
+    local.get $e10
+    i32.const 1
+    i32.add
+    local.set $e10
+  end
+  local.get $e10
+  local.get $bias
+  i32.add
+  local.set $e10
 
-func (c *Compiler) CompileModule(importPath string) (*ModuleScope, error) {
-	// 1. Resolve path
-	// If importPath starts with "./" or "../", it's relative to current module's path (dirname)
-	var absPath string
-	var err error
-	
-	if strings.HasPrefix(importPath, ".") {
-		base := c.baseDir
-		if c.currentModule != nil && c.currentModule.Path != "main" {
-			base = filepath.Dir(c.currentModule.Path)
-		}
-		absPath, err = filepath.Abs(filepath.Join(base, importPath))
-	} else {
-		// Assume absolute or CWD-relative if no prefix, or handle std lib
-		absPath, err = filepath.Abs(importPath)
-	}
-	
-	if err != nil {
-		return nil, err
-	}
-	
-	// Add extension if missing
-	if filepath.Ext(absPath) == "" {
-		absPath += ".omni"
-	}
-
-	// 2. Check cache
-	if scope, ok := c.loadedModules[absPath]; ok {
-		return scope, nil
-	}
-
-	// 3. Read file
-	content, err := os.ReadFile(absPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read module %s: %v", absPath, err)
-	}
+  ;; Extract 15 digits into scratch (most significant first)
+  i32.const 14
+  local.set $i
+  (block $ext_done
+    (loop $ext
+      local.get $i
+      i32.const 0
+      i32.lt_s
+      br_if $ext_done
+      local.get $buf
+      i32.const 32
+      i32.add
+      local.get $i
+      i32.add
+      local.get $digits
+      i64.const 10
+      i64.rem_u
+      i32.wrap_i64
+      i32.const 48
+      i32.add
+      i32.store8
+      local.get $digits
+      i64.const 10
+      i64.div_u
+      local.set $digits
+      local.get $i
+      i32.const 1
+      i32.sub
+      local.set $i
+      br $ext
+    )
+  )
 
-	// 4. Parse
-	l := lexer.New(string(content))
-	p := parser.New(l)
-	program := p.ParseProgram()
-	if len(p.Errors()) > 0 {
-		return nil, fmt.Errorf("parser errors in module %s: %v", absPath, p.Errors())
-	}
+  ;; Drop trailing zeros
+  i32.const 15
+  local.set $ndig
+  (block $trim_done
+    (loop $trim
+      local.get $ndig
+      i32.const 1
+      i32.le_s
+      br_if $trim_done
+      local.get $buf
+      i32.const 31
+      i32.add
+      local.get $ndig
+      i32.add
+      i32.load8_u
+      i32.const 48
+      i32.ne
+      br_if $trim_done
+      local.get $ndig
+      i32.const 1
+      i32.sub
+      local.set $ndig
+      br $trim
+    )
+  )
 
-	// 5. Create Scope
-	// Generate prefix based on hash of path
-	hash := sha256.Sum256([]byte(absPath))
-	prefix := "mod_" + hex.EncodeToString(hash[:4]) + "_"
-	
-	scope := &ModuleScope{
-		Path:          absPath,
-		Prefix:        prefix,
-		SymbolAliases: make(map[string]string),
-		Exports:       make(map[string]string),
-	}
-	
-	// Push scope
-	c.moduleStack = append(c.moduleStack, scope)
-	c.currentModule = scope
-	
-	// 6. Compile
-	// Recursively compile the program
-	if err := c.Compile(program); err != nil {
-		// pop scope on error
-		c.moduleStack = c.moduleStack[:len(c.moduleStack)-1]
-		c.currentModule = c.moduleStack[len(c.moduleStack)-1]
-		return nil, err
-	}
-	
-	// Pop scope
-	c.moduleStack = c.moduleStack[:len(c.moduleStack)-1]
-	c.currentModule = c.moduleStack[len(c.moduleStack)-1]
-	
-	// 7. Cache
-	c.loadedModules[absPath] = scope
-	
-	return scope, nil
-}
+  local.get $e10
+  i32.const 21
+  i32.lt_s
+  local.get $e10
+  i32.const -7
+  i32.gt_s
+  i32.and
+  if
+    local.get $e10
+    i32.const 0
+    i32.ge_s
+    if
+      ;; Integer part: e10 + 1 digits, padded with zeros
+      i32.const 0
+      local.set $i
+      (block $int_done
+        (loop $int
+          local.get $i
+          local.get $e10
+          i32.gt_s
+          br_if $int_done
+          local.get $buf
+          local.get $pos
+          i32.add
+          local.get $i
+          local.get $ndig
+          i32.lt_s
+          if (result i32)
+            local.get $buf
+            i32.const 32
+            i32.add
+            local.get $i
+            i32.add
+            i32.load8_u
+          else
+            i32.const 48
+          end
+          i32.store8
+          local.get $pos
+          i32.const 1
+          i32.add
+          local.set $pos
+          local.get $i
+          i32.const 1
+          i32.add
+          local.set $i
+          br $int
+        )
+      )
+    else
+      ;; "0." followed by -e10-1 zeros
+      local.get $buf
+      local.get $pos
+      i32.add
+      i32.const 48
+      i32.store8
+      local.get $pos
+      i32.const 1
+      i32.add
+      local.set $pos
+      local.get $buf
+      local.get $pos
+      i32.add
+      i32.const 46 ;; '.'
+      i32.store8
+      local.get $pos
+      i32.const 1
+      i32.add
+      local.set $pos
+      local.get $e10
+      i32.const 1
+      i32.add
+      local.set $i
+      (block $zeros_done
+        (loop $zeros
+          local.get $i
+          i32.const 0
+          i32.ge_s
+          br_if $zeros_done
+          local.get $buf
+          local.get $pos
+          i32.add
+          i32.const 48
+          i32.store8
+          local.get $pos
+          i32.const 1
+          i32.add
+          local.set $pos
+          local.get $i
+          i32.const 1
+          i32.add
+          local.set $i
+          br $zeros
+        )
+      )
+      i32.const 0
+      local.set $i
+    end
 
-func (c *Compiler) Compile(node ast.Node) error {
-	switch node := node.(type) {
-	case *ast.Program:
-		// Helper to unwrap ExportStatement
-		unwrap := func(s ast.Statement) (ast.Statement, bool) {
-			if exp, ok := s.(*ast.ExportStatement); ok {
-				return exp.Statement, true
-			}
-			return s, false
-		}
-		
-		// Helper to register export if needed
-		registerExport := func(s ast.Statement, inner ast.Statement) {
-			if _, ok := s.(*ast.ExportStatement); ok {
-				var name string
-				switch stmt := inner.(type) {
-				case *ast.ClassStatement:
-					name = stmt.Name.Value
-				case *ast.ExpressionStatement:
-					if fn, ok := stmt.Expression.(*ast.FunctionLiteral); ok {
-						name = fn.Name
-					}
-				}
-				if name != "" {
-					mangledName := c.currentModule.Prefix + name
-					c.currentModule.Exports[name] = mangledName
-				}
-			}
-		}
+    ;; Fraction digits (i .. ndig)
+    local.get $i
+    local.get $ndig
+    i32.lt_s
+    if
+      local.get $e10
+      i32.const 0
+      i32.ge_s
+      if
+        local.get $buf
+        local.get $pos
+        i32.add
+        i32.const 46 ;; '.'
+        i32.store8
+        local.get $pos
+        i32.const 1
+        i32.add
+        local.set $pos
+      end
+      (block $frac_done
+        (loop $frac
+          local.get $i
+          local.get $ndig
+          i32.ge_s
+          br_if $frac_done
+          local.get $buf
+          local.get $pos
+          i32.add
+          local.get $buf
+          i32.const 32
+          i32.add
+          local.get $i
+          i32.add
+          i32.load8_u
+          i32.store8
+          local.get $pos
+          i32.const 1
+          i32.add
+          local.set $pos
+          local.get $i
+          i32.const 1
+          i32.add
+          local.set $i
+          br $frac
+        )
+      )
+    end
+  else
+    ;; Scientific notation: d[.ddd]e+X
+    local.get $buf
+    local.get $pos
+    i32.add
+    local.get $buf
+    i32.load8_u offset=32
+    i32.store8
+    local.get $pos
+    i32.const 1
+    i32.add
+    local.set $pos
+    local.get $ndig
+    i32.const 1
+    i32.gt_s
+    if
+      local.get $buf
+      local.get $pos
+      i32.add
+      i32.const 46 ;; '.'
+      i32.store8
+      local.get $pos
+      i32.const 1
+      i32.add
+      local.set $pos
+      i32.const 1
+      local.set $i
+      (block $mant_done
+        (loop $mant
+          local.get $i
+          local.get $ndig
+          i32.ge_s
+          br_if $mant_done
+          local.get $buf
+          local.get $pos
+          i32.add
+          local.get $buf
+          i32.const 32
+          i32.add
+          local.get $i
+          i32.add
+          i32.load8_u
+          i32.store8
+          local.get $pos
+          i32.const 1
+          i32.add
+          local.set $pos
+          local.get $i
+          i32.const 1
+          i32.add
+          local.set $i
+          br $mant
+        )
+      )
+    end
+    local.get $buf
+    local.get $pos
+    i32.add
+    i32.const 101 ;; 'e'
+    i32.store8
+    local.get $buf
+    local.get $pos
+    i32.add
+    i32.const 43 ;; '+'
+    i32.const 45 ;; '-'
+    local.get $e10
+    i32.const 0
+    i32.ge_s
+    select
+    i32.store8 offset=1
+    local.get $pos
+    i32.const 2
+    i32.add
+    local.set $pos
+    local.get $e10
+    local.get $e10
+    i32.const 31
+    i32.shr_s
+    local.tee $exp
+    i32.xor
+    local.get $exp
+    i32.sub
+    local.set $exp
+    local.get $exp
+    i32.const 100
+    i32.ge_s
+    if
+      local.get $buf
+      local.get $pos
+      i32.add
+      local.get $exp
+      i32.const 100
+      i32.div_u
+      i32.const 48
+      i32.add
+      i32.store8
+      local.get $pos
+      i32.const 1
+      i32.add
+      local.set $pos
+    end
+    local.get $exp
+    i32.const 10
+    i32.ge_s
+    if
+      local.get $buf
+      local.get $pos
+      i32.add
+      local.get $exp
+      i32.const 10
+      i32.div_u
+      i32.const 10
+      i32.rem_u
+      i32.const 48
+      i32.add
+      i32.store8
+      local.get $pos
+      i32.const 1
+      i32.add
+      local.set $pos
+    end
+    local.get $buf
+    local.get $pos
+    i32.add
+    local.get $exp
+    i32.const 10
+    i32.rem_u
+    i32.const 48
+    i32.add
+    i32.store8
+    local.get $pos
+    i32.const 1
+    i32.add
+    local.set $pos
+  end
 
-		// 1. First pass: Compile imports
-		for _, stmt := range node.Statements {
-			if _, ok := stmt.(*ast.ImportStatement); ok {
-				if err := c.Compile(stmt); err != nil {
-					return err
-				}
-			}
-			if _, ok := stmt.(*ast.ImportModuleStatement); ok {
-				if err := c.Compile(stmt); err != nil {
-					return err
-				}
-			}
-		}
+  local.get $buf
+  local.get $pos
+  i32.add
+  i32.const 0
+  i32.store8
+  local.get $buf
+)
+`
 
-		// 1.2 Pass: Define Type Aliases
-		for _, stmt := range node.Statements {
-			s, _ := unwrap(stmt)
-			if typeAliasStmt, ok := s.(*ast.TypeAliasStatement); ok {
-				c.typeAliases[typeAliasStmt.Name.Value] = typeAliasStmt.Value
-			}
-		}
+// stdLibNullWAT: null (0) / undefined (哨兵 12, 见 undefinedPtr) 判断与真值
+const stdLibNullWAT = `
+(func $is_nullish (param $val i32) (result i32)
+  local.get $val
+  i32.eqz
+  local.get $val
+  i32.const 12
+  i32.eq
+  i32.or
+)
 
-		// 1.3 Pass: Define Enums
-		for _, stmt := range node.Statements {
-			s, _ := unwrap(stmt)
-			if enumStmt, ok := s.(*ast.EnumStatement); ok {
-				if err := c.defineEnum(enumStmt); err != nil {
-					return err
-				}
-			}
-		}
+(func $str_truthy (param $str i32) (result i32)
+  local.get $str
+  call $is_nullish
+  if
+    i32.const 0
+    return
+  end
+  local.get $str
+  call $strlen
+)
 
-		// 1.4 Pass: Define Interfaces
-		for _, stmt := range node.Statements {
-			s, _ := unwrap(stmt)
-			if ifaceStmt, ok := s.(*ast.InterfaceStatement); ok {
-				if err := c.defineInterface(ifaceStmt); err != nil {
-					return err
-				}
-			}
-		}
-
-		// 1.5 Pass: Define Classes
-		for _, stmt := range node.Statements {
-			s, _ := unwrap(stmt)
-			if classStmt, ok := s.(*ast.ClassStatement); ok {
-				if err := c.defineClass(classStmt); err != nil {
-					return err
-				}
-				registerExport(stmt, s)
-			}
-		}
+(func $union_truthy (param $val i32) (result i32)
+  (local $type_id i32)
+  local.get $val
+  call $is_nullish
+  if
+    i32.const 0
+    return
+  end
+  local.get $val
+  call $get_type_id
+  local.set $type_id
+  ;; int / bool: payload != 0
+  local.get $type_id
+  i32.const 1
+  i32.eq
+  local.get $type_id
+  i32.const 3
+  i32.eq
+  i32.or
+  if
+    local.get $val
+    i32.load
+    i32.const 0
+    i32.ne
+    return
+  end
+  ;; string: non-empty
+  local.get $type_id
+  i32.const 2
+  i32.eq
+  if
+    local.get $val
+    i32.load
+    call $strlen
+    i32.const 0
+    i32.ne
+    return
+  end
+  ;; number: != 0 (NaN is falsy too)
+  local.get $type_id
+  i32.const 8
+  i32.eq
+  if
+    local.get $val
+    f64.load
+    f64.const 0
+    f64.ne
+    local.get $val
+    f64.load
+    local.get $val
+    f64.load
+    f64.eq
+    i32.and
+    return
+  end
+  i32.const 1
+)
+`
 
-		// 1.6 Pass: Compile Class Methods
-		for _, stmt := range node.Statements {
-			s, _ := unwrap(stmt)
-			if classStmt, ok := s.(*ast.ClassStatement); ok {
-				if err := c.compileClassMethods(classStmt); err != nil {
-					return err
-				}
-			}
-		}
+// stdLibAsyncWAT: Promise 与事件循环
+// Promise 布局: [state (0 pending, 1 fulfilled, 2 rejected), reactions (Array of closures), value (8 bytes)]
+// 任务和 reaction 都是闭包对象 [table index, env]，以 $closure_i32_r_i32 调用；
+// 宿主在 main 返回后以及每次回调之后调用导出的 run_event_loop 执行队列中的任务
+const stdLibAsyncWAT = `
+(global $task_queue (mut i32) (i32.const 0))
+(global $task_head (mut i32) (i32.const 0))
+(global $unhandled_rejections (mut i32) (i32.const 0)) ;; Rejected promises nobody has awaited yet
 
-		// 1.7 Pass: Check Interface Implementation
-		for _, stmt := range node.Statements {
-			s, _ := unwrap(stmt)
-			if classStmt, ok := s.(*ast.ClassStatement); ok {
-				if err := c.checkInterfaceImplementation(classStmt); err != nil {
-					return err
-				}
-			}
-		}
+(func $promise_new (result i32)
+  i32.const 16
+  i32.const 12 ;; TypeID_Promise
+  call $malloc
+)
 
-		// 1.8 Pass: Collect Function Names and Signatures
-		for _, stmt := range node.Statements {
-			s, _ := unwrap(stmt)
-			if exprStmt, ok := s.(*ast.ExpressionStatement); ok {
-				if fn, ok := exprStmt.Expression.(*ast.FunctionLiteral); ok {
-					sig := FunctionSignature{ParamTypes: []DataType{}}
-					for _, p := range fn.Parameters {
-						t := c.resolveType(p.Type)
-						sig.ParamTypes = append(sig.ParamTypes, t)
-					}
-					sig.ReturnType = c.resolveType(fn.ReturnType)
-					if fn.ReturnType == "" {
-						sig.ReturnType = TypeVoid
-					}
-					
-					// Use prefixed name
-					mangledName := c.currentModule.Prefix + fn.Name
-					c.definedFuncs[mangledName] = sig
-					
-					// Assign ID for Scheduler
-					c.funcIDs[mangledName] = c.nextFuncID
-					c.nextFuncID++
-					
-					registerExport(stmt, s)
-				}
-			}
-		}
+(func $enqueue_task (param $task i32)
+  global.get $task_queue
+  i32.eqz
+  if
+    i32.const 8
+    call $array_new
+    global.set $task_queue
+  end
+  global.get $task_queue
+  local.get $task
+  call $array_push
+)
 
-		// 2. Second pass: Compile functions
-		for _, stmt := range node.Statements {
-			s, _ := unwrap(stmt)
-			if exprStmt, ok := s.(*ast.ExpressionStatement); ok {
-				if fn, ok := exprStmt.Expression.(*ast.FunctionLiteral); ok {
-					if err := c.compileFunction(fn); err != nil {
-						return err
-					}
-				}
-			}
-		}
+(func $run_event_loop
+  (local $task i32)
+  global.get $task_queue
+  i32.eqz
+  if
+    return
+  end
+  (block $done
+    (loop $next
+      global.get $task_head
+      global.get $task_queue
+      call $array_length
+      i32.ge_u
+      br_if $done
 
-	case *ast.ImportStatement:
-		// Generate Wasm import
-		funcName := node.Name.Value
-		
-		paramsStr := ""
-		for range node.Parameters {
-			paramsStr += " (param i32)"
-		}
+      global.get $task_queue
+      global.get $task_head
+      call $array_get
+      local.set $task
+      global.get $task_head
+      i32.const 1
+      i32.add
+      global.set $task_head
+
+      local.get $task
+      i32.load offset=4 ;; env
+      local.get $task
+      i32.load ;; table index
+      call_indirect (type $closure_i32_r_i32)
+      drop
+      br $next
+    )
+  )
+  ;; Drained: reuse the queue from the start
+  global.get $task_queue
+  i32.const 0
+  i32.store ;; length
+  i32.const 0
+  global.set $task_head
+)
+(export "run_event_loop" (func $run_event_loop))
 
-		resultStr := ""
-		if node.ReturnType != "void" && node.ReturnType != "" {
-			resultStr = " (result i32)"
-		}
+(func $promise_settle (param $p i32) (param $state i32)
+  (local $reactions i32)
+  (local $i i32)
+  local.get $p
+  local.get $state
+  i32.store
+  local.get $p
+  i32.load offset=4
+  local.set $reactions
+  local.get $p
+  i32.const 0
+  i32.store offset=4
+  local.get $reactions
+  i32.eqz
+  if
+    return
+  end
+  (block $done
+    (loop $next
+      local.get $i
+      local.get $reactions
+      call $array_length
+      i32.ge_u
+      br_if $done
+      local.get $reactions
+      local.get $i
+      call $array_get
+      call $enqueue_task
+      local.get $i
+      i32.const 1
+      i32.add
+      local.set $i
+      br $next
+    )
+  )
+)
 
-		importStr := fmt.Sprintf("(import \"env\" \"%s\" (func $%s%s%s))", funcName, funcName, paramsStr, resultStr)
-		c.imports = append(c.imports, importStr)
-		c.importedFuncs[funcName] = node
-	
-	case *ast.ImportModuleStatement:
-		// Compile module
-		scope, err := c.CompileModule(node.Source)
-		if err != nil {
-			return err
-		}
-		
-		// Import symbols
-		for _, ident := range node.Identifiers {
-			name := ident.Value
-			// Look up in exports
-			if mangledName, ok := scope.Exports[name]; ok {
-				c.currentModule.SymbolAliases[name] = mangledName
-			} else {
-				return fmt.Errorf("module %s does not export %s", node.Source, name)
-			}
-		}
-		return nil
+;; Settling an already settled promise does nothing
+(func $promise_resolve (param $p i32) (param $val i32)
+  local.get $p
+  i32.load
+  if
+    return
+  end
+  local.get $p
+  local.get $val
+  i32.store offset=8
+  local.get $p
+  i32.const 1
+  call $promise_settle
+)
+(export "promise_resolve" (func $promise_resolve))
 
-	case *ast.ExportStatement:
-		// Compile inner statement
-		// We need to determine the name to export.
-		var name string
-		
-		switch stmt := node.Statement.(type) {
-		case *ast.ClassStatement:
-			name = stmt.Name.Value
-		case *ast.ExpressionStatement:
-			if fn, ok := stmt.Expression.(*ast.FunctionLiteral); ok {
-				name = fn.Name
-			}
-		}
-		
-		// Compile the statement
-		if err := c.Compile(node.Statement); err != nil {
-			return err
-		}
-		
-		// Register export
-		if name != "" {
-			// The symbol is defined with prefix in the current module.
-			mangledName := c.currentModule.Prefix + name
-			c.currentModule.Exports[name] = mangledName
-		}
-		return nil
+(func $promise_resolve_f64 (param $p i32) (param $val f64)
+  local.get $p
+  i32.load
+  if
+    return
+  end
+  local.get $p
+  local.get $val
+  f64.store offset=8
+  local.get $p
+  i32.const 1
+  call $promise_settle
+)
 
-	case *ast.InterfaceStatement:
-		// Interfaces are compile-time only, no code generation needed.
-		return nil
+(func $promise_resolve_i64 (param $p i32) (param $val i64)
+  local.get $p
+  i32.load
+  if
+    return
+  end
+  local.get $p
+  local.get $val
+  i64.store offset=8
+  local.get $p
+  i32.const 1
+  call $promise_settle
+)
 
-	case *ast.EnumStatement:
-		// Enums are compile-time definitions (constants)
-		return nil
+(func $promise_reject (param $p i32) (param $reason i32)
+  local.get $p
+  i32.load
+  if
+    return
+  end
+  local.get $p
+  local.get $reason
+  i32.store offset=8
+  ;; No reactions yet: unhandled until something awaits it
+  local.get $p
+  i32.load offset=4
+  i32.eqz
+  if
+    global.get $unhandled_rejections
+    i32.eqz
+    if
+      i32.const 4
+      call $array_new
+      global.set $unhandled_rejections
+    end
+    global.get $unhandled_rejections
+    local.get $p
+    call $array_push
+  end
+  local.get $p
+  i32.const 2
+  call $promise_settle
+)
 
-	case *ast.SpawnStatement:
-		// spawn func(args)
-		// We need to:
-		// 1. Get function index (table index) - MVP: pass function name/ID?
-		// WASM `call_indirect` uses table index. We need to put functions in a table.
-		// For MVP, we can't easily get table index dynamically without extensive changes.
-		// Alternative: Pass function Name (string) to host, and host calls back?
-		// But host can't call internal WASM functions easily by name unless exported.
-		
-		// Strategy:
-		// 1. Compile call arguments into an Array or struct.
-		// 2. Call $thread_spawn(func_id, args_ptr).
-		// We need to map functions to IDs.
-		
-		// For MVP: Only support spawning 'main' or exported functions?
-		// Or assume we export everything or use a table.
-		
-		// Let's use a simplified approach:
-		// 1. Compile arguments -> store in array/struct.
-		// 2. Identify the function being called.
-		// 3. We need a dispatch function `(func $dispatch (param $id i32) (param $args i32))` exported to host.
-		// 4. Host calls `dispatch` in new thread.
-		
-		// But adding a dispatch function requires knowing all possible spawn targets.
-		// Let's assume for now we only spawn `function` calls (not methods).
-		
-		callExpr := node.Call
-		var funcName string
-		if ident, ok := callExpr.Function.(*ast.Identifier); ok {
-			funcName = ident.Value
-		} else {
-			return fmt.Errorf("spawn only supports direct function calls")
-		}
-		
-		// Create a unique ID for this function name (or use string)
-		// Using string is easier for host to debug, but slower.
-		// Let's use string for MVP.
-		
-		// 1. Pack arguments
-		argCount := len(callExpr.Arguments)
-		c.emit(fmt.Sprintf("i32.const %d", argCount))
-		c.emit("call $array_new")
-		// Stack: [arr]
-		
-		// Temp local for array
-		arrTemp := c.current.NextLocalID
-		c.current.NextLocalID++
-		realArrTemp := arrTemp + c.current.ParamCount
-		c.current.Symbols[fmt.Sprintf("$spawn_arr_%d", arrTemp)] = Symbol{Index: arrTemp, Type: TypeArray, IsParam: false, ShadowIndex: c.current.ShadowStackSize}
-		c.current.ShadowStackSize++ // Track it
-		
-		c.emit(fmt.Sprintf("local.set %d", realArrTemp))
-		
-		// Push to shadow stack
-		c.emit("global.get $shadow_stack_ptr")
-		c.emit(fmt.Sprintf("local.get %d", realArrTemp))
-		c.emit("i32.store")
-		c.emit("global.get $shadow_stack_ptr")
-		c.emit("i32.const 4")
-		c.emit("i32.add")
-		c.emit("global.set $shadow_stack_ptr")
-		
-		for _, arg := range callExpr.Arguments {
-			c.emit(fmt.Sprintf("local.get %d", realArrTemp))
-			if err := c.Compile(arg); err != nil {
-				return err
-			}
-			c.emit("call $array_push")
-		}
-		
-		// 2. Function ID
-		funcID, ok := c.funcIDs[funcName]
-		if !ok {
-			return fmt.Errorf("spawn target '%s' not found (imported functions not yet supported for spawn)", funcName)
-		}
-		c.emit(fmt.Sprintf("i32.const %d ;; func_id for %s", funcID, funcName))
-		
-		// 3. Call $scheduler_submit(func_id, args_arr_ptr)
-		c.emit(fmt.Sprintf("local.get %d", realArrTemp))
-		c.emit("call $scheduler_submit")
-		
-		c.stackType = TypeVoid
-		return nil
+;; Awaiting a rejected promise handles the rejection
+(func $promise_mark_handled (param $p i32)
+  (local $i i32)
+  global.get $unhandled_rejections
+  i32.eqz
+  if
+    return
+  end
+  (block $done
+    (loop $next
+      local.get $i
+      global.get $unhandled_rejections
+      call $array_length
+      i32.ge_u
+      br_if $done
+      global.get $unhandled_rejections
+      local.get $i
+      call $array_get
+      local.get $p
+      i32.eq
+      if
+        global.get $unhandled_rejections
+        local.get $i
+        i32.const 0
+        call $array_set
+      end
+      local.get $i
+      i32.const 1
+      i32.add
+      local.set $i
+      br $next
+    )
+  )
+)
+;; Host promises reject with a host handle: box it like every other rejection reason
+(func $promise_reject_host (param $p i32) (param $reason i32)
+  local.get $p
+  local.get $reason
+  i32.const 6 ;; TypeID_Host
+  call $box_value
+  call $promise_reject
+)
+(export "promise_reject" (func $promise_reject_host))
 
-	case *ast.TypeAliasStatement:
-		// Already handled in pass 1.2
-		return nil
+;; resolve / reject functions handed to a Promise executor (closure env = the promise)
+(func $promise_resolve_fn (param $env i32) (param $val i32) (result i32)
+  local.get $env
+  local.get $val
+  call $promise_resolve
+  i32.const 0
+)
 
-	case *ast.ExpressionStatement:
-		if err := c.Compile(node.Expression); err != nil {
-			return err
-		}
-		if c.stackType != TypeVoid {
-			c.emit("drop")
-		}
+(func $promise_resolve_fn_f64 (param $env i32) (param $val f64) (result i32)
+  local.get $env
+  local.get $val
+  call $promise_resolve_f64
+  i32.const 0
+)
 
-	case *ast.LetStatement:
-		if err := c.Compile(node.Value); err != nil {
-			return err
-		}
+(func $promise_resolve_fn_i64 (param $env i32) (param $val i64) (result i32)
+  local.get $env
+  local.get $val
+  call $promise_resolve_i64
+  i32.const 0
+)
 
-		valueType := c.stackType
-		if valueType == TypeUnknown {
-			valueType = TypeInt // Default to int for MVP if unknown
-		}
-		
-		// Union Type Check
-		// If declared type contains "|", it's a union.
-		// For MVP, if it is union, we box the value if it's not already boxed (TypeUnion).
-		// Currently we treat it as TypeUnion.
-		if strings.Contains(node.Type, "|") {
-			// Box the value
-			// We need a helper function $box_value(val, type_id) -> ptr
-			c.emitBoxValue(valueType)
-			valueType = TypeUnion
-		}
+(func $promise_resolve_fn_void (param $env i32) (result i32)
+  local.get $env
+  i32.const 0
+  call $promise_resolve
+  i32.const 0
+)
 
-		index := c.current.NextLocalID
-		shadowIndex := c.current.ShadowStackSize
-		
-		c.current.Symbols[node.Name.Value] = Symbol{
-			Index: index, 
-			Type: valueType, 
-			IsParam: false,
-			ShadowIndex: shadowIndex,
-		}
-		c.current.NextLocalID++
-		c.current.ShadowStackSize++
+(func $promise_reject_fn (param $env i32) (param $reason i32) (result i32)
+  local.get $env
+  local.get $reason
+  call $promise_reject
+  i32.const 0
+)
 
-		realIndex := index + c.current.ParamCount
-		// local.set %d
-		c.emit(fmt.Sprintf("local.set %d ;; %s (%s)", realIndex, node.Name.Value, valueType))
-		
-		// Push to shadow stack
-		// store(shadow_stack_ptr, value)
-		// shadow_stack_ptr += 4
-		c.emit("global.get $shadow_stack_ptr")
-		c.emit(fmt.Sprintf("local.get %d", realIndex))
-		c.emit("i32.store")
-		
-		c.emit("global.get $shadow_stack_ptr")
-		c.emit("i32.const 4")
-		c.emit("i32.add")
-		c.emit("global.set $shadow_stack_ptr")
+;; Takes the first rejected promise nobody awaited (0 if there is none); each one is reported once
+(func $take_unhandled_rejection (result i32)
+  (local $i i32)
+  (local $p i32)
+  global.get $unhandled_rejections
+  i32.eqz
+  if
+    i32.const 0
+    return
+  end
+  (block $done
+    (loop $next
+      local.get $i
+      global.get $unhandled_rejections
+      call $array_length
+      i32.ge_u
+      br_if $done
+      global.get $unhandled_rejections
+      local.get $i
+      call $array_get
+      local.tee $p
+      if
+        global.get $unhandled_rejections
+        local.get $i
+        i32.const 0
+        call $array_set
+        local.get $p
+        return
+      end
+      local.get $i
+      i32.const 1
+      i32.add
+      local.set $i
+      br $next
+    )
+  )
+  ;; All handled: reuse the list from the start
+  global.get $unhandled_rejections
+  i32.const 0
+  i32.store ;; length
+  i32.const 0
+)
 
-	case *ast.ClassStatement:
-		// Already handled in passes
-		return nil
+;; Run task once p is settled (right away if it already is)
+(func $promise_then (param $p i32) (param $task i32)
+  local.get $p
+  i32.load
+  if
+    local.get $p
+    i32.load
+    i32.const 2
+    i32.eq
+    if
+      local.get $p
+      call $promise_mark_handled
+    end
+    local.get $task
+    call $enqueue_task
+    return
+  end
+  local.get $p
+  i32.load offset=4
+  i32.eqz
+  if
+    local.get $p
+    i32.const 4
+    call $array_new
+    i32.store offset=4
+  end
+  local.get $p
+  i32.load offset=4
+  local.get $task
+  call $array_push
+)
 
-	case *ast.NewExpression:
-		className := node.Class.Value
-		
-		// 1. Try prefixed class
-		prefixed := ""
-		if c.currentModule != nil && c.currentModule.Prefix != "" {
-			prefixed = c.currentModule.Prefix + className
-		}
-		
-		if _, ok := c.classes[prefixed]; ok {
-			className = prefixed
-		} else if c.currentModule != nil {
-			// 2. Try alias
-			if alias, ok := c.currentModule.SymbolAliases[className]; ok {
-				// Alias might point to a class
-				if _, ok := c.classes[alias]; ok {
-					className = alias
-				}
-			}
-		}
+;; A rejected promise rethrows its reason at the await
+(func $promise_check (param $p i32)
+  local.get $p
+  i32.load
+  i32.const 2
+  i32.eq
+  if
+    local.get $p
+    i32.load offset=8
+    throw $exception
+  end
+)
 
-		classSym, ok := c.classes[className]
-		if !ok {
-			return fmt.Errorf("undefined class: %s", className)
-		}
+;; Host callbacks (setTimeout) call a closure and then drain the queue
+(func $run_callback (param $closure i32)
+  local.get $closure
+  i32.load offset=4 ;; env
+  local.get $closure
+  i32.load ;; table index
+  call_indirect (type $closure_i32_r_i32)
+  drop
+  call $run_event_loop
+)
+(export "run_callback" (func $run_callback))
+
+;; Awaiting a host value: the host settles the promise when its JS promise does
+(func $promise_from_host (param $handle i32) (result i32)
+  (local $p i32)
+  call $promise_new
+  local.set $p
+  local.get $handle
+  local.get $p
+  call $host_await
+  local.get $p
+)
+`
 
-		// malloc(size)
-		c.emit(fmt.Sprintf("i32.const %d", classSym.Size))
-		c.emit(fmt.Sprintf("i32.const %d", classSym.TypeID))
-		c.emit("call $malloc")
+// stdLibIteratorWAT: 生成器与迭代器协议
+// IteratorResult 布局: [value (8 bytes), done]；生成器对象就是它的状态机帧 [_, state, step closure, ...]，
+// state 为 -1 表示已经结束
+const stdLibIteratorWAT = `
+(func $iter_result_new (param $done i32) (param $type_id i32) (result i32)
+  (local $r i32)
+  i32.const 12
+  local.get $type_id
+  call $malloc
+  local.tee $r
+  local.get $done
+  i32.store offset=8
+  local.get $r
+)
 
-		// Check for constructor "init"
-		if mangledName, ok := classSym.Methods["init"]; ok {
-			// Store ptr in temp to use it multiple times
-			tempIndex := c.current.NextLocalID
-			c.current.NextLocalID++
-			realTempIndex := tempIndex + c.current.ParamCount
+;; return(): a generator suspended at a yield resumes there to run its finally blocks, then it is finished
+;; (slot 0, the promise of async frames, flags the request)
+(func $generator_return (param $gen i32) (result i32)
+  (local $step i32)
+  local.get $gen
+  i32.load offset=4
+  i32.const 0
+  i32.gt_s
+  if
+    local.get $gen
+    i32.const 1
+    i32.store ;; return() requested
+    local.get $gen
+    i32.load offset=8
+    local.set $step
+    local.get $step
+    i32.load offset=4 ;; env
+    local.get $step
+    i32.load ;; table index
+    call_indirect (type $closure_i32_r_i32)
+    drop
+  end
+  local.get $gen
+  i32.const -1
+  i32.store offset=4 ;; state = finished
+  i32.const 1
+  i32.const 13 ;; TypeID_IterResult
+  call $iter_result_new
+)
 
-			c.emit(fmt.Sprintf("local.set %d", realTempIndex))
+(func $generator_next (param $gen i32) (result i32)
+  (local $step i32)
+  local.get $gen
+  i32.load offset=4
+  i32.const -1
+  i32.eq
+  if
+    i32.const 1
+    i32.const 13 ;; TypeID_IterResult
+    call $iter_result_new
+    return
+  end
+  local.get $gen
+  i32.load offset=8
+  local.set $step
+  local.get $step
+  i32.load offset=4 ;; env
+  local.get $step
+  i32.load ;; table index
+  call_indirect (type $closure_i32_r_i32)
+)
+`
 
-			// Prepare 'this'
-			c.emit(fmt.Sprintf("local.get %d", realTempIndex))
+type Symbol struct {
+	Index   int
+	Type    DataType
+	IsParam bool
+	ShadowIndex int // Index in the shadow stack (-1 if not tracked)
+	TypeName string // Declared type, e.g. "Array<number>" or a class name
+	InEnv   bool    // Captured by a closure: lives in the environment object, Index is the byte offset
+	Env     *EnvLayout // InEnv: the per-iteration loop environment holding the variable (nil: the function's Env)
+}
 
-			// Args
-			for _, arg := range node.Arguments {
-				if err := c.Compile(arg); err != nil {
-					return err
-				}
-			}
+// FunctionScope represents a function being compiled
+type FunctionScope struct {
+	Name         string
+	Instructions []string
+	Symbols      map[string]Symbol
+	NextLocalID  int
+	ParamCount   int
+	ParamTypes   []DataType
+	ReturnType   DataType
+	LocalTypes   map[int]DataType // Local ID -> Type, for locals that are not i32
+	ShadowStackSize int // Number of pointer locals tracked
 
-			c.emit(fmt.Sprintf("call $%s", mangledName))
-			c.emit("drop") // Ignore init return value
+	// Closures
+	Parent      *FunctionScope // Enclosing function (closures only)
+	IsClosure   bool           // Param 0 is the enclosing environment
+	Captured    map[string]bool // Variables captured by inner closures
+	Env         *EnvLayout     // Environment for captured variables (nil if none)
+	EnvLocal    int            // Real index of the local holding Env
+	LoopEnvs    []*LoopEnv     // Per-iteration environments of the loops being compiled, innermost last
+	InferReturn bool           // Return type comes from the first return statement
+	ReturnTypeName string     // Declared or inferred return type name
+
+	// Control flow
+	ShadowPtrLocal int                   // Real index of the local holding the caller's shadow_stack_ptr
+	HasShadowFrame bool                  // ShadowPtrLocal is valid (restored on return)
+	Loops          []*LoopContext        // Enclosing loops / labeled blocks, innermost last
+	Finally        []*ast.BlockStatement // Finally blocks of the enclosing try statements, innermost last
+
+	// async functions and generators: the state machine that runs the body (nil otherwise)
+	Async *AsyncFrame
+}
 
-			// Return instance
-			c.emit(fmt.Sprintf("local.get %d", realTempIndex))
-		} else if len(node.Arguments) > 0 {
-			return fmt.Errorf("arguments provided for class %s but no 'init' method found", className)
-		}
+// AsyncFrame 描述 async 函数或生成器的状态机
+// 帧对象布局: [promise, state, step 闭包, 外层环境, 局部变量 0, 局部变量 1, ...] (局部变量各占 8 字节)
+// await / yield 挂起时把所有局部变量写入帧对象并返回；Promise 完成后 (生成器为下一次 next()) 重新调用状态机函数，
+// 它恢复局部变量，沿着包含挂起点的语句重新进入函数体 (跳过其余语句)，在挂起点继续执行
+// 生成器的帧对象就是生成器本身，promise 槽不使用，state 为 -1 表示已结束
+type AsyncFrame struct {
+	Generator     bool                              // function*: suspension points are yields
+	Points        map[ast.Expression]int            // Suspension point ids (1-based, pre-order, so every subtree is a contiguous range)
+	Results       map[ast.Expression]*ast.Identifier // Temp holding the value of each await (nil for Promise<void> and yields)
+	ResumingLocal int                               // Real index of the local that is 1 until the suspension point is reached again
+	ValueTypeName string                            // T of the returned Promise<T> / Iterator<T>
+	InferValue    bool                              // T comes from the first return (async) or yield (generator)
+}
 
-		c.stackType = TypeInt
-		return nil
+// LoopContext 描述 break / continue 的跳转目标
+type LoopContext struct {
+	Label         string // Source label ("" if unlabeled)
+	BreakLabel    string // WASM label of the enclosing block
+	ContinueLabel string // WASM label jumped to by continue ("" for labeled blocks)
+	LabelOnly     bool   // Labeled block: only `break label` may target it
+	ShadowLocal   int    // Real index of the local holding shadow_stack_ptr at loop entry
+	Finally       int    // Number of enclosing finally blocks at entry; break / continue runs the ones above it
+	Exit          *ast.BlockStatement // Run by break leaving this loop (for...of closes its iterator)
+}
 
-	case *ast.SuperExpression:
-		// super.method() logic needs MemberExpression context, but if used alone?
-		// Usually super() is for constructor.
-		// super.foo() is for method access.
-		// AST structure for super.foo() is MemberExpression(Object=SuperExpression, Property=foo)
-		
-		// If we are here, it means 'super' is used as a value, which is not really valid in this MVP except for member access.
-		// But let's return 'this' pointer because super calls usually operate on 'this' instance.
-		c.emit("local.get 0 ;; this (super)")
-		c.stackType = TypeInt
-		return nil
+// EnvLayout 描述闭包环境对象的布局：槽位 0 指向外层环境，之后每个被捕获的变量占 8 字节
+type EnvLayout struct {
+	TypeID  int
+	Size    int
+	Offsets map[string]int
+	Types   map[string]DataType
+}
 
-	case *ast.MemberExpression:
-		// Check for Enum Access (e.g., Color.Red)
-		if ident, ok := node.Object.(*ast.Identifier); ok {
-			enumName := ident.Value
-			
-			// Resolution
-			prefixed := ""
-			if c.currentModule != nil && c.currentModule.Prefix != "" {
-				prefixed = c.currentModule.Prefix + enumName
-			}
-			
-			if _, ok := c.enums[prefixed]; ok {
-				enumName = prefixed
-			} else if c.currentModule != nil {
-				if alias, ok := c.currentModule.SymbolAliases[enumName]; ok {
-					if _, ok := c.enums[alias]; ok {
-						enumName = alias
-					}
-				}
-			}
+type ModuleScope struct {
+	Path            string
+	Prefix          string
+	SymbolAliases   map[string]string // Local alias -> Mangled Global Name
+	Exports         map[string]string // Exported Name -> Mangled Global Name
+}
 
-			if members, isEnum := c.enums[enumName]; isEnum {
-				val, ok := members[node.Property.Value]
-				if !ok {
-					return fmt.Errorf("enum %s has no member %s", ident.Value, node.Property.Value)
-				}
-				c.emit(fmt.Sprintf("i32.const %d", val))
-				c.stackType = TypeInt
-				return nil
-			}
-		}
+type ClassSymbol struct {
+	Name       string
+	Size       int
+	Fields     map[string]int      // Name -> Offset
+	FieldTypes map[string]DataType // Name -> Type
+	FieldTypeNames map[string]string // Name -> Declared type
+	Methods    map[string]string   // Name -> MangledName
+	MethodSigs map[string]FunctionSignature // Name -> Signature (without 'this')
+	Members    map[string]MemberModifiers // Name -> Modifiers of every field and method (inherited ones included)
+	StaticFields     map[string]StaticField       // Name -> Module global
+	StaticMethods    map[string]string            // Name -> MangledName (no this)
+	StaticMethodSigs map[string]FunctionSignature // Name -> Signature
+	StaticMembers    map[string]MemberModifiers   // Name -> Modifiers of static fields and methods
+	Abstract         bool                         // abstract class: no new
+	VTable           []string                     // Slot -> Method key of every instance method (parent's slots first)
+	VTableSlots      map[string]int               // Method key -> Slot
+	VTableSigs       []FunctionSignature          // Slot -> Signature of the method that introduced it
+	VTableBase       int                          // Table index of slot 0, stored in the object header
+	AbstractMethods  map[string]string            // Method key -> Class declaring it, not implemented yet
+	Parent     string              // Parent class name (empty if none)
+	TypeID     int                 // Unique Type ID for GC
+}
 
-		// Check for process.env
-		if ident, ok := node.Object.(*ast.Identifier); ok && ident.Value == "process" && node.Property.Value == "env" {
-			if c.target != "wasi" {
-				return fmt.Errorf("process.env is only supported in WASI target")
-			}
-			c.emit("call $process_env")
-			c.stackType = TypeMap
-			return nil
-		}
+// MemberModifiers 类成员的修饰符；Owner 为声明该成员的类，子类继承时保持不变
+type MemberModifiers struct {
+	Access   string // "private" 或 "protected"；public 和未写修饰符时为空
+	Readonly bool
+	Owner    string
+}
 
-		// Special handling for super.method()
+// StaticField 静态字段，存放在 WASM 全局变量中
+type StaticField struct {
+	Global   string // WASM global name, e.g. $Config.debug
+	Type     DataType
+	TypeName string
+}
 
-		if _, ok := node.Object.(*ast.SuperExpression); ok {
-			if c.currentClass == "" {
-				return fmt.Errorf("super used outside of class")
-			}
-			// We just let it fall through. compile(SuperExpression) puts 'this' on stack.
-			// If it's a field access, the generic field lookup below will find it (inherited fields are copied).
-			// If it's a method access not in a call, it will fail or behave weirdly, but we don't support function pointers yet.
+// fieldInitMethod 是类的字段初始化器编译成的隐藏方法：new 在调用构造函数之前调用它 (先运行父类的)
+const fieldInitMethod = "@fields"
+
+// methodKey 返回方法在 Methods / StaticMethods 中的名字：访问器为隐藏的 @get_name / @set_name
+func methodKey(method *ast.FunctionLiteral) string {
+	if method.Accessor != "" {
+		return "@" + method.Accessor + "_" + method.Name
+	}
+	return method.Name
+}
+
+// accessRank 返回访问修饰符的限制程度，用于禁止子类收窄继承成员的可见性
+func accessRank(access string) int {
+	switch access {
+	case "protected":
+		return 1
+	case "private":
+		return 2
+	}
+	return 0
+}
+
+type InterfaceSymbol struct {
+	Name    string
+	Methods map[string]*ast.MethodSignature
+	Slots   []string // itable slot -> Method name, in declaration order
+}
+
+func NewFunctionScope(name string) *FunctionScope {
+	return &FunctionScope{
+		Name:         name,
+		Instructions: []string{},
+		Symbols:      make(map[string]Symbol),
+		NextLocalID:  0,
+		ParamCount:   0,
+		ParamTypes:   []DataType{},
+		ReturnType:   TypeInt,
+		LocalTypes:   make(map[int]DataType),
+	}
+}
+
+type FunctionSignature struct {
+	ParamTypes []DataType
+	ReturnType DataType
+	ParamTypeNames []string // Declared parameter types, e.g. "(int) => int"
+	ReturnTypeName string
+	Variadic       bool // The last parameter is a rest array (...args)
+	ParamNames     []string
+	Defaults       []ast.Expression // Default value per parameter (nil = required); b?: T defaults to undefined
+}
+
+// Compiler converts AST to WAT (WebAssembly Text Format)
+type Compiler struct {
+	functions      []*FunctionScope
+	current        *FunctionScope
+	imports        []string
+	stringPool     map[string]int // String literal -> memory offset
+	nextDataOffset int            // Next available memory offset
+	classes        map[string]ClassSymbol // Class name -> Symbol
+	interfaces     map[string]InterfaceSymbol // Interface name -> Symbol
+	usedItables    map[string]bool            // Interfaces whose methods are called dynamically
+	conforming     map[string]bool            // "class/interface" pairs being checked, for interfaces that mention themselves
+	currentClass   string                 // Current class being compiled
+	nextTypeID     int                    // Next available Type ID (start from 2)
+	importedFuncs  map[string]*ast.ImportStatement // Imported functions
+	definedFuncs   map[string]FunctionSignature    // Functions defined in source
+	enums          map[string]map[string]int       // Enum definitions
+	typeAliases    map[string]string               // Type aliases (Name -> TargetType)
+
+	// Type checking state
+	stackType     DataType
+	stackTypeName string // Full type of the value on stack when known (e.g. "Array<number>", class name)
+	
+	// Target platform ("wasi" or "browser")
+	target string
+
+	// Module System
+	moduleStack   []*ModuleScope
+	currentModule *ModuleScope
+	loadedModules map[string]*ModuleScope // Path -> Scope (with Exports)
+	baseDir       string                  // Base directory for resolving imports
+	
+	// Task Scheduler
+	funcIDs       map[string]int          // Function Name -> Unique ID (for Scheduler)
+	nextFuncID    int
+
+	// Closures (share the funcref table with the scheduler)
+	closureIDs       map[int]string    // Table index -> closure function name
+	closureTypes     map[string]string // call_indirect type name -> func type
+	funcRefs         map[string]int    // Named function -> table index of its closure trampoline
+	envs             []*EnvLayout
+	closureCount     int
+	expectedFuncType string // Type expected by the context of the next expression (function literals, number literals)
+
+	// Control flow
+	loopCount    int    // Counter for unique loop labels
+	pendingLabel string // Label for the next loop statement
+
+	// Static class members
+	staticInits     []staticInit  // Static field initializers not compiled yet
+	inStatic        bool          // Compiling a static method or initializer (no this)
+
+	// Generics (monomorphized: one copy per list of type arguments)
+	genericFuncs     map[string]*GenericTemplate // Prefixed name -> Generic function declaration
+	genericClasses   map[string]*GenericTemplate // Prefixed name -> Generic class declaration
+	genericInstances map[string]*GenericInstance // Instance name (e.g. Box__int) -> Instance
+	pendingInstances []*GenericInstance          // Instances whose bodies are not compiled yet
+}
+
+// GenericTemplate 泛型函数或泛型类的声明，本身不生成代码，用到时按类型实参实例化
+type GenericTemplate struct {
+	Name       string // With module prefix
+	TypeParams []*ast.TypeParameter
+	Function   *ast.FunctionLiteral
+	Class      *ast.ClassStatement
+	Module     *ModuleScope // Types in the declaration are resolved in this module
+}
+
+// GenericInstance 泛型的一个实例：声明的拷贝，其中的类型参数已替换为类型实参
+type GenericInstance struct {
+	Template *GenericTemplate
+	TypeArgs []string
+	Function *ast.FunctionLiteral
+	Class    *ast.ClassStatement
+}
+
+// staticInit 尚未编译的静态字段初始化器
+type staticInit struct {
+	Class string
+	Field *ast.FieldDefinition
+}
+
+// async 状态机在挂起点保存 / 恢复局部变量的占位指令，函数编译完成、局部变量数量确定后展开
+const (
+	asyncSpillMarker   = ";; @async spill locals"
+	asyncRestoreMarker = ";; @async restore locals"
+)
+
+func New(target string) *Compiler {
+	c := &Compiler{
+		functions:        []*FunctionScope{},
+		imports:          []string{},
+		stringPool:       make(map[string]int),
+		nextDataOffset:   undefinedPtr + len("undefined") + 1,
+		classes:          make(map[string]ClassSymbol),
+		interfaces:       make(map[string]InterfaceSymbol),
+		conforming:       make(map[string]bool),
+		usedItables:      make(map[string]bool),
+		nextTypeID:       firstClassTypeID, // 0-14 reserved (see TypeID_*), 15+=Classes
+		importedFuncs:    make(map[string]*ast.ImportStatement),
+		definedFuncs:     make(map[string]FunctionSignature),
+		enums:            make(map[string]map[string]int),
+		typeAliases:      make(map[string]string),
+		target:           target,
+		loadedModules:    make(map[string]*ModuleScope),
+		moduleStack:      []*ModuleScope{},
+		baseDir:          ".", // Default to current directory
+		funcIDs:          make(map[string]int),
+		nextFuncID:       1, // 0 reserved
+		closureIDs:       make(map[int]string),
+		closureTypes:     make(map[string]string),
+		funcRefs:         make(map[string]int),
+		genericFuncs:     make(map[string]*GenericTemplate),
+		genericClasses:   make(map[string]*GenericTemplate),
+		genericInstances: make(map[string]*GenericInstance),
+	}
+	
+	// Create main module scope
+	mainScope := &ModuleScope{
+		Path:          "main",
+		Prefix:        "",
+		SymbolAliases: make(map[string]string),
+		Exports:       make(map[string]string),
+	}
+	c.currentModule = mainScope
+	c.moduleStack = append(c.moduleStack, mainScope)
+	
+	// Add Host Interop Imports
+	c.imports = append(c.imports, `(import "env" "host_get_global" (func $host_get_global (param i32) (result i32)))`)
+	c.imports = append(c.imports, `(import "env" "host_get" (func $host_get (param i32) (param i32) (result i32)))`)
+	c.imports = append(c.imports, `(import "env" "host_set" (func $host_set (param i32) (param i32) (param i32)))`)
+	c.imports = append(c.imports, `(import "env" "host_call" (func $host_call (param i32) (param i32) (param i32) (param i32) (result i32)))`)
+	c.imports = append(c.imports, `(import "env" "host_from_int" (func $host_from_int (param i32) (result i32)))`)
+	c.imports = append(c.imports, `(import "env" "host_from_string" (func $host_from_string (param i32) (result i32)))`)
+	c.imports = append(c.imports, `(import "env" "host_to_int" (func $host_to_int (param i32) (result i32)))`)
+	c.imports = append(c.imports, `(import "env" "host_set_timeout" (func $host_set_timeout (param i32 i32)))`)
+	c.imports = append(c.imports, `(import "env" "host_await" (func $host_await (param i32 i32)))`)
+	c.imports = append(c.imports, `(import "env" "thread_spawn" (func $thread_spawn (param i32 i32) (result i32)))`)
+
+	if target == "wasi" {
+		c.imports = append(c.imports, `(import "wasi_snapshot_preview1" "environ_sizes_get" (func $environ_sizes_get (param i32 i32) (result i32)))`)
+		c.imports = append(c.imports, `(import "wasi_snapshot_preview1" "environ_get" (func $environ_get (param i32 i32) (result i32)))`)
+	}
+	
+	return c
+}
+
+func (c *Compiler) SetMainModulePath(path string) {
+	absPath, _ := filepath.Abs(path)
+	c.currentModule.Path = absPath
+	c.loadedModules[absPath] = c.currentModule
+}
+
+func (c *Compiler) emitBoxValue(typeVal DataType) {
+	// Stack: [value]
+	// Emit box_value(value, type_id)
+	if typeVal == TypeFloat {
+		c.emit("call $box_f64")
+		return
+	}
+	
+	// Convert typeVal to TypeID
+	var typeID int
+	switch typeVal {
+	case TypeInt:
+		typeID = TypeID_Int
+	case TypeString:
+		typeID = TypeID_String
+	case TypeBool:
+		typeID = TypeID_Bool
+	case TypeArray:
+		typeID = TypeID_Array
+	case TypeMap:
+		typeID = TypeID_Map
+	case TypeHost:
+		typeID = TypeID_Host
+	case TypeFunc:
+		typeID = TypeID_Closure
+	default:
+		typeID = TypeID_Unknown
+	}
+	
+	c.emit(fmt.Sprintf("i32.const %d", typeID))
+	c.emit("call $box_value")
+}
+
+// emitToUnion 把栈顶类型为 t 的值转换为联合类型的值：类实例的对象头中已有 TypeID，保持原样；
+// 其他值装箱，盒子的 TypeID 记录值的类型，$typeof / $class_name / $instance_of 据此在运行时判断
+func (c *Compiler) emitToUnion(t DataType, typeName string) {
+	if t == TypeInt && c.isObjectTypeName(typeName) {
+		return
+	}
+	c.emitBoxValue(t)
+}
+
+// newTempLocal 分配一个编译器内部使用的临时局部变量，返回其真实索引
+func (c *Compiler) newTempLocal(prefix string, t DataType) int {
+	tempIndex := c.current.NextLocalID
+	c.current.NextLocalID++
+	c.current.Symbols[fmt.Sprintf("$temp_%s_%d", prefix, tempIndex)] = Symbol{Index: tempIndex, Type: t, IsParam: false, ShadowIndex: -1}
+	if isWideType(t) {
+		c.current.LocalTypes[tempIndex] = t
+	}
+	return tempIndex + c.current.ParamCount
+}
+
+// bindTemp 把栈顶的值存入临时局部变量，返回引用它的标识符，
+// 以便用普通的成员 / 下标表达式继续编译 (可选链、解构)
+func (c *Compiler) bindTemp(prefix string, t DataType, typeName string) *ast.Identifier {
+	tempIndex := c.newTempLocal(prefix, t)
+	name := fmt.Sprintf("$temp_%s_%d", prefix, tempIndex-c.current.ParamCount)
+	sym := c.current.Symbols[name]
+	sym.TypeName = typeName
+	c.current.Symbols[name] = sym
+	c.emit(fmt.Sprintf("local.set %d", tempIndex))
+	return &ast.Identifier{Token: token.Token{Type: token.IDENT, Literal: name}, Value: name}
+}
+
+// tempLocal 返回 bindTemp 创建的临时变量的局部变量下标
+func (c *Compiler) tempLocal(temp *ast.Identifier) int {
+	return c.current.Symbols[temp.Value].Index + c.current.ParamCount
+}
+
+// importSignature 返回导入函数的 WASM 签名；只有 number (f64) 和 bigint (i64) 会改变值类型，其余按 i32 传递
+func (c *Compiler) importSignature(imp *ast.ImportStatement) FunctionSignature {
+	sig := FunctionSignature{ReturnType: TypeInt}
+	for _, p := range imp.Parameters {
+		t := TypeInt
+		if p != nil && isWideType(c.resolveType(p.Type)) {
+			t = c.resolveType(p.Type)
 		}
+		sig.ParamTypes = append(sig.ParamTypes, t)
+	}
+	if imp.ReturnType == "void" {
+		sig.ReturnType = TypeVoid
+	} else if isWideType(c.resolveType(imp.ReturnType)) {
+		sig.ReturnType = c.resolveType(imp.ReturnType)
+	}
+	return sig
+}
 
-		if err := c.Compile(node.Object); err != nil {
-			return err
+// wasmType 返回数据类型对应的 WASM 值类型
+func wasmType(t DataType) string {
+	switch t {
+	case TypeFloat:
+		return "f64"
+	case TypeBigInt:
+		return "i64"
+	}
+	return "i32"
+}
+
+// isWideType 判断值是否放在 i32 以外的 WASM 类型中 (number/bigint)：它们不是指针，不进入 shadow stack
+func isWideType(t DataType) bool {
+	return t == TypeFloat || t == TypeBigInt
+}
+
+// isObjectTypeName 判断类型名是否为类或接口 (这类值在栈上是 TypeInt 指针)
+func (c *Compiler) isObjectTypeName(typeName string) bool {
+	if i := strings.Index(typeName, "<"); i >= 0 {
+		typeName = typeName[:i]
+	}
+	names := []string{typeName}
+	if c.currentModule != nil {
+		if c.currentModule.Prefix != "" {
+			names = append(names, c.currentModule.Prefix+typeName)
 		}
-		
-		propName := node.Property.Value
-		
-		// String.length
-		if propName == "length" && c.stackType == TypeString {
-			c.emit("call $strlen")
-			c.stackType = TypeInt
-			return nil
+		if alias, ok := c.currentModule.SymbolAliases[typeName]; ok {
+			names = append(names, alias)
 		}
-
-		// Array.length
-		if propName == "length" {
-			// MVP: Assume if property is "length", it's array length
-			// In strict mode, we should check c.stackType == TypeArray
-			c.emit("call $array_length")
-			c.stackType = TypeInt
-			return nil
+	}
+	for _, name := range names {
+		if _, ok := c.classes[name]; ok {
+			return true
 		}
-		
-		// Host Object Property Get
-		if c.stackType == TypeHost {
-			// call $host_get(handle, "prop")
-			offset, ok := c.stringPool[propName]
-			if !ok {
-				offset = c.nextDataOffset
-				c.stringPool[propName] = offset
-				c.nextDataOffset += len(propName) + 1
-			}
-			c.emit(fmt.Sprintf("i32.const %d ;; \"%s\"", offset, propName))
-			c.emit("call $host_get")
-			c.stackType = TypeHost
-			return nil
+		if _, ok := c.interfaces[name]; ok {
+			return true
 		}
-		
-		// MVP: Search for property in all known classes (since we lack full type system)
-		// Better approach: Since we don't have type info on stack, we have to guess or search all classes.
-		// If multiple classes have same field name but different types, we might have issues.
-		// Ideally, we should track type of object on stack. But c.stackType is just DataType enum.
-		// If c.stackType is TypeInt (pointer), we don't know which class it is.
-		
-		offset := -1
-		var fieldType DataType = TypeInt
-		
-		found := false
-		for _, cls := range c.classes {
-			if off, ok := cls.Fields[propName]; ok {
-				offset = off
-				if ft, ok := cls.FieldTypes[propName]; ok {
-					fieldType = ft
-				}
-				found = true
-				break
-			}
+	}
+	if alias, ok := c.typeAliases[typeName]; ok && alias != typeName {
+		return c.isObjectTypeName(alias)
+	}
+	return false
+}
+
+// isPointerType 判断值是否为可以是 null / undefined 的引用
+func (c *Compiler) isPointerType(t DataType, typeName string) bool {
+	switch t {
+	case TypeString, TypeArray, TypeMap, TypeHost, TypeFunc, TypeUnion, TypeNull, TypeUndefined:
+		return true
+	case TypeInt:
+		return c.isObjectTypeName(typeName)
+	}
+	return false
+}
+
+// emitTruthy 将栈顶的值转换为条件值（非 0 即真）
+// 字符串按长度判断，空字符串为假；null / undefined 为假
+func (c *Compiler) emitTruthy(t DataType) {
+	switch t {
+	case TypeString:
+		c.emit("call $str_truthy")
+	case TypeUnion:
+		c.emit("call $union_truthy")
+	case TypeNull, TypeUndefined:
+		c.emit("drop")
+		c.emit("i32.const 0")
+	case TypeArray, TypeMap, TypeHost, TypeFunc:
+		c.emit("call $is_nullish")
+		c.emit("i32.eqz")
+	case TypeInt:
+		if c.isObjectTypeName(c.stackTypeName) {
+			c.emit("call $is_nullish")
+			c.emit("i32.eqz")
 		}
-		
-		if !found {
-			return fmt.Errorf("unknown property: %s", propName)
+	case TypeFloat:
+		c.emit("f64.const 0")
+		c.emit("f64.ne")
+	case TypeBigInt:
+		c.emit("i64.const 0")
+		c.emit("i64.ne")
+	}
+}
+
+// emitConvert 将栈顶的值从 from 转换为 to (int -> number 的提升，以及存入联合类型时装箱)
+// bigint 与其他数值类型之间不做隐式转换
+func (c *Compiler) emitConvert(from, to DataType, context string) error {
+	if from == TypeNull || from == TypeUndefined {
+		switch to {
+		case TypeFloat, TypeBigInt, TypeBool:
+			return fmt.Errorf("cannot use %s as %s in %s", from, typeNameOf(to, ""), context)
 		}
-		
-		c.emit(fmt.Sprintf("i32.const %d", offset))
-		c.emit("i32.add")
-		c.emit("i32.load")
-		c.stackType = fieldType
 		return nil
+	}
+	if to == TypeUnion && from != TypeUnion && from != TypeUnknown {
+		if from == TypeBigInt {
+			return fmt.Errorf("bigint cannot be stored in a union type yet (%s)", context)
+		}
+		c.emitToUnion(from, c.stackTypeName)
+		return nil
+	}
+	if (from == TypeBigInt) != (to == TypeBigInt) {
+		switch {
+		case from == TypeBigInt && (to == TypeUnion || to == TypeUnknown):
+		case from == TypeBigInt:
+			return fmt.Errorf("cannot use bigint as %s in %s", to, context)
+		default:
+			return fmt.Errorf("cannot use %s as bigint in %s", from, context)
+		}
+	}
+	if to == TypeFloat && from != TypeFloat {
+		if from != TypeInt && from != TypeBool {
+			return fmt.Errorf("cannot use %s as number in %s", from, context)
+		}
+		c.emit("f64.convert_i32_s")
+		return nil
+	}
+	if from == TypeFloat && to != TypeFloat && to != TypeUnion && to != TypeUnknown {
+		return fmt.Errorf("cannot use number as %s in %s", to, context)
+	}
+	return nil
+}
 
-	case *ast.AssignmentExpression:
-		// Handle MemberExpression assignment: obj.prop = val
-		if member, ok := node.Left.(*ast.MemberExpression); ok {
-			if err := c.Compile(member.Object); err != nil {
-				return err
-			}
-			targetType := c.stackType
-			
-			propName := member.Property.Value
-			
-			// Host Object Property Set
-			if targetType == TypeHost {
-				// call $host_set(handle, "prop", value)
-				offset, ok := c.stringPool[propName]
-				if !ok {
-					offset = c.nextDataOffset
-					c.stringPool[propName] = offset
-					c.nextDataOffset += len(propName) + 1
-				}
-				c.emit(fmt.Sprintf("i32.const %d ;; \"%s\"", offset, propName))
-				
-				// Value
-				if err := c.Compile(node.Value); err != nil {
+// compileArgs 编译调用参数，按形参类型做 int -> number 提升
+// 函数类型的形参为参数位置上的箭头函数提供参数类型
+func (c *Compiler) compileArgs(args []ast.Expression, sig FunctionSignature, context string) error {
+	args = expandSpreadLiterals(args)
+	paramTypes := sig.ParamTypes
+	fixed := len(paramTypes)
+	if sig.Variadic {
+		fixed--
+	}
+	// Defaults that refer to earlier parameters read them from temps
+	var bound map[string]Symbol
+	if len(args) < fixed && defaultsUseParams(sig) {
+		bound = make(map[string]Symbol)
+	}
+	for i, arg := range args {
+		if sig.Variadic && i == fixed {
+			// Remaining arguments are collected into the rest array
+			return c.compileRestArgs(args[i:], sig.ParamTypeNames[fixed], context)
+		}
+		if _, ok := arg.(*ast.SpreadElement); ok {
+			return spreadArgError(context)
+		}
+		if i < len(sig.ParamTypeNames) {
+			c.expectedFuncType = sig.ParamTypeNames[i]
+		}
+		err := c.Compile(arg)
+		c.expectedFuncType = ""
+		if err != nil {
+			return err
+		}
+		if i < len(paramTypes) {
+			what := fmt.Sprintf("argument %d of %s", i+1, context)
+			if i < len(sig.ParamTypeNames) {
+				if err := c.checkAssignable(c.stackType, c.stackTypeName, sig.ParamTypeNames[i], what); err != nil {
 					return err
 				}
-				valueType := c.stackType
-				
-				// Auto-convert value if needed
-				if valueType == TypeString {
-					c.emit("call $host_from_string")
-				} else if valueType == TypeInt || valueType == TypeBool {
-					c.emit("call $host_from_int")
-				}
-				// If it's TypeHost, it's already a handle
-				
-				c.emit("call $host_set")
-				c.emit("i32.const 0")
-				return nil
 			}
-			
-			// Check if we can find property in classes
-			offset := -1
-			for _, cls := range c.classes {
-				if off, ok := cls.Fields[propName]; ok {
-					offset = off
-					break
-				}
+			if err := c.emitConvert(c.stackType, paramTypes[i], what); err != nil {
+				return err
 			}
-			
-			if offset != -1 {
-				c.emit(fmt.Sprintf("i32.const %d", offset))
-				c.emit("i32.add")
-				
-				if err := c.Compile(node.Value); err != nil {
-					return err
-				}
-				
-				c.emit("i32.store")
-				c.emit("i32.const 0") // Assignment returns 0 (void)
-				return nil
+			if bound != nil {
+				bound[sig.ParamNames[i]] = c.keepArg(paramTypes[i], sig.ParamTypeNames[i])
 			}
-			
-			return fmt.Errorf("unknown property in assignment: %s", propName)
+		} else if isWideType(c.stackType) {
+			return fmt.Errorf("cannot pass %s as argument %d of %s", typeNameOf(c.stackType, ""), i+1, context)
 		}
-		
-		// Handle IndexExpression assignment: arr[i] = val, map["k"] = val
-		if indexExpr, ok := node.Left.(*ast.IndexExpression); ok {
-			if err := c.Compile(indexExpr.Left); err != nil {
-				return err
+	}
+
+	// Missing arguments take their default values, evaluated at the call site;
+	// without one (compileSlotArgs) the callee fills it in and gets a zero here
+	for i := len(args); i < fixed && i < len(sig.Defaults); i++ {
+		if sig.Defaults[i] == nil {
+			c.emit(wasmType(paramTypes[i]) + ".const 0")
+			continue
+		}
+		if err := c.compileDefaultArg(sig, i, bound, context); err != nil {
+			return err
+		}
+	}
+	if sig.Variadic && len(args) <= fixed {
+		// No rest arguments: pass an empty array
+		c.emit("i32.const 0")
+		c.emit("call $array_new")
+	}
+	return nil
+}
+
+// compileSlotArgs 编译经过 vtable 槽位的调用的实参：省略的参数传零值，最后传入实参个数，
+// 由实际调用的方法的 thunk 填入它自己的默认值
+func (c *Compiler) compileSlotArgs(args []ast.Expression, sig FunctionSignature, context string) error {
+	fixed := len(sig.ParamTypes)
+	if sig.Variadic {
+		fixed--
+	}
+	// Spreads only reach the rest parameter, so every fixed argument is written out
+	argc := len(expandSpreadLiterals(args))
+	if argc > fixed {
+		argc = fixed
+	}
+	omitted := sig
+	omitted.Defaults = make([]ast.Expression, len(sig.Defaults))
+	if err := c.compileArgs(args, omitted, context); err != nil {
+		return err
+	}
+	c.emit(fmt.Sprintf("i32.const %d ;; argc", argc))
+	return nil
+}
+
+// checkArgCount 检查实参个数；可选参数和带默认值的参数可以省略，剩余参数接受任意多个实参
+// 展开实参 (...arr) 的长度在运行时才知道，只能传给剩余参数 (数组字面量按其元素计算)
+func checkArgCount(sig FunctionSignature, args []ast.Expression, context string) error {
+	fixed := len(sig.ParamTypes)
+	if sig.Variadic {
+		fixed--
+	}
+	count := 0
+	for i, arg := range expandSpreadLiterals(args) {
+		if _, ok := arg.(*ast.SpreadElement); ok {
+			if !sig.Variadic || i < fixed {
+				return spreadArgError(context)
 			}
-			targetType := c.stackType
-			
-			if err := c.Compile(indexExpr.Index); err != nil {
-				return err
+			continue
+		}
+		count++
+	}
+	required := 0
+	for i := 0; i < fixed; i++ {
+		if i >= len(sig.Defaults) || sig.Defaults[i] == nil {
+			required = i + 1
+		}
+	}
+	tooFew := count < required
+	switch {
+	case sig.Variadic && tooFew:
+		return fmt.Errorf("function %s expects at least %d arguments, got %d", context, required, count)
+	case !sig.Variadic && (tooFew || count > fixed):
+		if required < fixed {
+			return fmt.Errorf("function %s expects %d to %d arguments, got %d", context, required, fixed, count)
+		}
+		return fmt.Errorf("function %s expects %d arguments, got %d", context, fixed, count)
+	}
+	return nil
+}
+
+// defaultsUseParams 判断参数默认值是否引用了其他参数，例如 function f(a: int, b = a * 2)
+func defaultsUseParams(sig FunctionSignature) bool {
+	params := make(map[string]bool)
+	for _, name := range sig.ParamNames {
+		params[name] = true
+	}
+	uses := false
+	for _, def := range sig.Defaults {
+		ast.Inspect(def, func(n ast.Node) bool {
+			if ident, ok := n.(*ast.Identifier); ok && params[ident.Value] {
+				uses = true
 			}
-			indexType := c.stackType
-			
-			// Value to set
-			if err := c.Compile(node.Value); err != nil {
+			return !uses
+		})
+	}
+	return uses
+}
+
+// defaultAssignable 检查默认值的类型与参数类型是否一致 (int 可以提升为 number，null / undefined 由 emitConvert 检查)
+func defaultAssignable(from, to DataType) bool {
+	switch {
+	case from == to, from == TypeUnknown, to == TypeUnknown, to == TypeUnion, to == TypeHost:
+		return true
+	case from == TypeInt && to == TypeFloat:
+		return true
+	case from == TypeNull, from == TypeUndefined:
+		return true
+	}
+	return false
+}
+
+// keepArg 把栈顶的实参存入临时变量后重新压栈，供后面参数的默认值引用
+func (c *Compiler) keepArg(t DataType, typeName string) Symbol {
+	temp := c.bindTemp("arg", t, typeName)
+	c.emit(fmt.Sprintf("local.get %d", c.tempLocal(temp)))
+	return c.current.Symbols[temp.Value]
+}
+
+// compileDefaultArg 在调用处编译第 i 个参数的默认值；bound 中的参数名在编译期间遮蔽调用者的同名变量
+func (c *Compiler) compileDefaultArg(sig FunctionSignature, i int, bound map[string]Symbol, context string) error {
+	def := sig.Defaults[i]
+	usesThis := false
+	ast.Inspect(def, func(n ast.Node) bool {
+		switch n.(type) {
+		case *ast.ThisExpression, *ast.SuperExpression:
+			usesThis = true
+		}
+		return !usesThis
+	})
+	if usesThis {
+		return fmt.Errorf("default value of parameter %s of %s cannot use this", sig.ParamNames[i], context)
+	}
+
+	shadowed := make(map[string]Symbol)
+	for name, sym := range bound {
+		if old, ok := c.current.Symbols[name]; ok {
+			shadowed[name] = old
+		}
+		c.current.Symbols[name] = sym
+	}
+	c.expectedFuncType = sig.ParamTypeNames[i]
+	err := c.Compile(def)
+	c.expectedFuncType = ""
+	for name := range bound {
+		if old, ok := shadowed[name]; ok {
+			c.current.Symbols[name] = old
+		} else {
+			delete(c.current.Symbols, name)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	what := fmt.Sprintf("default value of parameter %s of %s", sig.ParamNames[i], context)
+	if !defaultAssignable(c.stackType, sig.ParamTypes[i]) {
+		return fmt.Errorf("cannot use %s as %s in %s", typeNameOf(c.stackType, c.stackTypeName), sig.ParamTypeNames[i], what)
+	}
+	if err := c.emitConvert(c.stackType, sig.ParamTypes[i], what); err != nil {
+		return err
+	}
+	if bound != nil {
+		bound[sig.ParamNames[i]] = c.keepArg(sig.ParamTypes[i], sig.ParamTypeNames[i])
+	}
+	return nil
+}
+
+// compileRestArgs 把多余的实参收集到新数组中，作为剩余参数传入
+// 展开实参 (...arr) 整体追加到数组末尾
+func (c *Compiler) compileRestArgs(args []ast.Expression, restTypeName string, context string) error {
+	elemTypeName := c.elementTypeName(restTypeName)
+	elemType := c.resolveType(elemTypeName)
+	if elemTypeName == "" {
+		elemType = TypeUnknown
+	}
+
+	c.emit(fmt.Sprintf("i32.const %d", len(args)))
+	c.emit("call $array_new")
+	rest := c.bindTemp("rest", TypeArray, restTypeName)
+	for _, arg := range args {
+		if err := c.Compile(rest); err != nil {
+			return err
+		}
+		if spread, ok := arg.(*ast.SpreadElement); ok {
+			if err := c.Compile(spread.Argument); err != nil {
 				return err
 			}
-			
-			if targetType == TypeArray {
-				c.emit("call $array_set")
-			} else if targetType == TypeMap || indexType == TypeString {
-				c.emit("call $map_set")
-			} else {
-				// Fallback
-				c.emit("call $array_set")
+			if err := c.checkSpreadSource(c.stackType, c.stackTypeName, elemType, context); err != nil {
+				return err
 			}
-			
-			c.emit("i32.const 0")
-			return nil
+			c.emit("call $array_push_all")
+			continue
 		}
-		
-		// Handle Identifier assignment: x = val
-		if ident, ok := node.Left.(*ast.Identifier); ok {
-			if err := c.Compile(node.Value); err != nil {
+		c.expectedFuncType = elemTypeName
+		err := c.Compile(arg)
+		c.expectedFuncType = ""
+		if err != nil {
+			return err
+		}
+		if elemType != TypeUnknown && elemType != TypeFloat {
+			if err := c.emitConvert(c.stackType, elemType, "rest argument of "+context); err != nil {
 				return err
 			}
-			
-			sym, ok := c.current.Symbols[ident.Value]
-			if !ok {
-				return fmt.Errorf("undefined variable: %s", ident.Value)
+			c.stackType = elemType
+		}
+		if err := c.emitStoreElement(c.stackType, elemType); err != nil {
+			return err
+		}
+		c.emit("call $array_push")
+	}
+	return c.Compile(rest)
+}
+
+// expandSpreadLiterals 把展开的数组字面量 f(...[1, 2]) 换成其中的元素，长度在编译期已知
+func expandSpreadLiterals(args []ast.Expression) []ast.Expression {
+	var out []ast.Expression
+	for i, arg := range args {
+		spread, ok := arg.(*ast.SpreadElement)
+		if !ok {
+			if out != nil {
+				out = append(out, arg)
 			}
-			
-			realIndex := sym.Index
-			if !sym.IsParam {
-				realIndex += c.current.ParamCount
+			continue
+		}
+		lit, ok := spread.Argument.(*ast.ArrayLiteral)
+		if !ok || hasSpread(lit.Elements) {
+			if out != nil {
+				out = append(out, arg)
 			}
-			
-			c.emit(fmt.Sprintf("local.set %d", realIndex))
-			c.emit(fmt.Sprintf("local.get %d", realIndex))
-			
-			// Update shadow stack: value = stack[shadowBase + shadowIndex*4]
-			// We have shadowBase in local(shadowBaseIndex).
-			shadowBaseIndex := c.current.ParamCount
-			
-			c.emit(fmt.Sprintf("local.get %d ;; shadow base", shadowBaseIndex))
-			c.emit(fmt.Sprintf("i32.const %d", sym.ShadowIndex * 4))
-			c.emit("i32.add")
-			c.emit(fmt.Sprintf("local.get %d ;; value", realIndex))
-			c.emit("i32.store")
-			
-			// Assignment returns the value (TS/JS behavior)
-			// Stack already has the value from local.get above
-			c.stackType = sym.Type
-			return nil
+			continue
 		}
-		
-		return fmt.Errorf("invalid assignment target")
+		if out == nil {
+			out = append([]ast.Expression{}, args[:i]...)
+		}
+		out = append(out, lit.Elements...)
+	}
+	if out == nil {
+		return args
+	}
+	return out
+}
 
-	case *ast.ThisExpression:
-		// 'this' is always param 0
-		c.emit("local.get 0 ;; this")
-		c.stackType = TypeInt
-		return nil
+func hasSpread(exprs []ast.Expression) bool {
+	for _, e := range exprs {
+		if _, ok := e.(*ast.SpreadElement); ok {
+			return true
+		}
+	}
+	return false
+}
 
-	case *ast.Identifier:
-		sym, ok := c.current.Symbols[node.Value]
-		if ok {
-			realIndex := sym.Index
-			if !sym.IsParam {
-				realIndex += c.current.ParamCount
-			}
+// spreadArgError 报告不能展开到固定参数的数组：它的长度在运行时才知道 (与 TypeScript 相同)
+func spreadArgError(context string) error {
+	return fmt.Errorf("a spread argument of %s must be passed to a rest parameter: the array's length is not known at compile time", context)
+}
 
-			c.emit(fmt.Sprintf("local.get %d ;; %s (%s)", realIndex, node.Value, sym.Type))
-			c.stackType = sym.Type
-		} else {
-			// If not found in locals, check if it's a known class (constructor) or global
-			if _, ok := c.classes[node.Value]; ok {
-				// It's a class name, but we are using it as a value?
-				// Maybe static method call? Not supported yet.
-				return fmt.Errorf("class usage as value not supported: %s", node.Value)
-			}
-			
-			// Assume implicit global (Host Object)
-			// call $host_get_global("name")
-			offset, ok := c.stringPool[node.Value]
-			if !ok {
-				offset = c.nextDataOffset
-				c.stringPool[node.Value] = offset
-				c.nextDataOffset += len(node.Value) + 1
-			}
-			c.emit(fmt.Sprintf("i32.const %d ;; \"%s\"", offset, node.Value))
-			c.emit("call $host_get_global")
-			c.stackType = TypeHost
-		}
+// compileObjectSpread 编译 Map 字面量中的 ...expr，在栈上留下要复制的 Map
+// 类实例按字段声明顺序复制到一个新的 Map 中
+func (c *Compiler) compileObjectSpread(spread *ast.SpreadElement) error {
+	if err := c.Compile(spread.Argument); err != nil {
+		return err
+	}
+	if c.stackType == TypeMap {
+		return nil
+	}
+	classSym, ok := c.classes[c.stackTypeName]
+	if !ok || c.stackType != TypeInt {
+		return fmt.Errorf("cannot spread %s in a map literal", typeNameOf(c.stackType, c.stackTypeName))
+	}
+	obj := c.bindTemp("spread", TypeInt, classSym.Name)
 
-	case *ast.TryStatement:
-		c.emit("try")
-		// c.emit("do") // Legacy try does not use 'do'
-		
-		if err := c.Compile(node.Body); err != nil {
+	fields := make([]string, 0, len(classSym.Fields))
+	for name := range classSym.Fields {
+		fields = append(fields, name)
+	}
+	sort.Slice(fields, func(i, j int) bool { return classSym.Fields[fields[i]] < classSym.Fields[fields[j]] })
+
+	c.emit("call $map_new")
+	result := c.bindTemp("spread_map", TypeMap, "")
+	for _, name := range fields {
+		if err := c.Compile(result); err != nil {
 			return err
 		}
-		
-		c.emit("catch $exception")
-		if node.Catch != nil {
-			// Stack has exception payload (i32)
-			index := c.current.NextLocalID
-			c.current.NextLocalID++
-			
-			c.current.Symbols[node.CatchVar] = Symbol{
-				Index: index,
-				Type: TypeInt, 
-				IsParam: false,
-				ShadowIndex: -1,
-			}
-			realIndex := index + c.current.ParamCount
-			c.emit(fmt.Sprintf("local.set %d", realIndex))
-			
-			if err := c.Compile(node.Catch); err != nil {
-				return err
-			}
-		} else {
-			c.emit("drop") 
+		if err := c.Compile(&ast.StringLiteral{Token: spread.Token, Value: name}); err != nil {
+			return err
 		}
-		
-		c.emit("end")
-		
-		if node.Finally != nil {
-			if err := c.Compile(node.Finally); err != nil {
-				return err
-			}
+		field := &ast.MemberExpression{Token: spread.Token, Object: obj, Property: &ast.Identifier{Token: spread.Token, Value: name}}
+		if err := c.Compile(field); err != nil {
+			return err
 		}
-		return nil
+		if err := c.emitStoreElement(c.stackType, TypeUnknown); err != nil {
+			return err
+		}
+		c.emit("call $map_set")
+	}
+	if err := c.Compile(result); err != nil {
+		return err
+	}
+	c.stackTypeName = ""
+	return nil
+}
 
-	case *ast.ThrowStatement:
-		if err := c.Compile(node.Value); err != nil {
+// checkSpreadSource 检查展开的来源是数组，且元素的存储方式与目标数组一致 (number 元素是装箱的)
+func (c *Compiler) checkSpreadSource(t DataType, typeName string, elemType DataType, context string) error {
+	if t != TypeArray {
+		return fmt.Errorf("cannot spread %s in %s", typeNameOf(t, typeName), context)
+	}
+	srcFloat := c.resolveType(c.elementTypeName(typeName)) == TypeFloat
+	if srcFloat != (elemType == TypeFloat) {
+		return fmt.Errorf("cannot spread %s into Array<%s> in %s", typeNameOf(t, typeName), typeNameOf(elemType, ""), context)
+	}
+	return nil
+}
+
+// emitStoreElement 将栈顶的值按容器元素的存储方式转换（数组/Map 的槽位是 i32，number 需要装箱，联合类型的元素按联合类型装箱）
+func (c *Compiler) emitStoreElement(valueType DataType, elemType DataType) error {
+	if elemType == TypeBigInt || valueType == TypeBigInt {
+		return fmt.Errorf("bigint values cannot be stored in arrays or maps yet")
+	}
+	if elemType == TypeUnion {
+		return c.emitConvert(valueType, TypeUnion, "collection element")
+	}
+	if elemType == TypeFloat || valueType == TypeFloat {
+		if err := c.emitConvert(valueType, TypeFloat, "collection element"); err != nil {
 			return err
 		}
-		c.emit("throw $exception")
-		c.stackType = TypeVoid
-		return nil
+		c.emit("call $box_f64")
+	}
+	return nil
+}
 
-	case *ast.PrefixExpression:
-		if node.Operator == "typeof" {
-			if err := c.Compile(node.Right); err != nil {
-				return err
-			}
-			// Stack: [value]
-			
-			// Runtime check
-			if c.stackType == TypeUnion {
-				c.emit("call $typeof") // Returns TypeID
-				
-				// Map TypeID to String
-				// 1 -> "int", 2 -> "string", ...
-				// We need a helper function or switch here.
-				// For MVP, let's just return "int" or "string" for now?
-				// Or better, let $typeof return the string pointer directly?
-				// But $typeof is general purpose.
-				
-				// Let's implement TypeID to String mapping inline or helper
-				// Or change $typeof to return string ptr?
-				// TypeID is better for comparisons.
-				
-				// For now, let's just assume we return the TypeID as int for debugging
-				// But typeof returns string "number", "string", "object", etc.
-				
-				// HACK: Return string based on TypeID
-				// Since we can't easily switch in WASM without block structure,
-				// let's just use a simple series of `if`.
-				
-				// Stack: [TypeID]
-				// We need to convert this to string ptr.
-				// For MVP, since we don't have switch/if chains generation yet easily here,
-				// let's assume we map:
-				// 1 (int) -> "number"
-				// 2 (string) -> "string"
-				// 3 (bool) -> "boolean"
-				// 4 (array) -> "object"
-				// 5 (map) -> "object"
-				// 6 (host) -> "object"
-				// 7 (union) -> "object"
-				
-				// We really need a runtime helper `type_id_to_string` in stdLibWAT.
-				// But strings are in data segment.
-				
-				// Let's implement a quick check for common types.
-				// We will drop TypeID and push "object" for now as default.
-				// This explains why we see "object" for int/string in union test.
-				// To fix this, we need real logic.
-				
-				// Let's use `host_from_int` to debug print it? No.
-				
-				c.emit("drop") // drop typeid
-				
-				offset, ok := c.stringPool["object"]
-				if !ok {
-					offset = c.nextDataOffset
-					c.stringPool["object"] = offset
-					c.nextDataOffset += len("object") + 1
+// emitLoadElement 按元素类型解释从数组 / Map 槽位读出的 i32
+// number 元素是装箱的；其他有类型的元素按原样读取
+func (c *Compiler) emitLoadElement(elemTypeName string) {
+	c.stackType = TypeInt
+	switch elemType := c.resolveType(elemTypeName); {
+	case elemTypeName == "":
+	case elemType == TypeFloat:
+		c.emit("call $unbox_f64")
+		c.stackType = TypeFloat
+	case elemType == TypeString, elemType == TypeBool, elemType == TypeArray, elemType == TypeMap, elemType == TypeFunc, elemType == TypeUnion:
+		c.stackType = elemType
+	}
+	c.stackTypeName = elemTypeName
+}
+
+// elementTypeName 返回容器类型的元素类型名: Array<T> -> T, Map<K, V> -> V
+func (c *Compiler) elementTypeName(typeName string) string {
+	if alias, ok := c.typeAliases[typeName]; ok {
+		return c.elementTypeName(alias)
+	}
+	if !strings.HasSuffix(typeName, ">") {
+		return ""
+	}
+	inner := ""
+	if strings.HasPrefix(typeName, "Array<") {
+		inner = typeName[len("Array<") : len(typeName)-1]
+	} else if strings.HasPrefix(typeName, "Map<") {
+		inner = typeName[len("Map<") : len(typeName)-1]
+		// Split at the top-level comma
+		depth := 0
+		for i, ch := range inner {
+			switch ch {
+			case '<':
+				depth++
+			case '>':
+				depth--
+			case ',':
+				if depth == 0 {
+					return strings.TrimSpace(inner[i+1:])
 				}
-				c.emit(fmt.Sprintf("i32.const %d", offset))
-				c.stackType = TypeString
-				return nil
-			}
-			
-			// Compile-time known type
-			var typeStr string
-			switch c.stackType {
-			case TypeInt:
-				typeStr = "number" // JS convention
-			case TypeString:
-				typeStr = "string"
-			case TypeBool:
-				typeStr = "boolean"
-			case TypeArray:
-				typeStr = "object"
-			case TypeMap:
-				typeStr = "object"
-			case TypeHost:
-				typeStr = "object" // or "host"
-			default:
-				typeStr = "undefined"
-			}
-			
-			c.emit("drop") // drop value
-			
-			offset, ok := c.stringPool[typeStr]
-			if !ok {
-				offset = c.nextDataOffset
-				c.stringPool[typeStr] = offset
-				c.nextDataOffset += len(typeStr) + 1
 			}
-			c.emit(fmt.Sprintf("i32.const %d", offset))
-			c.stackType = TypeString
-			return nil
 		}
+		return ""
+	}
+	return strings.TrimSpace(inner)
+}
 
-		if err := c.Compile(node.Right); err != nil {
-			return err
+// resolveElementType 返回容器元素的数据类型，未知时为 TypeUnknown
+func (c *Compiler) resolveElementType(typeName string) DataType {
+	elem := c.elementTypeName(typeName)
+	if elem == "" {
+		return TypeUnknown
+	}
+	return c.resolveType(elem)
+}
+
+// lookupField 查找字段；优先使用静态已知的类名，否则在所有类中搜索
+func (c *Compiler) lookupField(className string, propName string) (int, DataType, string, bool) {
+	if cls, ok := c.classes[className]; ok {
+		if off, ok := cls.Fields[propName]; ok {
+			return off, cls.FieldTypes[propName], cls.FieldTypeNames[propName], true
 		}
-		
-		switch node.Operator {
-		case "!":
-			c.emit("i32.eqz")
-			c.stackType = TypeBool
-		case "-":
-			if c.stackType != TypeInt {
-				return fmt.Errorf("operator - not defined for type %s", c.stackType)
-			}
-			c.emit("i32.const -1")
-			c.emit("i32.mul")
-			c.stackType = TypeInt
-		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+	}
+	for _, cls := range c.classes {
+		if off, ok := cls.Fields[propName]; ok {
+			return off, cls.FieldTypes[propName], cls.FieldTypeNames[propName], true
 		}
+	}
+	return -1, TypeUnknown, "", false
+}
 
-	case *ast.InfixExpression:
-		if err := c.Compile(node.Left); err != nil {
+// compileStaticCall 编译静态方法调用 Class.name(args)，没有 this 参数
+func (c *Compiler) compileStaticCall(cls ClassSymbol, name string, node *ast.CallExpression) error {
+	mangledName, ok := cls.StaticMethods[name]
+	if !ok {
+		return fmt.Errorf("line %d: class %s has no static method %s", node.Token.Line, cls.Name, name)
+	}
+	if err := c.accessError(cls.StaticMembers[name], name, node.Token.Line); err != nil {
+		return err
+	}
+	sig := cls.StaticMethodSigs[name]
+	if err := checkArgCount(sig, node.Arguments, cls.Name+"."+name); err != nil {
+		return err
+	}
+	if err := c.compileArgs(node.Arguments, sig, cls.Name+"."+name); err != nil {
+		return err
+	}
+	c.emit(fmt.Sprintf("call $%s", mangledName))
+	c.setCallResult(sig)
+	return nil
+}
+
+// compileSetterCall 调用 set 访问器 (实例访问器的对象已在栈上，className 为其类型，静态访问器为空)；赋值表达式的值为传入的值
+func (c *Compiler) compileSetterCall(className, key, setter string, sig FunctionSignature, value ast.Expression) error {
+	var valueType DataType
+	var valueTypeName string
+	var tempIndex int
+	err := c.emitMethodCall(className, key, setter, sig, func(argc bool) error {
+		if err := c.Compile(value); err != nil {
 			return err
 		}
-		leftType := c.stackType
-
-		if err := c.Compile(node.Right); err != nil {
+		valueType, valueTypeName = c.stackType, c.stackTypeName
+		if err := c.emitConvert(valueType, sig.ParamTypes[0], "setter "+setter); err != nil {
 			return err
 		}
-		rightType := c.stackType
+		if isWideType(sig.ParamTypes[0]) {
+			valueType = sig.ParamTypes[0]
+		}
+		tempIndex = c.newTempLocal("assign", valueType)
+		c.emit(fmt.Sprintf("local.tee %d", tempIndex))
+		if argc {
+			c.emit("i32.const 1 ;; argc")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	c.emit("drop")
+	c.emit(fmt.Sprintf("local.get %d", tempIndex))
+	c.stackType = valueType
+	c.stackTypeName = valueTypeName
+	return nil
+}
 
-		switch node.Operator {
-		case "+":
-			if leftType == TypeInt && rightType == TypeInt {
-				c.emit("i32.add")
-				c.stackType = TypeInt
-			} else if leftType == TypeString && rightType == TypeString {
-				c.emit("call $str_concat")
-				c.stackType = TypeString
-			} else if leftType == TypeString && rightType == TypeInt {
-				// String + Int
-				c.emit("call $itos")
-				c.emit("call $str_concat")
-				c.stackType = TypeString
-			} else if leftType == TypeInt && rightType == TypeString {
-				// Int + String
-				// Swap via temp local
-				tempIndex := c.current.NextLocalID
-				c.current.NextLocalID++
-				realTempIndex := tempIndex + c.current.ParamCount
-				c.current.Symbols[fmt.Sprintf("$temp_swap_%d", tempIndex)] = Symbol{Index: tempIndex, Type: TypeString, IsParam: false, ShadowIndex: -1}
-				
-				c.emit(fmt.Sprintf("local.set %d", realTempIndex)) // Pop string
-				c.emit("call $itos")                      // Convert int
-				c.emit(fmt.Sprintf("local.get %d", realTempIndex)) // Push string back
-				c.emit("call $str_concat")
-				c.stackType = TypeString
-			} else {
-				return fmt.Errorf("operator + not defined for types %s and %s", leftType, rightType)
-			}
-		case "-":
-			if leftType == TypeInt && rightType == TypeInt {
-				c.emit("i32.sub")
-				c.stackType = TypeInt
-			} else {
-				return fmt.Errorf("operator - not defined for types %s and %s", leftType, rightType)
-			}
-		case "*":
-			if leftType == TypeInt && rightType == TypeInt {
-				c.emit("i32.mul")
-				c.stackType = TypeInt
-			} else {
-				return fmt.Errorf("operator * not defined for types %s and %s", leftType, rightType)
+// lookupAccessor 查找访问器方法 (key 为 @get_name / @set_name)，类未知时搜索所有类
+func (c *Compiler) lookupAccessor(className string, key string) (string, FunctionSignature, bool) {
+	if cls, ok := c.classes[className]; ok {
+		mangledName, ok := cls.Methods[key]
+		return mangledName, cls.MethodSigs[key], ok
+	}
+	for _, cls := range c.classes {
+		if mangledName, ok := cls.Methods[key]; ok {
+			return mangledName, cls.MethodSigs[key], true
+		}
+	}
+	return "", FunctionSignature{}, false
+}
+
+// staticClass 判断表达式是否为类名 (Class.member 的对象部分)，局部变量优先
+func (c *Compiler) staticClass(expr ast.Expression) (ClassSymbol, bool) {
+	ident, ok := expr.(*ast.Identifier)
+	if !ok {
+		return ClassSymbol{}, false
+	}
+	if _, _, ok := c.lookupVariable(ident.Value); ok {
+		return ClassSymbol{}, false
+	}
+	cls, ok := c.classes[c.resolveClassName(ident.Value)]
+	return cls, ok
+}
+
+// compileAside 编译表达式但先不输出，返回其指令与结果类型 (用于在生成分支前确定结果类型)
+func (c *Compiler) compileAside(node ast.Expression) ([]string, DataType, string, error) {
+	start := len(c.current.Instructions)
+	if err := c.Compile(node); err != nil {
+		return nil, TypeUnknown, "", err
+	}
+	code := append([]string{}, c.current.Instructions[start:]...)
+	c.current.Instructions = c.current.Instructions[:start]
+	return code, c.stackType, c.stackTypeName, nil
+}
+
+// unionMembers 在最外层的 | 处分割联合类型名
+func unionMembers(typeName string) []string {
+	var members []string
+	depth, last := 0, 0
+	for i, ch := range typeName {
+		switch ch {
+		case '(', '<':
+			depth++
+		case ')', '>':
+			depth--
+		case '|':
+			if depth == 0 {
+				members = append(members, strings.TrimSpace(typeName[last:i]))
+				last = i + 1
 			}
-		case "/":
-			if leftType == TypeInt && rightType == TypeInt {
-				c.emit("i32.div_s")
-				c.stackType = TypeInt
-			} else {
-				return fmt.Errorf("operator / not defined for types %s and %s", leftType, rightType)
+		}
+	}
+	return append(members, strings.TrimSpace(typeName[last:]))
+}
+
+// unifyBranches 返回两个分支值的公共类型：相同类型保持不变，int 与 number 提升为 number，
+// null / undefined 与引用类型合并为该引用类型，与值类型合并为联合类型
+func (c *Compiler) unifyBranches(aType DataType, aName string, bType DataType, bName string) (DataType, string, error) {
+	isNullish := func(t DataType) bool { return t == TypeNull || t == TypeUndefined }
+	switch {
+	case aType == bType:
+		if aName == "" {
+			aName = bName
+		}
+		return aType, aName, nil
+	case (aType == TypeInt && bType == TypeFloat) || (aType == TypeFloat && bType == TypeInt):
+		return TypeFloat, "", nil
+	case aType == TypeVoid || bType == TypeVoid || aType == TypeBigInt || bType == TypeBigInt:
+	case isNullish(aType) && c.isPointerType(bType, bName):
+		return bType, bName, nil
+	case isNullish(bType) && c.isPointerType(aType, aName):
+		return aType, aName, nil
+	default:
+		return TypeUnion, typeNameOf(aType, aName) + "|" + typeNameOf(bType, bName), nil
+	}
+	return TypeUnknown, "", fmt.Errorf("incompatible branch types %s and %s", typeNameOf(aType, aName), typeNameOf(bType, bName))
+}
+
+// compileConditional 编译三元表达式 cond ? a : b
+func (c *Compiler) compileConditional(node *ast.ConditionalExpression) error {
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+	c.emitTruthy(c.stackType)
+
+	consequence, consType, consName, err := c.compileAside(node.Consequence)
+	if err != nil {
+		return err
+	}
+	alternative, altType, altName, err := c.compileAside(node.Alternative)
+	if err != nil {
+		return err
+	}
+
+	if consType == TypeVoid && altType == TypeVoid {
+		c.emit("if")
+		c.current.Instructions = append(c.current.Instructions, consequence...)
+		c.emit("else")
+		c.current.Instructions = append(c.current.Instructions, alternative...)
+		c.emit("end")
+		c.stackType = TypeVoid
+		return nil
+	}
+	resultType, resultName, err := c.unifyBranches(consType, consName, altType, altName)
+	if err != nil {
+		return fmt.Errorf("conditional expression: %v", err)
+	}
+
+	c.emit(fmt.Sprintf("if (result %s)", wasmType(resultType)))
+	c.current.Instructions = append(c.current.Instructions, consequence...)
+	if err := c.emitConvert(consType, resultType, "conditional expression"); err != nil {
+		return err
+	}
+	c.emit("else")
+	c.current.Instructions = append(c.current.Instructions, alternative...)
+	if err := c.emitConvert(altType, resultType, "conditional expression"); err != nil {
+		return err
+	}
+	c.emit("end")
+	c.stackType = resultType
+	c.stackTypeName = resultName
+	return nil
+}
+
+// compileNullish 编译 a ?? b：只有 a 为 null / undefined 时才求值 b
+func (c *Compiler) compileNullish(node *ast.InfixExpression) error {
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+	leftType, leftName := c.stackType, c.stackTypeName
+	if !c.isPointerType(leftType, leftName) {
+		// Value types (int / number / bool / bigint) are never nullish
+		return nil
+	}
+	if leftType == TypeNull || leftType == TypeUndefined {
+		c.emit("drop")
+		return c.Compile(node.Right)
+	}
+
+	tempIndex := c.newTempLocal("nullish", leftType)
+	c.emit(fmt.Sprintf("local.tee %d", tempIndex))
+	c.emit("call $is_nullish")
+
+	rightCode, rightType, rightName, err := c.compileAside(node.Right)
+	if err != nil {
+		return err
+	}
+
+	// int | null ?? int: unbox the left value instead of boxing the right one
+	unbox := false
+	var resultType DataType
+	var resultName string
+	if leftType == TypeUnion {
+		var rest []string
+		for _, member := range unionMembers(leftName) {
+			if member != "null" && member != "undefined" {
+				rest = append(rest, member)
 			}
-		case "==":
-			if leftType == rightType || (leftType == TypeHost && rightType == TypeInt) || (leftType == TypeInt && rightType == TypeHost) {
-				c.emit("i32.eq")
-				c.stackType = TypeBool
-			} else {
-				return fmt.Errorf("operator == not defined for types %s and %s", leftType, rightType)
+		}
+		if len(rest) == 1 && c.resolveType(rest[0]) == rightType && rightType != TypeBigInt {
+			unbox = true
+			resultType, resultName = rightType, rightName
+			if resultName == "" {
+				resultName = rest[0]
 			}
-		case "!=":
-			if leftType == rightType || (leftType == TypeHost && rightType == TypeInt) || (leftType == TypeInt && rightType == TypeHost) {
-				c.emit("i32.ne")
-				c.stackType = TypeBool
-			} else {
-				return fmt.Errorf("operator != not defined for types %s and %s", leftType, rightType)
+		}
+	}
+	if !unbox {
+		resultType, resultName, err = c.unifyBranches(leftType, leftName, rightType, rightName)
+		if err != nil {
+			return fmt.Errorf("operator ??: %v", err)
+		}
+	}
+
+	c.emit(fmt.Sprintf("if (result %s)", wasmType(resultType)))
+	c.current.Instructions = append(c.current.Instructions, rightCode...)
+	if err := c.emitConvert(rightType, resultType, "operator ??"); err != nil {
+		return err
+	}
+	c.emit("else")
+	c.emit(fmt.Sprintf("local.get %d", tempIndex))
+	switch {
+	case unbox && resultType == TypeFloat:
+		c.emit("call $unbox_f64")
+	case unbox:
+		c.emit("call $unbox_value")
+	default:
+		if err := c.emitConvert(leftType, resultType, "operator ??"); err != nil {
+			return err
+		}
+	}
+	c.emit("end")
+	c.stackType = resultType
+	c.stackTypeName = resultName
+	return nil
+}
+
+// compileNullComparison 编译与 null / undefined 的比较
+// == / != 同时匹配 null 与 undefined，=== / !== 只匹配字面量本身；值类型永远不相等
+func (c *Compiler) compileNullComparison(op string, start, mid int, leftType DataType, leftName string, rightType DataType, rightName string) error {
+	negate := false
+	switch op {
+	case "==", "===":
+	case "!=", "!==":
+		negate = true
+	default:
+		return fmt.Errorf("operator %s not defined for types %s and %s", op, typeNameOf(leftType, leftName), typeNameOf(rightType, rightName))
+	}
+	strict := op == "===" || op == "!=="
+
+	// Keep only the tested value on the stack
+	valueType, valueName, nullType := leftType, leftName, rightType
+	if rightType != TypeNull && rightType != TypeUndefined {
+		valueType, valueName, nullType = rightType, rightName, leftType
+		c.current.Instructions = append(c.current.Instructions[:start], c.current.Instructions[mid:]...)
+	} else {
+		c.current.Instructions = c.current.Instructions[:mid]
+	}
+
+	switch {
+	case valueType == TypeNull || valueType == TypeUndefined:
+		c.emit("drop")
+		if !strict || valueType == nullType {
+			c.emit("i32.const 1")
+		} else {
+			c.emit("i32.const 0")
+		}
+	case !c.isPointerType(valueType, valueName):
+		c.emit("drop")
+		c.emit("i32.const 0")
+	case !strict:
+		c.emit("call $is_nullish")
+	case nullType == TypeNull:
+		c.emit("i32.eqz")
+	default:
+		c.emit(fmt.Sprintf("i32.const %d", undefinedPtr))
+		c.emit("i32.eq")
+	}
+	if negate {
+		c.emit("i32.eqz")
+	}
+	c.stackType = TypeBool
+	return nil
+}
+
+// optionalLink 返回链式表达式中离 node 最近的可选环节 (a?.b.c() 中的 a?.b)，没有时返回 nil
+func optionalLink(node ast.Expression) ast.Expression {
+	for {
+		switch n := node.(type) {
+		case *ast.MemberExpression:
+			if n.Optional {
+				return n
 			}
-		case "<":
-			if leftType == TypeInt && rightType == TypeInt {
-				c.emit("i32.lt_s")
-				c.stackType = TypeBool
-			} else {
-				return fmt.Errorf("operator < not defined for types %s and %s", leftType, rightType)
+			node = n.Object
+		case *ast.CallExpression:
+			if n.Optional {
+				return n
 			}
-		case ">":
-			if leftType == TypeInt && rightType == TypeInt {
-				c.emit("i32.gt_s")
-				c.stackType = TypeBool
-			} else {
-				return fmt.Errorf("operator > not defined for types %s and %s", leftType, rightType)
+			node = n.Function
+		case *ast.IndexExpression:
+			if n.Optional {
+				return n
 			}
+			node = n.Left
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return nil
 		}
+	}
+}
 
+// replaceChainObject 复制 node 到 link 为止的链，把 link 的对象替换为 object 并去掉 link 的 ?.
+func replaceChainObject(node, link, object ast.Expression) ast.Expression {
+	switch n := node.(type) {
+	case *ast.MemberExpression:
+		copied := *n
+		if node == link {
+			copied.Object, copied.Optional = object, false
+		} else {
+			copied.Object = replaceChainObject(n.Object, link, object)
+		}
+		return &copied
 	case *ast.CallExpression:
-		if member, ok := node.Function.(*ast.MemberExpression); ok {
-			// Check for super.method()
-			if _, isSuper := member.Object.(*ast.SuperExpression); isSuper {
-				if c.currentClass == "" {
-					return fmt.Errorf("super call outside of class method")
-				}
-				
-				currentSym := c.classes[c.currentClass]
-				parentName := currentSym.Parent
-				if parentName == "" {
-					return fmt.Errorf("super call in class with no parent")
-				}
-				
-				parentSym := c.classes[parentName]
-				methodName := member.Property.Value
-				
-				mangledName, ok := parentSym.Methods[methodName]
-				if !ok {
-					return fmt.Errorf("method %s not found in parent class %s", methodName, parentName)
-				}
-				
-				// Push 'this' (local 0) as first argument
-				c.emit("local.get 0 ;; this (super)")
-				
-				// Compile other arguments
-				for _, arg := range node.Arguments {
-					if err := c.Compile(arg); err != nil {
-						return err
-					}
-				}
-				
-				c.emit(fmt.Sprintf("call $%s", mangledName))
-				c.stackType = TypeInt
-				return nil
-			}
+		copied := *n
+		if node == link {
+			copied.Function, copied.Optional = object, false
+		} else {
+			copied.Function = replaceChainObject(n.Function, link, object)
+		}
+		return &copied
+	case *ast.IndexExpression:
+		copied := *n
+		if node == link {
+			copied.Left, copied.Optional = object, false
+		} else {
+			copied.Left = replaceChainObject(n.Left, link, object)
+		}
+		return &copied
+	}
+	return node
+}
 
-			// Check for Array methods: push, pop (TODO)
-			// Problem: We need to know if object is Array.
-			// MVP: If method name is "push", treat as array push.
-			if member.Property.Value == "push" {
-				if err := c.Compile(member.Object); err != nil {
-					return err
-				}
-				// Stack: [array_ptr]
-				
-				// Compile arguments (expect 1)
-				if len(node.Arguments) != 1 {
-					return fmt.Errorf("push expects 1 argument")
-				}
-				if err := c.Compile(node.Arguments[0]); err != nil {
-					return err
-				}
-				
-				c.emit("call $array_push")
-				c.stackType = TypeVoid
-				return nil
+// compileOptionalChain 编译含 ?. 的链：对象为 null / undefined 时整条链短路为 undefined
+// 值类型的结果 (int / number / bool) 装箱为 "T|undefined" 联合类型
+func (c *Compiler) compileOptionalChain(chain, link ast.Expression) error {
+	var object ast.Expression
+	switch n := link.(type) {
+	case *ast.MemberExpression:
+		object = n.Object
+	case *ast.CallExpression:
+		object = n.Function
+	case *ast.IndexExpression:
+		object = n.Left
+	}
+	if err := c.Compile(object); err != nil {
+		return err
+	}
+	objectType, objectName := c.stackType, c.stackTypeName
+
+	// The rest of the chain reads the object from a temp local
+	temp := c.bindTemp("optional", objectType, objectName)
+	rest := replaceChainObject(chain, link, temp)
+	code, resultType, resultName, err := c.compileAside(rest)
+	if err != nil {
+		return err
+	}
+	if !c.isPointerType(objectType, objectName) {
+		// Value types are never nullish: ?. behaves like .
+		c.current.Instructions = append(c.current.Instructions, code...)
+		c.stackType, c.stackTypeName = resultType, resultName
+		return nil
+	}
+
+	if err := c.Compile(temp); err != nil {
+		return err
+	}
+	c.emit("call $is_nullish")
+	if resultType == TypeVoid {
+		c.emit("i32.eqz")
+		c.emit("if")
+		c.current.Instructions = append(c.current.Instructions, code...)
+		c.emit("end")
+		c.stackType = TypeVoid
+		return nil
+	}
+
+	box := !c.isPointerType(resultType, resultName)
+	if box && resultType == TypeBigInt {
+		return fmt.Errorf("optional chain cannot produce a bigint yet")
+	}
+	valueType, valueName := resultType, resultName
+	if box {
+		resultType, resultName = TypeUnion, typeNameOf(valueType, valueName)+"|undefined"
+	}
+	c.emit(fmt.Sprintf("if (result %s)", wasmType(resultType)))
+	c.emit(fmt.Sprintf("i32.const %d ;; undefined", undefinedPtr))
+	c.emit("else")
+	c.current.Instructions = append(c.current.Instructions, code...)
+	if box {
+		c.emitToUnion(valueType, valueName)
+	}
+	c.emit("end")
+	c.stackType = resultType
+	c.stackTypeName = resultName
+	return nil
+}
+
+// compileDestructuring 编译解构声明 (declare) 或解构赋值：值先存入临时变量，
+// 每个目标再展开为 temp.key (类字段 / $map_get) 或 temp[i] ($array_get) 的 let 或赋值
+// 赋值形式的结果是右侧的值
+func (c *Compiler) compileDestructuring(pattern, value ast.Expression, typeName string, declare bool) error {
+	if err := c.Compile(value); err != nil {
+		return err
+	}
+	valueType := c.stackType
+	if typeName == "" {
+		typeName = c.stackTypeName
+	}
+	temp := c.bindTemp("destructure", valueType, typeName)
+
+	switch p := pattern.(type) {
+	case *ast.ObjectPattern:
+		if !c.isPointerType(valueType, typeName) && valueType != TypeUnknown {
+			return fmt.Errorf("cannot destructure %s as an object", typeNameOf(valueType, typeName))
+		}
+		for _, prop := range p.Properties {
+			var access ast.Expression = &ast.MemberExpression{Token: prop.Key.Token, Object: temp, Property: prop.Key}
+			if valueType == TypeMap {
+				access = &ast.IndexExpression{Token: prop.Key.Token, Left: temp, Index: &ast.StringLiteral{Token: prop.Key.Token, Value: prop.Key.Value}}
 			}
-			
-			// Check for String methods: substring, charCodeAt
-			if member.Property.Value == "substring" {
-				if err := c.Compile(member.Object); err != nil {
-					return err
-				}
-				// Stack: [str_ptr]
-				
-				if len(node.Arguments) != 2 {
-					return fmt.Errorf("substring expects 2 arguments (start, end)")
-				}
-				
-				if err := c.Compile(node.Arguments[0]); err != nil {
+			if prop.Default != nil && valueType == TypeMap {
+				// Missing keys take the default; a stored 0 is still a value
+				if err := c.Compile(temp); err != nil {
 					return err
 				}
-				if err := c.Compile(node.Arguments[1]); err != nil {
+				if err := c.Compile(&ast.StringLiteral{Token: prop.Key.Token, Value: prop.Key.Value}); err != nil {
 					return err
 				}
-				
-				c.emit("call $string_substring")
-				c.stackType = TypeString
-				return nil
+				c.emit("call $map_has")
+				has := c.bindTemp("has", TypeBool, "boolean")
+				access = &ast.ConditionalExpression{Token: prop.Key.Token, Condition: has, Consequence: access, Alternative: prop.Default}
+			} else if prop.Default != nil {
+				// Missing (null / undefined) properties take the default
+				access = &ast.InfixExpression{Token: token.Token{Type: token.NULLISH, Literal: "??"}, Left: access, Operator: "??", Right: prop.Default}
 			}
-			
-			if member.Property.Value == "charCodeAt" {
-				if err := c.Compile(member.Object); err != nil {
-					return err
-				}
-				// Stack: [str_ptr]
-				
-				if len(node.Arguments) != 1 {
-					return fmt.Errorf("charCodeAt expects 1 argument (index)")
-				}
-				
-				if err := c.Compile(node.Arguments[0]); err != nil {
-					return err
-				}
-				
-				c.emit("call $string_charCodeAt")
-				c.stackType = TypeInt
-				return nil
+			if err := c.bindPatternTarget(prop.Target, access, declare); err != nil {
+				return err
 			}
-
-			// Check for fs.writeFile / fs.writeFileSync (WASI only)
-			if ident, ok := member.Object.(*ast.Identifier); ok && ident.Value == "fs" && (member.Property.Value == "writeFile" || member.Property.Value == "writeFileSync") {
-				if c.target != "wasi" {
-					return fmt.Errorf("fs.%s is only supported in WASI target", member.Property.Value)
-				}
-				
-				if len(node.Arguments) < 2 || len(node.Arguments) > 3 {
-					return fmt.Errorf("fs.%s expects 2 or 3 arguments (path, content, [options])", member.Property.Value)
-				}
-				
-				// Compile path
-				if err := c.Compile(node.Arguments[0]); err != nil {
-					return err
-				}
-				
-				// Compile content
-				if err := c.Compile(node.Arguments[1]); err != nil {
-					return err
-				}
-
-				// Ignore options for now (TODO: support options)
-				
-				c.emit("call $fs_writeFile")
-				c.stackType = TypeVoid
-				return nil
+		}
+	case *ast.ArrayPattern:
+		if valueType != TypeArray {
+			return fmt.Errorf("cannot destructure %s as an array", typeNameOf(valueType, typeName))
+		}
+		for i, el := range p.Elements {
+			if el == nil {
+				continue
 			}
-
-			// Check for fs.readFile / fs.readFileSync (WASI only)
-			if ident, ok := member.Object.(*ast.Identifier); ok && ident.Value == "fs" && (member.Property.Value == "readFile" || member.Property.Value == "readFileSync") {
-				if c.target != "wasi" {
-					return fmt.Errorf("fs.%s is only supported in WASI target", member.Property.Value)
-				}
-				
-				if len(node.Arguments) < 1 || len(node.Arguments) > 2 {
-					return fmt.Errorf("fs.%s expects 1 or 2 arguments (path, [options])", member.Property.Value)
-				}
-				
-				// Compile path
-				if err := c.Compile(node.Arguments[0]); err != nil {
-					return err
-				}
-				
-				// Ignore options for now
-				
-				c.emit("call $fs_readFile")
-				c.stackType = TypeString
-				return nil
+			index := &ast.IntegerLiteral{Token: token.Token{Type: token.INT, Literal: strconv.Itoa(i)}, Value: int64(i)}
+			var access ast.Expression = &ast.IndexExpression{Token: p.Token, Left: temp, Index: index}
+			if el.Default != nil {
+				// Elements past the end take the default
+				length := &ast.MemberExpression{Token: p.Token, Object: temp, Property: &ast.Identifier{Token: p.Token, Value: "length"}}
+				inRange := &ast.InfixExpression{Token: token.Token{Type: token.LT, Literal: "<"}, Left: index, Operator: "<", Right: length}
+				access = &ast.ConditionalExpression{Token: p.Token, Condition: inRange, Consequence: access, Alternative: el.Default}
 			}
-
-			// Check for fs.existsSync (WASI only)
-			if ident, ok := member.Object.(*ast.Identifier); ok && ident.Value == "fs" && member.Property.Value == "existsSync" {
-				if c.target != "wasi" {
-					return fmt.Errorf("fs.existsSync is only supported in WASI target")
-				}
-				
-				if len(node.Arguments) != 1 {
-					return fmt.Errorf("fs.existsSync expects 1 argument (path)")
-				}
-				
-				// Compile path
-				if err := c.Compile(node.Arguments[0]); err != nil {
-					return err
-				}
-				
-				c.emit("call $fs_existsSync")
-				c.stackType = TypeBool
-				return nil
+			if err := c.bindPatternTarget(el.Target, access, declare); err != nil {
+				return err
 			}
-
-			// Check for fs.unlinkSync (WASI only)
-			if ident, ok := member.Object.(*ast.Identifier); ok && ident.Value == "fs" && member.Property.Value == "unlinkSync" {
-				if c.target != "wasi" {
-					return fmt.Errorf("fs.unlinkSync is only supported in WASI target")
-				}
-				
-				if len(node.Arguments) != 1 {
-					return fmt.Errorf("fs.unlinkSync expects 1 argument (path)")
-				}
-				
-				if err := c.Compile(node.Arguments[0]); err != nil {
-					return err
-				}
-				
-				c.emit("call $fs_unlink")
-				c.emit("drop") // Ignore result for now (void return in Node)
-				c.stackType = TypeVoid
-				return nil
+		}
+		if p.Rest != nil {
+			if err := c.Compile(temp); err != nil {
+				return err
 			}
-
-			// Check for fs.mkdirSync (WASI only)
-			if ident, ok := member.Object.(*ast.Identifier); ok && ident.Value == "fs" && member.Property.Value == "mkdirSync" {
-				if c.target != "wasi" {
-					return fmt.Errorf("fs.mkdirSync is only supported in WASI target")
-				}
-				
-				if len(node.Arguments) < 1 || len(node.Arguments) > 2 {
-					return fmt.Errorf("fs.mkdirSync expects 1 or 2 arguments (path, [options])")
-				}
-				
-				if err := c.Compile(node.Arguments[0]); err != nil {
-					return err
-				}
-				
-				c.emit("call $fs_mkdir")
-				c.emit("drop")
-				c.stackType = TypeVoid
-				return nil
+			c.emit(fmt.Sprintf("i32.const %d", len(p.Elements)))
+			c.emit("call $array_slice")
+			rest := c.bindTemp("rest", TypeArray, typeName)
+			if err := c.bindPatternTarget(p.Rest, rest, declare); err != nil {
+				return err
 			}
+		}
+	default:
+		return fmt.Errorf("invalid destructuring pattern %s", pattern.String())
+	}
 
-			// Check for fs.rmdirSync (WASI only)
-			if ident, ok := member.Object.(*ast.Identifier); ok && ident.Value == "fs" && member.Property.Value == "rmdirSync" {
-				if c.target != "wasi" {
-					return fmt.Errorf("fs.rmdirSync is only supported in WASI target")
-				}
-				
-				if len(node.Arguments) != 1 {
-					return fmt.Errorf("fs.rmdirSync expects 1 argument (path)")
-				}
-				
-				if err := c.Compile(node.Arguments[0]); err != nil {
-					return err
-				}
-				
-				c.emit("call $fs_rmdir")
-				c.emit("drop")
-				c.stackType = TypeVoid
-				return nil
-			}
+	if declare {
+		c.stackType = TypeVoid
+		return nil
+	}
+	return c.Compile(temp)
+}
 
-			// Check for console.log / console.error / console.warn
-			if ident, ok := member.Object.(*ast.Identifier); ok && ident.Value == "console" {
-				method := member.Property.Value
-				if method == "log" || method == "error" || method == "warn" {
-					if _, ok := c.importedFuncs["console_log_str"]; !ok {
-						c.importedFuncs["console_log_str"] = &ast.ImportStatement{ReturnType: "void", Parameters: make([]*ast.FieldDefinition, 1)}
-					}
-					if _, ok := c.importedFuncs["console_log_int"]; !ok {
-						c.importedFuncs["console_log_int"] = &ast.ImportStatement{ReturnType: "void", Parameters: make([]*ast.FieldDefinition, 1)}
-					}
-					if _, ok := c.importedFuncs["console_log_char"]; !ok {
-						c.importedFuncs["console_log_char"] = &ast.ImportStatement{ReturnType: "void", Parameters: make([]*ast.FieldDefinition, 1)}
-					}
+// bindPatternTarget 把 access 的值绑定到解构目标：声明新变量、赋值给已有的左值，或继续解构嵌套模式
+func (c *Compiler) bindPatternTarget(target, access ast.Expression, declare bool) error {
+	ident, isIdent := target.(*ast.Identifier)
+	switch t := target.(type) {
+	case *ast.ObjectPattern, *ast.ArrayPattern:
+		if err := c.compileDestructuring(t, access, "", declare); err != nil {
+			return err
+		}
+	default:
+		if declare && isIdent {
+			return c.Compile(&ast.LetStatement{Token: ident.Token, Name: ident, Value: access})
+		}
+		if declare {
+			return fmt.Errorf("invalid destructuring target %s", target.String())
+		}
+		if err := c.Compile(&ast.AssignmentExpression{Token: token.Token{Type: token.ASSIGN, Literal: "="}, Left: target, Value: access}); err != nil {
+			return err
+		}
+	}
+	if c.stackType != TypeVoid {
+		c.emit("drop")
+	}
+	c.stackType = TypeVoid
+	return nil
+}
 
-					for i, arg := range node.Arguments {
-						if i > 0 {
-							c.emit("i32.const 32") // space
-							c.emit("call $console_log_char")
-						}
+// compileLogicalExpression 编译短路求值的 && 与 ||
+// 结果是其中一个操作数的值 (a || b 返回 a 或 b)，两侧类型不同时按 unifyBranches 合并
+func (c *Compiler) compileLogicalExpression(node *ast.InfixExpression) error {
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+	leftType, leftName := c.stackType, c.stackTypeName
+	tempIndex := c.newTempLocal("logic", leftType)
+	c.emit(fmt.Sprintf("local.tee %d", tempIndex))
+	c.emitTruthy(leftType)
+
+	// Compile the right operand aside so we know its type before choosing
+	// how each branch produces its value.
+	rightCode, rightType, rightName, err := c.compileAside(node.Right)
+	if err != nil {
+		return err
+	}
 
-						if err := c.Compile(arg); err != nil {
-							return err
-						}
+	// The result is one of the operands (0 || "s" is "s"), so mixed operand types give a union
+	resultType, resultName, err := c.unifyBranches(leftType, leftName, rightType, rightName)
+	if err != nil {
+		return fmt.Errorf("operator %s: %v", node.Operator, err)
+	}
+	context := "operator " + node.Operator
+	emitLeft := func() error {
+		c.emit(fmt.Sprintf("local.get %d", tempIndex))
+		return c.emitConvert(leftType, resultType, context)
+	}
+	emitRight := func() error {
+		c.current.Instructions = append(c.current.Instructions, rightCode...)
+		return c.emitConvert(rightType, resultType, context)
+	}
 
-						switch c.stackType {
-						case TypeString:
-							c.emit("call $console_log_str")
-						case TypeInt:
-							c.emit("call $console_log_int")
-						case TypeBool:
-							c.emit("call $console_log_int")
-						default:
-							c.emit("call $console_log_int")
-						}
-					}
+	// a && b: b when a is truthy, otherwise a; a || b: a when a is truthy, otherwise b
+	whenTruthy, otherwise := emitRight, emitLeft
+	if node.Operator == "||" {
+		whenTruthy, otherwise = emitLeft, emitRight
+	}
+	c.emit(fmt.Sprintf("if (result %s)", wasmType(resultType)))
+	if err := whenTruthy(); err != nil {
+		return err
+	}
+	c.emit("else")
+	if err := otherwise(); err != nil {
+		return err
+	}
+	c.emit("end")
 
-					// Newline
-					c.emit("i32.const 10")
-					c.emit("call $console_log_char")
-					
-					c.stackType = TypeVoid
-					return nil
-				}
-			}
+	c.stackType = resultType
+	c.stackTypeName = resultName
+	return nil
+}
 
-			// Check for std.args (WASI only)
-			// Usage: std.args() -> Array<string>
-			if ident, ok := member.Object.(*ast.Identifier); ok && ident.Value == "std" && member.Property.Value == "args" {
-				if c.target != "wasi" {
-					return fmt.Errorf("std.args is only supported in WASI target")
-				}
-				
-				if len(node.Arguments) != 0 {
-					return fmt.Errorf("std.args expects 0 arguments")
-				}
-				
-				c.emit("call $std_args")
-				c.stackType = TypeArray
-				return nil
-			}
+// compileFloatInfix 编译 number (f64) 的二元运算，int 操作数先提升为 f64
+// start/mid 是左右操作数指令在当前函数中的起始位置
+func (c *Compiler) compileFloatInfix(operator string, start, mid int, leftType, rightType DataType) error {
+	leftCode := append([]string{}, c.current.Instructions[start:mid]...)
+	rightCode := append([]string{}, c.current.Instructions[mid:]...)
+	c.current.Instructions = c.current.Instructions[:start]
 
-			// Check for std.atomic
-			if ident, ok := member.Object.(*ast.Identifier); ok && ident.Value == "std" && member.Property.Value == "atomic" {
-				// Usage: std.atomic.add(arr, index, val)
-				// Since MemberExpression only goes one level deep (std.atomic), we need another property access.
-				// Wait, the parser parses `std.atomic.add` as (std.atomic).add
-				// So member.Object is `std.atomic`.
-				// But here member.Object is an Identifier "std".
-				// So `std.atomic` is the property access.
-				// But this is a CallExpression.
-				// If we call `std.atomic.add(...)`, the Function is MemberExpression(Object=MemberExpression(std, atomic), Property=add).
-				// We need to handle nested member expressions properly or flatten them.
-				
-				// Currently, we are in `CallExpression` handler. `node.Function` is `MemberExpression`.
-				// If `std.atomic.add`, `member.Object` is `MemberExpression` (std.atomic).
-				
-				// Let's check if member.Object is MemberExpression
-				return fmt.Errorf("std.atomic usage not supported in this form yet")
-			}
-			
-			// Handle std.atomic.add(...)
-			// node.Function is MemberExpression(Object=MemberExpression(std, atomic), Property=add)
-			if subMember, ok := member.Object.(*ast.MemberExpression); ok {
-				if ident, ok := subMember.Object.(*ast.Identifier); ok && ident.Value == "std" && subMember.Property.Value == "atomic" {
-					method := member.Property.Value
-					
-					// Common logic for array access
-					// Arg 0: Array
-					// Arg 1: Index
-					
-					if len(node.Arguments) < 2 {
-						return fmt.Errorf("std.atomic.%s requires at least array and index arguments", method)
-					}
-					
-					// 1. Compile Array (get ptr)
-					if err := c.Compile(node.Arguments[0]); err != nil { return err }
-					// Check type? Assume TypeArray.
-					// Array structure: [capacity, count, data_ptr] at offsets 0, 4, 8.
-					// We need data_ptr.
-					// Stack: [array_struct_ptr]
-					c.emit("i32.const 8")
-					c.emit("i32.add")
-					c.emit("i32.load")
-					// Stack: [data_ptr]
-					
-					// 2. Compile Index
-					if err := c.Compile(node.Arguments[1]); err != nil { return err }
-					// Stack: [data_ptr, index]
-					
-					// Calculate address: data_ptr + index * 4
-					c.emit("i32.const 4")
-					c.emit("i32.mul")
-					c.emit("i32.add")
-					// Stack: [address]
-					
-					switch method {
-					case "add":
-						// Arg 2: Value
-						if len(node.Arguments) != 3 { return fmt.Errorf("atomic.add expects 3 args") }
-						if err := c.Compile(node.Arguments[2]); err != nil { return err }
-						c.emit("i32.atomic.rmw.add")
-						c.stackType = TypeInt
-						
-					case "sub":
-						if len(node.Arguments) != 3 { return fmt.Errorf("atomic.sub expects 3 args") }
-						if err := c.Compile(node.Arguments[2]); err != nil { return err }
-						c.emit("i32.atomic.rmw.sub")
-						c.stackType = TypeInt
-						
-					case "load":
-						if len(node.Arguments) != 2 { return fmt.Errorf("atomic.load expects 2 args") }
-						c.emit("i32.atomic.load")
-						c.stackType = TypeInt
-						
-					case "store":
-						if len(node.Arguments) != 3 { return fmt.Errorf("atomic.store expects 3 args") }
-						if err := c.Compile(node.Arguments[2]); err != nil { return err }
-						c.emit("i32.atomic.store")
-						c.stackType = TypeVoid // store returns nothing
-						
-					case "wait":
-						// wait(addr, expected, timeout) -> 0=ok, 1=not_equal, 2=timed_out
-						if len(node.Arguments) != 4 { return fmt.Errorf("atomic.wait expects 4 args (arr, idx, expected, timeout)") }
-						if err := c.Compile(node.Arguments[2]); err != nil { return err } // Expected
-						if err := c.Compile(node.Arguments[3]); err != nil { return err } // Timeout (i64)
-						// Convert timeout to i64 if it's i32
-						c.emit("i64.extend_i32_s") 
-						c.emit("memory.atomic.wait32")
-						c.stackType = TypeInt
-						
-					case "notify":
-						// notify(addr, count) -> count
-						if len(node.Arguments) != 3 { return fmt.Errorf("atomic.notify expects 3 args (arr, idx, count)") }
-						if err := c.Compile(node.Arguments[2]); err != nil { return err } // Count
-						c.emit("memory.atomic.notify")
-						c.stackType = TypeInt
-						
-					default:
-						return fmt.Errorf("unknown atomic method: %s", method)
-					}
-					return nil
-				}
-			}
+	c.current.Instructions = append(c.current.Instructions, leftCode...)
+	if leftType == TypeInt {
+		c.emit("f64.convert_i32_s")
+	}
+	c.current.Instructions = append(c.current.Instructions, rightCode...)
+	if rightType == TypeInt {
+		c.emit("f64.convert_i32_s")
+	}
 
-			// Check for path module methods
-			if ident, ok := member.Object.(*ast.Identifier); ok && ident.Value == "path" {
-				switch member.Property.Value {
-				case "basename":
-					if len(node.Arguments) != 1 {
-						return fmt.Errorf("path.basename expects 1 argument (path)")
-					}
-					if err := c.Compile(node.Arguments[0]); err != nil {
-						return err
-					}
-					c.emit("call $path_basename")
-					c.stackType = TypeString
-					return nil
+	switch operator {
+	case "+", "-", "*", "/":
+		ops := map[string]string{"+": "f64.add", "-": "f64.sub", "*": "f64.mul", "/": "f64.div"}
+		c.emit(ops[operator])
+		c.stackType = TypeFloat
+	case "%":
+		// a % b = a - trunc(a / b) * b (sign follows the dividend, as in JS)
+		b := c.newTempLocal("rem", TypeFloat)
+		a := c.newTempLocal("rem", TypeFloat)
+		c.emit(fmt.Sprintf("local.set %d", b))
+		c.emit(fmt.Sprintf("local.tee %d", a))
+		c.emit(fmt.Sprintf("local.get %d", a))
+		c.emit(fmt.Sprintf("local.get %d", b))
+		c.emit("f64.div")
+		c.emit("f64.trunc")
+		c.emit(fmt.Sprintf("local.get %d", b))
+		c.emit("f64.mul")
+		c.emit("f64.sub")
+		c.stackType = TypeFloat
+	case "==", "===", "!=", "!==", "<", ">", "<=", ">=":
+		ops := map[string]string{"==": "f64.eq", "===": "f64.eq", "!=": "f64.ne", "!==": "f64.ne", "<": "f64.lt", ">": "f64.gt", "<=": "f64.le", ">=": "f64.ge"}
+		c.emit(ops[operator])
+		c.stackType = TypeBool
+	default:
+		return fmt.Errorf("operator %s not defined for types %s and %s", operator, leftType, rightType)
+	}
+	return nil
+}
 
-				case "dirname":
-					if len(node.Arguments) != 1 {
-						return fmt.Errorf("path.dirname expects 1 argument (path)")
-					}
-					if err := c.Compile(node.Arguments[0]); err != nil {
-						return err
-					}
-					c.emit("call $path_dirname")
-					c.stackType = TypeString
-					return nil
+// bitwiseOps 位运算符对应的 i32 指令后缀
+var bitwiseOps = map[string]string{"&": "and", "|": "or", "^": "xor", "<<": "shl", ">>": "shr_s", ">>>": "shr_u"}
 
-				case "extname":
-					if len(node.Arguments) != 1 {
-						return fmt.Errorf("path.extname expects 1 argument (path)")
-					}
-					if err := c.Compile(node.Arguments[0]); err != nil {
-						return err
-					}
-					c.emit("call $path_extname")
-					c.stackType = TypeString
-					return nil
+// compileBitwiseInfix 编译 int 的位运算；number 操作数按 JS 规则先截断为 32 位整数
+// start/mid 是左右操作数指令在当前函数中的起始位置
+func (c *Compiler) compileBitwiseInfix(operator string, start, mid int, leftType, rightType DataType) error {
+	isInt := func(t DataType) bool { return t == TypeInt || t == TypeBool || t == TypeFloat }
+	if !isInt(leftType) || !isInt(rightType) {
+		return fmt.Errorf("operator %s not defined for types %s and %s", operator, leftType, rightType)
+	}
+	if rightType == TypeFloat {
+		c.emitToInt32()
+	}
+	if leftType == TypeFloat {
+		rightCode := append([]string{}, c.current.Instructions[mid:]...)
+		c.current.Instructions = c.current.Instructions[:mid]
+		c.emitToInt32()
+		c.current.Instructions = append(c.current.Instructions, rightCode...)
+	}
+	c.emit("i32." + bitwiseOps[operator])
+	c.stackType = TypeInt
+	return nil
+}
 
-				case "join":
-					if len(node.Arguments) == 0 {
-						return fmt.Errorf("path.join expects at least 1 argument")
-					}
-					
-					// Compile first argument
-					if err := c.Compile(node.Arguments[0]); err != nil {
-						return err
-					}
-					
-					// Iterate through rest arguments and call path_join2 iteratively
-					for i := 1; i < len(node.Arguments); i++ {
-						if err := c.Compile(node.Arguments[i]); err != nil {
-							return err
-						}
-						c.emit("call $path_join2")
-					}
-					
-					c.stackType = TypeString
-					return nil
-				}
-			}
+// emitToInt32 将栈顶的 f64 转为 i32 (JS ToInt32：截断后按 2^32 取模，NaN 为 0)
+func (c *Compiler) emitToInt32() {
+	c.emit("i64.trunc_sat_f64_s")
+	c.emit("i32.wrap_i64")
+}
 
-			// Check for process.exit
-			if ident, ok := member.Object.(*ast.Identifier); ok && ident.Value == "process" && member.Property.Value == "exit" {
-				if c.target != "wasi" {
-					return fmt.Errorf("process.exit is only supported in WASI target")
-				}
-				if len(node.Arguments) != 1 {
-					return fmt.Errorf("process.exit expects 1 argument (exit code)")
-				}
-				if err := c.Compile(node.Arguments[0]); err != nil {
-					return err
-				}
-				c.emit("call $proc_exit")
-				c.emit("unreachable") // Should not return
-				c.stackType = TypeVoid
-				return nil
-			}
+// compileBigIntInfix 编译两个 bigint (i64) 的二元运算；除以 0 时 trap
+func (c *Compiler) compileBigIntInfix(operator string) error {
+	arith := map[string]string{"+": "i64.add", "-": "i64.sub", "*": "i64.mul", "/": "i64.div_s", "%": "i64.rem_s",
+		"&": "i64.and", "|": "i64.or", "^": "i64.xor", "<<": "i64.shl", ">>": "i64.shr_s"}
+	compare := map[string]string{"==": "i64.eq", "===": "i64.eq", "!=": "i64.ne", "!==": "i64.ne", "<": "i64.lt_s", ">": "i64.gt_s", "<=": "i64.le_s", ">=": "i64.ge_s"}
+	if op, ok := arith[operator]; ok {
+		c.emit(op)
+		c.stackType = TypeBigInt
+		return nil
+	}
+	if op, ok := compare[operator]; ok {
+		c.emit(op)
+		c.stackType = TypeBool
+		return nil
+	}
+	return fmt.Errorf("operator %s not defined for type bigint", operator)
+}
 
+// intLiteralValue 检查整数字面量能否放入 int (i32)，放不下的字面量是 number (f64)
+// 十进制按有符号范围；0x/0b/0o 字面量是位模式，允许到 0xFFFFFFFF
+func intLiteralValue(lit *ast.IntegerLiteral, negative bool) (int32, bool) {
+	value := lit.Value
+	if negative {
+		value = -value
+	}
+	if value >= math.MinInt32 && value <= math.MaxInt32 {
+		return int32(value), true
+	}
+	literal := strings.ToLower(lit.Token.Literal)
+	prefixed := strings.HasPrefix(literal, "0x") || strings.HasPrefix(literal, "0b") || strings.HasPrefix(literal, "0o")
+	if prefixed && !negative && value <= math.MaxUint32 {
+		return int32(uint32(value)), true
+	}
+	return 0, false
+}
 
-			// Check for process.env (WASI only)
-			// Usage: process.env -> Map<string, string>
-			// But here we are in CallExpression.
-			// If user does `process.env()`, that's not Node.js like.
-			// Node.js usage: `process.env` is an object.
-			// But in our AST, if we access `process.env`, it is a MemberExpression.
-			// If we do `process.env["KEY"]`, it is IndexExpression.
-			// If we do `process.env.KEY`, it is MemberExpression.
-			
-			// This block is for CallExpression.
-			// So `process.env()` calls. Not what we want.
+// expectsNumber 判断上下文期望的类型是否为 number：此时整数字面量直接编译为 f64 常量，
+// 不经过 i32 (0xFFFFFFFF 是 4294967295 而不是 -1)
+func (c *Compiler) expectsNumber(typeName string) bool {
+	return typeName != "" && c.resolveType(typeName) == TypeFloat
+}
 
+// compileUpdateExpression 编译 ++x / --x / x++ / x--
+// 展开为 x = x ± 1，后缀形式再还原出旧值
+func (c *Compiler) compileUpdateExpression(target ast.Expression, operator string, tok token.Token, postfix bool) error {
+	switch target.(type) {
+	case *ast.Identifier, *ast.MemberExpression, *ast.IndexExpression:
+	default:
+		return fmt.Errorf("invalid operand for %s: %s", operator, target.String())
+	}
 
-			// Method call: obj.method(args)
-			// 1. Compile obj to get 'this' pointer
-			if err := c.Compile(member.Object); err != nil {
-				return err
-			}
-			// Stack: [obj_ptr]
-			targetType := c.stackType
-			
-			// Host Object Method Call
-			if targetType == TypeHost {
-				// We have object handle on stack.
-				// Need to pack arguments into an array in Wasm memory.
-				
-				// 1. Allocate args array
-				argCount := len(node.Arguments)
-				
-				// Create a temp local for args pointer
-				tempName := fmt.Sprintf("$args_temp_%d", c.current.NextLocalID)
-				tempIndex := c.current.NextLocalID
-				c.current.Symbols[tempName] = Symbol{Index: tempIndex, Type: TypeInt, IsParam: false, ShadowIndex: -1} // No GC trace for temp buffer
-				c.current.NextLocalID++
-				
-				if argCount > 0 {
-					c.emit(fmt.Sprintf("i32.const %d", argCount*4))
-					c.emit("i32.const 20") // Use ArrayData type for simplicity (ignored by GC mostly)
-					c.emit("call $malloc")
-					c.emit(fmt.Sprintf("local.set %d", tempIndex+c.current.ParamCount))
-					
-					// 2. Compile and store arguments
-					for i, arg := range node.Arguments {
-						// Get array ptr
-						c.emit(fmt.Sprintf("local.get %d", tempIndex+c.current.ParamCount))
-						c.emit(fmt.Sprintf("i32.const %d", i*4))
-						c.emit("i32.add")
-						
-						// Compile Arg
-						if err := c.Compile(arg); err != nil {
-							return err
-						}
-						argType := c.stackType
-						
-						// Convert primitive to handle
-						if argType == TypeString {
-								c.emit("call $host_from_string")
-							} else if argType == TypeInt || argType == TypeBool {
-								c.emit("call $host_from_int")
-							}
-						
-						// Store
-						c.emit("i32.store")
-					}
-				} else {
-					c.emit("i32.const 0")
-					c.emit(fmt.Sprintf("local.set %d", tempIndex+c.current.ParamCount))
-				}
-				
-				// Prepare call: host_call(handle, method_name, args_ptr, args_len)
-				// Stack has [obj_handle] already (from compiling member.Object)
-				// Wait, compiling member.Object put it on stack. But we interrupted flow to setup args.
-				// We need to save obj_handle first!
-				
-				// Rework:
-				// 1. Compile Object -> local.set $obj
-				// 2. Allocate Args -> local.set $args
-				// 3. Fill Args
-				// 4. call host_call($obj, "method", $args, len)
-				
-				// Since we already compiled Object and it is on stack...
-				// Let's store it in another temp
-				objTempName := fmt.Sprintf("$obj_temp_%d", c.current.NextLocalID)
-				objTempIndex := c.current.NextLocalID
-				c.current.Symbols[objTempName] = Symbol{Index: objTempIndex, Type: TypeHost, IsParam: false, ShadowIndex: -1}
-				c.current.NextLocalID++
-				
-				c.emit(fmt.Sprintf("local.set %d", objTempIndex+c.current.ParamCount))
-				
-				// Now allocate args (same as above)
-				if argCount > 0 {
-					c.emit(fmt.Sprintf("i32.const %d", argCount*4))
-					c.emit("i32.const 20")
-					c.emit("call $malloc")
-					c.emit(fmt.Sprintf("local.set %d", tempIndex+c.current.ParamCount))
-					
-					for i, arg := range node.Arguments {
-						c.emit(fmt.Sprintf("local.get %d", tempIndex+c.current.ParamCount))
-						c.emit(fmt.Sprintf("i32.const %d", i*4))
-						c.emit("i32.add")
-						if err := c.Compile(arg); err != nil { return err }
-							argType := c.stackType
-							if argType == TypeString {
-								c.emit("call $host_from_string")
-							} else if argType == TypeInt || argType == TypeBool {
-								c.emit("call $host_from_int")
-							}
-						c.emit("i32.store")
-					}
-				} else {
-					c.emit("i32.const 0")
-					c.emit(fmt.Sprintf("local.set %d", tempIndex+c.current.ParamCount))
-				}
-				
-				// Call
-				c.emit(fmt.Sprintf("local.get %d", objTempIndex+c.current.ParamCount))
-				
-				methodName := member.Property.Value
-				offset, ok := c.stringPool[methodName]
-				if !ok {
-					offset = c.nextDataOffset
-					c.stringPool[methodName] = offset
-					c.nextDataOffset += len(methodName) + 1
-				}
-				c.emit(fmt.Sprintf("i32.const %d ;; \"%s\"", offset, methodName))
-				
-				c.emit(fmt.Sprintf("local.get %d", tempIndex+c.current.ParamCount))
-				c.emit(fmt.Sprintf("i32.const %d", argCount))
-				c.emit("call $host_call")
-				c.stackType = TypeHost
-				return nil
-			}
+	target, err := c.bindLvalue(target)
+	if err != nil {
+		return err
+	}
 
-			// Look up method name in ALL classes.
-			methodName := member.Property.Value
-			var mangledName string
-			found := false
-			for _, cls := range c.classes {
-				if name, ok := cls.Methods[methodName]; ok {
-					mangledName = name
-					found = true
-					break
+	op := "+"
+	if operator == "--" {
+		op = "-"
+	}
+	assign := &ast.AssignmentExpression{
+		Token: tok,
+		Left:  target,
+		Value: &ast.InfixExpression{
+			Token:    tok,
+			Left:     target,
+			Operator: op,
+			Right:    &ast.IntegerLiteral{Token: tok, Value: 1},
+		},
+	}
+	if err := c.Compile(assign); err != nil {
+		return err
+	}
+	resultType := c.stackType
+	if resultType != TypeInt && resultType != TypeFloat {
+		return fmt.Errorf("operator %s not defined for type %s", operator, resultType)
+	}
+
+	if postfix {
+		// Stack: [new value] -> [old value]
+		prefix := wasmType(resultType)
+		c.emit(prefix + ".const 1")
+		if op == "+" {
+			c.emit(prefix + ".sub")
+		} else {
+			c.emit(prefix + ".add")
+		}
+	}
+	c.stackType = resultType
+	return nil
+}
+
+// compileCompoundAssignment 编译 a op= b：a 中的对象和下标只求值一次，读和写都使用同一个值
+func (c *Compiler) compileCompoundAssignment(node *ast.AssignmentExpression) error {
+	infix := node.Value.(*ast.InfixExpression)
+	target, err := c.bindLvalue(node.Left)
+	if err != nil {
+		return err
+	}
+	return c.Compile(&ast.AssignmentExpression{
+		Token: node.Token,
+		Left:  target,
+		Value: &ast.InfixExpression{Token: infix.Token, Left: target, Operator: infix.Operator, Right: infix.Right},
+	})
+}
+
+// bindLvalue 先对赋值目标中的对象和下标表达式求值并存入临时变量，
+// 返回读写都引用这些临时变量的等价目标 (arr[i++] += 1 中的 i++ 只执行一次)
+func (c *Compiler) bindLvalue(target ast.Expression) (ast.Expression, error) {
+	switch t := target.(type) {
+	case *ast.MemberExpression:
+		if _, ok := c.staticClass(t.Object); ok {
+			return target, nil
+		}
+		object, err := c.bindOperand(t.Object)
+		if err != nil {
+			return nil, err
+		}
+		bound := *t
+		bound.Object = object
+		return &bound, nil
+	case *ast.IndexExpression:
+		left, err := c.bindOperand(t.Left)
+		if err != nil {
+			return nil, err
+		}
+		index, err := c.bindOperand(t.Index)
+		if err != nil {
+			return nil, err
+		}
+		bound := *t
+		bound.Left = left
+		bound.Index = index
+		return &bound, nil
+	}
+	return target, nil
+}
+
+// bindOperand 把表达式的值存入临时变量；变量、this、super 和字面量求值没有副作用，原样返回
+func (c *Compiler) bindOperand(expr ast.Expression) (ast.Expression, error) {
+	switch expr.(type) {
+	case *ast.Identifier, *ast.ThisExpression, *ast.SuperExpression, *ast.IntegerLiteral, *ast.StringLiteral:
+		return expr, nil
+	}
+	if err := c.Compile(expr); err != nil {
+		return nil, err
+	}
+	return c.bindTemp("lvalue", c.stackType, c.stackTypeName), nil
+}
+
+func (c *Compiler) resolveType(typeName string) DataType {
+	visited := make(map[string]bool)
+	current := typeName
+	for {
+		if visited[current] {
+			return TypeUnknown // Cycle detected
+		}
+		visited[current] = true
+
+		if len(unionMembers(current)) > 1 {
+			return TypeUnion // Only a top-level | makes a union: Array<int | null> is an array
+		}
+
+		if strings.HasPrefix(current, "(") {
+			return TypeFunc // (int) => int
+		}
+
+		switch current {
+		case "int":
+			return TypeInt
+		case "string":
+			return TypeString
+		case "bool":
+			return TypeBool
+		case "number", "float":
+			return TypeFloat
+		case "bigint":
+			return TypeBigInt
+		case "null":
+			return TypeNull
+		case "undefined":
+			return TypeUndefined
+		case "void":
+			return TypeVoid
+		case "unknown":
+			return TypeUnion // Caught exceptions and rejection reasons: tagged like union values
+		case "array":
+			return TypeArray
+		case "map":
+			return TypeMap
+		}
+
+		if strings.HasPrefix(current, "Array<") {
+			return TypeArray
+		}
+		if strings.HasPrefix(current, "Map<") {
+			return TypeMap
+		}
+
+		if alias, ok := c.typeAliases[current]; ok {
+			current = alias
+			continue
+		}
+
+		// Not a basic type or alias, assume Class/Interface (pointer) -> TypeInt
+		return TypeInt
+	}
+}
+
+func (c *Compiler) CompileModule(importPath string) (*ModuleScope, error) {
+	// 1. Resolve path
+	// If importPath starts with "./" or "../", it's relative to current module's path (dirname)
+	var absPath string
+	var err error
+	
+	if strings.HasPrefix(importPath, ".") {
+		base := c.baseDir
+		if c.currentModule != nil && c.currentModule.Path != "main" {
+			base = filepath.Dir(c.currentModule.Path)
+		}
+		absPath, err = filepath.Abs(filepath.Join(base, importPath))
+	} else {
+		// Assume absolute or CWD-relative if no prefix, or handle std lib
+		absPath, err = filepath.Abs(importPath)
+	}
+	
+	if err != nil {
+		return nil, err
+	}
+	
+	// Add extension if missing
+	if filepath.Ext(absPath) == "" {
+		absPath += ".omni"
+	}
+
+	// 2. Check cache
+	if scope, ok := c.loadedModules[absPath]; ok {
+		return scope, nil
+	}
+
+	// 3. Read file
+	content, err := os.ReadFile(absPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read module %s: %v", absPath, err)
+	}
+
+	// 4. Parse
+	l := lexer.New(string(content))
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		return nil, fmt.Errorf("parser errors in module %s: %v", absPath, p.Errors())
+	}
+
+	// 5. Create Scope
+	// Generate prefix based on hash of path
+	hash := sha256.Sum256([]byte(absPath))
+	prefix := "mod_" + hex.EncodeToString(hash[:4]) + "_"
+	
+	scope := &ModuleScope{
+		Path:          absPath,
+		Prefix:        prefix,
+		SymbolAliases: make(map[string]string),
+		Exports:       make(map[string]string),
+	}
+	
+	// Push scope
+	c.moduleStack = append(c.moduleStack, scope)
+	c.currentModule = scope
+	
+	// 6. Compile
+	// Recursively compile the program
+	if err := c.Compile(program); err != nil {
+		// pop scope on error
+		c.moduleStack = c.moduleStack[:len(c.moduleStack)-1]
+		c.currentModule = c.moduleStack[len(c.moduleStack)-1]
+		return nil, err
+	}
+	
+	// Pop scope
+	c.moduleStack = c.moduleStack[:len(c.moduleStack)-1]
+	c.currentModule = c.moduleStack[len(c.moduleStack)-1]
+	
+	// 7. Cache
+	c.loadedModules[absPath] = scope
+	
+	return scope, nil
+}
+
+func (c *Compiler) Compile(node ast.Node) error {
+	c.stackTypeName = ""
+	// The expected function type only applies to the node compiled right now
+	expectedFuncType := c.expectedFuncType
+	c.expectedFuncType = ""
+	switch n := node.(type) {
+	case *ast.PrefixExpression, *ast.InfixExpression, *ast.PostfixExpression:
+		// 运算结果不是对象，不能沿用操作数留下的类型名 (a ?? b 除外)
+		if infix, ok := n.(*ast.InfixExpression); !ok || infix.Operator != "??" {
+			defer func() { c.stackTypeName = "" }()
+		}
+	case ast.Expression:
+		if link := optionalLink(n); link != nil {
+			return c.compileOptionalChain(n, link)
+		}
+	}
+	switch node := node.(type) {
+	case *ast.Program:
+		// Helper to unwrap ExportStatement
+		unwrap := func(s ast.Statement) (ast.Statement, bool) {
+			if exp, ok := s.(*ast.ExportStatement); ok {
+				return exp.Statement, true
+			}
+			return s, false
+		}
+		
+		// Helper to register export if needed
+		registerExport := func(s ast.Statement, inner ast.Statement) {
+			if _, ok := s.(*ast.ExportStatement); ok {
+				var name string
+				switch stmt := inner.(type) {
+				case *ast.ClassStatement:
+					name = stmt.Name.Value
+				case *ast.ExpressionStatement:
+					if fn, ok := stmt.Expression.(*ast.FunctionLiteral); ok {
+						name = fn.Name
+					}
+				}
+				if name != "" {
+					mangledName := c.currentModule.Prefix + name
+					c.currentModule.Exports[name] = mangledName
 				}
 			}
-			if !found {
-				return fmt.Errorf("unknown method: %s", methodName)
+		}
+
+		// 1. First pass: Compile imports
+		for _, stmt := range node.Statements {
+			if _, ok := stmt.(*ast.ImportStatement); ok {
+				if err := c.Compile(stmt); err != nil {
+					return err
+				}
 			}
-			
-			// Compile other arguments
-			for _, arg := range node.Arguments {
-				if err := c.Compile(arg); err != nil {
+			if _, ok := stmt.(*ast.ImportModuleStatement); ok {
+				if err := c.Compile(stmt); err != nil {
 					return err
 				}
 			}
-			
-			c.emit(fmt.Sprintf("call $%s", mangledName))
-			c.stackType = TypeInt
-			return nil
 		}
 
-		if ident, ok := node.Function.(*ast.Identifier); ok {
-			funcName := ident.Value
+		// 1.2 Pass: Define Type Aliases
+		for _, stmt := range node.Statements {
+			s, _ := unwrap(stmt)
+			if typeAliasStmt, ok := s.(*ast.TypeAliasStatement); ok {
+				c.typeAliases[typeAliasStmt.Name.Value] = typeAliasStmt.Value
+			}
+		}
 
-			// Determine call type
-			isLocalSymbol := false
-			if _, ok := c.current.Symbols[funcName]; ok {
-				isLocalSymbol = true
+		// 1.3 Pass: Define Enums
+		for _, stmt := range node.Statements {
+			s, _ := unwrap(stmt)
+			if enumStmt, ok := s.(*ast.EnumStatement); ok {
+				if err := c.defineEnum(enumStmt); err != nil {
+					return err
+				}
 			}
-			
-			// Module Resolution
-			prefixed := ""
-			if c.currentModule != nil && c.currentModule.Prefix != "" {
-				prefixed = c.currentModule.Prefix + funcName
+		}
+
+		// 1.35 Pass: Collect Generic Functions and Classes (instantiated when used)
+		for _, stmt := range node.Statements {
+			s, _ := unwrap(stmt)
+			generic, err := c.defineGeneric(s)
+			if err != nil {
+				return err
 			}
-			
-			resolvedName := funcName
-			isDefined := false
-			
-			// 1. Try prefixed (Local module function)
-			if _, ok := c.definedFuncs[prefixed]; ok {
-				resolvedName = prefixed
-				isDefined = true
-			} else if c.currentModule != nil {
-				// 2. Try alias (Imported module function)
-				if alias, ok := c.currentModule.SymbolAliases[funcName]; ok {
-					resolvedName = alias
-					// Check if it's defined (it should be)
-					if _, ok := c.definedFuncs[alias]; ok {
-						isDefined = true
-					}
-				}
+			if generic {
+				registerExport(stmt, s)
 			}
-			
-			// 3. Try global (stdlib or main or FFI that looks defined?)
-			if !isDefined {
-				if _, ok := c.definedFuncs[funcName]; ok {
-					resolvedName = funcName
-					isDefined = true
+		}
+
+		// 1.4 Pass: Define Interfaces
+		for _, stmt := range node.Statements {
+			s, _ := unwrap(stmt)
+			if ifaceStmt, ok := s.(*ast.InterfaceStatement); ok {
+				if err := c.defineInterface(ifaceStmt); err != nil {
+					return err
 				}
 			}
-			
-			// FFI Import Check (always global name)
-			isImported := false
-			if _, ok := c.importedFuncs[funcName]; ok {
-				isImported = true
-				resolvedName = funcName
+		}
+
+		// 1.5 Pass: Define Classes
+		for _, stmt := range node.Statements {
+			s, _ := unwrap(stmt)
+			if classStmt, ok := s.(*ast.ClassStatement); ok && len(classStmt.TypeParams) == 0 {
+				if err := c.expandTypeNames(classStmt, nil); err != nil {
+					return err
+				}
+				if err := c.defineClass(classStmt); err != nil {
+					return err
+				}
+				registerExport(stmt, s)
 			}
-			
-			// 1. Local Symbol (e.g. host handle in var)
-			if isLocalSymbol {
-				if err := c.Compile(ident); err != nil { return err }
-				if c.stackType == TypeHost {
-					// Call host function handle
-					handleTemp := c.current.NextLocalID
-					c.current.NextLocalID++
-					realHandleTemp := handleTemp + c.current.ParamCount
-					c.emit(fmt.Sprintf("local.set %d", realHandleTemp))
+		}
+
+		// 1.6 Pass: Compile Class Methods
+		for _, stmt := range node.Statements {
+			s, _ := unwrap(stmt)
+			if classStmt, ok := s.(*ast.ClassStatement); ok && len(classStmt.TypeParams) == 0 {
+				if err := c.compileClassMethods(classStmt); err != nil {
+					return err
+				}
+			}
+		}
+
+		// 1.7 Pass: Check Abstract and Interface Implementation
+		for _, stmt := range node.Statements {
+			s, _ := unwrap(stmt)
+			if classStmt, ok := s.(*ast.ClassStatement); ok && len(classStmt.TypeParams) == 0 {
+				if err := c.checkAbstractImplementation(classStmt); err != nil {
+					return err
+				}
+				if err := c.checkInterfaceImplementation(classStmt); err != nil {
+					return err
+				}
+			}
+		}
+
+		// 1.8 Pass: Collect Function Names and Signatures
+		for _, stmt := range node.Statements {
+			s, _ := unwrap(stmt)
+			if exprStmt, ok := s.(*ast.ExpressionStatement); ok {
+				if fn, ok := exprStmt.Expression.(*ast.FunctionLiteral); ok && len(fn.TypeParams) == 0 {
+					if err := c.expandTypeNames(fn, nil); err != nil {
+						return err
+					}
+					sig := c.functionSignature(fn)
 					
-					// Prepare args
-					argCount := len(node.Arguments)
-					argsTemp := c.current.NextLocalID
-					c.current.NextLocalID++
-					realArgsTemp := argsTemp + c.current.ParamCount
+					// Use prefixed name
+					mangledName := c.currentModule.Prefix + fn.Name
+					c.definedFuncs[mangledName] = sig
 					
-					if argCount > 0 {
-						c.emit(fmt.Sprintf("i32.const %d", argCount*4))
-						c.emit("i32.const 20")
-						c.emit("call $malloc")
-						c.emit(fmt.Sprintf("local.set %d", realArgsTemp))
-						
-						for i, arg := range node.Arguments {
-							c.emit(fmt.Sprintf("local.get %d", realArgsTemp))
-							c.emit(fmt.Sprintf("i32.const %d", i*4))
-							c.emit("i32.add")
-							if err := c.Compile(arg); err != nil { return err }
-							argType := c.stackType
-							if argType == TypeString {
-								c.emit("call $host_from_string")
-							} else if argType == TypeInt || argType == TypeBool {
-								c.emit("call $host_from_int")
-							}
-							c.emit("i32.store")
-						}
-					} else {
-						c.emit("i32.const 0")
-						c.emit(fmt.Sprintf("local.set %d", realArgsTemp))
-					}
+					// Assign ID for Scheduler
+					c.funcIDs[mangledName] = c.nextFuncID
+					c.nextFuncID++
 					
-					c.emit(fmt.Sprintf("local.get %d", realHandleTemp))
-					c.emit("i32.const 0")
-					c.emit(fmt.Sprintf("local.get %d", realArgsTemp))
-					c.emit(fmt.Sprintf("i32.const %d", argCount))
-					c.emit("call $host_call")
-					c.stackType = TypeHost
-					return nil
+					registerExport(stmt, s)
 				}
-				// Else: Local var but not TypeHost? Function pointer not supported.
-				return fmt.Errorf("calling local variable %s of type %s not supported", funcName, c.stackType)
 			}
-			
-			// 2. Imported Function
-			if isImported {
-				// Compile arguments normally (push to stack)
-				for _, arg := range node.Arguments {
-					if err := c.Compile(arg); err != nil { return err }
-				}
-				
-				c.emit(fmt.Sprintf("call $%s", resolvedName))
-				
-				if c.importedFuncs[resolvedName].ReturnType != "void" {
-					c.stackType = TypeInt
-				} else {
-					c.stackType = TypeVoid
+		}
+
+		// 2. Second pass: Compile functions
+		for _, stmt := range node.Statements {
+			s, _ := unwrap(stmt)
+			if exprStmt, ok := s.(*ast.ExpressionStatement); ok {
+				if fn, ok := exprStmt.Expression.(*ast.FunctionLiteral); ok && len(fn.TypeParams) == 0 {
+					if err := c.compileFunction(fn); err != nil {
+						return err
+					}
 				}
-				return nil
 			}
-			
-			// 3. Defined Internal Function (or stdlib)
-			if isDefined {
-				sig := c.definedFuncs[resolvedName]
-				if len(node.Arguments) != len(sig.ParamTypes) {
-					return fmt.Errorf("function %s expects %d arguments, got %d", funcName, len(sig.ParamTypes), len(node.Arguments))
-				}
+		}
+
+		// 3. Generic instances and static field initializers of every module, once the entry program is done
+		if len(c.moduleStack) == 1 {
+			if err := c.compileGenericInstances(); err != nil {
+				return err
+			}
+			if err := c.compileStaticInits(); err != nil {
+				return err
+			}
+			// Static initializers may instantiate more generics
+			if err := c.compileGenericInstances(); err != nil {
+				return err
+			}
+		}
+
+	case *ast.ImportStatement:
+		// Generate Wasm import
+		funcName := node.Name.Value
+		
+		paramsStr := ""
+		for range node.Parameters {
+			paramsStr += " (param i32)"
+		}
+
+		resultStr := ""
+		if node.ReturnType != "void" && node.ReturnType != "" {
+			resultStr = " (result i32)"
+		}
+
+		importStr := fmt.Sprintf("(import \"env\" \"%s\" (func $%s%s%s))", funcName, funcName, paramsStr, resultStr)
+		c.imports = append(c.imports, importStr)
+		c.importedFuncs[funcName] = node
+	
+	case *ast.ImportModuleStatement:
+		// Compile module
+		scope, err := c.CompileModule(node.Source)
+		if err != nil {
+			return err
+		}
+		
+		// Import symbols
+		for _, ident := range node.Identifiers {
+			name := ident.Value
+			// Look up in exports
+			if mangledName, ok := scope.Exports[name]; ok {
+				c.currentModule.SymbolAliases[name] = mangledName
+			} else {
+				return fmt.Errorf("module %s does not export %s", node.Source, name)
+			}
+		}
+		return nil
+
+	case *ast.ExportStatement:
+		// Compile inner statement
+		// We need to determine the name to export.
+		var name string
+		
+		switch stmt := node.Statement.(type) {
+		case *ast.ClassStatement:
+			name = stmt.Name.Value
+		case *ast.ExpressionStatement:
+			if fn, ok := stmt.Expression.(*ast.FunctionLiteral); ok {
+				name = fn.Name
+			}
+		}
+		
+		// Compile the statement
+		if err := c.Compile(node.Statement); err != nil {
+			return err
+		}
+		
+		// Register export
+		if name != "" {
+			// The symbol is defined with prefix in the current module.
+			mangledName := c.currentModule.Prefix + name
+			c.currentModule.Exports[name] = mangledName
+		}
+		return nil
+
+	case *ast.InterfaceStatement:
+		// Interfaces are compile-time only, no code generation needed.
+		return nil
+
+	case *ast.EnumStatement:
+		// Enums are compile-time definitions (constants)
+		return nil
+
+	case *ast.SpawnStatement:
+		// spawn func(args)
+		// We need to:
+		// 1. Get function index (table index) - MVP: pass function name/ID?
+		// WASM `call_indirect` uses table index. We need to put functions in a table.
+		// For MVP, we can't easily get table index dynamically without extensive changes.
+		// Alternative: Pass function Name (string) to host, and host calls back?
+		// But host can't call internal WASM functions easily by name unless exported.
+		
+		// Strategy:
+		// 1. Compile call arguments into an Array or struct.
+		// 2. Call $thread_spawn(func_id, args_ptr).
+		// We need to map functions to IDs.
+		
+		// For MVP: Only support spawning 'main' or exported functions?
+		// Or assume we export everything or use a table.
+		
+		// Let's use a simplified approach:
+		// 1. Compile arguments -> store in array/struct.
+		// 2. Identify the function being called.
+		// 3. We need a dispatch function `(func $dispatch (param $id i32) (param $args i32))` exported to host.
+		// 4. Host calls `dispatch` in new thread.
+		
+		// But adding a dispatch function requires knowing all possible spawn targets.
+		// Let's assume for now we only spawn `function` calls (not methods).
+		
+		callExpr := node.Call
+		var funcName string
+		if ident, ok := callExpr.Function.(*ast.Identifier); ok {
+			funcName = ident.Value
+		} else {
+			return fmt.Errorf("spawn only supports direct function calls")
+		}
+		
+		// Create a unique ID for this function name (or use string)
+		// Using string is easier for host to debug, but slower.
+		// Let's use string for MVP.
+		
+		// 1. Pack arguments
+		argCount := len(callExpr.Arguments)
+		c.emit(fmt.Sprintf("i32.const %d", argCount))
+		c.emit("call $array_new")
+		// Stack: [arr]
+		
+		// Temp local for array
+		arrTemp := c.current.NextLocalID
+		c.current.NextLocalID++
+		realArrTemp := arrTemp + c.current.ParamCount
+		c.current.Symbols[fmt.Sprintf("$spawn_arr_%d", arrTemp)] = Symbol{Index: arrTemp, Type: TypeArray, IsParam: false, ShadowIndex: c.current.ShadowStackSize}
+		c.current.ShadowStackSize++ // Track it
+		
+		c.emit(fmt.Sprintf("local.set %d", realArrTemp))
+		
+		// Push to shadow stack
+		c.emit("global.get $shadow_stack_ptr")
+		c.emit(fmt.Sprintf("local.get %d", realArrTemp))
+		c.emit("i32.store")
+		c.emit("global.get $shadow_stack_ptr")
+		c.emit("i32.const 4")
+		c.emit("i32.add")
+		c.emit("global.set $shadow_stack_ptr")
+		
+		paramTypes := c.definedFuncs[funcName].ParamTypes
+		for i, arg := range callExpr.Arguments {
+			c.emit(fmt.Sprintf("local.get %d", realArrTemp))
+			if err := c.Compile(arg); err != nil {
+				return err
+			}
+			// number args travel boxed; the wrapper unboxes them
+			argType := c.stackType
+			if i < len(paramTypes) {
+				if err := c.emitConvert(argType, paramTypes[i], "spawn "+funcName); err != nil {
+					return err
+				}
+				if paramTypes[i] == TypeFloat {
+					argType = TypeFloat
+				}
+			}
+			if err := c.emitStoreElement(argType, argType); err != nil {
+				return err
+			}
+			c.emit("call $array_push")
+		}
+		
+		// 2. Function ID
+		funcID, ok := c.funcIDs[funcName]
+		if !ok {
+			return fmt.Errorf("spawn target '%s' not found (imported functions not yet supported for spawn)", funcName)
+		}
+		c.emit(fmt.Sprintf("i32.const %d ;; func_id for %s", funcID, funcName))
+		
+		// 3. Call $scheduler_submit(func_id, args_arr_ptr)
+		c.emit(fmt.Sprintf("local.get %d", realArrTemp))
+		c.emit("call $scheduler_submit")
+		
+		c.stackType = TypeVoid
+		return nil
+
+	case *ast.TypeAliasStatement:
+		// Already handled in pass 1.2
+		return nil
+
+	case *ast.ExpressionStatement:
+		if fn, ok := node.Expression.(*ast.FunctionLiteral); ok && fn.Name != "" && c.current != nil {
+			// Nested function declaration: bind it like `let name = function...`
+			return c.Compile(&ast.LetStatement{Token: node.Token, Name: &ast.Identifier{Token: fn.Token, Value: fn.Name}, Value: fn})
+		}
+		if err := c.Compile(node.Expression); err != nil {
+			return err
+		}
+		if c.stackType != TypeVoid {
+			c.emit("drop")
+		}
+
+	case *ast.LetStatement:
+		if node.Pattern != nil {
+			return c.compileDestructuring(node.Pattern, node.Value, node.Type, true)
+		}
+		captured := c.current.Env != nil && c.current.Captured[node.Name.Value]
+		if captured {
+			if fn, isFunc := node.Value.(*ast.FunctionLiteral); isFunc {
+				// Register the slot first so the function can call itself
+				typeName := node.Type
+				if typeName == "" {
+					sig, _ := c.closureSignature(fn, "")
+					typeName = funcTypeName(sig)
+				}
+				env, offset := c.envSlot(node.Name.Value)
+				c.current.Symbols[node.Name.Value] = Symbol{Index: offset, Type: TypeFunc, ShadowIndex: -1, TypeName: typeName, InEnv: true, Env: env}
+			}
+		}
+
+		c.expectedFuncType = node.Type
+		err := c.Compile(node.Value)
+		c.expectedFuncType = ""
+		if err != nil {
+			return err
+		}
+
+		valueType := c.stackType
+		if valueType == TypeUnknown {
+			valueType = TypeInt // Default to int for MVP if unknown
+		}
+		typeName := node.Type
+		if typeName == "" {
+			typeName = c.stackTypeName
+		} else if err := c.checkAssignable(valueType, c.stackTypeName, node.Type, "declaration of "+node.Name.Value); err != nil {
+			return err
+		}
+
+		// Declared number: promote int initializers to f64
+		if node.Type != "" && c.resolveType(node.Type) != TypeUnion {
+			declared := c.resolveType(node.Type)
+			if err := c.emitConvert(valueType, declared, fmt.Sprintf("declaration of %s", node.Name.Value)); err != nil {
+				return err
+			}
+			if isWideType(declared) || valueType == TypeNull || valueType == TypeUndefined {
+				// Dog | null = null is still a Dog variable
+				valueType = declared
+			}
+		}
+		
+		// Union Type Check
+		// If declared type is a union (int | null, or an alias of one),
+		// we box the value if it's not already boxed (TypeUnion).
+		if node.Type != "" && c.resolveType(node.Type) == TypeUnion {
+			if valueType == TypeBigInt {
+				return fmt.Errorf("bigint cannot be stored in a union type yet: %s", node.Name.Value)
+			}
+			// Box the value; null / undefined and other unions are already references
+			// We need a helper function $box_value(val, type_id) -> ptr
+			if valueType != TypeUnion && valueType != TypeNull && valueType != TypeUndefined {
+				c.emitToUnion(valueType, c.stackTypeName)
+			}
+			valueType = TypeUnion
+		}
+
+		if captured {
+			// Captured by a closure: the variable lives in the environment object
+			env, offset := c.envSlot(node.Name.Value)
+			sym := Symbol{Index: offset, Type: valueType, ShadowIndex: -1, TypeName: typeName, InEnv: true, Env: env}
+			if env != nil {
+				env.Types[node.Name.Value] = valueType
+			} else {
+				c.current.Env.Types[node.Name.Value] = valueType
+			}
+			c.current.Symbols[node.Name.Value] = sym
+			c.emitStoreCaptured(node.Name.Value, sym, c.current)
+			c.emit("drop")
+			return nil
+		}
+
+		if isWideType(valueType) {
+			// f64/i64 locals hold no pointer, so they are not tracked by the shadow stack
+			index := c.current.NextLocalID
+			c.current.Symbols[node.Name.Value] = Symbol{
+				Index: index,
+				Type: valueType,
+				IsParam: false,
+				ShadowIndex: -1,
+				TypeName: typeName,
+			}
+			c.current.LocalTypes[index] = valueType
+			c.current.NextLocalID++
+			c.emit(fmt.Sprintf("local.set %d ;; %s (%s)", index+c.current.ParamCount, node.Name.Value, valueType))
+			return nil
+		}
+
+		index := c.current.NextLocalID
+		shadowIndex := c.current.ShadowStackSize
+		
+		c.current.Symbols[node.Name.Value] = Symbol{
+			Index: index, 
+			Type: valueType, 
+			IsParam: false,
+			ShadowIndex: shadowIndex,
+			TypeName: typeName,
+		}
+		c.current.NextLocalID++
+		c.current.ShadowStackSize++
+
+		realIndex := index + c.current.ParamCount
+		// local.set %d
+		c.emit(fmt.Sprintf("local.set %d ;; %s (%s)", realIndex, node.Name.Value, valueType))
+		
+		// Push to shadow stack
+		// store(shadow_stack_ptr, value)
+		// shadow_stack_ptr += 4
+		c.emit("global.get $shadow_stack_ptr")
+		c.emit(fmt.Sprintf("local.get %d", realIndex))
+		c.emit("i32.store")
+		
+		c.emit("global.get $shadow_stack_ptr")
+		c.emit("i32.const 4")
+		c.emit("i32.add")
+		c.emit("global.set $shadow_stack_ptr")
+
+	case *ast.ClassStatement:
+		// Already handled in passes
+		return nil
+
+	case *ast.NewExpression:
+		if tmpl, ok := c.lookupGeneric(c.genericClasses, node.Class.Value); ok {
+			return c.compileGenericNew(tmpl, node)
+		}
+		className := c.resolveClassName(node.Class.Value)
+		if _, ok := c.classes[className]; !ok && className == "Promise" {
+			return c.compileNewPromise(node)
+		}
+		return c.compileNew(node, className, func(sig FunctionSignature, context string) error {
+			if err := checkArgCount(sig, node.Arguments, context); err != nil {
+				return err
+			}
+			return c.compileArgs(node.Arguments, sig, context)
+		})
+
+	case *ast.SuperExpression:
+		// super.method() logic needs MemberExpression context, but if used alone?
+		// Usually super() is for constructor.
+		// super.foo() is for method access.
+		// AST structure for super.foo() is MemberExpression(Object=SuperExpression, Property=foo)
+		
+		// If we are here, it means 'super' is used as a value, which is not really valid in this MVP except for member access.
+		// But let's return 'this' pointer because super calls usually operate on 'this' instance.
+		c.emitThis()
+		c.stackType = TypeInt
+		return nil
+
+	case *ast.MemberExpression:
+		// Check for Enum Access (e.g., Color.Red)
+		if ident, ok := node.Object.(*ast.Identifier); ok {
+			if members, isEnum := c.lookupEnum(ident.Value); isEnum {
+				val, ok := members[node.Property.Value]
+				if !ok {
+					return fmt.Errorf("enum %s has no member %s", ident.Value, node.Property.Value)
+				}
+				c.emit(fmt.Sprintf("i32.const %d", val))
+				c.stackType = TypeInt
+				return nil
+			}
+		}
+
+		// Static fields and get accessors: Class.name
+		if cls, ok := c.staticClass(node.Object); ok {
+			name := node.Property.Value
+			if err := c.accessError(cls.StaticMembers[name], name, node.Token.Line); err != nil {
+				return err
+			}
+			if field, ok := cls.StaticFields[name]; ok {
+				c.emit("global.get " + field.Global)
+				c.stackType = field.Type
+				c.stackTypeName = field.TypeName
+				return nil
+			}
+			if getter, ok := cls.StaticMethods["@get_"+name]; ok {
+				sig := cls.StaticMethodSigs["@get_"+name]
+				c.emit(fmt.Sprintf("call $%s", getter))
+				c.setCallResult(sig)
+				return nil
+			}
+			if name == "name" {
+				// Class.name
+				className := displayClassName(cls.Name)
+				c.emit(fmt.Sprintf("i32.const %d ;; %q", c.internString(className), className))
+				c.stackType = TypeString
+				return nil
+			}
+			return fmt.Errorf("line %d: class %s has no static property %s", node.Token.Line, cls.Name, name)
+		}
+
+		// obj.constructor.name: the class name at runtime
+		if inner, ok := node.Object.(*ast.MemberExpression); ok && inner.Property.Value == "constructor" && node.Property.Value == "name" {
+			return c.compileConstructorName(inner.Object)
+		}
+
+		// Check for process.env
+		if ident, ok := node.Object.(*ast.Identifier); ok && ident.Value == "process" && node.Property.Value == "env" {
+			if c.target != "wasi" {
+				return fmt.Errorf("process.env is only supported in WASI target")
+			}
+			c.emit("call $process_env")
+			c.stackType = TypeMap
+			return nil
+		}
+
+		// Special handling for super.method()
+
+		if _, ok := node.Object.(*ast.SuperExpression); ok {
+			if c.currentClass == "" {
+				return fmt.Errorf("super used outside of class")
+			}
+			// We just let it fall through. compile(SuperExpression) puts 'this' on stack.
+			// If it's a field access, the generic field lookup below will find it (inherited fields are copied).
+			// If it's a method access not in a call, it will fail or behave weirdly, but we don't support function pointers yet.
+		}
+
+		if err := c.Compile(node.Object); err != nil {
+			return err
+		}
+		objTypeName := c.stackTypeName
+		
+		propName := node.Property.Value
+		
+		// String.length
+		if propName == "length" && c.stackType == TypeString {
+			c.emit("call $strlen")
+			c.stackType = TypeInt
+			c.stackTypeName = ""
+			return nil
+		}
+
+		// Array.length
+		if propName == "length" {
+			// MVP: Assume if property is "length", it's array length
+			// In strict mode, we should check c.stackType == TypeArray
+			c.emit("call $array_length")
+			c.stackType = TypeInt
+			c.stackTypeName = ""
+			return nil
+		}
+		
+		// Host Object Property Get
+		if c.stackType == TypeHost {
+			// call $host_get(handle, "prop")
+			offset, ok := c.stringPool[propName]
+			if !ok {
+				offset = c.nextDataOffset
+				c.stringPool[propName] = offset
+				c.nextDataOffset += len(propName) + 1
+			}
+			c.emit(fmt.Sprintf("i32.const %d ;; \"%s\"", offset, propName))
+			c.emit("call $host_get")
+			c.stackType = TypeHost
+			return nil
+		}
+		
+		// IteratorResult<T>: [value, done]
+		if valueTypeName, ok := iteratorResultValueType(objTypeName); ok {
+			switch propName {
+			case "value":
+				valueType := c.resolveValueType(valueTypeName)
+				c.emit(wasmType(valueType) + ".load ;; value")
+				c.stackType = valueType
+				c.stackTypeName = valueTypeName
+			case "done":
+				c.emit("i32.load offset=8 ;; done")
+				c.stackType = TypeBool
+			default:
+				return fmt.Errorf("IteratorResult has no property %s", propName)
+			}
+			return nil
+		}
+
+		// MVP: Search for property in all known classes (since we lack full type system)
+		// Better approach: Since we don't have type info on stack, we have to guess or search all classes.
+		// If multiple classes have same field name but different types, we might have issues.
+		// Ideally, we should track type of object on stack. But c.stackType is just DataType enum.
+		// If c.stackType is TypeInt (pointer), we don't know which class it is.
+		
+		// get accessor: call the hidden @get_ method with the object on the stack
+		accessorClass := objTypeName
+		if _, ok := node.Object.(*ast.SuperExpression); ok {
+			accessorClass = c.classes[c.currentClass].Parent
+		}
+		if getter, sig, ok := c.lookupAccessor(accessorClass, "@get_"+propName); ok {
+			if err := c.checkMemberAccess(objTypeName, propName, node.Token.Line); err != nil {
+				return err
+			}
+			if accessorClass == objTypeName {
+				err := c.emitMethodCall(accessorClass, "@get_"+propName, getter, sig, func(bool) error { return nil })
+				if err != nil {
+					return err
+				}
+			} else if owner, abstract := c.classes[accessorClass].AbstractMethods["@get_"+propName]; abstract {
+				return fmt.Errorf("line %d: abstract accessor %s of class %s cannot be called through super", node.Token.Line, propName, owner)
+			} else {
+				c.emit(fmt.Sprintf("call $%s", getter)) // super.name
+			}
+			c.setCallResult(sig)
+			return nil
+		}
+
+		offset, fieldType, fieldTypeName, found := c.lookupField(objTypeName, propName)
+		if !found {
+			if _, _, ok := c.lookupAccessor(accessorClass, "@set_"+propName); ok {
+				return fmt.Errorf("line %d: property %s has a setter but no getter", node.Token.Line, propName)
+			}
+			if cls, ok := c.classes[objTypeName]; ok {
+				if _, ok := cls.StaticFields[propName]; ok {
+					return fmt.Errorf("line %d: %s is a static property, use %s.%s", node.Token.Line, propName, cls.Name, propName)
+				}
+			}
+			return fmt.Errorf("unknown property: %s", propName)
+		}
+		if err := c.checkMemberAccess(objTypeName, propName, node.Token.Line); err != nil {
+			return err
+		}
+		
+		c.emit(fmt.Sprintf("i32.const %d", offset))
+		c.emit("i32.add")
+		c.emit(wasmType(fieldType) + ".load")
+		c.stackType = fieldType
+		c.stackTypeName = fieldTypeName
+		return nil
+
+	case *ast.AssignmentExpression:
+		switch node.Left.(type) {
+		case *ast.ObjectPattern, *ast.ArrayPattern:
+			return c.compileDestructuring(node.Left, node.Value, "", false)
+		}
+		if node.Compound {
+			return c.compileCompoundAssignment(node)
+		}
+		// Handle MemberExpression assignment: obj.prop = val
+		if member, ok := node.Left.(*ast.MemberExpression); ok {
+			// Static fields and set accessors: Class.name = val
+			if cls, ok := c.staticClass(member.Object); ok {
+				name := member.Property.Value
+				if err := c.accessError(cls.StaticMembers[name], name, member.Token.Line); err != nil {
+					return err
+				}
+				if field, ok := cls.StaticFields[name]; ok {
+					if cls.StaticMembers[name].Readonly {
+						return fmt.Errorf("line %d: cannot assign to %s because it is a read-only property", member.Token.Line, name)
+					}
+					if err := c.Compile(node.Value); err != nil {
+						return err
+					}
+					if err := c.emitConvert(c.stackType, field.Type, "assignment to "+name); err != nil {
+						return err
+					}
+					tempIndex := c.newTempLocal("assign", field.Type)
+					c.emit(fmt.Sprintf("local.tee %d", tempIndex))
+					c.emit("global.set " + field.Global)
+					c.emit(fmt.Sprintf("local.get %d", tempIndex))
+					c.stackType = field.Type
+					c.stackTypeName = field.TypeName
+					return nil
+				}
+				if setter, ok := cls.StaticMethods["@set_"+name]; ok {
+					return c.compileSetterCall("", "@set_"+name, setter, cls.StaticMethodSigs["@set_"+name], node.Value)
+				}
+				if _, ok := cls.StaticMethods["@get_"+name]; ok {
+					return fmt.Errorf("line %d: cannot assign to %s because it is a read-only property", member.Token.Line, name)
+				}
+				return fmt.Errorf("line %d: class %s has no static property %s", member.Token.Line, cls.Name, name)
+			}
+
+			if err := c.Compile(member.Object); err != nil {
+				return err
+			}
+			targetType := c.stackType
+			objTypeName := c.stackTypeName
+			
+			propName := member.Property.Value
+			
+			// Host Object Property Set
+			if targetType == TypeHost {
+				// call $host_set(handle, "prop", value)
+				offset, ok := c.stringPool[propName]
+				if !ok {
+					offset = c.nextDataOffset
+					c.stringPool[propName] = offset
+					c.nextDataOffset += len(propName) + 1
+				}
+				c.emit(fmt.Sprintf("i32.const %d ;; \"%s\"", offset, propName))
+				
+				// Value
+				if err := c.Compile(node.Value); err != nil {
+					return err
+				}
+				valueType := c.stackType
+				if isWideType(valueType) {
+					return fmt.Errorf("passing %s to host objects is not supported yet", typeNameOf(valueType, ""))
+				}
+				
+				// Auto-convert value if needed
+				if valueType == TypeString {
+					c.emit("call $host_from_string")
+				} else if valueType == TypeInt || valueType == TypeBool {
+					c.emit("call $host_from_int")
+				}
+				// If it's TypeHost, it's already a handle
+				
+				c.emit("call $host_set")
+				c.emit("i32.const 0")
+				return nil
+			}
+			
+			// set accessor: the object is already on the stack
+			accessorClass := objTypeName
+			if _, ok := member.Object.(*ast.SuperExpression); ok {
+				accessorClass = c.classes[c.currentClass].Parent
+			}
+			if setter, sig, ok := c.lookupAccessor(accessorClass, "@set_"+propName); ok {
+				if err := c.checkMemberAccess(objTypeName, propName, member.Token.Line); err != nil {
+					return err
+				}
+				if accessorClass != objTypeName {
+					accessorClass = "" // super.name = value calls the parent's setter directly
+				}
+				return c.compileSetterCall(accessorClass, "@set_"+propName, setter, sig, node.Value)
+			}
+
+			// Check if we can find property in classes
+			offset, fieldType, fieldTypeName, found := c.lookupField(objTypeName, propName)
+			
+			if found {
+				if err := c.checkMemberAccess(objTypeName, propName, member.Token.Line); err != nil {
+					return err
+				}
+				if err := c.checkReadonlyAssignment(objTypeName, propName, member.Token.Line); err != nil {
+					return err
+				}
+				c.emit(fmt.Sprintf("i32.const %d", offset))
+				c.emit("i32.add")
+				
+				c.expectedFuncType = fieldTypeName
+				err := c.Compile(node.Value)
+				c.expectedFuncType = ""
+				if err != nil {
+					return err
+				}
+				valueType := c.stackType
+				if err := c.checkAssignable(valueType, c.stackTypeName, fieldTypeName, "assignment to "+propName); err != nil {
+					return err
+				}
+				if err := c.emitConvert(valueType, fieldType, "assignment to "+propName); err != nil {
+					return err
+				}
+				if isWideType(fieldType) {
+					valueType = fieldType
+				}
+				
+				// Assignment returns the stored value
+				tempIndex := c.newTempLocal("assign", valueType)
+				c.emit(fmt.Sprintf("local.tee %d", tempIndex))
+				c.emit(wasmType(valueType) + ".store")
+				c.emit(fmt.Sprintf("local.get %d", tempIndex))
+				c.stackType = valueType
+				return nil
+			}
+			
+			if _, _, ok := c.lookupAccessor(accessorClass, "@get_"+propName); ok {
+				return fmt.Errorf("line %d: cannot assign to %s because it is a read-only property", member.Token.Line, propName)
+			}
+			return fmt.Errorf("unknown property in assignment: %s", propName)
+		}
+		
+		// Handle IndexExpression assignment: arr[i] = val, map["k"] = val
+		if indexExpr, ok := node.Left.(*ast.IndexExpression); ok {
+			if err := c.Compile(indexExpr.Left); err != nil {
+				return err
+			}
+			targetType := c.stackType
+			elemType := c.resolveElementType(c.stackTypeName)
+			
+			if err := c.Compile(indexExpr.Index); err != nil {
+				return err
+			}
+			indexType := c.stackType
+			
+			// Value to set
+			if err := c.Compile(node.Value); err != nil {
+				return err
+			}
+			valueType := c.stackType
+			if err := c.emitConvert(valueType, elemType, "element assignment"); err != nil {
+				return err
+			}
+			if elemType == TypeFloat || elemType == TypeUnion {
+				valueType = elemType
+			}
+			tempIndex := c.newTempLocal("assign", valueType)
+			c.emit(fmt.Sprintf("local.tee %d", tempIndex))
+			if err := c.emitStoreElement(valueType, elemType); err != nil {
+				return err
+			}
+			
+			if targetType == TypeArray {
+				c.emit("call $array_set")
+			} else if targetType == TypeMap || indexType == TypeString {
+				c.emit("call $map_set")
+			} else {
+				// Fallback
+				c.emit("call $array_set")
+			}
+			
+			// Assignment returns the stored value
+			c.emit(fmt.Sprintf("local.get %d", tempIndex))
+			c.stackType = valueType
+			return nil
+		}
+		
+		// Handle Identifier assignment: x = val
+		if ident, ok := node.Left.(*ast.Identifier); ok {
+			sym, owner, ok := c.lookupVariable(ident.Value)
+			if !ok {
+				return fmt.Errorf("undefined variable: %s", ident.Value)
+			}
+			c.expectedFuncType = sym.TypeName
+			err := c.Compile(node.Value)
+			c.expectedFuncType = ""
+			if err != nil {
+				return err
+			}
+			if err := c.checkAssignable(c.stackType, c.stackTypeName, sym.TypeName, "assignment to "+ident.Value); err != nil {
+				return err
+			}
+			if err := c.emitConvert(c.stackType, sym.Type, "assignment to "+ident.Value); err != nil {
+				return err
+			}
+			if sym.InEnv {
+				c.emitStoreCaptured(ident.Value, sym, owner)
+				c.stackType = sym.Type
+				c.stackTypeName = sym.TypeName
+				return nil
+			}
+			
+			realIndex := sym.Index
+			if !sym.IsParam {
+				realIndex += c.current.ParamCount
+			}
+			
+			c.emit(fmt.Sprintf("local.set %d", realIndex))
+			c.emit(fmt.Sprintf("local.get %d", realIndex))
+			
+			if isWideType(sym.Type) || sym.ShadowIndex < 0 {
+				// Not tracked by the shadow stack
+				c.stackType = sym.Type
+				c.stackTypeName = sym.TypeName
+				return nil
+			}
+			
+			// Update shadow stack: value = stack[shadowBase + shadowIndex*4]
+			// We have shadowBase in local(shadowBaseIndex).
+			shadowBaseIndex := c.current.ParamCount
+			
+			c.emit(fmt.Sprintf("local.get %d ;; shadow base", shadowBaseIndex))
+			c.emit(fmt.Sprintf("i32.const %d", sym.ShadowIndex * 4))
+			c.emit("i32.add")
+			c.emit(fmt.Sprintf("local.get %d ;; value", realIndex))
+			c.emit("i32.store")
+			
+			// Assignment returns the value (TS/JS behavior)
+			// Stack already has the value from local.get above
+			c.stackType = sym.Type
+			return nil
+		}
+		
+		return fmt.Errorf("invalid assignment target")
+
+	case *ast.ThisExpression:
+		if c.inStatic {
+			return fmt.Errorf("line %d: this is not available in a static member of class %s", node.Token.Line, c.currentClass)
+		}
+		c.emitThis()
+		c.stackType = TypeInt
+		c.stackTypeName = c.currentClass
+		return nil
+
+	case *ast.NullLiteral:
+		c.emit("i32.const 0 ;; null")
+		c.stackType = TypeNull
+
+	case *ast.UndefinedLiteral:
+		c.emit(fmt.Sprintf("i32.const %d ;; undefined", undefinedPtr))
+		c.stackType = TypeUndefined
+
+	case *ast.ConditionalExpression:
+		return c.compileConditional(node)
+
+	case *ast.Identifier:
+		sym, owner, ok := c.lookupVariable(node.Value)
+		if ok {
+			c.emitLoadVariable(node.Value, sym, owner)
+			c.stackType = sym.Type
+			c.stackTypeName = sym.TypeName
+		} else if resolvedName, isDefined := c.resolveFunctionName(node.Value); isDefined {
+			// Named function used as a value
+			c.emitFunctionRef(resolvedName)
+		} else {
+			if _, ok := c.lookupGeneric(c.genericFuncs, node.Value); ok {
+				return fmt.Errorf("line %d: generic function %s cannot be used as a value, only called", node.Token.Line, node.Value)
+			}
+			// If not found in locals, check if it's a known class (constructor) or global
+			if _, ok := c.classes[node.Value]; ok {
+				// It's a class name, but we are using it as a value?
+				// Maybe static method call? Not supported yet.
+				return fmt.Errorf("class usage as value not supported: %s", node.Value)
+			}
+			
+			// Assume implicit global (Host Object)
+			// call $host_get_global("name")
+			offset, ok := c.stringPool[node.Value]
+			if !ok {
+				offset = c.nextDataOffset
+				c.stringPool[node.Value] = offset
+				c.nextDataOffset += len(node.Value) + 1
+			}
+			c.emit(fmt.Sprintf("i32.const %d ;; \"%s\"", offset, node.Value))
+			c.emit("call $host_get_global")
+			c.stackType = TypeHost
+		}
+
+	case *ast.TryStatement:
+		index := -1
+		if node.Catch != nil {
+			index = c.current.NextLocalID
+			c.current.NextLocalID++
+		}
+		// try...finally without catch: the exception waits in a temp while the finally block runs
+		pending, threw := -1, -1
+		if node.Catch == nil && node.Finally != nil {
+			pending = c.newTempLocal("exception", TypeUnknown)
+			threw = c.newTempLocal("threw", TypeBool)
+			if c.current.Async != nil {
+				c.emitNotResuming()
+				c.emit("if")
+			}
+			c.emit("i32.const 0")
+			c.emit(fmt.Sprintf("local.set %d", threw))
+			if c.current.Async != nil {
+				c.emit("end")
+			}
+		}
+		c.emit("try")
+		// c.emit("do") // Legacy try does not use 'do'
+
+		if async := c.current.Async; async != nil && node.Catch != nil {
+			if lo, hi, ok := async.suspendRange(node.Catch); ok {
+				// Resuming inside the catch block: throw the caught value again to get back into it
+				c.emit(fmt.Sprintf("local.get %d ;; resuming", async.ResumingLocal))
+				c.emitStateInRange(lo, hi)
+				c.emit("i32.and")
+				c.emit("if")
+				c.emit(fmt.Sprintf("local.get %d", index+c.current.ParamCount))
+				c.emit("throw $exception")
+				c.emit("end")
+			}
+		}
+		
+		if node.Finally != nil {
+			// break / continue leaving the body or the catch block run the finally block first
+			c.current.Finally = append(c.current.Finally, node.Finally)
+		}
+		if err := c.Compile(node.Body); err != nil {
+			return err
+		}
+		
+		c.emit("catch $exception")
+		if node.Catch != nil {
+			// Stack has exception payload: a tagged value (see ThrowStatement)
+			c.current.Symbols[node.CatchVar] = Symbol{
+				Index: index,
+				Type: TypeUnion,
+				IsParam: false,
+				ShadowIndex: -1,
+				TypeName: "unknown",
+			}
+			realIndex := index + c.current.ParamCount
+			c.emit(fmt.Sprintf("local.set %d", realIndex))
+			c.emitShadowPush(realIndex) // Keep a boxed value alive during the catch block
+			
+			if err := c.Compile(node.Catch); err != nil {
+				return err
+			}
+		} else if pending >= 0 {
+			c.emit(fmt.Sprintf("local.set %d", pending))
+			c.emitShadowPush(pending) // Keep the exception alive during the finally block
+			c.emit("i32.const 1")
+			c.emit(fmt.Sprintf("local.set %d", threw))
+		} else {
+			c.emit("drop") 
+		}
+		if node.Finally != nil {
+			c.current.Finally = c.current.Finally[:len(c.current.Finally)-1]
+		}
+		
+		c.emit("end")
+		
+		if node.Finally != nil {
+			if err := c.Compile(node.Finally); err != nil {
+				return err
+			}
+		}
+		if pending >= 0 {
+			// Nothing caught the exception: throw it again once the finally block has run
+			c.emit(fmt.Sprintf("local.get %d", threw))
+			c.emit("if")
+			c.emit(fmt.Sprintf("local.get %d", pending))
+			c.emit("throw $exception")
+			c.emit("end")
+		}
+		return nil
+
+	case *ast.AwaitExpression:
+		// Awaits are evaluated before their statement (see hoistSuspends)
+		if c.current == nil || c.current.Async == nil || c.current.Async.Generator {
+			return fmt.Errorf("line %d: await is only valid in async functions", node.Token.Line)
+		}
+		result, ok := c.current.Async.Results[node]
+		if !ok {
+			return fmt.Errorf("line %d: await is not supported here", node.Token.Line)
+		}
+		if result == nil {
+			c.stackType = TypeVoid // Promise<void>
+			return nil
+		}
+		return c.Compile(result)
+
+	case *ast.YieldExpression:
+		// Like awaits, yields run before their statement; next() takes no argument, so yield evaluates to undefined
+		if c.current == nil || c.current.Async == nil || !c.current.Async.Generator {
+			return fmt.Errorf("line %d: yield is only valid in generator functions", node.Token.Line)
+		}
+		if _, ok := c.current.Async.Results[node]; !ok {
+			if node.Delegate {
+				return fmt.Errorf("line %d: yield* is only supported as a statement", node.Token.Line)
+			}
+			return fmt.Errorf("line %d: yield is not supported here", node.Token.Line)
+		}
+		c.emit(fmt.Sprintf("i32.const %d ;; undefined", undefinedPtr))
+		c.stackType = TypeUndefined
+		return nil
+
+	case *ast.ThrowStatement:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		// Thrown values are tagged like union values, so catch can tell them apart at runtime
+		if err := c.emitConvert(c.stackType, TypeUnion, "throw"); err != nil {
+			return err
+		}
+		c.emit("throw $exception")
+		c.stackType = TypeVoid
+		return nil
+
+	case *ast.PrefixExpression:
+		if node.Operator == "typeof" {
+			if err := c.Compile(node.Right); err != nil {
+				return err
+			}
+			// Stack: [value]
+			
+			// Runtime check: boxed union values carry their TypeID
+			if c.stackType == TypeUnion {
+				c.emit("call $typeof_name")
+				c.stackType = TypeString
+				return nil
+			}
+			
+			// Compile-time known type
+			var typeStr string
+			switch c.stackType {
+			case TypeInt, TypeFloat:
+				typeStr = "number" // JS convention
+				if c.isObjectTypeName(c.stackTypeName) {
+					typeStr = "object"
+				}
+			case TypeBigInt:
+				typeStr = "bigint"
+			case TypeString:
+				typeStr = "string"
+			case TypeBool:
+				typeStr = "boolean"
+			case TypeArray:
+				typeStr = "object"
+			case TypeMap:
+				typeStr = "object"
+			case TypeHost:
+				typeStr = "object" // or "host"
+			case TypeFunc:
+				typeStr = "function"
+			case TypeNull:
+				typeStr = "object" // JS convention
+			default:
+				typeStr = "undefined"
+			}
+			
+			if c.isPointerType(c.stackType, c.stackTypeName) && c.stackType != TypeNull && c.stackType != TypeUndefined {
+				// References may hold null or undefined (string | undefined): check the pointer at runtime
+				temp := c.newTempLocal("typeof", TypeInt)
+				c.emit(fmt.Sprintf("local.tee %d", temp))
+				c.emit("i32.eqz")
+				c.emit("if (result i32)")
+				c.emit(fmt.Sprintf("i32.const %d ;; \"object\"", c.internString("object")))
+				c.emit("else")
+				c.emit(fmt.Sprintf("local.get %d", temp))
+				c.emit(fmt.Sprintf("i32.const %d ;; undefined", undefinedPtr))
+				c.emit("i32.eq")
+				c.emit("if (result i32)")
+				c.emit(fmt.Sprintf("i32.const %d ;; \"undefined\"", c.internString("undefined")))
+				c.emit("else")
+				c.emit(fmt.Sprintf("i32.const %d ;; %q", c.internString(typeStr), typeStr))
+				c.emit("end")
+				c.emit("end")
+				c.stackType = TypeString
+				return nil
+			}
+
+			c.emit("drop") // drop value
+			
+			offset, ok := c.stringPool[typeStr]
+			if !ok {
+				offset = c.nextDataOffset
+				c.stringPool[typeStr] = offset
+				c.nextDataOffset += len(typeStr) + 1
+			}
+			c.emit(fmt.Sprintf("i32.const %d", offset))
+			c.stackType = TypeString
+			return nil
+		}
+
+		if node.Operator == "++" || node.Operator == "--" {
+			return c.compileUpdateExpression(node.Right, node.Operator, node.Token, false)
+		}
+		if lit, ok := node.Right.(*ast.IntegerLiteral); ok && node.Operator == "-" {
+			// -2147483648 only fits as a whole
+			value, fits := intLiteralValue(lit, true)
+			if !fits || c.expectsNumber(expectedFuncType) {
+				c.emit(fmt.Sprintf("f64.const %d", -lit.Value))
+				c.stackType = TypeFloat
+				return nil
+			}
+			c.emit(fmt.Sprintf("i32.const %d", value))
+			c.stackType = TypeInt
+			return nil
+		}
+
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		
+		switch node.Operator {
+		case "!":
+			c.emitTruthy(c.stackType)
+			c.emit("i32.eqz")
+			c.stackType = TypeBool
+		case "~":
+			switch c.stackType {
+			case TypeBigInt:
+				c.emit("i64.const -1")
+				c.emit("i64.xor")
+				return nil
+			case TypeFloat:
+				c.emitToInt32()
+			case TypeInt, TypeBool:
+			default:
+				return fmt.Errorf("operator ~ not defined for type %s", c.stackType)
+			}
+			c.emit("i32.const -1")
+			c.emit("i32.xor")
+			c.stackType = TypeInt
+		case "-":
+			if c.stackType == TypeFloat {
+				c.emit("f64.neg")
+				return nil
+			}
+			if c.stackType == TypeBigInt {
+				c.emit("i64.const -1")
+				c.emit("i64.mul")
+				return nil
+			}
+			if c.stackType != TypeInt {
+				return fmt.Errorf("operator - not defined for type %s", c.stackType)
+			}
+			c.emit("i32.const -1")
+			c.emit("i32.mul")
+			c.stackType = TypeInt
+		default:
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+
+	case *ast.PostfixExpression:
+		return c.compileUpdateExpression(node.Left, node.Operator, node.Token, true)
+
+	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return c.compileLogicalExpression(node)
+		}
+		if node.Operator == "??" {
+			return c.compileNullish(node)
+		}
+		if node.Operator == "instanceof" {
+			return c.compileInstanceOf(node)
+		}
+
+		start := len(c.current.Instructions)
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		leftType := c.stackType
+		leftTypeName := c.stackTypeName
+
+		mid := len(c.current.Instructions)
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		rightType := c.stackType
+
+		if leftType == TypeNull || leftType == TypeUndefined || rightType == TypeNull || rightType == TypeUndefined {
+			return c.compileNullComparison(node.Operator, start, mid, leftType, leftTypeName, rightType, c.stackTypeName)
+		}
+		isNumeric := func(t DataType) bool { return t == TypeInt || t == TypeFloat || t == TypeBigInt }
+		if leftType == TypeBigInt && rightType == TypeBigInt {
+			return c.compileBigIntInfix(node.Operator)
+		}
+		if (leftType == TypeBigInt || rightType == TypeBigInt) && isNumeric(leftType) && isNumeric(rightType) {
+			return fmt.Errorf("cannot mix bigint and other types in %s: %s and %s", node.Operator, leftType, rightType)
+		}
+		if _, ok := bitwiseOps[node.Operator]; ok {
+			return c.compileBitwiseInfix(node.Operator, start, mid, leftType, rightType)
+		}
+		if (leftType == TypeFloat || rightType == TypeFloat) && isNumeric(leftType) && isNumeric(rightType) {
+			return c.compileFloatInfix(node.Operator, start, mid, leftType, rightType)
+		}
+
+		switch node.Operator {
+		case "+":
+			if leftType == TypeInt && rightType == TypeInt {
+				c.emit("i32.add")
+				c.stackType = TypeInt
+			} else if leftType == TypeString && rightType == TypeString {
+				c.emit("call $str_concat")
+				c.stackType = TypeString
+			} else if leftType == TypeString && rightType == TypeInt {
+				// String + Int
+				c.emit("call $itos")
+				c.emit("call $str_concat")
+				c.stackType = TypeString
+			} else if leftType == TypeString && (isWideType(rightType) || rightType == TypeUnion) {
+				if err := c.emitToString(rightType, "+"); err != nil {
+					return err
+				}
+				c.emit("call $str_concat")
+				c.stackType = TypeString
+			} else if (isWideType(leftType) || leftType == TypeUnion) && rightType == TypeString {
+				realTempIndex := c.newTempLocal("swap", TypeString)
+				c.emit(fmt.Sprintf("local.set %d", realTempIndex)) // Pop string
+				if err := c.emitToString(leftType, "+"); err != nil { // Convert number
+					return err
+				}
+				c.emit(fmt.Sprintf("local.get %d", realTempIndex)) // Push string back
+				c.emit("call $str_concat")
+				c.stackType = TypeString
+			} else if leftType == TypeInt && rightType == TypeString {
+				// Int + String
+				// Swap via temp local
+				tempIndex := c.current.NextLocalID
+				c.current.NextLocalID++
+				realTempIndex := tempIndex + c.current.ParamCount
+				c.current.Symbols[fmt.Sprintf("$temp_swap_%d", tempIndex)] = Symbol{Index: tempIndex, Type: TypeString, IsParam: false, ShadowIndex: -1}
+				
+				c.emit(fmt.Sprintf("local.set %d", realTempIndex)) // Pop string
+				c.emit("call $itos")                      // Convert int
+				c.emit(fmt.Sprintf("local.get %d", realTempIndex)) // Push string back
+				c.emit("call $str_concat")
+				c.stackType = TypeString
+			} else {
+				return fmt.Errorf("operator + not defined for types %s and %s", leftType, rightType)
+			}
+		case "-":
+			if leftType == TypeInt && rightType == TypeInt {
+				c.emit("i32.sub")
+				c.stackType = TypeInt
+			} else {
+				return fmt.Errorf("operator - not defined for types %s and %s", leftType, rightType)
+			}
+		case "*":
+			if leftType == TypeInt && rightType == TypeInt {
+				c.emit("i32.mul")
+				c.stackType = TypeInt
+			} else {
+				return fmt.Errorf("operator * not defined for types %s and %s", leftType, rightType)
+			}
+		case "/":
+			if leftType == TypeInt && rightType == TypeInt {
+				c.emit("i32.div_s")
+				c.stackType = TypeInt
+			} else {
+				return fmt.Errorf("operator / not defined for types %s and %s", leftType, rightType)
+			}
+		case "%":
+			if leftType == TypeInt && rightType == TypeInt {
+				c.emit("i32.rem_s")
+				c.stackType = TypeInt
+			} else {
+				return fmt.Errorf("operator %% not defined for types %s and %s", leftType, rightType)
+			}
+		case "==", "===":
+			if leftType == TypeString && rightType == TypeString {
+				// Strings compare by content
+				c.emit("call $string_equals")
+				c.stackType = TypeBool
+			} else if leftType == rightType || (leftType == TypeHost && rightType == TypeInt) || (leftType == TypeInt && rightType == TypeHost) {
+				c.emit("i32.eq")
+				c.stackType = TypeBool
+			} else {
+				return fmt.Errorf("operator %s not defined for types %s and %s", node.Operator, leftType, rightType)
+			}
+		case "!=", "!==":
+			if leftType == TypeString && rightType == TypeString {
+				c.emit("call $string_equals")
+				c.emit("i32.eqz")
+				c.stackType = TypeBool
+			} else if leftType == rightType || (leftType == TypeHost && rightType == TypeInt) || (leftType == TypeInt && rightType == TypeHost) {
+				c.emit("i32.ne")
+				c.stackType = TypeBool
+			} else {
+				return fmt.Errorf("operator %s not defined for types %s and %s", node.Operator, leftType, rightType)
+			}
+		case "<":
+			if leftType == TypeInt && rightType == TypeInt {
+				c.emit("i32.lt_s")
+				c.stackType = TypeBool
+			} else {
+				return fmt.Errorf("operator < not defined for types %s and %s", leftType, rightType)
+			}
+		case ">":
+			if leftType == TypeInt && rightType == TypeInt {
+				c.emit("i32.gt_s")
+				c.stackType = TypeBool
+			} else {
+				return fmt.Errorf("operator > not defined for types %s and %s", leftType, rightType)
+			}
+		case "<=":
+			if leftType == TypeInt && rightType == TypeInt {
+				c.emit("i32.le_s")
+				c.stackType = TypeBool
+			} else {
+				return fmt.Errorf("operator <= not defined for types %s and %s", leftType, rightType)
+			}
+		case ">=":
+			if leftType == TypeInt && rightType == TypeInt {
+				c.emit("i32.ge_s")
+				c.stackType = TypeBool
+			} else {
+				return fmt.Errorf("operator >= not defined for types %s and %s", leftType, rightType)
+			}
+		default:
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+
+	case *ast.CallExpression:
+		if index, ok := node.Function.(*ast.IndexExpression); ok && isSymbolIterator(index.Index) {
+			// obj[Symbol.iterator]() calls the method declared as *[Symbol.iterator]() / [Symbol.iterator]()
+			member := &ast.MemberExpression{Token: node.Token, Object: index.Left, Property: &ast.Identifier{Token: node.Token, Value: ast.SymbolIteratorMethod}}
+			return c.Compile(&ast.CallExpression{Token: node.Token, Function: member, Arguments: node.Arguments})
+		}
+		if member, ok := node.Function.(*ast.MemberExpression); ok {
+			// Check for super.method()
+			if _, isSuper := member.Object.(*ast.SuperExpression); isSuper {
+				if c.currentClass == "" {
+					return fmt.Errorf("super call outside of class method")
+				}
+				
+				currentSym := c.classes[c.currentClass]
+				parentName := currentSym.Parent
+				if parentName == "" {
+					return fmt.Errorf("super call in class with no parent")
+				}
+				
+				parentSym := c.classes[parentName]
+				methodName := member.Property.Value
+				if c.inStatic {
+					// super.m() in a static method calls the parent's static m
+					return c.compileStaticCall(parentSym, methodName, node)
+				}
+				
+				mangledName, ok := parentSym.Methods[methodName]
+				if !ok {
+					return fmt.Errorf("method %s not found in parent class %s", methodName, parentName)
+				}
+				if _, abstract := parentSym.AbstractMethods[methodName]; abstract {
+					return fmt.Errorf("line %d: abstract method %s of class %s cannot be called through super", node.Token.Line, methodName, parentSym.AbstractMethods[methodName])
+				}
+				
+				// Push 'this' as first argument
+				c.emitThis()
+				
+				// Compile other arguments
+				sig := parentSym.MethodSigs[methodName]
+				if err := checkArgCount(sig, node.Arguments, parentName+"."+methodName); err != nil {
+					return err
+				}
+				if err := c.compileArgs(node.Arguments, sig, parentName+"."+methodName); err != nil {
+					return err
+				}
+				
+				c.emit(fmt.Sprintf("call $%s", mangledName))
+				c.setCallResult(sig)
+				return nil
+			}
+
+			// Static methods: Class.m(args)
+			if cls, ok := c.staticClass(member.Object); ok {
+				return c.compileStaticCall(cls, member.Property.Value, node)
+			}
+
+			// Check for Array methods: push, pop (TODO)
+			// Problem: We need to know if object is Array.
+			// MVP: If method name is "push", treat as array push, unless the object is a class instance (Stack.push)
+			if member.Property.Value == "push" {
+				object, _, objectTypeName, err := c.compileAside(member.Object)
+				if err != nil {
+					return err
+				}
+				if _, isClass := c.classes[objectTypeName]; !isClass {
+					c.current.Instructions = append(c.current.Instructions, object...)
+					elemType := c.resolveElementType(objectTypeName)
+					// Stack: [array_ptr]
+
+					// Compile arguments (expect 1)
+					if len(node.Arguments) != 1 {
+						return fmt.Errorf("push expects 1 argument")
+					}
+					if err := c.Compile(node.Arguments[0]); err != nil {
+						return err
+					}
+					if err := c.emitStoreElement(c.stackType, elemType); err != nil {
+						return err
+					}
+
+					c.emit("call $array_push")
+					c.stackType = TypeVoid
+					return nil
+				}
+			}
+			
+			// Check for String methods: substring, charCodeAt
+			if member.Property.Value == "substring" {
+				if err := c.Compile(member.Object); err != nil {
+					return err
+				}
+				// Stack: [str_ptr]
+				
+				if len(node.Arguments) != 2 {
+					return fmt.Errorf("substring expects 2 arguments (start, end)")
+				}
+				
+				if err := c.Compile(node.Arguments[0]); err != nil {
+					return err
+				}
+				if err := c.Compile(node.Arguments[1]); err != nil {
+					return err
+				}
+				
+				c.emit("call $string_substring")
+				c.stackType = TypeString
+				return nil
+			}
+			
+			if member.Property.Value == "charCodeAt" {
+				if err := c.Compile(member.Object); err != nil {
+					return err
+				}
+				// Stack: [str_ptr]
+				
+				if len(node.Arguments) != 1 {
+					return fmt.Errorf("charCodeAt expects 1 argument (index)")
+				}
+				
+				if err := c.Compile(node.Arguments[0]); err != nil {
+					return err
+				}
+				
+				c.emit("call $string_charCodeAt")
+				c.stackType = TypeInt
+				return nil
+			}
+
+			// Check for fs.writeFile / fs.writeFileSync (WASI only)
+			if ident, ok := member.Object.(*ast.Identifier); ok && ident.Value == "fs" && (member.Property.Value == "writeFile" || member.Property.Value == "writeFileSync") {
+				if c.target != "wasi" {
+					return fmt.Errorf("fs.%s is only supported in WASI target", member.Property.Value)
+				}
+				
+				if len(node.Arguments) < 2 || len(node.Arguments) > 3 {
+					return fmt.Errorf("fs.%s expects 2 or 3 arguments (path, content, [options])", member.Property.Value)
+				}
+				
+				// Compile path
+				if err := c.Compile(node.Arguments[0]); err != nil {
+					return err
+				}
+				
+				// Compile content
+				if err := c.Compile(node.Arguments[1]); err != nil {
+					return err
+				}
+
+				// Ignore options for now (TODO: support options)
+				
+				c.emit("call $fs_writeFile")
+				c.stackType = TypeVoid
+				return nil
+			}
+
+			// Check for fs.readFile / fs.readFileSync (WASI only)
+			if ident, ok := member.Object.(*ast.Identifier); ok && ident.Value == "fs" && (member.Property.Value == "readFile" || member.Property.Value == "readFileSync") {
+				if c.target != "wasi" {
+					return fmt.Errorf("fs.%s is only supported in WASI target", member.Property.Value)
+				}
+				
+				if len(node.Arguments) < 1 || len(node.Arguments) > 2 {
+					return fmt.Errorf("fs.%s expects 1 or 2 arguments (path, [options])", member.Property.Value)
+				}
+				
+				// Compile path
+				if err := c.Compile(node.Arguments[0]); err != nil {
+					return err
+				}
+				
+				// Ignore options for now
+				
+				c.emit("call $fs_readFile")
+				c.stackType = TypeString
+				return nil
+			}
+
+			// Check for fs.existsSync (WASI only)
+			if ident, ok := member.Object.(*ast.Identifier); ok && ident.Value == "fs" && member.Property.Value == "existsSync" {
+				if c.target != "wasi" {
+					return fmt.Errorf("fs.existsSync is only supported in WASI target")
+				}
+				
+				if len(node.Arguments) != 1 {
+					return fmt.Errorf("fs.existsSync expects 1 argument (path)")
+				}
+				
+				// Compile path
+				if err := c.Compile(node.Arguments[0]); err != nil {
+					return err
+				}
+				
+				c.emit("call $fs_existsSync")
+				c.stackType = TypeBool
+				return nil
+			}
+
+			// Check for fs.unlinkSync (WASI only)
+			if ident, ok := member.Object.(*ast.Identifier); ok && ident.Value == "fs" && member.Property.Value == "unlinkSync" {
+				if c.target != "wasi" {
+					return fmt.Errorf("fs.unlinkSync is only supported in WASI target")
+				}
+				
+				if len(node.Arguments) != 1 {
+					return fmt.Errorf("fs.unlinkSync expects 1 argument (path)")
+				}
+				
+				if err := c.Compile(node.Arguments[0]); err != nil {
+					return err
+				}
+				
+				c.emit("call $fs_unlink")
+				c.emit("drop") // Ignore result for now (void return in Node)
+				c.stackType = TypeVoid
+				return nil
+			}
+
+			// Check for fs.mkdirSync (WASI only)
+			if ident, ok := member.Object.(*ast.Identifier); ok && ident.Value == "fs" && member.Property.Value == "mkdirSync" {
+				if c.target != "wasi" {
+					return fmt.Errorf("fs.mkdirSync is only supported in WASI target")
+				}
+				
+				if len(node.Arguments) < 1 || len(node.Arguments) > 2 {
+					return fmt.Errorf("fs.mkdirSync expects 1 or 2 arguments (path, [options])")
+				}
+				
+				if err := c.Compile(node.Arguments[0]); err != nil {
+					return err
+				}
+				
+				c.emit("call $fs_mkdir")
+				c.emit("drop")
+				c.stackType = TypeVoid
+				return nil
+			}
+
+			// Check for fs.rmdirSync (WASI only)
+			if ident, ok := member.Object.(*ast.Identifier); ok && ident.Value == "fs" && member.Property.Value == "rmdirSync" {
+				if c.target != "wasi" {
+					return fmt.Errorf("fs.rmdirSync is only supported in WASI target")
+				}
+				
+				if len(node.Arguments) != 1 {
+					return fmt.Errorf("fs.rmdirSync expects 1 argument (path)")
+				}
+				
+				if err := c.Compile(node.Arguments[0]); err != nil {
+					return err
+				}
+				
+				c.emit("call $fs_rmdir")
+				c.emit("drop")
+				c.stackType = TypeVoid
+				return nil
+			}
+
+			// Promise.resolve(value) / Promise.reject(reason)
+			if ident, ok := member.Object.(*ast.Identifier); ok && ident.Value == "Promise" {
+				if _, _, isLocal := c.lookupVariable("Promise"); !isLocal {
+					return c.compilePromiseStatic(member.Property.Value, node.Arguments)
+				}
+			}
+
+			// Check for console.log / console.error / console.warn
+			if ident, ok := member.Object.(*ast.Identifier); ok && ident.Value == "console" {
+				method := member.Property.Value
+				if method == "log" || method == "error" || method == "warn" {
+					if _, ok := c.importedFuncs["console_log_str"]; !ok {
+						c.importedFuncs["console_log_str"] = &ast.ImportStatement{ReturnType: "void", Parameters: make([]*ast.FieldDefinition, 1)}
+					}
+					if _, ok := c.importedFuncs["console_log_int"]; !ok {
+						c.importedFuncs["console_log_int"] = &ast.ImportStatement{ReturnType: "void", Parameters: make([]*ast.FieldDefinition, 1)}
+					}
+					if _, ok := c.importedFuncs["console_log_char"]; !ok {
+						c.importedFuncs["console_log_char"] = &ast.ImportStatement{ReturnType: "void", Parameters: make([]*ast.FieldDefinition, 1)}
+					}
+
+					for i, arg := range node.Arguments {
+						if i > 0 {
+							c.emit("i32.const 32") // space
+							c.emit("call $console_log_char")
+						}
+
+						if err := c.Compile(arg); err != nil {
+							return err
+						}
+
+						switch c.stackType {
+						case TypeString:
+							c.emit("call $console_log_str")
+						case TypeFloat:
+							c.emit("call $ftos")
+							c.emit("call $console_log_str")
+						case TypeBigInt:
+							c.emit("call $i64tos")
+							c.emit("call $console_log_str")
+						case TypeUnion, TypeNull, TypeUndefined:
+							c.emit("call $union_to_string")
+							c.emit("call $console_log_str")
+						case TypeInt:
+							c.emit("call $console_log_int")
+						case TypeBool:
+							c.emit("call $console_log_int")
+						default:
+							c.emit("call $console_log_int")
+						}
+					}
+
+					// Newline
+					c.emit("i32.const 10")
+					c.emit("call $console_log_char")
+					
+					c.stackType = TypeVoid
+					return nil
+				}
+			}
+
+			// Check for std.args (WASI only)
+			// Usage: std.args() -> Array<string>
+			if ident, ok := member.Object.(*ast.Identifier); ok && ident.Value == "std" && member.Property.Value == "args" {
+				if c.target != "wasi" {
+					return fmt.Errorf("std.args is only supported in WASI target")
+				}
+				
+				if len(node.Arguments) != 0 {
+					return fmt.Errorf("std.args expects 0 arguments")
+				}
+				
+				c.emit("call $std_args")
+				c.stackType = TypeArray
+				return nil
+			}
+
+			// Check for std.atomic
+			if ident, ok := member.Object.(*ast.Identifier); ok && ident.Value == "std" && member.Property.Value == "atomic" {
+				// Usage: std.atomic.add(arr, index, val)
+				// Since MemberExpression only goes one level deep (std.atomic), we need another property access.
+				// Wait, the parser parses `std.atomic.add` as (std.atomic).add
+				// So member.Object is `std.atomic`.
+				// But here member.Object is an Identifier "std".
+				// So `std.atomic` is the property access.
+				// But this is a CallExpression.
+				// If we call `std.atomic.add(...)`, the Function is MemberExpression(Object=MemberExpression(std, atomic), Property=add).
+				// We need to handle nested member expressions properly or flatten them.
+				
+				// Currently, we are in `CallExpression` handler. `node.Function` is `MemberExpression`.
+				// If `std.atomic.add`, `member.Object` is `MemberExpression` (std.atomic).
+				
+				// Let's check if member.Object is MemberExpression
+				return fmt.Errorf("std.atomic usage not supported in this form yet")
+			}
+			
+			// Handle std.atomic.add(...)
+			// node.Function is MemberExpression(Object=MemberExpression(std, atomic), Property=add)
+			if subMember, ok := member.Object.(*ast.MemberExpression); ok {
+				if ident, ok := subMember.Object.(*ast.Identifier); ok && ident.Value == "std" && subMember.Property.Value == "atomic" {
+					method := member.Property.Value
+					
+					// Common logic for array access
+					// Arg 0: Array
+					// Arg 1: Index
+					
+					if len(node.Arguments) < 2 {
+						return fmt.Errorf("std.atomic.%s requires at least array and index arguments", method)
+					}
+					
+					// 1. Compile Array (get ptr)
+					if err := c.Compile(node.Arguments[0]); err != nil { return err }
+					// Check type? Assume TypeArray.
+					// Array structure: [capacity, count, data_ptr] at offsets 0, 4, 8.
+					// We need data_ptr.
+					// Stack: [array_struct_ptr]
+					c.emit("i32.const 8")
+					c.emit("i32.add")
+					c.emit("i32.load")
+					// Stack: [data_ptr]
+					
+					// 2. Compile Index
+					if err := c.Compile(node.Arguments[1]); err != nil { return err }
+					// Stack: [data_ptr, index]
+					
+					// Calculate address: data_ptr + index * 4
+					c.emit("i32.const 4")
+					c.emit("i32.mul")
+					c.emit("i32.add")
+					// Stack: [address]
+					
+					switch method {
+					case "add":
+						// Arg 2: Value
+						if len(node.Arguments) != 3 { return fmt.Errorf("atomic.add expects 3 args") }
+						if err := c.Compile(node.Arguments[2]); err != nil { return err }
+						c.emit("i32.atomic.rmw.add")
+						c.stackType = TypeInt
+						
+					case "sub":
+						if len(node.Arguments) != 3 { return fmt.Errorf("atomic.sub expects 3 args") }
+						if err := c.Compile(node.Arguments[2]); err != nil { return err }
+						c.emit("i32.atomic.rmw.sub")
+						c.stackType = TypeInt
+						
+					case "and", "or", "xor", "xchg":
+						if len(node.Arguments) != 3 { return fmt.Errorf("atomic.%s expects 3 args", method) }
+						if err := c.Compile(node.Arguments[2]); err != nil { return err }
+						c.emit("i32.atomic.rmw." + method)
+						c.stackType = TypeInt
+						
+					case "cmpxchg":
+						// cmpxchg(arr, idx, expected, replacement) -> old value
+						if len(node.Arguments) != 4 { return fmt.Errorf("atomic.cmpxchg expects 4 args (arr, idx, expected, replacement)") }
+						if err := c.Compile(node.Arguments[2]); err != nil { return err } // Expected
+						if err := c.Compile(node.Arguments[3]); err != nil { return err } // Replacement
+						c.emit("i32.atomic.rmw.cmpxchg")
+						c.stackType = TypeInt
+						
+					case "load":
+						if len(node.Arguments) != 2 { return fmt.Errorf("atomic.load expects 2 args") }
+						c.emit("i32.atomic.load")
+						c.stackType = TypeInt
+						
+					case "store":
+						if len(node.Arguments) != 3 { return fmt.Errorf("atomic.store expects 3 args") }
+						if err := c.Compile(node.Arguments[2]); err != nil { return err }
+						c.emit("i32.atomic.store")
+						c.stackType = TypeVoid // store returns nothing
+						
+					case "wait":
+						// wait(addr, expected, timeout) -> 0=ok, 1=not_equal, 2=timed_out
+						if len(node.Arguments) != 4 { return fmt.Errorf("atomic.wait expects 4 args (arr, idx, expected, timeout)") }
+						if err := c.Compile(node.Arguments[2]); err != nil { return err } // Expected
+						if err := c.Compile(node.Arguments[3]); err != nil { return err } // Timeout (i64)
+						// Convert timeout to i64 if it's i32
+						if c.stackType != TypeBigInt {
+							c.emit("i64.extend_i32_s")
+						}
+						c.emit("memory.atomic.wait32")
+						c.stackType = TypeInt
+						
+					case "notify":
+						// notify(addr, count) -> count
+						if len(node.Arguments) != 3 { return fmt.Errorf("atomic.notify expects 3 args (arr, idx, count)") }
+						if err := c.Compile(node.Arguments[2]); err != nil { return err } // Count
+						c.emit("memory.atomic.notify")
+						c.stackType = TypeInt
+						
+					default:
+						return fmt.Errorf("unknown atomic method: %s", method)
+					}
+					return nil
+				}
+			}
+
+			// Check for path module methods
+			if ident, ok := member.Object.(*ast.Identifier); ok && ident.Value == "path" {
+				switch member.Property.Value {
+				case "basename":
+					if len(node.Arguments) != 1 {
+						return fmt.Errorf("path.basename expects 1 argument (path)")
+					}
+					if err := c.Compile(node.Arguments[0]); err != nil {
+						return err
+					}
+					c.emit("call $path_basename")
+					c.stackType = TypeString
+					return nil
+
+				case "dirname":
+					if len(node.Arguments) != 1 {
+						return fmt.Errorf("path.dirname expects 1 argument (path)")
+					}
+					if err := c.Compile(node.Arguments[0]); err != nil {
+						return err
+					}
+					c.emit("call $path_dirname")
+					c.stackType = TypeString
+					return nil
+
+				case "extname":
+					if len(node.Arguments) != 1 {
+						return fmt.Errorf("path.extname expects 1 argument (path)")
+					}
+					if err := c.Compile(node.Arguments[0]); err != nil {
+						return err
+					}
+					c.emit("call $path_extname")
+					c.stackType = TypeString
+					return nil
+
+				case "join":
+					if len(node.Arguments) == 0 {
+						return fmt.Errorf("path.join expects at least 1 argument")
+					}
+					
+					// Compile first argument
+					if err := c.Compile(node.Arguments[0]); err != nil {
+						return err
+					}
+					
+					// Iterate through rest arguments and call path_join2 iteratively
+					for i := 1; i < len(node.Arguments); i++ {
+						if err := c.Compile(node.Arguments[i]); err != nil {
+							return err
+						}
+						c.emit("call $path_join2")
+					}
+					
+					c.stackType = TypeString
+					return nil
+				}
+			}
+
+			// Check for process.exit
+			if ident, ok := member.Object.(*ast.Identifier); ok && ident.Value == "process" && member.Property.Value == "exit" {
+				if c.target != "wasi" {
+					return fmt.Errorf("process.exit is only supported in WASI target")
+				}
+				if len(node.Arguments) != 1 {
+					return fmt.Errorf("process.exit expects 1 argument (exit code)")
+				}
+				if err := c.Compile(node.Arguments[0]); err != nil {
+					return err
+				}
+				c.emit("call $proc_exit")
+				c.emit("unreachable") // Should not return
+				c.stackType = TypeVoid
+				return nil
+			}
+
+
+			// Check for process.env (WASI only)
+			// Usage: process.env -> Map<string, string>
+			// But here we are in CallExpression.
+			// If user does `process.env()`, that's not Node.js like.
+			// Node.js usage: `process.env` is an object.
+			// But in our AST, if we access `process.env`, it is a MemberExpression.
+			// If we do `process.env["KEY"]`, it is IndexExpression.
+			// If we do `process.env.KEY`, it is MemberExpression.
+			
+			// This block is for CallExpression.
+			// So `process.env()` calls. Not what we want.
+
+
+			// Method call: obj.method(args)
+			// 1. Compile obj to get 'this' pointer
+			if err := c.Compile(member.Object); err != nil {
+				return err
+			}
+			// Stack: [obj_ptr]
+			targetType := c.stackType
+			objTypeName := c.stackTypeName
+			
+			// Host Object Method Call
+			if targetType == TypeHost {
+				// We have object handle on stack.
+				// Need to pack arguments into an array in Wasm memory.
+				
+				// 1. Allocate args array
+				argCount := len(node.Arguments)
+				
+				// Create a temp local for args pointer
+				tempName := fmt.Sprintf("$args_temp_%d", c.current.NextLocalID)
+				tempIndex := c.current.NextLocalID
+				c.current.Symbols[tempName] = Symbol{Index: tempIndex, Type: TypeInt, IsParam: false, ShadowIndex: -1} // No GC trace for temp buffer
+				c.current.NextLocalID++
+				
+				if argCount > 0 {
+					c.emit(fmt.Sprintf("i32.const %d", argCount*4))
+					c.emit("i32.const 20") // Use ArrayData type for simplicity (ignored by GC mostly)
+					c.emit("call $malloc")
+					c.emit(fmt.Sprintf("local.set %d", tempIndex+c.current.ParamCount))
+					
+					// 2. Compile and store arguments
+					for i, arg := range node.Arguments {
+						// Get array ptr
+						c.emit(fmt.Sprintf("local.get %d", tempIndex+c.current.ParamCount))
+						c.emit(fmt.Sprintf("i32.const %d", i*4))
+						c.emit("i32.add")
+						
+						// Compile Arg
+						if err := c.Compile(arg); err != nil {
+							return err
+						}
+						argType := c.stackType
+						
+						// Convert primitive to handle
+						if isWideType(argType) {
+							return fmt.Errorf("passing %s to host functions is not supported yet", typeNameOf(argType, ""))
+						}
+						if argType == TypeString {
+								c.emit("call $host_from_string")
+							} else if argType == TypeInt || argType == TypeBool {
+								c.emit("call $host_from_int")
+							}
+						
+						// Store
+						c.emit("i32.store")
+					}
+				} else {
+					c.emit("i32.const 0")
+					c.emit(fmt.Sprintf("local.set %d", tempIndex+c.current.ParamCount))
+				}
+				
+				// Prepare call: host_call(handle, method_name, args_ptr, args_len)
+				// Stack has [obj_handle] already (from compiling member.Object)
+				// Wait, compiling member.Object put it on stack. But we interrupted flow to setup args.
+				// We need to save obj_handle first!
+				
+				// Rework:
+				// 1. Compile Object -> local.set $obj
+				// 2. Allocate Args -> local.set $args
+				// 3. Fill Args
+				// 4. call host_call($obj, "method", $args, len)
+				
+				// Since we already compiled Object and it is on stack...
+				// Let's store it in another temp
+				objTempName := fmt.Sprintf("$obj_temp_%d", c.current.NextLocalID)
+				objTempIndex := c.current.NextLocalID
+				c.current.Symbols[objTempName] = Symbol{Index: objTempIndex, Type: TypeHost, IsParam: false, ShadowIndex: -1}
+				c.current.NextLocalID++
+				
+				c.emit(fmt.Sprintf("local.set %d", objTempIndex+c.current.ParamCount))
+				
+				// Now allocate args (same as above)
+				if argCount > 0 {
+					c.emit(fmt.Sprintf("i32.const %d", argCount*4))
+					c.emit("i32.const 20")
+					c.emit("call $malloc")
+					c.emit(fmt.Sprintf("local.set %d", tempIndex+c.current.ParamCount))
+					
+					for i, arg := range node.Arguments {
+						c.emit(fmt.Sprintf("local.get %d", tempIndex+c.current.ParamCount))
+						c.emit(fmt.Sprintf("i32.const %d", i*4))
+						c.emit("i32.add")
+						if err := c.Compile(arg); err != nil { return err }
+							argType := c.stackType
+							if isWideType(argType) {
+								return fmt.Errorf("passing %s to host functions is not supported yet", typeNameOf(argType, ""))
+							}
+							if argType == TypeString {
+								c.emit("call $host_from_string")
+							} else if argType == TypeInt || argType == TypeBool {
+								c.emit("call $host_from_int")
+							}
+						c.emit("i32.store")
+					}
+				} else {
+					c.emit("i32.const 0")
+					c.emit(fmt.Sprintf("local.set %d", tempIndex+c.current.ParamCount))
+				}
+				
+				// Call
+				c.emit(fmt.Sprintf("local.get %d", objTempIndex+c.current.ParamCount))
+				
+				methodName := member.Property.Value
+				offset, ok := c.stringPool[methodName]
+				if !ok {
+					offset = c.nextDataOffset
+					c.stringPool[methodName] = offset
+					c.nextDataOffset += len(methodName) + 1
+				}
+				c.emit(fmt.Sprintf("i32.const %d ;; \"%s\"", offset, methodName))
+				
+				c.emit(fmt.Sprintf("local.get %d", tempIndex+c.current.ParamCount))
+				c.emit(fmt.Sprintf("i32.const %d", argCount))
+				c.emit("call $host_call")
+				c.stackType = TypeHost
+				return nil
+			}
+
+			methodName := member.Property.Value
+
+			// Array iteration with callbacks
+			if targetType == TypeArray && (methodName == "forEach" || methodName == "map" || methodName == "filter") {
+				return c.compileArrayIteration(methodName, objTypeName, node.Arguments)
+			}
+
+			// Iterator<T>: generators resume their state machine, classes implementing Iterator<T> are dispatched by TypeID
+			if valueTypeName, ok := iteratorValueType(objTypeName); ok && (methodName == "next" || methodName == "return") {
+				if len(node.Arguments) > 0 {
+					return fmt.Errorf("%s() on %s takes no arguments", methodName, objTypeName)
+				}
+				c.emit("call $iterator_" + methodName)
+				c.stackType = TypeInt
+				c.stackTypeName = "IteratorResult<" + valueTypeName + ">"
+				return nil
+			}
+
+			// Interface-typed values dispatch through itables
+			if iface, ok := c.interfaces[objTypeName]; ok {
+				return c.compileInterfaceCall(iface, methodName, node)
+			}
+
+			// Prefer the statically known class, otherwise look up method name in ALL classes.
+			var mangledName string
+			var sig FunctionSignature
+			found := false
+			dispatchClass := ""
+			if err := c.checkMemberAccess(objTypeName, methodName, member.Token.Line); err != nil {
+				return err
+			}
+			if cls, ok := c.classes[objTypeName]; ok {
+				mangledName, found = cls.Methods[methodName]
+				sig = cls.MethodSigs[methodName]
+				dispatchClass = objTypeName
+				if !found && cls.FieldTypes[methodName] == TypeFunc {
+					// Field holding a function: obj.callback(args)
+					c.emit(fmt.Sprintf("i32.load offset=%d ;; %s", cls.Fields[methodName], methodName))
+					return c.emitClosureCall(cls.FieldTypeNames[methodName], node.Arguments, methodName)
+				}
+			}
+			if !found {
+				for _, cls := range c.classes {
+					if name, ok := cls.Methods[methodName]; ok {
+						mangledName = name
+						sig = cls.MethodSigs[methodName]
+						found = true
+						break
+					}
+				}
+			}
+			if !found {
+				return fmt.Errorf("unknown method: %s", methodName)
+			}
+			
+			// Compile other arguments
+			if err := checkArgCount(sig, node.Arguments, methodName); err != nil {
+				return err
+			}
+			err := c.emitMethodCall(dispatchClass, methodName, mangledName, sig, func(argc bool) error {
+				if argc {
+					return c.compileSlotArgs(node.Arguments, sig, methodName)
+				}
+				return c.compileArgs(node.Arguments, sig, methodName)
+			})
+			if err != nil {
+				return err
+			}
+			c.setCallResult(sig)
+			return nil
+		}
+
+		if ident, ok := node.Function.(*ast.Identifier); ok {
+			funcName := ident.Value
+
+			// Determine call type
+			_, _, isLocalSymbol := c.lookupVariable(funcName)
+			
+			// Module Resolution
+			resolvedName, isDefined := c.resolveFunctionName(funcName)
+			
+			// FFI Import Check (always global name)
+			isImported := false
+			if _, ok := c.importedFuncs[funcName]; ok {
+				isImported = true
+				resolvedName = funcName
+			}
+
+			// Explicit type arguments: pick<string>(...)
+			if len(node.TypeArgs) > 0 {
+				if tmpl, ok := c.lookupGeneric(c.genericFuncs, funcName); ok && !isLocalSymbol && !isDefined {
+					return c.compileGenericCall(tmpl, node)
+				}
+				return fmt.Errorf("line %d: %s is not a generic function, it takes no type arguments", node.Token.Line, funcName)
+			}
+			
+			// 1. Local Symbol (e.g. host handle in var)
+			if isLocalSymbol {
+				if err := c.Compile(ident); err != nil { return err }
+				if c.stackType == TypeHost {
+					// Call host function handle
+					handleTemp := c.current.NextLocalID
+					c.current.NextLocalID++
+					realHandleTemp := handleTemp + c.current.ParamCount
+					c.emit(fmt.Sprintf("local.set %d", realHandleTemp))
+					
+					// Prepare args
+					argCount := len(node.Arguments)
+					argsTemp := c.current.NextLocalID
+					c.current.NextLocalID++
+					realArgsTemp := argsTemp + c.current.ParamCount
+					
+					if argCount > 0 {
+						c.emit(fmt.Sprintf("i32.const %d", argCount*4))
+						c.emit("i32.const 20")
+						c.emit("call $malloc")
+						c.emit(fmt.Sprintf("local.set %d", realArgsTemp))
+						
+						for i, arg := range node.Arguments {
+							c.emit(fmt.Sprintf("local.get %d", realArgsTemp))
+							c.emit(fmt.Sprintf("i32.const %d", i*4))
+							c.emit("i32.add")
+							if err := c.Compile(arg); err != nil { return err }
+							argType := c.stackType
+							if isWideType(argType) {
+								return fmt.Errorf("passing %s to host functions is not supported yet", typeNameOf(argType, ""))
+							}
+							if argType == TypeString {
+								c.emit("call $host_from_string")
+							} else if argType == TypeInt || argType == TypeBool {
+								c.emit("call $host_from_int")
+							}
+							c.emit("i32.store")
+						}
+					} else {
+						c.emit("i32.const 0")
+						c.emit(fmt.Sprintf("local.set %d", realArgsTemp))
+					}
+					
+					c.emit(fmt.Sprintf("local.get %d", realHandleTemp))
+					c.emit("i32.const 0")
+					c.emit(fmt.Sprintf("local.get %d", realArgsTemp))
+					c.emit(fmt.Sprintf("i32.const %d", argCount))
+					c.emit("call $host_call")
+					c.stackType = TypeHost
+					return nil
+				}
+				if c.stackType == TypeFunc {
+					return c.emitClosureCall(c.stackTypeName, node.Arguments, funcName)
+				}
+				return fmt.Errorf("calling local variable %s of type %s not supported", funcName, c.stackType)
+			}
+			
+			// 2. Imported Function
+			if isImported {
+				// Compile arguments normally (push to stack)
+				sig := c.importSignature(c.importedFuncs[resolvedName])
+				if err := c.compileArgs(node.Arguments, sig, funcName); err != nil {
+					return err
+				}
+				
+				c.emit(fmt.Sprintf("call $%s", resolvedName))
+				
+				if isWideType(sig.ReturnType) {
+					c.stackType = sig.ReturnType
+				} else if c.importedFuncs[resolvedName].ReturnType != "void" {
+					c.stackType = TypeInt
+				} else {
+					c.stackType = TypeVoid
+				}
+				return nil
+			}
+			
+			// 3. Generic function, instantiated for the type arguments inferred from the call
+			if tmpl, ok := c.lookupGeneric(c.genericFuncs, funcName); ok && !isDefined {
+				return c.compileGenericCall(tmpl, node)
+			}
+
+			// 4. Defined Internal Function (or stdlib)
+			if isDefined {
+				sig := c.definedFuncs[resolvedName]
+				if err := checkArgCount(sig, node.Arguments, funcName); err != nil {
+					return err
+				}
+
+				if err := c.compileArgs(node.Arguments, sig, funcName); err != nil {
+					return err
+				}
+				c.emit(fmt.Sprintf("call $%s", resolvedName))
+				c.setCallResult(sig)
+				return nil
+			}
+			
+			// 4. Implicit Global Host Call
+			// If not local, not imported, not defined -> Host Call
+			if funcName == "setTimeout" {
+				return c.compileSetTimeout(node.Arguments)
+			}
+			
+			if c.target == "wasi" {
+				if funcName == "print" {
+					// Handle print via WASI
+					arg := node.Arguments[0]
+					if err := c.Compile(arg); err != nil { return err }
+					if isWideType(c.stackType) {
+						if err := c.emitToString(c.stackType, "print"); err != nil {
+							return err
+						}
+					}
+					
+					c.emit("call $wasi_print")
+					c.stackType = TypeVoid
+					return nil
+				}
+				if funcName == "int_to_string" {
+					if len(node.Arguments) != 1 {
+						return fmt.Errorf("int_to_string expects 1 argument")
+					}
+					if err := c.Compile(node.Arguments[0]); err != nil { return err }
+					c.emit("call $itos")
+					c.stackType = TypeString
+					return nil
+				}
+				return fmt.Errorf("unknown function or global in WASI mode: %s", funcName)
+			}
+
+			// Get Global Handle
+			offset, ok := c.stringPool[funcName]
+			if !ok {
+				offset = c.nextDataOffset
+				c.stringPool[funcName] = offset
+				c.nextDataOffset += len(funcName) + 1
+			}
+			c.emit(fmt.Sprintf("i32.const %d ;; \"%s\"", offset, funcName))
+			c.emit("call $host_get_global")
+			
+			handleTemp := c.current.NextLocalID
+			c.current.NextLocalID++
+			realHandleTemp := handleTemp + c.current.ParamCount
+			c.emit(fmt.Sprintf("local.set %d", realHandleTemp))
+			
+			argCount := len(node.Arguments)
+			argsTemp := c.current.NextLocalID
+			c.current.NextLocalID++
+			realArgsTemp := argsTemp + c.current.ParamCount
+			
+			if argCount > 0 {
+				c.emit(fmt.Sprintf("i32.const %d", argCount*4))
+				c.emit("i32.const 20")
+				c.emit("call $malloc")
+				c.emit(fmt.Sprintf("local.set %d", realArgsTemp))
+				
+				for i, arg := range node.Arguments {
+					c.emit(fmt.Sprintf("local.get %d", realArgsTemp))
+					c.emit(fmt.Sprintf("i32.const %d", i*4))
+					c.emit("i32.add")
+					if err := c.Compile(arg); err != nil { return err }
+					argType := c.stackType
+					if isWideType(argType) {
+						return fmt.Errorf("passing %s to host functions is not supported yet", typeNameOf(argType, ""))
+					}
+					if argType == TypeString {
+						c.emit("call $host_from_string")
+					} else if argType == TypeInt || argType == TypeBool {
+						c.emit("call $host_from_int")
+					}
+					c.emit("i32.store")
+				}
+			} else {
+				c.emit("i32.const 0")
+				c.emit(fmt.Sprintf("local.set %d", realArgsTemp))
+			}
+			
+			c.emit(fmt.Sprintf("local.get %d", realHandleTemp))
+			c.emit("i32.const 0")
+			c.emit(fmt.Sprintf("local.get %d", realArgsTemp))
+			c.emit(fmt.Sprintf("i32.const %d", argCount))
+			c.emit("call $host_call")
+			c.stackType = TypeHost
+			return nil
+		} else {
+			// Callee is an expression: it must evaluate to a closure, e.g. makeAdder(1)(2)
+			if err := c.Compile(node.Function); err != nil {
+				return err
+			}
+			if c.stackType != TypeFunc {
+				return fmt.Errorf("cannot call value of type %s: %s", c.stackType, node.Function.String())
+			}
+			return c.emitClosureCall(c.stackTypeName, node.Arguments, node.Function.String())
+		}
+
+	case *ast.IntegerLiteral:
+		value, fits := intLiteralValue(node, false)
+		if !fits || c.expectsNumber(expectedFuncType) {
+			c.emit(fmt.Sprintf("f64.const %d", node.Value))
+			c.stackType = TypeFloat
+			return nil
+		}
+		c.emit(fmt.Sprintf("i32.const %d", value))
+		c.stackType = TypeInt
+
+	case *ast.BigIntLiteral:
+		c.emit(fmt.Sprintf("i64.const %d", node.Value))
+		c.stackType = TypeBigInt
+
+	case *ast.FloatLiteral:
+		c.emit(fmt.Sprintf("f64.const %s", strconv.FormatFloat(node.Value, 'g', -1, 64)))
+		c.stackType = TypeFloat
+
+	case *ast.StringLiteral:
+		offset, ok := c.stringPool[node.Value]
+		if !ok {
+			offset = c.nextDataOffset
+			c.stringPool[node.Value] = offset
+			c.nextDataOffset += len(node.Value) + 1
+		}
+		c.emit(fmt.Sprintf("i32.const %d ;; pointer to %q", offset, node.Value))
+		c.stackType = TypeString
+
+	case *ast.TemplateLiteral:
+		return c.compileTemplate(node)
+
+	case *ast.TaggedTemplateExpression:
+		// tag`a ${x} b` is tag(["a ", " b"], x)
+		strs := &ast.ArrayLiteral{Token: node.Token}
+		for _, q := range node.Quasi.Quasis {
+			strs.Elements = append(strs.Elements, &ast.StringLiteral{Token: node.Token, Value: q})
+		}
+		args := append([]ast.Expression{strs}, node.Quasi.Expressions...)
+		return c.Compile(&ast.CallExpression{Token: node.Token, Function: node.Tag, Arguments: args})
+
+	case *ast.SpreadElement:
+		return fmt.Errorf("spread syntax %s is only allowed in array literals, map literals and call arguments", node.String())
+
+	case *ast.ArrayLiteral:
+		length := len(node.Elements)
+		// Use dynamic array: $array_new(capacity)
+		// For literal, use length as initial capacity
+		
+		c.emit(fmt.Sprintf("i32.const %d", length))
+		c.emit("call $array_new")
+		
+		// Use a temporary local to store the array pointer so we can push elements
+		tempName := fmt.Sprintf("$arr_temp_%d", c.current.NextLocalID)
+		tempIndex := c.current.NextLocalID
+		// Note: TypeArray is used for tracking, but shadow stack treats everything as pointer (int)
+		c.current.Symbols[tempName] = Symbol{Index: tempIndex, Type: TypeArray, IsParam: false, ShadowIndex: c.current.ShadowStackSize}
+		c.current.NextLocalID++
+		c.current.ShadowStackSize++
+
+		c.emit(fmt.Sprintf("local.set %d", tempIndex+c.current.ParamCount))
+		
+		// Push to shadow stack (it's a root!)
+		c.emit("global.get $shadow_stack_ptr")
+		c.emit(fmt.Sprintf("local.get %d", tempIndex+c.current.ParamCount))
+		c.emit("i32.store")
+		c.emit("global.get $shadow_stack_ptr")
+		c.emit("i32.const 4")
+		c.emit("i32.add")
+		c.emit("global.set $shadow_stack_ptr")
+
+		// Array<int | null> literals box their elements like union variables
+		unionElems := c.resolveType(expectedFuncType) == TypeArray && c.resolveType(c.elementTypeName(expectedFuncType)) == TypeUnion
+		elemTarget := ""
+		if c.resolveType(expectedFuncType) == TypeArray {
+			elemTarget = c.elementTypeName(expectedFuncType)
+		}
+
+		// Compile elements aside first: if any element is a number, the
+		// whole literal becomes Array<number> and every element is boxed.
+		elemCode := make([][]string, len(node.Elements))
+		elemTypes := make([]DataType, len(node.Elements))
+		elemNames := make([]string, len(node.Elements))
+		spreads := make([]bool, len(node.Elements))
+		hasFloat := false
+		for i, el := range node.Elements {
+			start := len(c.current.Instructions)
+			if spread, ok := el.(*ast.SpreadElement); ok {
+				// [...other]: the element type comes from the spread array
+				if err := c.Compile(spread.Argument); err != nil {
+					return err
+				}
+				if c.stackType != TypeArray {
+					return fmt.Errorf("cannot spread %s in an array literal", typeNameOf(c.stackType, c.stackTypeName))
+				}
+				spreads[i] = true
+				elemNames[i] = c.elementTypeName(c.stackTypeName)
+				elemTypes[i] = TypeInt
+				if elemNames[i] != "" {
+					elemTypes[i] = c.resolveType(elemNames[i])
+				}
+				c.stackType = elemTypes[i]
+			} else if err := c.Compile(el); err != nil {
+				return err
+			} else if err := c.checkInterfaceAssignable(c.stackType, c.stackTypeName, elemTarget, fmt.Sprintf("element %d of the array literal", i+1)); err != nil {
+				return err
+			} else {
+				elemTypes[i] = c.stackType
+				elemNames[i] = c.stackTypeName
+			}
+			elemCode[i] = append([]string{}, c.current.Instructions[start:]...)
+			c.current.Instructions = c.current.Instructions[:start]
+			if c.stackType == TypeFloat && !unionElems {
+				hasFloat = true
+			}
+			if c.stackType == TypeBigInt {
+				return fmt.Errorf("bigint values cannot be stored in arrays or maps yet")
+			}
+		}
+
+		// Push elements
+		for i := range node.Elements {
+			// Prepare array ptr
+			c.emit(fmt.Sprintf("local.get %d", tempIndex+c.current.ParamCount))
+			
+			// Compile value
+			c.current.Instructions = append(c.current.Instructions, elemCode[i]...)
+			if spreads[i] {
+				// Spread elements are already stored the way this array stores them
+				if hasFloat && elemTypes[i] != TypeFloat {
+					return fmt.Errorf("cannot spread Array<%s> into an Array<number>", typeNameOf(elemTypes[i], elemNames[i]))
+				}
+				c.emit("call $array_push_all")
+				continue
+			}
+			if hasFloat {
+				if err := c.emitStoreElement(elemTypes[i], TypeFloat); err != nil {
+					return err
+				}
+			}
+			if unionElems {
+				c.stackTypeName = elemNames[i]
+				if err := c.emitStoreElement(elemTypes[i], TypeUnion); err != nil {
+					return err
+				}
+			}
+			
+			// Call $array_push
+			c.emit("call $array_push")
+		}
+
+		// Return array pointer
+		c.emit(fmt.Sprintf("local.get %d", tempIndex+c.current.ParamCount))
+		c.stackType = TypeArray
+		if unionElems {
+			c.stackTypeName = expectedFuncType
+		} else if hasFloat {
+			c.stackTypeName = "Array<number>"
+		} else if length > 0 {
+			// Elements of one reference type (strings, arrays, objects ...) give Array<T>
+			same := true
+			for i := range elemTypes {
+				same = same && elemTypes[i] == elemTypes[0] && typeNameOf(elemTypes[i], elemNames[i]) == typeNameOf(elemTypes[0], elemNames[0])
+			}
+			switch elemTypes[0] {
+			case TypeUnion, TypeNull, TypeUndefined:
+				same = false
+			}
+			if same && c.isPointerType(elemTypes[0], elemNames[0]) {
+				c.stackTypeName = fmt.Sprintf("Array<%s>", typeNameOf(elemTypes[0], elemNames[0]))
+			}
+		}
+
+	case *ast.MapLiteral:
+		if valueTypeName, ok := iteratorResultValueType(expectedFuncType); ok {
+			return c.compileIteratorResultLiteral(node, valueTypeName)
+		}
+		c.emit("call $map_new")
+		
+		// Use a temporary local to store the map pointer
+		tempName := fmt.Sprintf("$map_temp_%d", c.current.NextLocalID)
+		tempIndex := c.current.NextLocalID
+		c.current.Symbols[tempName] = Symbol{Index: tempIndex, Type: TypeMap, IsParam: false, ShadowIndex: c.current.ShadowStackSize}
+		c.current.NextLocalID++
+		c.current.ShadowStackSize++
+
+		c.emit(fmt.Sprintf("local.set %d", tempIndex+c.current.ParamCount))
+		
+		// Push to shadow stack
+		c.emit("global.get $shadow_stack_ptr")
+		c.emit(fmt.Sprintf("local.get %d", tempIndex+c.current.ParamCount))
+		c.emit("i32.store")
+		c.emit("global.get $shadow_stack_ptr")
+		c.emit("i32.const 4")
+		c.emit("i32.add")
+		c.emit("global.set $shadow_stack_ptr")
+		
+		// Compile values aside first: a map whose values are all numeric with
+		// at least one number becomes Map<string, number> (all values boxed).
+		type mapPair struct {
+			keyCode, valCode []string
+			valType          DataType
+			spread           bool
+		}
+		pairs := []mapPair{}
+		numeric := true
+		hasFloat := false
+		for _, key := range node.Keys {
+			start := len(c.current.Instructions)
+			if spread, ok := key.(*ast.SpreadElement); ok {
+				// {...other}: copy the entries of a map, or the fields of a class instance
+				if err := c.compileObjectSpread(spread); err != nil {
+					return err
+				}
+				if c.resolveType(c.elementTypeName(c.stackTypeName)) == TypeFloat {
+					hasFloat = true
+				} else {
+					numeric = false
+				}
+				pairs = append(pairs, mapPair{valCode: append([]string{}, c.current.Instructions[start:]...), spread: true})
+				c.current.Instructions = c.current.Instructions[:start]
+				continue
+			}
+			val := node.Pairs[key]
+			// Compile Key
+			if err := c.Compile(key); err != nil {
+				return err
+			}
+			if c.stackType != TypeString {
+				return fmt.Errorf("map keys must be strings")
+			}
+			mid := len(c.current.Instructions)
+			
+			// Compile Value
+			if err := c.Compile(val); err != nil {
+				return err
+			}
+			switch c.stackType {
+			case TypeFloat:
+				hasFloat = true
+			case TypeInt:
+			case TypeBigInt:
+				return fmt.Errorf("bigint values cannot be stored in arrays or maps yet")
+			default:
+				numeric = false
+			}
+			pairs = append(pairs, mapPair{
+				keyCode: append([]string{}, c.current.Instructions[start:mid]...),
+				valCode: append([]string{}, c.current.Instructions[mid:]...),
+				valType: c.stackType,
+			})
+			c.current.Instructions = c.current.Instructions[:start]
+		}
+		elemType := TypeUnknown
+		if hasFloat && numeric {
+			elemType = TypeFloat
+		}
+
+		// Set elements
+		for _, pair := range pairs {
+			// Prepare map ptr
+			c.emit(fmt.Sprintf("local.get %d", tempIndex+c.current.ParamCount))
+			if pair.spread {
+				c.current.Instructions = append(c.current.Instructions, pair.valCode...)
+				c.emit("call $map_assign")
+				continue
+			}
+			c.current.Instructions = append(c.current.Instructions, pair.keyCode...)
+			c.current.Instructions = append(c.current.Instructions, pair.valCode...)
+			if err := c.emitStoreElement(pair.valType, elemType); err != nil {
+				return err
+			}
+			
+			// Call $map_set
+			c.emit("call $map_set")
+		}
+		
+		// Return map pointer
+		c.emit(fmt.Sprintf("local.get %d", tempIndex+c.current.ParamCount))
+		c.stackType = TypeMap
+		if elemType == TypeFloat {
+			c.stackTypeName = "Map<string, number>"
+		}
+
+	case *ast.IndexExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		
+		targetType := c.stackType
+		elemTypeName := c.elementTypeName(c.stackTypeName)
+		
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+		indexType := c.stackType
+
+		if targetType == TypeArray {
+			c.emit("call $array_get")
+			c.stackType = TypeInt
+		} else if targetType == TypeMap || indexType == TypeString {
+			// If target is map OR index is string (duck typing for MVP)
+			c.emit("call $map_get")
+			c.stackType = TypeInt // Values are untyped int/ptr
+		} else {
+			// Fallback to array get if we don't know type (assume int index)
+			c.emit("call $array_get")
+			c.stackType = TypeInt
+		}
+
+		c.emitLoadElement(elemTypeName)
+
+	case *ast.Boolean:
+		if node.Value {
+			c.emit("i32.const 1")
+		} else {
+			c.emit("i32.const 0")
+		}
+		c.stackType = TypeBool
+
+	case *ast.IfExpression:
+		if err := c.Compile(node.Condition); err != nil {
+			return err
+		}
+		c.emitTruthy(c.stackType)
+
+		c.emit("if (result i32)")
+
+		if err := c.Compile(node.Consequence); err != nil {
+			return err
+		}
+		// MVP: If blocks don't return values, push dummy 0
+		c.emit("i32.const 0")
+
+		c.emit("else")
+		if node.Alternative != nil {
+			if err := c.Compile(node.Alternative); err != nil {
+				return err
+			}
+			c.emit("i32.const 0")
+		} else {
+			// Dummy return for else branch to satisfy type checker
+			c.emit("i32.const 0")
+		}
+
+		c.emit("end")
+		c.stackType = TypeInt
+
+	case *ast.FunctionLiteral:
+		// Top-level functions are compiled by compileFunction from Program;
+		// everywhere else a function literal is a closure value.
+		return c.compileClosure(node, expectedFuncType)
+
+	case *ast.BlockStatement:
+		if c.current.Async != nil {
+			return c.compileAsyncBlock(node.Statements)
+		}
+		for _, stmt := range node.Statements {
+			if err := c.Compile(stmt); err != nil {
+				return err
+			}
+		}
+
+	case *ast.ReturnStatement:
+		if c.current.Async != nil {
+			return c.compileAsyncReturn(node.ReturnValue, node.Token.Line)
+		}
+		if !c.current.InferReturn {
+			c.expectedFuncType = c.current.ReturnTypeName // return { value, done }, closures, number literals
+		}
+		if err := c.Compile(node.ReturnValue); err != nil {
+			return err
+		}
+		if c.current.InferReturn {
+			// Arrow function without a declared return type: the first return decides
+			c.current.InferReturn = false
+			if isWideType(c.stackType) {
+				c.current.ReturnType = c.stackType
+			}
+			c.current.ReturnTypeName = typeNameOf(c.stackType, c.stackTypeName)
+		} else if err := c.checkAssignable(c.stackType, c.stackTypeName, c.current.ReturnTypeName, "return from "+c.current.Name); err != nil {
+			return err
+		}
+		if isWideType(c.stackType) && c.current.ReturnType != c.stackType {
+			name := typeNameOf(c.stackType, "")
+			return fmt.Errorf("cannot return %s from %s: declare the return type as %s", name, c.current.Name, name)
+		}
+		returnType := c.current.ReturnType
+		if c.current.ReturnTypeName != "" && c.resolveType(c.current.ReturnTypeName) == TypeUnion {
+			returnType = TypeUnion // int | null results are boxed like union variables
+		}
+		if c.stackType == TypeVoid {
+			// Returning the result of a void call
+			c.emitDefaultReturn()
+		} else if err := c.emitConvert(c.stackType, returnType, "return"); err != nil {
+			return err
+		}
+		if len(c.current.Finally) > 0 {
+			// The result waits in a temp while the finally blocks run
+			result := c.newTempLocal("return", c.current.ReturnType)
+			c.emit(fmt.Sprintf("local.set %d", result))
+			if err := c.emitPendingFinally(0, node.Token.Line); err != nil {
+				return err
+			}
+			c.emit(fmt.Sprintf("local.get %d", result))
+		}
+		if c.current.HasShadowFrame {
+			// Unwind: pop everything this call pushed to the shadow stack
+			c.emit(fmt.Sprintf("local.get %d", c.current.ShadowPtrLocal))
+			c.emit("global.set $shadow_stack_ptr")
+		}
+		c.emit("return")
+
+	case *ast.WhileStatement:
+		loopEnv := c.beginLoopEnv(loopBodyNames(node.Body))
+		loop := c.beginLoop()
+		c.emit("block " + loop.BreakLabel)
+		c.emit("loop " + loop.ContinueLabel)
+		c.emitLoopReset(loop)
+
+		// Check condition: if false (0), break
+		if err := c.compileLoopCondition(node.Condition, loop.BreakLabel); err != nil {
+			return err
+		}
+
+		// Compile body
+		c.emitIterationEnv(loopEnv)
+		if err := c.Compile(node.Body); err != nil {
+			return err
+		}
+
+		// Jump back to start of loop
+		c.emit("br " + loop.ContinueLabel)
+
+		c.emit("end") // end of loop
+		c.emit("end") // end of block
+		c.endLoop(loop)
+		c.endLoopEnv(loopEnv)
+
+	case *ast.DoWhileStatement:
+		// The body runs before the first test; continue jumps to the condition
+		loopEnv := c.beginLoopEnv(loopBodyNames(node.Body))
+		loop := c.beginLoop()
+		topLabel := strings.Replace(loop.BreakLabel, "$break", "$top", 1)
+		c.emit("block " + loop.BreakLabel)
+		c.emit("loop " + topLabel)
+		c.emitLoopReset(loop)
+
+		c.emit("block " + loop.ContinueLabel)
+		c.emitIterationEnv(loopEnv)
+		if err := c.Compile(node.Body); err != nil {
+			return err
+		}
+		c.emit("end")
+
+		if c.current.Async != nil {
+			if err := c.hoistSuspends(node.Condition); err != nil {
+				return err
+			}
+		}
+		if err := c.Compile(node.Condition); err != nil {
+			return err
+		}
+		c.emitTruthy(c.stackType)
+		c.emit("br_if " + topLabel)
+
+		c.emit("end")
+		c.emit("end")
+		c.endLoop(loop)
+		c.endLoopEnv(loopEnv)
+
+	case *ast.SequenceExpression:
+		// Evaluate left to right; only the last value is kept
+		for i, exp := range node.Expressions {
+			if err := c.Compile(exp); err != nil {
+				return err
+			}
+			if i < len(node.Expressions)-1 && c.stackType != TypeVoid {
+				c.emit("drop")
+			}
+		}
+
+	case *ast.ForStatement:
+		// let / const loop variables (in the header or the body) captured by closures get a new binding per iteration
+		names := loopBodyNames(node.Body)
+		if decl, ok := node.Init.(*ast.LetStatement); ok {
+			var target ast.Expression = decl.Pattern
+			if decl.Name != nil {
+				target = decl.Name
+			}
+			names = append(patternNames(target), names...)
+		}
+		loopEnv := c.beginLoopEnv(names)
+		if loopEnv != nil {
+			if c.current.Async != nil {
+				c.emitNotResuming()
+				c.emit("if")
+			}
+			c.emitNewLoopEnv(loopEnv, false)
+			if c.current.Async != nil {
+				c.emit("end")
+			}
+		}
+
+		// Init (skipped when an async function resumes inside the loop)
+		if node.Init != nil {
+			if c.current.Async != nil {
+				if err := c.compileAsyncBlock([]ast.Statement{node.Init}); err != nil {
+					return err
+				}
+			} else if err := c.Compile(node.Init); err != nil {
+				return err
+			}
+		}
+
+		loop := c.beginLoop()
+		topLabel := strings.Replace(loop.BreakLabel, "$break", "$top", 1)
+		c.emit("block " + loop.BreakLabel)
+		c.emit("loop " + topLabel)
+		c.emitLoopReset(loop)
+
+		// Condition
+		if node.Condition != nil {
+			if err := c.compileLoopCondition(node.Condition, loop.BreakLabel); err != nil {
+				return err
+			}
+		}
+
+		// Body (continue jumps to the update)
+		c.emit("block " + loop.ContinueLabel)
+		if err := c.Compile(node.Body); err != nil {
+			return err
+		}
+		c.emit("end")
+		if loopEnv != nil {
+			// The next iteration starts from a copy, so the update does not change the bindings closures hold
+			c.emitNewLoopEnv(loopEnv, true)
+		}
+
+		// Update
+		if node.Update != nil {
+			if c.current.Async != nil {
+				if err := c.hoistSuspends(node.Update); err != nil {
+					return err
+				}
+			}
+			if err := c.Compile(node.Update); err != nil {
+				return err
+			}
+		}
+
+		c.emit("br " + topLabel)
+		c.emit("end")
+		c.emit("end")
+		c.endLoop(loop)
+		c.endLoopEnv(loopEnv)
+
+	case *ast.ForOfStatement:
+		return c.compileForOf(node)
+
+	case *ast.LabeledStatement:
+		switch node.Statement.(type) {
+		case *ast.WhileStatement, *ast.DoWhileStatement, *ast.ForStatement, *ast.ForOfStatement, *ast.SwitchStatement:
+			c.pendingLabel = node.Label
+			return c.Compile(node.Statement)
+		}
+		// Labeled block: only `break label` can leave it
+		c.loopCount++
+		block := &LoopContext{Label: node.Label, BreakLabel: fmt.Sprintf("$%s_%d", node.Label, c.loopCount), LabelOnly: true, Finally: len(c.current.Finally)}
+		c.current.Loops = append(c.current.Loops, block)
+		c.emit("block " + block.BreakLabel)
+		if err := c.Compile(node.Statement); err != nil {
+			return err
+		}
+		c.emit("end")
+		c.current.Loops = c.current.Loops[:len(c.current.Loops)-1]
+
+	case *ast.SwitchStatement:
+		return c.compileSwitch(node)
+
+	case *ast.BreakStatement:
+		target, err := c.findJumpTarget(node.Label, false)
+		if err != nil {
+			return err
+		}
+		if err := c.emitPendingFinally(target.Finally, node.Token.Line); err != nil {
+			return err
+		}
+		if target.Exit != nil {
+			if err := c.Compile(target.Exit); err != nil {
+				return err
+			}
+		}
+		c.emit("br " + target.BreakLabel)
+		c.stackType = TypeVoid
+
+	case *ast.ContinueStatement:
+		target, err := c.findJumpTarget(node.Label, true)
+		if err != nil {
+			return err
+		}
+		if err := c.emitPendingFinally(target.Finally, node.Token.Line); err != nil {
+			return err
+		}
+		c.emit("br " + target.ContinueLabel)
+		c.stackType = TypeVoid
+
+	}
+	return nil
+}
+
+func (c *Compiler) compileFunction(fn *ast.FunctionLiteral) error {
+	funcName := fn.Name
+	if c.currentModule != nil && c.currentModule.Prefix != "" {
+		funcName = c.currentModule.Prefix + fn.Name
+	}
+	scope := NewFunctionScope(funcName)
+	c.current = scope
+	c.functions = append(c.functions, scope)
+
+	// Save previous shadow stack pointer
+	// We need a local for this
+	// 1. Register parameters
+	for i, param := range fn.Parameters {
+		t := c.resolveType(param.Type)
+		shadowIndex := scope.ShadowStackSize
+		if isWideType(t) {
+			shadowIndex = -1 // f64/i64 params keep their slot but are never traced
+		}
+		scope.Symbols[param.Name.Value] = Symbol{
+			Index: i, 
+			Type: t, 
+			IsParam: true,
+			ShadowIndex: shadowIndex,
+			TypeName: param.Type,
+		}
+		scope.ParamTypes = append(scope.ParamTypes, t)
+		scope.ParamCount++
+		scope.ShadowStackSize++
+	}
+	scope.ReturnTypeName = fn.ReturnType
+	if fn.ReturnType != "" && isWideType(c.resolveType(fn.ReturnType)) {
+		scope.ReturnType = c.resolveType(fn.ReturnType)
+	}
+	if fn.Generator {
+		_, err := c.compileGeneratorFunction(fn, fn.ReturnType, false, false)
+		return err
+	}
+	if fn.Async {
+		_, err := c.compileAsyncFunction(fn, fn.ReturnType, false, false)
+		return err
+	}
+
+	// 2. Save previous shadow stack pointer, 3. push params to shadow stack
+	realShadowPtrLocal := c.emitShadowPrologue()
+	if funcName == "main" && len(c.staticInits) > 0 {
+		c.emit("call $init_statics")
+		c.emit("drop")
+	}
+
+	// 4. Environment for variables captured by closures
+	c.setupEnv(capturedNames(fn.Parameters, fn.Body, false))
+
+	if err := c.Compile(fn.Body); err != nil {
+		return err
+	}
+
+	// Restore shadow stack pointer
+	c.emit(fmt.Sprintf("local.get %d", realShadowPtrLocal))
+	c.emit("global.set $shadow_stack_ptr")
+
+	// Implicit return 0 if no return statement (for void functions or just safety)
+	c.emitDefaultReturn()
+	return nil
+}
+
+// emitShadowPrologue 保存调用前的 shadow_stack_ptr 并把参数压入 shadow stack，返回保存位置的局部变量索引
+func (c *Compiler) emitShadowPrologue() int {
+	scope := c.current
+	shadowPtrLocal := scope.NextLocalID
+	scope.NextLocalID++
+	realShadowPtrLocal := shadowPtrLocal + scope.ParamCount
+	
+	scope.ShadowPtrLocal = realShadowPtrLocal
+	scope.HasShadowFrame = true
+	
+	c.emit("global.get $shadow_stack_ptr")
+	c.emit(fmt.Sprintf("local.set %d ;; save previous shadow_stack_ptr", realShadowPtrLocal))
+
+	for i := 0; i < scope.ParamCount; i++ {
+		c.emit("global.get $shadow_stack_ptr")
+		if isWideType(scope.ParamTypes[i]) {
+			c.emit("i32.const 0")
+		} else {
+			c.emit(fmt.Sprintf("local.get %d", i))
+		}
+		c.emit("i32.store")
+		
+		c.emit("global.get $shadow_stack_ptr")
+		c.emit("i32.const 4")
+		c.emit("i32.add")
+		c.emit("global.set $shadow_stack_ptr")
+	}
+	return realShadowPtrLocal
+}
+
+// beginLoop 进入一个循环：分配唯一的 WASM 标签，并记录循环入口处的 shadow_stack_ptr
+// 每次迭代开始和退出循环时都会恢复它，因此 break / continue 不会让 shadow stack 增长
+func (c *Compiler) beginLoop() *LoopContext {
+	c.loopCount++
+	loop := &LoopContext{
+		Label:         c.pendingLabel,
+		BreakLabel:    fmt.Sprintf("$break_%d", c.loopCount),
+		ContinueLabel: fmt.Sprintf("$continue_%d", c.loopCount),
+		ShadowLocal:   c.newTempLocal("loop_shadow", TypeInt),
+		Finally:       len(c.current.Finally),
+	}
+	c.pendingLabel = ""
+	c.current.Loops = append(c.current.Loops, loop)
+
+	c.emit("global.get $shadow_stack_ptr")
+	c.emit(fmt.Sprintf("local.set %d ;; shadow_stack_ptr at loop entry", loop.ShadowLocal))
+	return loop
+}
+
+// compileForOf 编译 for (const x of iterable) / for (const k in map)
+// 数组按下标遍历，字符串按 UTF-8 字符遍历，Map 依次遍历每个桶的链表 (for...of 需要 [key, value] 模式)
+func (c *Compiler) compileForOf(node *ast.ForOfStatement) error {
+	kind := "for...of"
+	if node.In {
+		kind = "for...in"
+	}
+	// In async functions the setup and the fetch of the next element are skipped when resuming inside the body
+	async := c.current.Async
+	if async != nil {
+		if err := c.hoistSuspends(node.Iterable); err != nil {
+			return err
+		}
+		c.emitNotResuming()
+		c.emit("if")
+	}
+	if err := c.Compile(node.Iterable); err != nil {
+		return err
+	}
+	iterType, iterName := c.stackType, c.stackTypeName
+	protocol := false // Iterator<T> or a class with next(): call next() until done
+	if iterType != TypeArray && iterType != TypeMap && iterType != TypeString && !node.In {
+		name, ok, err := c.emitGetIterator(iterName)
+		if err != nil {
+			return err
+		}
+		protocol = ok
+		iterName = name
+	}
+	if !protocol && iterType != TypeArray && iterType != TypeMap && (iterType != TypeString || node.In) {
+		return fmt.Errorf("%s cannot iterate over %s", kind, typeNameOf(iterType, iterName))
+	}
+	var target ast.Expression = node.Declaration.Pattern
+	if node.Declaration.Name != nil {
+		target = node.Declaration.Name
+	}
+	var entry *ast.ArrayPattern
+	if iterType == TypeMap && !node.In {
+		entry, _ = target.(*ast.ArrayPattern)
+		if entry == nil || len(entry.Elements) > 2 || entry.Rest != nil {
+			return fmt.Errorf("for...of over a map needs a [key, value] pattern, got %s", target.String())
+		}
+	}
+
+	// The collection stays reachable for the GC while the body runs
+	iter := c.bindTemp("iter", iterType, iterName)
+	c.emitShadowPush(c.tempLocal(iter))
+	c.emit("i32.const 0")
+	cursor := c.bindTemp("cursor", TypeInt, "int") // array index, string byte offset or map bucket
+	var entryPtr *ast.Identifier
+	if iterType == TypeMap {
+		c.emit("i32.const 0")
+		entryPtr = c.bindTemp("entry", TypeInt, "")
+	}
+	if async != nil {
+		c.emit("end")
+	}
+
+	// Leaving the loop early (break, return) closes the iterator, so a generator runs its finally blocks
+	var closeIter *ast.BlockStatement
+	if protocol && c.hasIteratorReturn(iterName) {
+		call := &ast.CallExpression{Token: node.Token, Function: &ast.MemberExpression{Token: node.Token, Object: iter, Property: &ast.Identifier{Token: node.Token, Value: "return"}}}
+		closeIter = &ast.BlockStatement{Token: node.Token, Statements: []ast.Statement{&ast.ExpressionStatement{Token: node.Token, Expression: call}}}
+		c.current.Finally = append(c.current.Finally, closeIter)
+	}
+	loop := c.beginLoop()
+	loop.Exit = closeIter
+	topLabel := strings.Replace(loop.BreakLabel, "$break", "$top", 1)
+	c.emit("block " + loop.BreakLabel)
+	c.emit("loop " + topLabel)
+	c.emitLoopReset(loop)
+	if async != nil {
+		c.emitNotResuming()
+		c.emit("if")
+	}
+
+	// Fetch the next element, or leave the loop
+	var bindings []ast.Expression // values for the loop variable (map entries: key, value)
+	switch {
+	case protocol:
+		next := &ast.MemberExpression{Token: node.Token, Object: iter, Property: &ast.Identifier{Token: node.Token, Value: "next"}}
+		if err := c.Compile(&ast.CallExpression{Token: node.Token, Function: next}); err != nil {
+			return err
+		}
+		if _, ok := iteratorResultValueType(c.stackTypeName); !ok {
+			return fmt.Errorf("%s.next() must return IteratorResult<T>, not %s", iterName, typeNameOf(c.stackType, c.stackTypeName))
+		}
+		result := c.bindTemp("result", TypeInt, c.stackTypeName)
+		c.emit(fmt.Sprintf("local.get %d", c.tempLocal(result)))
+		c.emit("i32.load offset=8 ;; done")
+		c.emit("br_if " + loop.BreakLabel)
+		bindings = []ast.Expression{&ast.MemberExpression{Token: node.Token, Object: result, Property: &ast.Identifier{Token: node.Token, Value: "value"}}}
+
+	case iterType == TypeArray:
+		c.emit(fmt.Sprintf("local.get %d", c.tempLocal(cursor)))
+		c.emit(fmt.Sprintf("local.get %d", c.tempLocal(iter)))
+		c.emit("call $array_length")
+		c.emit("i32.ge_s")
+		c.emit("br_if " + loop.BreakLabel)
+		if node.In {
+			bindings = []ast.Expression{cursor}
+		} else {
+			bindings = []ast.Expression{&ast.IndexExpression{Token: node.Token, Left: iter, Index: cursor}}
+		}
+
+	case iterType == TypeString:
+		c.emit(fmt.Sprintf("local.get %d", c.tempLocal(iter)))
+		c.emit(fmt.Sprintf("local.get %d", c.tempLocal(cursor)))
+		c.emit("i32.add")
+		c.emit("i32.load8_u")
+		c.emit("i32.eqz")
+		c.emit("br_if " + loop.BreakLabel)
+		c.emit(fmt.Sprintf("local.get %d", c.tempLocal(iter)))
+		c.emit(fmt.Sprintf("local.get %d", c.tempLocal(cursor)))
+		c.emit(fmt.Sprintf("local.get %d", c.tempLocal(iter)))
+		c.emit(fmt.Sprintf("local.get %d", c.tempLocal(cursor)))
+		c.emit("call $utf8_next")
+		c.emit(fmt.Sprintf("local.tee %d", c.tempLocal(cursor)))
+		c.emit("call $string_substring")
+		bindings = []ast.Expression{c.bindTemp("char", TypeString, "string")}
+
+	case iterType == TypeMap:
+		// Skip empty buckets until an entry is found
+		foundLabel := strings.Replace(loop.BreakLabel, "$break", "$found", 1)
+		scanLabel := strings.Replace(loop.BreakLabel, "$break", "$scan", 1)
+		c.emit("block " + foundLabel)
+		c.emit("loop " + scanLabel)
+		c.emit(fmt.Sprintf("local.get %d", c.tempLocal(entryPtr)))
+		c.emit("br_if " + foundLabel)
+		c.emit(fmt.Sprintf("local.get %d", c.tempLocal(cursor)))
+		c.emit(fmt.Sprintf("local.get %d", c.tempLocal(iter)))
+		c.emit("i32.load ;; capacity")
+		c.emit("i32.ge_u")
+		c.emit("br_if " + loop.BreakLabel)
+		c.emit(fmt.Sprintf("local.get %d", c.tempLocal(iter)))
+		c.emit("i32.load offset=8 ;; buckets")
+		c.emit(fmt.Sprintf("local.get %d", c.tempLocal(cursor)))
+		c.emit("i32.const 4")
+		c.emit("i32.mul")
+		c.emit("i32.add")
+		c.emit("i32.load")
+		c.emit(fmt.Sprintf("local.set %d", c.tempLocal(entryPtr)))
+		c.emit(fmt.Sprintf("local.get %d", c.tempLocal(cursor)))
+		c.emit("i32.const 1")
+		c.emit("i32.add")
+		c.emit(fmt.Sprintf("local.set %d", c.tempLocal(cursor)))
+		c.emit("br " + scanLabel)
+		c.emit("end")
+		c.emit("end")
+
+		c.emit(fmt.Sprintf("local.get %d", c.tempLocal(entryPtr)))
+		c.emit("i32.load ;; key")
+		bindings = []ast.Expression{c.bindTemp("key", TypeString, "string")}
+		if !node.In {
+			c.emit(fmt.Sprintf("local.get %d", c.tempLocal(entryPtr)))
+			c.emit("i32.load offset=4 ;; value")
+			c.emitLoadElement(c.elementTypeName(iterName))
+			bindings = append(bindings, c.bindTemp("value", c.stackType, c.stackTypeName))
+		}
+		// Advance before the body so that continue moves on
+		c.emit(fmt.Sprintf("local.get %d", c.tempLocal(entryPtr)))
+		c.emit("i32.load offset=8 ;; next")
+		c.emit(fmt.Sprintf("local.set %d", c.tempLocal(entryPtr)))
+	}
+	if async != nil {
+		c.emit("end")
+	}
+
+	// Body (continue jumps to the update)
+	c.emit("block " + loop.ContinueLabel)
+	if async != nil {
+		c.emitNotResuming()
+		c.emit("if")
+	}
+	// Loop variables captured by closures get a new binding per iteration
+	loopEnv := c.beginLoopEnv(append(patternNames(target), loopBodyNames(node.Body)...))
+	if loopEnv != nil {
+		c.emitNewLoopEnv(loopEnv, false)
+	}
+	if entry != nil {
+		for i, el := range entry.Elements {
+			if el == nil {
+				continue
+			}
+			if el.Default != nil {
+				return fmt.Errorf("for...of over a map cannot use default values in %s", target.String())
+			}
+			if err := c.declareLoopVariable(node.Declaration, el.Target, bindings[i]); err != nil {
+				return err
+			}
+		}
+	} else if err := c.declareLoopVariable(node.Declaration, target, bindings[0]); err != nil {
+		return err
+	}
+	if async != nil {
+		c.emit("end")
+	}
+	if err := c.Compile(node.Body); err != nil {
+		return err
+	}
+	c.emit("end")
+
+	if iterType == TypeArray {
+		c.emit(fmt.Sprintf("local.get %d", c.tempLocal(cursor)))
+		c.emit("i32.const 1")
+		c.emit("i32.add")
+		c.emit(fmt.Sprintf("local.set %d", c.tempLocal(cursor)))
+	}
+
+	c.emit("br " + topLabel)
+	c.emit("end")
+	c.emit("end")
+	c.endLoop(loop)
+	c.endLoopEnv(loopEnv)
+	if closeIter != nil {
+		c.current.Finally = c.current.Finally[:len(c.current.Finally)-1]
+	}
+	c.stackType = TypeVoid
+	return nil
+}
+
+// hasIteratorReturn 判断迭代器有没有 return()：生成器都有，手写的迭代器类可以定义它
+func (c *Compiler) hasIteratorReturn(typeName string) bool {
+	if _, ok := iteratorValueType(typeName); ok {
+		return true
+	}
+	if cls, ok := c.classes[typeName]; ok {
+		_, ok := cls.Methods["return"]
+		return ok
+	}
+	return false
+}
+
+// emitGetIterator 为栈顶的对象取得迭代器：实现了 [Symbol.iterator]() 的类先调用它，
+// 结果是 Iterator<T> 或带 next() 方法的类实例时返回它的类型名和 true，否则原样保留栈顶的值
+func (c *Compiler) emitGetIterator(typeName string) (string, bool, error) {
+	if cls, ok := c.classes[typeName]; ok {
+		if _, ok := cls.Methods[ast.SymbolIteratorMethod]; ok {
+			iterable := c.bindTemp("iterable", TypeInt, typeName)
+			method := &ast.MemberExpression{Object: iterable, Property: &ast.Identifier{Value: ast.SymbolIteratorMethod}}
+			if err := c.Compile(&ast.CallExpression{Function: method}); err != nil {
+				return "", false, err
+			}
+			typeName = c.stackTypeName
+			if typeName == "" {
+				return "", false, fmt.Errorf("%s[Symbol.iterator]() must declare its return type", cls.Name)
+			}
+		}
+	}
+	if _, ok := iteratorValueType(typeName); ok {
+		return typeName, true, nil
+	}
+	if cls, ok := c.classes[typeName]; ok {
+		if _, ok := cls.Methods["next"]; ok {
+			return typeName, true, nil
+		}
+	}
+	return typeName, false, nil
+}
+
+// declareLoopVariable 用 let 声明把 value 绑定到循环变量 (标识符或解构模式)
+func (c *Compiler) declareLoopVariable(decl *ast.LetStatement, target, value ast.Expression) error {
+	let := &ast.LetStatement{Token: decl.Token, Value: value}
+	if ident, ok := target.(*ast.Identifier); ok {
+		let.Name = ident
+	} else {
+		let.Pattern = target
+	}
+	return c.Compile(let)
+}
+
+// emitLoopReset 丢弃循环体压入 shadow stack 的值
+func (c *Compiler) emitLoopReset(loop *LoopContext) {
+	c.emit(fmt.Sprintf("local.get %d", loop.ShadowLocal))
+	c.emit("global.set $shadow_stack_ptr")
+}
+
+// endLoop 离开循环 (条件不成立或 break 之后)
+func (c *Compiler) endLoop(loop *LoopContext) {
+	c.emitLoopReset(loop)
+	c.current.Loops = c.current.Loops[:len(c.current.Loops)-1]
+}
+
+// findJumpTarget 查找 break / continue 的目标：无标签时为最内层循环，有标签时按名字查找
+func (c *Compiler) findJumpTarget(label string, isContinue bool) (*LoopContext, error) {
+	keyword := "break"
+	if isContinue {
+		keyword = "continue"
+	}
+	loops := c.current.Loops
+	for i := len(loops) - 1; i >= 0; i-- {
+		loop := loops[i]
+		if label == "" {
+			// Unlabeled continue skips switch statements
+			if !loop.LabelOnly && (!isContinue || loop.ContinueLabel != "") {
+				return loop, nil
+			}
+			continue
+		}
+		if loop.Label != label {
+			continue
+		}
+		if isContinue && loop.ContinueLabel == "" {
+			return nil, fmt.Errorf("continue %s: label does not refer to a loop", label)
+		}
+		return loop, nil
+	}
+	if label != "" {
+		return nil, fmt.Errorf("%s %s: undefined label", keyword, label)
+	}
+	return nil, fmt.Errorf("%s outside of loop", keyword)
+}
+
+// emitPendingFinally 在 break / continue / return 跳出 try 之前，按从内到外的顺序执行途经的 finally 块
+// (只执行外层 depth 个之后的)；for...of 关闭迭代器的代码也在其中
+func (c *Compiler) emitPendingFinally(depth int, line int) error {
+	pending := c.current.Finally
+	for i := len(pending) - 1; i >= depth; i-- {
+		if async := c.current.Async; async != nil {
+			if _, _, ok := async.suspendRange(pending[i]); ok {
+				return fmt.Errorf("line %d: leaving a try whose finally block contains await or yield is not supported", line)
+			}
+		}
+		// A break inside the finally block itself only runs the outer ones
+		c.current.Finally = pending[:i]
+		err := c.Compile(pending[i])
+		c.current.Finally = pending
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compileTemplate 把模板字符串展开为 $str_concat 链，插值按类型转换为字符串
+func (c *Compiler) compileTemplate(node *ast.TemplateLiteral) error {
+	// `${x}...` starts with the converted value instead of concatenating onto ""
+	leading := node.Quasis[0] != "" || len(node.Expressions) == 0
+	if leading {
+		if err := c.Compile(&ast.StringLiteral{Token: node.Token, Value: node.Quasis[0]}); err != nil {
+			return err
+		}
+	}
+	for i, expr := range node.Expressions {
+		if err := c.Compile(expr); err != nil {
+			return err
+		}
+		if err := c.emitToString(c.stackType, "template literal"); err != nil {
+			return err
+		}
+		if i > 0 || leading {
+			c.emit("call $str_concat")
+		}
+
+		if quasi := node.Quasis[i+1]; quasi != "" {
+			if err := c.Compile(&ast.StringLiteral{Token: node.Token, Value: quasi}); err != nil {
+				return err
+			}
+			c.emit("call $str_concat")
+		}
+	}
+	c.stackType = TypeString
+	return nil
+}
+
+// emitToString 将栈顶的值转换为字符串 (int / bool 使用 $itos，number 使用 $ftos)
+func (c *Compiler) emitToString(t DataType, context string) error {
+	switch t {
+	case TypeString:
+	case TypeInt, TypeBool:
+		c.emit("call $itos")
+	case TypeFloat:
+		c.emit("call $ftos")
+	case TypeBigInt:
+		c.emit("call $i64tos")
+	case TypeUnion, TypeNull, TypeUndefined:
+		c.emit("call $union_to_string")
+	default:
+		return fmt.Errorf("cannot convert %s to string in %s", t, context)
+	}
+	return nil
+}
+
+// lookupEnum 按模块规则解析枚举名: 模块前缀 -> 导入别名 -> 全局
+func (c *Compiler) lookupEnum(name string) (map[string]int, bool) {
+	if c.currentModule != nil && c.currentModule.Prefix != "" {
+		if members, ok := c.enums[c.currentModule.Prefix+name]; ok {
+			return members, true
+		}
+	}
+	if c.currentModule != nil {
+		if alias, ok := c.currentModule.SymbolAliases[name]; ok {
+			if members, ok := c.enums[alias]; ok {
+				return members, true
+			}
+		}
+	}
+	members, ok := c.enums[name]
+	return members, ok
+}
+
+// constIntValue 返回编译期可知的整数值 (整数字面量、负数字面量、枚举成员)
+func (c *Compiler) constIntValue(expr ast.Expression) (int, bool) {
+	switch e := expr.(type) {
+	case *ast.IntegerLiteral:
+		return int(e.Value), true
+	case *ast.PrefixExpression:
+		if e.Operator == "-" {
+			if v, ok := c.constIntValue(e.Right); ok {
+				return -v, true
+			}
+		}
+	case *ast.MemberExpression:
+		if ident, ok := e.Object.(*ast.Identifier); ok {
+			if members, ok := c.lookupEnum(ident.Value); ok {
+				v, ok := members[e.Property.Value]
+				return v, ok
+			}
+		}
+	}
+	return 0, false
+}
+
+// compileSwitch 编译 switch 语句
+// 每个 case 对应一层 block，跳到第 i 层 block 的末尾即进入第 i 个 case 的语句，case 之间自然贯穿 (fallthrough)。
+// 分派方式：稠密的整数/枚举 case 使用 br_table，其余情况逐个比较 (字符串使用 $string_equals)
+func (c *Compiler) compileSwitch(node *ast.SwitchStatement) error {
+	async := c.current.Async
+	if async != nil {
+		for _, sc := range node.Cases {
+			if keyword := suspendKeyword(sc.Test); keyword != "" {
+				return fmt.Errorf("line %d: %s in a case test is not supported yet", sc.Token.Line, keyword)
+			}
+		}
+		if err := c.hoistSuspends(node.Discriminant); err != nil {
+			return err
+		}
+		c.emitNotResuming()
+		c.emit("if")
+	}
+	if err := c.Compile(node.Discriminant); err != nil {
+		return err
+	}
+	discType := c.stackType
+	discIndex := c.newTempLocal("switch", discType)
+	c.emit(fmt.Sprintf("local.set %d", discIndex))
+	if async != nil {
+		c.emit("end")
+	}
+
+	c.loopCount++
+	id := c.loopCount
+	exit := &LoopContext{Label: c.pendingLabel, BreakLabel: fmt.Sprintf("$switch_%d", id), Finally: len(c.current.Finally)}
+	c.pendingLabel = ""
+
+	caseLabels := make([]string, len(node.Cases))
+	defaultLabel := exit.BreakLabel
+	for i, sc := range node.Cases {
+		caseLabels[i] = fmt.Sprintf("$case_%d_%d", id, i)
+		if sc.Test == nil {
+			defaultLabel = caseLabels[i]
+		}
+	}
+
+	c.emit("block " + exit.BreakLabel)
+	for i := len(node.Cases) - 1; i >= 0; i-- {
+		c.emit("block " + caseLabels[i])
+	}
+
+	// Dispatch
+	if async != nil {
+		// Resuming inside a case body: jump straight to it
+		for i, sc := range node.Cases {
+			if lo, hi, ok := async.suspendRange(sc.Body); ok {
+				c.emit(fmt.Sprintf("local.get %d ;; resuming", async.ResumingLocal))
+				c.emitStateInRange(lo, hi)
+				c.emit("i32.and")
+				c.emit("br_if " + caseLabels[i])
+			}
+		}
+	}
+	values := make(map[int]int) // case value -> case index (first one wins)
+	allConst := discType == TypeInt || discType == TypeBool
+	minValue, maxValue := 0, 0
+	for i, sc := range node.Cases {
+		if sc.Test == nil || !allConst {
+			continue
+		}
+		v, ok := c.constIntValue(sc.Test)
+		if !ok {
+			allConst = false
+			continue
+		}
+		if len(values) == 0 || v < minValue {
+			minValue = v
+		}
+		if len(values) == 0 || v > maxValue {
+			maxValue = v
+		}
+		if _, dup := values[v]; !dup {
+			values[v] = i
+		}
+	}
+	span := maxValue - minValue + 1
+	// Dense: the jump table is at most 3x the number of cases
+	if allConst && len(values) >= 3 && span <= 3*len(values) {
+		targets := make([]string, span)
+		for v := minValue; v <= maxValue; v++ {
+			if i, ok := values[v]; ok {
+				targets[v-minValue] = caseLabels[i]
+			} else {
+				targets[v-minValue] = defaultLabel
+			}
+		}
+		c.emit(fmt.Sprintf("local.get %d", discIndex))
+		if minValue != 0 {
+			c.emit(fmt.Sprintf("i32.const %d", minValue))
+			c.emit("i32.sub")
+		}
+		c.emit(fmt.Sprintf("br_table %s %s", strings.Join(targets, " "), defaultLabel))
+	} else {
+		for i, sc := range node.Cases {
+			if sc.Test == nil {
+				continue
+			}
+			c.emit(fmt.Sprintf("local.get %d", discIndex))
+			if err := c.Compile(sc.Test); err != nil {
+				return err
+			}
+			testType := c.stackType
+			switch {
+			case discType == TypeString || testType == TypeString:
+				if discType != testType {
+					return fmt.Errorf("switch on %s cannot have case of type %s", discType, testType)
+				}
+				c.emit("call $string_equals")
+			case discType == TypeFloat || testType == TypeFloat:
+				if discType != TypeFloat {
+					return fmt.Errorf("switch on %s cannot have case of type number", discType)
+				}
+				if err := c.emitConvert(testType, TypeFloat, "switch case"); err != nil {
+					return err
+				}
+				c.emit("f64.eq")
+			case discType == TypeBigInt || testType == TypeBigInt:
+				if discType != testType {
+					return fmt.Errorf("switch on %s cannot have case of type %s", discType, testType)
+				}
+				c.emit("i64.eq")
+			default:
+				c.emit("i32.eq")
+			}
+			c.emit("br_if " + caseLabels[i])
+		}
+		c.emit("br " + defaultLabel)
+	}
+
+	// Case bodies; `break` leaves the switch
+	c.current.Loops = append(c.current.Loops, exit)
+	for i, sc := range node.Cases {
+		c.emit(fmt.Sprintf("end ;; %s", caseLabels[i]))
+		if err := c.Compile(sc.Body); err != nil {
+			return err
+		}
+	}
+	c.current.Loops = c.current.Loops[:len(c.current.Loops)-1]
+	c.emit("end")
+	c.stackType = TypeVoid
+	return nil
+}
+
+// emitShadowPush 把局部变量压入 shadow stack，使其成为 GC 根
+func (c *Compiler) emitShadowPush(realIndex int) {
+	c.emit("global.get $shadow_stack_ptr")
+	c.emit(fmt.Sprintf("local.get %d", realIndex))
+	c.emit("i32.store")
+	c.emit("global.get $shadow_stack_ptr")
+	c.emit("i32.const 4")
+	c.emit("i32.add")
+	c.emit("global.set $shadow_stack_ptr")
+	c.current.ShadowStackSize++
+}
+
+// resolveFunctionName 按模块规则解析函数名: 模块前缀 -> 导入别名 -> 全局
+func (c *Compiler) resolveFunctionName(funcName string) (string, bool) {
+	prefixed := ""
+	if c.currentModule != nil && c.currentModule.Prefix != "" {
+		prefixed = c.currentModule.Prefix + funcName
+	}
+	
+	// 1. Try prefixed (Local module function)
+	if _, ok := c.definedFuncs[prefixed]; ok {
+		return prefixed, true
+	}
+	resolvedName := funcName
+	if c.currentModule != nil {
+		// 2. Try alias (Imported module function)
+		if alias, ok := c.currentModule.SymbolAliases[funcName]; ok {
+			resolvedName = alias
+			if _, ok := c.definedFuncs[alias]; ok {
+				return alias, true
+			}
+		}
+	}
+	
+	// 3. Try global (stdlib or main)
+	if _, ok := c.definedFuncs[funcName]; ok {
+		return funcName, true
+	}
+	return resolvedName, false
+}
+
+// setCallResult 根据被调函数的签名设置调用结果的类型
+// void 函数同样返回一个 i32 (0)
+func (c *Compiler) setCallResult(sig FunctionSignature) {
+	c.stackType = sig.ReturnType
+	if c.stackType == TypeVoid || c.stackType == TypeUnknown || c.stackType == "" {
+		c.stackType = TypeInt
+	}
+	c.stackTypeName = sig.ReturnTypeName
+}
+
+// functionSignature 根据函数声明构造签名；未声明返回类型时为 void
+func (c *Compiler) functionSignature(fn *ast.FunctionLiteral) FunctionSignature {
+	sig := FunctionSignature{ParamTypes: []DataType{}, ReturnType: TypeVoid, ReturnTypeName: fn.ReturnType}
+	for _, p := range fn.Parameters {
+		sig.ParamTypes = append(sig.ParamTypes, c.resolveType(p.Type))
+		sig.ParamTypeNames = append(sig.ParamTypeNames, p.Type)
+		sig.ParamNames = append(sig.ParamNames, p.Name.Value)
+		sig.Variadic = p.Rest
+		def := p.Value
+		if p.Optional {
+			def = &ast.UndefinedLiteral{Token: p.Token}
+		}
+		sig.Defaults = append(sig.Defaults, def)
+	}
+	if fn.ReturnType != "" {
+		sig.ReturnType = c.resolveType(fn.ReturnType)
+	} else if fn.Generator {
+		sig.ReturnType = TypeInt
+		sig.ReturnTypeName = "Iterator<int>"
+	} else if fn.Async {
+		sig.ReturnType = TypeInt
+		sig.ReturnTypeName = "Promise<void>"
+	}
+	return sig
+}
+
+// typeNameOf 返回栈上值在源码中的类型名
+func typeNameOf(t DataType, typeName string) string {
+	if typeName != "" {
+		return typeName
+	}
+	switch t {
+	case TypeFloat:
+		return "number"
+	case TypeBigInt:
+		return "bigint"
+	case TypeUnknown, TypeUnion:
+		return "int"
+	}
+	return string(t)
+}
+
+// funcTypeName 返回签名对应的函数类型名，例如 "(int, number) => string"、"(string, ...Array<int>) => void"
+func funcTypeName(sig FunctionSignature) string {
+	params := append([]string{}, sig.ParamTypeNames...)
+	if sig.Variadic && len(params) > 0 {
+		params[len(params)-1] = "..." + params[len(params)-1]
+	}
+	return fmt.Sprintf("(%s) => %s", strings.Join(params, ", "), typeNameOf(sig.ReturnType, sig.ReturnTypeName))
+}
+
+// splitTypeList 在最外层的逗号处分割类型列表
+func splitTypeList(list string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(list); i++ {
+		switch list[i] {
+		case '(', '<':
+			depth++
+		case ')':
+			depth--
+		case '>':
+			if i == 0 || list[i-1] != '=' { // "=>" is not a closing bracket
+				depth--
+			}
+		case ',':
+			if depth == 0 {
+				parts = append(parts, strings.TrimSpace(list[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	if rest := strings.TrimSpace(list[start:]); rest != "" {
+		parts = append(parts, rest)
+	}
+	return parts
+}
+
+// funcSignatureFromTypeName 解析函数类型名 "(int, number) => string"
+func (c *Compiler) funcSignatureFromTypeName(typeName string) (FunctionSignature, bool) {
+	if alias, ok := c.typeAliases[typeName]; ok {
+		return c.funcSignatureFromTypeName(alias)
+	}
+	if !strings.HasPrefix(typeName, "(") {
+		return FunctionSignature{}, false
+	}
+
+	// Find the ')' closing the parameter list
+	depth, end := 0, -1
+	for i := 0; i < len(typeName) && end < 0; i++ {
+		switch typeName[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				end = i
+			}
+		}
+	}
+	if end < 0 {
+		return FunctionSignature{}, false
+	}
+
+	sig := FunctionSignature{ParamTypes: []DataType{}, ReturnType: TypeUnknown}
+	for _, param := range splitTypeList(typeName[1:end]) {
+		if strings.HasPrefix(param, "...") {
+			param = param[3:]
+			sig.Variadic = true
+		}
+		sig.ParamTypeNames = append(sig.ParamTypeNames, param)
+		sig.ParamTypes = append(sig.ParamTypes, c.resolveType(param))
+	}
+	rest := strings.TrimSpace(typeName[end+1:])
+	if strings.HasPrefix(rest, "=>") {
+		sig.ReturnTypeName = strings.TrimSpace(rest[2:])
+		sig.ReturnType = c.resolveType(sig.ReturnTypeName)
+	}
+	return sig, true
+}
+
+// checkAssignable 检查赋值、传参和返回时值的类型能否当作目标类型：函数类型和接口类型
+func (c *Compiler) checkAssignable(valueType DataType, valueTypeName, targetTypeName, context string) error {
+	if err := c.checkFuncAssignable(valueType, valueTypeName, targetTypeName, context); err != nil {
+		return err
+	}
+	if err := c.checkGenericAssignable(valueType, valueTypeName, targetTypeName, context); err != nil {
+		return err
+	}
+	return c.checkInterfaceAssignable(valueType, valueTypeName, targetTypeName, context)
+}
+
+// checkGenericAssignable 检查同一泛型类的实例之间的赋值：Box<int> 和 Box<string> 是不同的类，
+// 值 (或其父类) 是同一模板的其他实例时报错
+func (c *Compiler) checkGenericAssignable(valueType DataType, valueTypeName, targetTypeName, context string) error {
+	if valueType != TypeInt {
+		return nil
+	}
+	target := c.resolveClassName(targetTypeName)
+	want, ok := c.genericInstances[target]
+	if !ok || want.Class == nil {
+		return nil
+	}
+	for name := c.resolveClassName(valueTypeName); name != ""; {
+		if name == target {
+			return nil
+		}
+		if got, ok := c.genericInstances[name]; ok && got.Template == want.Template {
+			return fmt.Errorf("cannot use %s as %s in %s: type arguments differ", c.displayInstanceName(name), c.displayInstanceName(target), context)
+		}
+		cls, ok := c.classes[name]
+		if !ok {
+			return nil
+		}
+		name = cls.Parent
+	}
+	return nil
+}
+
+// checkFuncAssignable 检查函数值能否赋给声明的函数类型：call_indirect 要求参数个数和类型完全一致，
+// 不一致的函数值会在调用时陷入 trap，所以在赋值处报错
+func (c *Compiler) checkFuncAssignable(valueType DataType, valueTypeName, targetTypeName, context string) error {
+	if valueType != TypeFunc {
+		return nil
+	}
+	target, ok := c.funcSignatureFromTypeName(targetTypeName)
+	if !ok {
+		return nil
+	}
+	value, ok := c.funcSignatureFromTypeName(valueTypeName)
+	if !ok {
+		return nil
+	}
+	mismatch := func(reason string) error {
+		return fmt.Errorf("cannot use %s as %s in %s: %s", valueTypeName, targetTypeName, context, reason)
+	}
+	if len(value.ParamTypes) != len(target.ParamTypes) || value.Variadic != target.Variadic {
+		return mismatch(fmt.Sprintf("expected %d parameter(s), got %d", len(target.ParamTypes), len(value.ParamTypes)))
+	}
+	for i, t := range target.ParamTypes {
+		if value.ParamTypes[i] != t {
+			return mismatch(fmt.Sprintf("parameter %d is %s, expected %s", i+1, value.ParamTypeNames[i], target.ParamTypeNames[i]))
+		}
+	}
+	if target.ReturnType != TypeUnknown && value.ReturnType != TypeUnknown && value.ReturnType != target.ReturnType {
+		return mismatch(fmt.Sprintf("returns %s, expected %s", typeNameOf(value.ReturnType, value.ReturnTypeName), target.ReturnTypeName))
+	}
+	return nil
+}
+
+// declaredNames 返回函数自身声明的变量 (参数、let、catch 变量、内部具名函数)，不进入内部函数
+func declaredNames(params []*ast.FieldDefinition, body *ast.BlockStatement) map[string]bool {
+	names := make(map[string]bool)
+	for _, p := range params {
+		names[p.Name.Value] = true
+	}
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FunctionLiteral:
+			if n.Name != "" {
+				names[n.Name] = true
+			}
+			return false
+		case *ast.LetStatement:
+			if n.Pattern != nil {
+				for _, name := range patternNames(n.Pattern) {
+					names[name] = true
+				}
+			} else {
+				names[n.Name.Value] = true
+			}
+		case *ast.TryStatement:
+			if n.CatchVar != "" {
+				names[n.CatchVar] = true
+			}
+		}
+		return true
+	})
+	return names
+}
+
+// patternNames 返回解构模式声明的变量名 (不包括默认值中引用的名字)
+func patternNames(pattern ast.Expression) []string {
+	var names []string
+	switch p := pattern.(type) {
+	case *ast.Identifier:
+		names = append(names, p.Value)
+	case *ast.ObjectPattern:
+		for _, prop := range p.Properties {
+			names = append(names, patternNames(prop.Target)...)
+		}
+	case *ast.ArrayPattern:
+		for _, el := range p.Elements {
+			if el != nil {
+				names = append(names, patternNames(el.Target)...)
+			}
+		}
+		if p.Rest != nil {
+			names = append(names, patternNames(p.Rest)...)
+		}
+	}
+	return names
+}
+
+// loopBodyNames 返回循环体中 let / const 声明的变量，不进入内部函数和内层循环 (内层循环有自己的迭代环境)
+func loopBodyNames(body ast.Statement) []string {
+	var names []string
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FunctionLiteral, *ast.ClassStatement:
+			return false
+		case *ast.WhileStatement, *ast.DoWhileStatement, *ast.ForStatement, *ast.ForOfStatement:
+			return false
+		case *ast.LetStatement:
+			if n.Pattern != nil {
+				names = append(names, patternNames(n.Pattern)...)
+			} else {
+				names = append(names, n.Name.Value)
+			}
+		}
+		return true
+	})
+	return names
+}
+
+// freeNames 返回函数中引用但没有在函数内声明的名字 (包括 this)
+func freeNames(fn *ast.FunctionLiteral) map[string]bool {
+	free := make(map[string]bool)
+	var visit func(n ast.Node) bool
+	visit = func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FunctionLiteral:
+			for name := range freeNames(n) {
+				free[name] = true
+			}
+			return false
+		case *ast.Identifier:
+			free[n.Value] = true
+		case *ast.ThisExpression:
+			free["this"] = true
+		case *ast.MemberExpression:
+			// Property names are not variable references
+			ast.Inspect(n.Object, visit)
+			return false
+		}
+		return true
+	}
+	ast.Inspect(fn.Body, visit)
+
+	for name := range declaredNames(fn.Parameters, fn.Body) {
+		delete(free, name)
+	}
+	return free
+}
+
+// capturedNames 返回函数中被内部闭包引用的变量
+func capturedNames(params []*ast.FieldDefinition, body *ast.BlockStatement, hasThis bool) map[string]bool {
+	declared := declaredNames(params, body)
+	if hasThis {
+		declared["this"] = true
+	}
+	captured := make(map[string]bool)
+	ast.Inspect(body, func(n ast.Node) bool {
+		if fn, ok := n.(*ast.FunctionLiteral); ok {
+			for name := range freeNames(fn) {
+				if declared[name] {
+					captured[name] = true
+				}
+			}
+			return false
+		}
+		return true
+	})
+	return captured
+}
+
+// setupEnv 为被内部闭包捕获的变量分配环境对象，并把被捕获的参数搬进去
+// 必须在参数注册之后、函数体编译之前调用
+func (c *Compiler) setupEnv(captured map[string]bool) {
+	scope := c.current
+	scope.Captured = captured
+	if len(captured) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(captured))
+	for name := range captured {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	env := &EnvLayout{TypeID: c.allocTypeID(), Offsets: make(map[string]int), Types: make(map[string]DataType)}
+	offset := 4 // Slot 0: enclosing environment
+	for _, name := range names {
+		env.Offsets[name] = offset
+		env.Types[name] = TypeInt
+		offset += 8
+	}
+	env.Size = offset
+	c.envs = append(c.envs, env)
+	scope.Env = env
+
+	scope.EnvLocal = c.newTempLocal("env", TypeInt)
+	c.emit(fmt.Sprintf("i32.const %d", env.Size))
+	c.emit(fmt.Sprintf("i32.const %d ;; env type", env.TypeID))
+	c.emit("call $malloc")
+	c.emit(fmt.Sprintf("local.set %d", scope.EnvLocal))
+	c.emitShadowPush(scope.EnvLocal)
+
+	// Link to the enclosing environment
+	c.emit(fmt.Sprintf("local.get %d", scope.EnvLocal))
+	if scope.IsClosure {
+		c.emitEnclosingEnv()
+	} else {
+		c.emit("i32.const 0")
+	}
+	c.emit("i32.store")
+
+	// Move captured parameters into the environment
+	for _, name := range names {
+		sym, ok := scope.Symbols[name]
+		if !ok || !sym.IsParam {
+			continue
+		}
+		c.emit(fmt.Sprintf("local.get %d", scope.EnvLocal))
+		c.emit(fmt.Sprintf("local.get %d", sym.Index))
+		c.emit(fmt.Sprintf("%s.store offset=%d ;; %s (captured)", wasmType(sym.Type), env.Offsets[name], name))
+		env.Types[name] = sym.Type
+		scope.Symbols[name] = Symbol{Index: env.Offsets[name], Type: sym.Type, ShadowIndex: -1, TypeName: sym.TypeName, InEnv: true}
+	}
+}
+
+// LoopEnv 循环的每次迭代环境：被闭包捕获的 let / const 循环变量每次迭代都有新的绑定，
+// 存放在每次迭代新建的环境对象中 (槽 0 链接到外层环境)，之前迭代中创建的闭包仍持有旧的绑定
+type LoopEnv struct {
+	Layout *EnvLayout
+	Local  int               // Real index of the local holding the current iteration's environment
+	saved  map[string]Symbol // Symbols shadowed by the loop variables, restored after the loop
+}
+
+// beginLoopEnv 为 names 中被闭包捕获的循环变量创建每次迭代的环境 (此后声明的这些变量存放在其中)
+// 没有被捕获的循环变量时返回 nil；调用方用 emitNewLoopEnv 分配环境对象
+func (c *Compiler) beginLoopEnv(names []string) *LoopEnv {
+	scope := c.current
+	var captured []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if scope.Env != nil && scope.Captured[name] && !seen[name] {
+			seen[name] = true
+			captured = append(captured, name)
+		}
+	}
+	if len(captured) == 0 {
+		return nil
+	}
+	sort.Strings(captured)
+
+	layout := &EnvLayout{TypeID: c.allocTypeID(), Offsets: make(map[string]int), Types: make(map[string]DataType)}
+	offset := 4 // Slot 0: enclosing environment
+	for _, name := range captured {
+		layout.Offsets[name] = offset
+		layout.Types[name] = TypeInt
+		offset += 8
+	}
+	layout.Size = offset
+	c.envs = append(c.envs, layout)
+
+	// TypeUnknown: the frame of an async function traces the local for the GC
+	env := &LoopEnv{Layout: layout, Local: c.newTempLocal("loop_env", TypeUnknown), saved: make(map[string]Symbol)}
+	for _, name := range captured {
+		if sym, ok := scope.Symbols[name]; ok {
+			env.saved[name] = sym
+		}
+	}
+	scope.LoopEnvs = append(scope.LoopEnvs, env)
+	return env
+}
+
+// emitNewLoopEnv 为下一次迭代新建 env 的环境对象；copyBindings 为 true 时复制上一次迭代的绑定 (for 循环的 i++ 作用于新的绑定)
+func (c *Compiler) emitNewLoopEnv(env *LoopEnv, copyBindings bool) {
+	temp := c.newTempLocal("loop_env", TypeInt)
+	c.emit(fmt.Sprintf("i32.const %d", env.Layout.Size))
+	c.emit(fmt.Sprintf("i32.const %d ;; loop env type", env.Layout.TypeID))
+	c.emit("call $malloc")
+	c.emit(fmt.Sprintf("local.tee %d", temp))
+	if copyBindings {
+		c.emit(fmt.Sprintf("local.get %d", env.Local))
+		c.emit("i32.load")
+	} else {
+		// Link to the environment just outside this loop
+		outer := c.current.EnvLocal
+		for i, loop := range c.current.LoopEnvs {
+			if loop == env && i > 0 {
+				outer = c.current.LoopEnvs[i-1].Local
+			}
+		}
+		c.emit(fmt.Sprintf("local.get %d", outer))
+	}
+	c.emit("i32.store ;; enclosing env")
+	if copyBindings {
+		names := make([]string, 0, len(env.Layout.Offsets))
+		for name := range env.Layout.Offsets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			c.emit(fmt.Sprintf("local.get %d", temp))
+			c.emit(fmt.Sprintf("local.get %d", env.Local))
+			c.emit(fmt.Sprintf("i64.load offset=%d", env.Layout.Offsets[name]))
+			c.emit(fmt.Sprintf("i64.store offset=%d ;; %s", env.Layout.Offsets[name], name))
+		}
+	}
+	c.emit(fmt.Sprintf("local.get %d", temp))
+	c.emit(fmt.Sprintf("local.set %d", env.Local))
+}
+
+// emitIterationEnv 在 while / do...while 每次迭代的循环体之前为 env 新建环境对象 (异步函数恢复执行时保留原来的环境)
+func (c *Compiler) emitIterationEnv(env *LoopEnv) {
+	if env == nil {
+		return
+	}
+	if c.current.Async != nil {
+		c.emitNotResuming()
+		c.emit("if")
+	}
+	c.emitNewLoopEnv(env, false)
+	if c.current.Async != nil {
+		c.emit("end")
+	}
+}
+
+// endLoopEnv 在循环结束后调用：循环变量离开作用域，恢复被它们遮蔽的符号
+func (c *Compiler) endLoopEnv(env *LoopEnv) {
+	if env == nil {
+		return
+	}
+	scope := c.current
+	scope.LoopEnvs = scope.LoopEnvs[:len(scope.LoopEnvs)-1]
+	for name := range env.Layout.Offsets {
+		if sym, ok := env.saved[name]; ok {
+			scope.Symbols[name] = sym
+		} else {
+			delete(scope.Symbols, name)
+		}
+	}
+}
+
+// envSlot 返回被捕获的变量 name 所在的环境 (nil 为函数环境) 和它在其中的偏移
+func (c *Compiler) envSlot(name string) (*EnvLayout, int) {
+	for i := len(c.current.LoopEnvs) - 1; i >= 0; i-- {
+		layout := c.current.LoopEnvs[i].Layout
+		if offset, ok := layout.Offsets[name]; ok {
+			return layout, offset
+		}
+	}
+	return nil, c.current.Env.Offsets[name]
+}
+
+// allocTypeID 分配一个新的 GC 类型 ID，跳过运行时保留的 20-22 (ArrayData, MapBuckets, MapEntry)
+func (c *Compiler) allocTypeID() int {
+	for c.nextTypeID >= 20 && c.nextTypeID <= 22 {
+		c.nextTypeID++
+	}
+	id := c.nextTypeID
+	c.nextTypeID++
+	return id
+}
+
+// lookupVariable 查找变量：先查当前函数，再沿闭包的外层函数查找被捕获的变量
+func (c *Compiler) lookupVariable(name string) (Symbol, *FunctionScope, bool) {
+	if sym, ok := c.current.Symbols[name]; ok {
+		return sym, c.current, true
+	}
+	for s := c.current.Parent; s != nil; s = s.Parent {
+		if sym, ok := s.Symbols[name]; ok {
+			return sym, s, sym.InEnv
+		}
+	}
+	return Symbol{}, nil, false
+}
+
+// enclosingEnvScope 返回闭包参数 0 所指向的环境所属的函数
+func enclosingEnvScope(s *FunctionScope) *FunctionScope {
+	if !s.IsClosure {
+		return nil
+	}
+	for p := s.Parent; p != nil; p = p.Parent {
+		if p.Env != nil {
+			return p
+		}
+		if !p.IsClosure {
+			return nil
+		}
+	}
+	return nil
+}
+
+// emitEnvPointer 压入 owner 中的环境对象 env (nil 为 owner 的函数环境) 的指针；在闭包中沿环境链向外查找
+func (c *Compiler) emitEnvPointer(owner *FunctionScope, env *EnvLayout) {
+	if owner == c.current {
+		c.emit(fmt.Sprintf("local.get %d ;; env", owner.envLocal(env)))
+		return
+	}
+	// The enclosing env is the innermost loop environment that was active where the closure was created
+	c.emitEnclosingEnv()
+	s := enclosingEnvScope(c.current)
+	for ; s != nil && s != owner; s = enclosingEnvScope(s) {
+		for i := 0; i <= s.envDepth(nil); i++ {
+			c.emit("i32.load ;; outer env")
+		}
+	}
+	if s != nil {
+		for i := 0; i < s.envDepth(env); i++ {
+			c.emit("i32.load ;; outer env")
+		}
+	}
+}
+
+// envLocal 返回保存环境 env (nil 为函数环境) 的局部变量下标
+func (s *FunctionScope) envLocal(env *EnvLayout) int {
+	for _, loop := range s.LoopEnvs {
+		if loop.Layout == env {
+			return loop.Local
+		}
+	}
+	return s.EnvLocal
+}
+
+// envDepth 返回从最内层的循环环境沿槽 0 向外到达环境 env (nil 为函数环境) 需要的次数
+func (s *FunctionScope) envDepth(env *EnvLayout) int {
+	for i := len(s.LoopEnvs) - 1; i >= 0; i-- {
+		if s.LoopEnvs[i].Layout == env {
+			return len(s.LoopEnvs) - 1 - i
+		}
+	}
+	return len(s.LoopEnvs)
+}
+
+// emitThis 压入 this：方法的参数 0，被闭包捕获时在环境中，在 async 方法的状态机中是局部变量
+func (c *Compiler) emitThis() {
+	if sym, owner, ok := c.lookupVariable("this"); ok {
+		c.emitLoadVariable("this", sym, owner)
+	} else {
+		c.emit("local.get 0 ;; this")
+	}
+}
+
+// emitLoadVariable 压入变量的值
+func (c *Compiler) emitLoadVariable(name string, sym Symbol, owner *FunctionScope) {
+	if sym.InEnv {
+		c.emitEnvPointer(owner, sym.Env)
+		c.emit(fmt.Sprintf("%s.load offset=%d ;; %s (captured)", wasmType(sym.Type), sym.Index, name))
+		return
+	}
+	realIndex := sym.Index
+	if !sym.IsParam {
+		realIndex += c.current.ParamCount
+	}
+	c.emit(fmt.Sprintf("local.get %d ;; %s (%s)", realIndex, name, sym.Type))
+}
+
+// emitStoreCaptured 把栈顶的值写入环境中的变量，栈上保留该值
+func (c *Compiler) emitStoreCaptured(name string, sym Symbol, owner *FunctionScope) {
+	tempIndex := c.newTempLocal("captured", sym.Type)
+	c.emit(fmt.Sprintf("local.set %d", tempIndex))
+	c.emitEnvPointer(owner, sym.Env)
+	c.emit(fmt.Sprintf("local.get %d", tempIndex))
+	c.emit(fmt.Sprintf("%s.store offset=%d ;; %s (captured)", wasmType(sym.Type), sym.Index, name))
+	c.emit(fmt.Sprintf("local.get %d", tempIndex))
+}
+
+// emitCurrentEnv 压入在当前函数中新建的闭包应当持有的环境指针
+func (c *Compiler) emitCurrentEnv() {
+	if n := len(c.current.LoopEnvs); n > 0 {
+		c.emit(fmt.Sprintf("local.get %d ;; loop env", c.current.LoopEnvs[n-1].Local))
+	} else if c.current.Env != nil {
+		c.emit(fmt.Sprintf("local.get %d ;; env", c.current.EnvLocal))
+	} else if c.current.IsClosure {
+		c.emitEnclosingEnv()
+	} else {
+		c.emit("i32.const 0")
+	}
+}
+
+// emitEnclosingEnv 压入闭包参数 0 所指向的外层环境；async 函数的状态机从帧对象中读取它
+func (c *Compiler) emitEnclosingEnv() {
+	if c.current.Async != nil {
+		c.emit("local.get 0 ;; frame")
+		c.emit("i32.load offset=12 ;; enclosing env")
+		return
+	}
+	c.emit("local.get 0 ;; enclosing env")
+}
+
+// emitClosureObject 创建闭包对象 [table index, env]
+func (c *Compiler) emitClosureObject(tableIndex int, withEnv bool) {
+	c.emitClosureWithEnv(tableIndex, func() {
+		if withEnv {
+			c.emitCurrentEnv()
+		} else {
+			c.emit("i32.const 0")
+		}
+	})
+}
+
+// emitClosureWithEnv 创建闭包对象 [table index, env]，env 由 emitEnv 压入
+func (c *Compiler) emitClosureWithEnv(tableIndex int, emitEnv func()) {
+	tempIndex := c.newTempLocal("closure", TypeFunc)
+	c.emit("i32.const 8")
+	c.emit(fmt.Sprintf("i32.const %d ;; closure", TypeID_Closure))
+	c.emit("call $malloc")
+	c.emit(fmt.Sprintf("local.tee %d", tempIndex))
+	c.emit(fmt.Sprintf("i32.const %d ;; table index", tableIndex))
+	c.emit("i32.store")
+	c.emit(fmt.Sprintf("local.get %d", tempIndex))
+	emitEnv()
+	c.emit("i32.store offset=4")
+	c.emit(fmt.Sprintf("local.get %d", tempIndex))
+}
+
+// closureSignature 根据函数字面量和上下文期望的函数类型确定闭包签名
+// 返回类型既没有声明也无法从上下文得知时，inferReturn 为 true
+func (c *Compiler) closureSignature(fn *ast.FunctionLiteral, expected string) (sig FunctionSignature, inferReturn bool) {
+	expectedSig, _ := c.funcSignatureFromTypeName(expected)
+	sig.ParamTypes = []DataType{}
+	for i, p := range fn.Parameters {
+		typeName := p.Type
+		if typeName == "" {
+			typeName = "int"
+			if p.Rest {
+				typeName = "Array<int>"
+			}
+			if i < len(expectedSig.ParamTypeNames) {
+				typeName = expectedSig.ParamTypeNames[i]
+			}
+		}
+		sig.ParamTypeNames = append(sig.ParamTypeNames, typeName)
+		sig.ParamTypes = append(sig.ParamTypes, c.resolveType(typeName))
+		sig.Variadic = p.Rest
+	}
+
+	switch {
+	case fn.ReturnType != "":
+		sig.ReturnTypeName = fn.ReturnType
+	case expectedSig.ReturnTypeName != "" && expectedSig.ReturnType != TypeVoid:
+		sig.ReturnTypeName = expectedSig.ReturnTypeName
+	default:
+		sig.ReturnTypeName = "int"
+		sig.ReturnType = TypeInt
+		return sig, true
+	}
+	sig.ReturnType = c.resolveType(sig.ReturnTypeName)
+	return sig, false
+}
+
+// compileClosure 把函数字面量编译为独立的 WASM 函数，并在当前函数中创建闭包对象
+// 闭包函数的参数 0 是创建它时的环境指针
+func (c *Compiler) compileClosure(fn *ast.FunctionLiteral, expected string) error {
+	outer := c.current
+	if outer == nil {
+		return fmt.Errorf("function expression outside of a function")
+	}
+	for _, p := range fn.Parameters {
+		if p.Optional || p.Value != nil {
+			return fmt.Errorf("function expressions cannot have optional or default parameters (%s)", p.Name.Value)
+		}
+	}
+	sig, inferReturn := c.closureSignature(fn, expected)
+
+	c.closureCount++
+	scope := NewFunctionScope(fmt.Sprintf("%s_closure%d", outer.Name, c.closureCount))
+	scope.Parent = outer
+	scope.IsClosure = true
+	scope.InferReturn = inferReturn && !fn.Async && !fn.Generator
+	scope.ReturnTypeName = sig.ReturnTypeName
+	if inferReturn {
+		scope.ReturnTypeName = "void" // Until a return statement says otherwise
+	}
+	if isWideType(sig.ReturnType) {
+		scope.ReturnType = sig.ReturnType
+	}
+	c.functions = append(c.functions, scope)
+	c.current = scope
+
+	// Param 0: enclosing environment
+	scope.ParamTypes = append(scope.ParamTypes, TypeInt)
+	scope.ParamCount++
+	scope.ShadowStackSize++
+	for i, param := range fn.Parameters {
+		t := sig.ParamTypes[i]
+		shadowIndex := scope.ShadowStackSize
+		if isWideType(t) {
+			shadowIndex = -1
+		}
+		scope.Symbols[param.Name.Value] = Symbol{Index: i + 1, Type: t, IsParam: true, ShadowIndex: shadowIndex, TypeName: sig.ParamTypeNames[i]}
+		scope.ParamTypes = append(scope.ParamTypes, t)
+		scope.ParamCount++
+		scope.ShadowStackSize++
+	}
+
+	if fn.Generator {
+		returnTypeName := sig.ReturnTypeName
+		if inferReturn {
+			returnTypeName = ""
+		}
+		valueTypeName, err := c.compileGeneratorFunction(fn, returnTypeName, inferReturn, false)
+		if err != nil {
+			return err
+		}
+		sig.ReturnTypeName = "Iterator<" + valueTypeName + ">"
+		sig.ReturnType = TypeInt
+	} else if fn.Async {
+		returnTypeName := sig.ReturnTypeName
+		if inferReturn {
+			returnTypeName = ""
+		}
+		valueTypeName, err := c.compileAsyncFunction(fn, returnTypeName, inferReturn, false)
+		if err != nil {
+			return err
+		}
+		sig.ReturnTypeName = "Promise<" + valueTypeName + ">"
+		sig.ReturnType = TypeInt
+	} else {
+		realShadowPtrLocal := c.emitShadowPrologue()
+		c.setupEnv(capturedNames(fn.Parameters, fn.Body, false))
+
+		if err := c.Compile(fn.Body); err != nil {
+			return err
+		}
+
+		c.emit(fmt.Sprintf("local.get %d", realShadowPtrLocal))
+		c.emit("global.set $shadow_stack_ptr")
+		c.emitDefaultReturn()
+
+		if inferReturn {
+			sig.ReturnTypeName = scope.ReturnTypeName
+			sig.ReturnType = c.resolveType(scope.ReturnTypeName)
+		}
+	}
+	c.current = outer
+
+	tableIndex := c.nextFuncID
+	c.nextFuncID++
+	c.closureIDs[tableIndex] = scope.Name
+	c.emitClosureObject(tableIndex, true)
+	c.stackType = TypeFunc
+	c.stackTypeName = funcTypeName(sig)
+	return nil
+}
+
+// promiseValueType 返回 Promise<T> 中的 T
+func promiseValueType(typeName string) (string, bool) {
+	if strings.HasPrefix(typeName, "Promise<") && strings.HasSuffix(typeName, ">") {
+		return strings.TrimSpace(typeName[len("Promise<") : len(typeName)-1]), true
+	}
+	return "", false
+}
+
+// iteratorValueType 返回 Iterator<T> / Generator<T> / IterableIterator<T> 中的 T (Generator 的其余类型参数被忽略)
+func iteratorValueType(typeName string) (string, bool) {
+	for _, prefix := range []string{"Iterator<", "Generator<", "IterableIterator<"} {
+		if strings.HasPrefix(typeName, prefix) && strings.HasSuffix(typeName, ">") {
+			if args := splitTypeList(typeName[len(prefix) : len(typeName)-1]); len(args) > 0 {
+				return args[0], true
+			}
+		}
+	}
+	return "", false
+}
+
+// iteratorResultValueType 返回 IteratorResult<T> 中的 T
+func iteratorResultValueType(typeName string) (string, bool) {
+	if strings.HasPrefix(typeName, "IteratorResult<") && strings.HasSuffix(typeName, ">") {
+		return strings.TrimSpace(typeName[len("IteratorResult<") : len(typeName)-1]), true
+	}
+	return "", false
+}
+
+// promiseSuffix 返回按值的 WASM 类型区分的运行时函数后缀 ($promise_resolve / _f64 / _i64)
+func promiseSuffix(t DataType) string {
+	switch t {
+	case TypeFloat:
+		return "_f64"
+	case TypeBigInt:
+		return "_i64"
+	}
+	return ""
+}
+
+// resolveValueType 解析 Promise<T> 的 T；await 宿主值得到的 "host" 仍是宿主对象
+func (c *Compiler) resolveValueType(valueTypeName string) DataType {
+	if valueTypeName == string(TypeHost) {
+		return TypeHost
+	}
+	return c.resolveType(valueTypeName)
+}
+
+// compileAsyncFunction 编译 async 函数，参数已注册到当前函数中
+// 当前函数只创建帧对象和 Promise、启动状态机并返回 Promise；函数体编译为状态机函数 <name>_async，
+// 它的唯一参数是帧对象，以闭包 [table index, frame] 的形式登记为所等待的 Promise 的 reaction
+// returnTypeName 为声明的返回类型 ("" 即 Promise<void>)，inferValue 时 T 由第一个 return 决定；返回 T
+func (c *Compiler) compileAsyncFunction(fn *ast.FunctionLiteral, returnTypeName string, inferValue bool, hasThis bool) (string, error) {
+	valueTypeName := "void"
+	if returnTypeName != "" {
+		inner, ok := promiseValueType(returnTypeName)
+		if !ok {
+			return "", fmt.Errorf("async function %s must return Promise<T>, not %s", fn.Name, returnTypeName)
+		}
+		valueTypeName = inner
+	}
+	frame := &AsyncFrame{ValueTypeName: valueTypeName, InferValue: inferValue}
+	if err := c.compileStateMachine(fn, frame, hasThis); err != nil {
+		return "", err
+	}
+	return frame.ValueTypeName, nil
+}
+
+// compileGeneratorFunction 编译生成器函数 function*，参数已注册到当前函数中
+// 当前函数只创建帧对象 (即生成器对象) 并返回，不执行函数体；每次 next() 调用状态机函数 <name>_next，
+// 运行到下一个 yield 或函数结束，返回 IteratorResult<T>
+// returnTypeName 为声明的返回类型 ("" 即 Iterator<int>)，inferValue 时 T 由第一个 yield 决定；返回 T
+func (c *Compiler) compileGeneratorFunction(fn *ast.FunctionLiteral, returnTypeName string, inferValue bool, hasThis bool) (string, error) {
+	if fn.Async {
+		return "", fmt.Errorf("async generator %s is not supported yet", fn.Name)
+	}
+	valueTypeName := "int"
+	if returnTypeName != "" {
+		inner, ok := iteratorValueType(returnTypeName)
+		if !ok {
+			return "", fmt.Errorf("generator %s must return Iterator<T> or Generator<T>, not %s", fn.Name, returnTypeName)
+		}
+		valueTypeName = inner
+	}
+	desugarYieldDelegates(fn.Body)
+	frame := &AsyncFrame{Generator: true, ValueTypeName: valueTypeName, InferValue: inferValue}
+	if err := c.compileStateMachine(fn, frame, hasThis); err != nil {
+		return "", err
+	}
+	return frame.ValueTypeName, nil
+}
+
+// compileStateMachine 把 async 函数或生成器的函数体编译为状态机函数，并在当前函数 (入口) 中创建帧对象
+// async 函数的入口接着运行状态机并返回 Promise；生成器的入口直接返回帧对象
+func (c *Compiler) compileStateMachine(fn *ast.FunctionLiteral, frame *AsyncFrame, hasThis bool) error {
+	entry := c.current
+	realShadowPtrLocal := c.emitShadowPrologue()
+
+	suffix := "_async"
+	if frame.Generator {
+		suffix = "_next"
+	}
+	step := NewFunctionScope(entry.Name + suffix)
+	step.Parent = entry.Parent
+	step.IsClosure = entry.IsClosure
+	step.ParamTypes = []DataType{TypeInt} // frame
+	step.ParamCount = 1
+	step.ShadowStackSize = 1
+	frame.Points = make(map[ast.Expression]int)
+	frame.Results = make(map[ast.Expression]*ast.Identifier)
+	step.Async = frame
+	c.functions = append(c.functions, step)
+	// The saved shadow_stack_ptr must be the first local: assignments find the shadow slots relative to it
+	c.current = step
+	stepShadowPtrLocal := c.emitShadowPrologue()
+
+	// Parameters (and this) become locals of the state machine, loaded from the frame like every other local
+	params := make([]string, 0, len(entry.Symbols))
+	for name, sym := range entry.Symbols {
+		if sym.IsParam {
+			params = append(params, name)
+		}
+	}
+	sort.Slice(params, func(i, j int) bool { return entry.Symbols[params[i]].Index < entry.Symbols[params[j]].Index })
+	slots := make([]int, len(params))
+	for i, name := range params {
+		sym := entry.Symbols[name]
+		slots[i] = step.NextLocalID
+		step.NextLocalID++
+		if isWideType(sym.Type) {
+			step.LocalTypes[slots[i]] = sym.Type
+		}
+		step.Symbols[name] = Symbol{Index: slots[i] + step.ParamCount, Type: sym.Type, IsParam: true, ShadowIndex: -1, TypeName: sym.TypeName}
+	}
+
+	var err error
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FunctionLiteral:
+			return false
+		case *ast.AwaitExpression:
+			if frame.Generator && err == nil {
+				err = fmt.Errorf("line %d: await is only valid in async functions", n.Token.Line)
+			}
+			frame.Points[n] = len(frame.Points) + 1
+		case *ast.YieldExpression:
+			if !frame.Generator && err == nil {
+				err = fmt.Errorf("line %d: yield is only valid in generator functions", n.Token.Line)
+			}
+			frame.Points[n] = len(frame.Points) + 1
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	c.emit(asyncRestoreMarker)
+	frame.ResumingLocal = c.newTempLocal("resuming", TypeInt)
+	c.emit("local.get 0")
+	c.emit("i32.load offset=4 ;; state")
+	c.emit("i32.const 0")
+	c.emit("i32.ne")
+	c.emit(fmt.Sprintf("local.set %d ;; resuming", frame.ResumingLocal))
+
+	// Anything thrown by an async body rejects the promise; a generator finishes and rethrows to the caller of next()
+	c.emit("try")
+	c.emitNotResuming()
+	c.emit("if")
+	c.setupEnv(capturedNames(fn.Parameters, fn.Body, hasThis))
+	c.emit("end")
+	if err := c.Compile(fn.Body); err != nil {
+		return err
+	}
+	if err := c.compileAsyncReturn(nil, fn.Token.Line); err != nil {
+		return err
+	}
+	c.emit("catch $exception")
+	reason := c.newTempLocal("reason", TypeInt)
+	c.emit(fmt.Sprintf("local.set %d", reason))
+	if frame.Generator {
+		c.emit("local.get 0")
+		c.emit("i32.const -1")
+		c.emit("i32.store offset=4 ;; state = finished")
+		c.emit(fmt.Sprintf("local.get %d", stepShadowPtrLocal))
+		c.emit("global.set $shadow_stack_ptr")
+		c.emit(fmt.Sprintf("local.get %d", reason))
+		c.emit("throw $exception")
+	} else {
+		c.emit("local.get 0")
+		c.emit("i32.load ;; promise")
+		c.emit(fmt.Sprintf("local.get %d", reason))
+		c.emit("call $promise_reject")
+	}
+	c.emit("end")
+	c.emit(fmt.Sprintf("local.get %d", stepShadowPtrLocal))
+	c.emit("global.set $shadow_stack_ptr")
+	c.emitDefaultReturn()
+	step.Instructions = expandAsyncMarkers(step)
+
+	// Frame layout, traced by the GC like a closure environment
+	layout := &EnvLayout{TypeID: c.allocTypeID(), Size: 16 + 8*step.NextLocalID, Offsets: make(map[string]int), Types: make(map[string]DataType)}
+	layout.Offsets["$step"], layout.Types["$step"] = 8, TypeFunc
+	layout.Offsets["$env"], layout.Types["$env"] = 12, TypeUnknown
+	for name, sym := range step.Symbols {
+		if !sym.InEnv {
+			layout.Offsets[name], layout.Types[name] = 16+8*(sym.Index-step.ParamCount), sym.Type
+		}
+	}
+	if step.Env != nil {
+		layout.Offsets["$env_local"], layout.Types["$env_local"] = 16+8*(step.EnvLocal-step.ParamCount), TypeUnknown
+	}
+	c.envs = append(c.envs, layout)
+
+	tableIndex := c.nextFuncID
+	c.nextFuncID++
+	c.closureIDs[tableIndex] = step.Name
+	c.current = entry
+
+	// Entry: allocate the frame; an async function runs the state machine up to the first await and returns the promise
+	frameLocal := c.newTempLocal("frame", TypeInt)
+	c.emit(fmt.Sprintf("i32.const %d", layout.Size))
+	if frame.Generator {
+		c.emit(fmt.Sprintf("i32.const %d ;; generator", layout.TypeID))
+	} else {
+		c.emit(fmt.Sprintf("i32.const %d ;; async frame", layout.TypeID))
+	}
+	c.emit("call $malloc")
+	c.emit(fmt.Sprintf("local.set %d", frameLocal))
+	c.emitShadowPush(frameLocal)
+	if !frame.Generator {
+		c.emit(fmt.Sprintf("local.get %d", frameLocal))
+		c.emit("call $promise_new")
+		c.emit("i32.store ;; promise")
+	}
+	c.emit(fmt.Sprintf("local.get %d", frameLocal))
+	c.emitClosureWithEnv(tableIndex, func() {
+		c.emit(fmt.Sprintf("local.get %d", frameLocal))
+	})
+	c.emit("i32.store offset=8 ;; step")
+	if entry.IsClosure {
+		c.emit(fmt.Sprintf("local.get %d", frameLocal))
+		c.emit("local.get 0 ;; enclosing env")
+		c.emit("i32.store offset=12")
+	}
+	for i, name := range params {
+		sym := entry.Symbols[name]
+		c.emit(fmt.Sprintf("local.get %d", frameLocal))
+		c.emit(fmt.Sprintf("local.get %d", sym.Index))
+		c.emit(fmt.Sprintf("%s.store offset=%d ;; %s", wasmType(sym.Type), 16+8*slots[i], name))
+	}
+	if !frame.Generator {
+		c.emit(fmt.Sprintf("local.get %d", frameLocal))
+		c.emit(fmt.Sprintf("call $%s", step.Name))
+		c.emit("drop")
+	}
+	c.emit(fmt.Sprintf("local.get %d", realShadowPtrLocal))
+	c.emit("global.set $shadow_stack_ptr")
+	c.emit(fmt.Sprintf("local.get %d", frameLocal))
+	if !frame.Generator {
+		c.emit("i32.load ;; promise")
+	}
+	return nil
+}
+
+// expandAsyncMarkers 把挂起点 / 入口处的占位指令展开为保存 / 恢复全部局部变量
+// 调用时保存的 shadow_stack_ptr 属于本次调用，不保存
+func expandAsyncMarkers(scope *FunctionScope) []string {
+	var spill, restore []string
+	for id := 0; id < scope.NextLocalID; id++ {
+		realIndex := id + scope.ParamCount
+		if realIndex == scope.ShadowPtrLocal {
+			continue
+		}
+		t := wasmType(scope.LocalTypes[id])
+		offset := 16 + 8*id
+		spill = append(spill, "local.get 0", fmt.Sprintf("local.get %d", realIndex), fmt.Sprintf("%s.store offset=%d", t, offset))
+		restore = append(restore, "local.get 0", fmt.Sprintf("%s.load offset=%d", t, offset), fmt.Sprintf("local.set %d", realIndex))
+	}
+	out := make([]string, 0, len(scope.Instructions)+len(spill))
+	for _, ins := range scope.Instructions {
+		switch ins {
+		case asyncSpillMarker:
+			out = append(out, spill...)
+		case asyncRestoreMarker:
+			out = append(out, restore...)
+		default:
+			out = append(out, ins)
+		}
+	}
+	return out
+}
+
+// suspendRange 返回 node 中挂起点编号的范围 (先序编号，任何子树都是连续的一段)
+func (a *AsyncFrame) suspendRange(node ast.Node) (lo, hi int, ok bool) {
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FunctionLiteral:
+			return false
+		case *ast.AwaitExpression, *ast.YieldExpression:
+			id := a.Points[n.(ast.Expression)]
+			if !ok || id < lo {
+				lo = id
+			}
+			if !ok || id > hi {
+				hi = id
+			}
+			ok = true
+		}
+		return true
+	})
+	return lo, hi, ok
+}
+
+// emitNotResuming 压入条件：不是在恢复执行 (正常执行，或已经回到了挂起点)
+func (c *Compiler) emitNotResuming() {
+	c.emit(fmt.Sprintf("local.get %d ;; resuming", c.current.Async.ResumingLocal))
+	c.emit("i32.eqz")
+}
+
+// emitStateInRange 压入条件：挂起点编号在 [lo, hi] 之内
+func (c *Compiler) emitStateInRange(lo, hi int) {
+	c.emit("local.get 0")
+	c.emit("i32.load offset=4 ;; state")
+	c.emit(fmt.Sprintf("i32.const %d", lo))
+	if lo == hi {
+		c.emit("i32.eq")
+		return
+	}
+	c.emit("i32.sub")
+	c.emit(fmt.Sprintf("i32.const %d", hi-lo))
+	c.emit("i32.le_u")
+}
+
+// emitResumeGuard 开始一个 if 块：正常执行时总会进入，恢复执行时只进入包含挂起点的语句
+func (c *Compiler) emitResumeGuard(lo, hi int) {
+	c.emitNotResuming()
+	c.emitStateInRange(lo, hi)
+	c.emit("i32.or")
+	c.emit("if")
+}
+
+// compileAsyncBlock 编译 async 函数中的语句序列
+// 不含 await 的连续语句放进同一个 if，恢复执行时整体跳过
+func (c *Compiler) compileAsyncBlock(stmts []ast.Statement) error {
+	async := c.current.Async
+	for i := 0; i < len(stmts); {
+		if lo, hi, ok := async.suspendRange(stmts[i]); ok {
+			if err := c.compileAsyncStatement(stmts[i], lo, hi); err != nil {
+				return err
+			}
+			i++
+			continue
+		}
+		c.emitNotResuming()
+		c.emit("if")
+		for ; i < len(stmts); i++ {
+			if _, _, ok := async.suspendRange(stmts[i]); ok {
+				break
+			}
+			if err := c.Compile(stmts[i]); err != nil {
+				return err
+			}
+		}
+		c.emit("end")
+	}
+	return nil
+}
+
+// compileAsyncStatement 编译包含挂起点 lo..hi 的语句
+// 简单语句先求值其中的 await；复合语句 (if、循环、try、switch) 在恢复执行时跳过条件，直接进入包含挂起点的分支
+func (c *Compiler) compileAsyncStatement(stmt ast.Statement, lo, hi int) error {
+	c.emitResumeGuard(lo, hi)
+	var err error
+	switch s := stmt.(type) {
+	case *ast.ExpressionStatement:
+		if ifExp, ok := s.Expression.(*ast.IfExpression); ok {
+			err = c.compileAsyncIf(ifExp)
+		} else if err = c.hoistSuspends(s.Expression); err == nil {
+			err = c.Compile(s)
+		}
+	case *ast.LetStatement, *ast.ReturnStatement, *ast.ThrowStatement:
+		if err = c.hoistSuspends(s); err == nil {
+			err = c.Compile(s)
+		}
+	default:
+		err = c.Compile(stmt)
+	}
+	if err != nil {
+		return err
+	}
+	c.emit("end")
+	return nil
+}
+
+// compileAsyncIf 编译 async 函数中的 if 语句：恢复执行时不再求值条件，进入包含挂起点的分支
+func (c *Compiler) compileAsyncIf(node *ast.IfExpression) error {
+	async := c.current.Async
+	if err := c.hoistSuspends(node.Condition); err != nil {
+		return err
+	}
+	c.emit(fmt.Sprintf("local.get %d ;; resuming", async.ResumingLocal))
+	c.emit("if (result i32)")
+	if lo, hi, ok := async.suspendRange(node.Consequence); ok {
+		c.emitStateInRange(lo, hi)
+	} else {
+		c.emit("i32.const 0")
+	}
+	c.emit("else")
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+	c.emitTruthy(c.stackType)
+	c.emit("end")
+
+	c.emit("if")
+	if err := c.Compile(node.Consequence); err != nil {
+		return err
+	}
+	if node.Alternative != nil {
+		c.emit("else")
+		if err := c.Compile(node.Alternative); err != nil {
+			return err
+		}
+	}
+	c.emit("end")
+	c.stackType = TypeVoid
+	return nil
+}
+
+// suspendKeyword 返回 node 中第一个挂起点的关键字 ("await" / "yield")，没有时返回 "" (不进入内部函数)
+func suspendKeyword(node ast.Node) string {
+	keyword := ""
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n.(type) {
+		case *ast.FunctionLiteral:
+			return false
+		case *ast.AwaitExpression:
+			keyword = "await"
+		case *ast.YieldExpression:
+			keyword = "yield"
+		}
+		return keyword == ""
+	})
+	return keyword
+}
+
+// collectSuspends 按求值顺序返回 node 中的 await / yield (不进入内部函数)
+// 它们会被提到所在语句之前求值，因此不能出现在有条件求值的位置
+func collectSuspends(node ast.Node) ([]ast.Expression, error) {
+	var points []ast.Expression
+	var err error
+	var visit func(n ast.Node) bool
+	visit = func(n ast.Node) bool {
+		if err != nil {
+			return false
+		}
+		switch n := n.(type) {
+		case *ast.FunctionLiteral:
+			return false
+		case *ast.AwaitExpression:
+			ast.Inspect(n.Argument, visit)
+			points = append(points, n)
+			return false
+		case *ast.YieldExpression:
+			ast.Inspect(n.Argument, visit)
+			points = append(points, n)
+			return false
+		case *ast.InfixExpression:
+			if n.Operator == "&&" || n.Operator == "||" || n.Operator == "??" {
+				ast.Inspect(n.Left, visit)
+				if keyword := suspendKeyword(n.Right); keyword != "" {
+					err = fmt.Errorf("line %d: %s in the right operand of %s is not supported yet, use an if statement", n.Token.Line, keyword, n.Operator)
+				}
+				return false
+			}
+		case *ast.ConditionalExpression:
+			ast.Inspect(n.Condition, visit)
+			keyword := suspendKeyword(n.Consequence)
+			if keyword == "" {
+				keyword = suspendKeyword(n.Alternative)
+			}
+			if keyword != "" {
+				err = fmt.Errorf("line %d: %s in a branch of ?: is not supported yet, use an if statement", n.Token.Line, keyword)
+			}
+			return false
+		}
+		return true
+	}
+	ast.Inspect(node, visit)
+	return points, err
+}
+
+// hoistSuspends 在语句之前依次求值其中的 await / yield，结果存入临时变量，编译 AwaitExpression 时读取
+func (c *Compiler) hoistSuspends(node ast.Node) error {
+	points, err := collectSuspends(node)
+	if err != nil {
+		return err
+	}
+	async := c.current.Async
+	for _, point := range points {
+		id := async.Points[point]
+		c.emitResumeGuard(id, id)
+		switch point := point.(type) {
+		case *ast.AwaitExpression:
+			err = c.compileAwaitPoint(point, id)
+		case *ast.YieldExpression:
+			err = c.compileYieldPoint(point, id)
+		}
+		if err != nil {
+			return err
+		}
+		if c.stackType == TypeVoid {
+			async.Results[point] = nil
+		} else {
+			async.Results[point] = c.bindTemp("await", c.stackType, c.stackTypeName)
+		}
+		c.emit("end")
+	}
+	return nil
+}
+
+// compileAwaitPoint 编译挂起点 id：第一次到达时求值 Promise，保存状态和局部变量后返回；
+// Promise 完成后状态机从这里继续，取出结果 (被拒绝时抛出原因)
+func (c *Compiler) compileAwaitPoint(node *ast.AwaitExpression, id int) error {
+	async := c.current.Async
+	promise := c.newTempLocal("awaited", TypeInt)
+	var valueTypeName string
+	c.emitNotResuming()
+	c.emit("if")
+	if err := c.Compile(node.Argument); err != nil {
+		return err
+	}
+	valueTypeName = c.emitToPromise(c.stackType, c.stackTypeName)
+	c.emit(fmt.Sprintf("local.set %d", promise))
+	c.emit("local.get 0")
+	c.emit(fmt.Sprintf("i32.const %d", id))
+	c.emit(fmt.Sprintf("i32.store offset=4 ;; state = await #%d", id))
+	c.emit(asyncSpillMarker)
+	c.emit(fmt.Sprintf("local.get %d", promise))
+	c.emit("local.get 0")
+	c.emit("i32.load offset=8 ;; step")
+	c.emit("call $promise_then")
+	c.emit(fmt.Sprintf("local.get %d", c.current.ShadowPtrLocal))
+	c.emit("global.set $shadow_stack_ptr")
+	c.emit("i32.const 0")
+	c.emit("return")
+	c.emit("end")
+
+	c.emit("i32.const 0")
+	c.emit(fmt.Sprintf("local.set %d ;; resumed at await #%d", async.ResumingLocal, id))
+	c.emit(fmt.Sprintf("local.get %d", promise))
+	c.emit("call $promise_check")
+	valueType := c.resolveValueType(valueTypeName)
+	c.stackType = valueType
+	c.stackTypeName = ""
+	if valueType == TypeVoid {
+		return nil
+	}
+	c.emit(fmt.Sprintf("local.get %d", promise))
+	c.emit(fmt.Sprintf("%s.load offset=8", wasmType(valueType)))
+	if valueType != TypeHost {
+		c.stackTypeName = valueTypeName
+	}
+	return nil
+}
+
+// emitToPromise 把栈顶的值变为 Promise，返回 T：Promise<T> 原样使用，宿主值 (JS Promise) 由宿主完成，
+// 其他值包装为已完成的 Promise (await 之后的代码仍然在下一个任务中执行)
+func (c *Compiler) emitToPromise(t DataType, typeName string) string {
+	if valueTypeName, ok := promiseValueType(typeName); ok {
+		return valueTypeName
+	}
+	if t == TypeHost {
+		c.emit("call $promise_from_host")
+		return string(TypeHost)
+	}
+	promise := c.newTempLocal("promise", TypeInt)
+	if t == TypeVoid {
+		c.emit("call $promise_new")
+		c.emit(fmt.Sprintf("local.tee %d", promise))
+		c.emit("i32.const 0")
+		c.emit("call $promise_resolve")
+		c.emit(fmt.Sprintf("local.get %d", promise))
+		return "void"
+	}
+	value := c.newTempLocal("value", t)
+	c.emit(fmt.Sprintf("local.set %d", value))
+	c.emit("call $promise_new")
+	c.emit(fmt.Sprintf("local.tee %d", promise))
+	c.emit(fmt.Sprintf("local.get %d", value))
+	c.emit("call $promise_resolve" + promiseSuffix(t))
+	c.emit(fmt.Sprintf("local.get %d", promise))
+	return typeNameOf(t, typeName)
+}
+
+// emitFrameValue 把栈顶的值转换为状态机的 T (Promise<T> / Iterator<T>)，没有值 (TypeVoid) 时使用零值；
+// T 需要推断时 (没有声明类型的箭头函数 / 生成器) 由这个值决定
+func (c *Compiler) emitFrameValue(context string) (DataType, error) {
+	frame := c.current.Async
+	if frame.InferValue {
+		frame.InferValue = false
+		frame.ValueTypeName = "void"
+		if c.stackType != TypeVoid {
+			frame.ValueTypeName = typeNameOf(c.stackType, c.stackTypeName)
+		}
+	}
+	valueType := c.resolveValueType(frame.ValueTypeName)
+	switch {
+	case valueType == TypeVoid:
+		if c.stackType != TypeVoid {
+			c.emit("drop")
+		}
+		c.emit("i32.const 0")
+	case c.stackType == TypeVoid:
+		c.emit(wasmType(valueType) + ".const 0")
+	default:
+		if err := c.emitConvert(c.stackType, valueType, context); err != nil {
+			return TypeVoid, err
+		}
+	}
+	return valueType, nil
+}
+
+// compileAsyncReturn 用返回值完成 async 函数的 Promise 并结束本次执行；value 为 nil 时使用零值
+func (c *Compiler) compileAsyncReturn(value ast.Expression, line int) error {
+	if c.current.Async.Generator {
+		return c.compileGeneratorReturn(value, line)
+	}
+	c.stackType = TypeVoid
+	if value != nil {
+		if err := c.Compile(value); err != nil {
+			return err
+		}
+	}
+	valueType, err := c.emitFrameValue("return")
+	if err != nil {
+		return err
+	}
+	resultType := valueType
+	if !isWideType(resultType) {
+		resultType = TypeInt
+	}
+	result := c.newTempLocal("result", resultType)
+	c.emit(fmt.Sprintf("local.set %d", result))
+	if err := c.emitPendingFinally(0, line); err != nil {
+		return err
+	}
+	c.emit("local.get 0")
+	c.emit("i32.load ;; promise")
+	c.emit(fmt.Sprintf("local.get %d", result))
+	c.emit("call $promise_resolve" + promiseSuffix(valueType))
+	c.emit(fmt.Sprintf("local.get %d", c.current.ShadowPtrLocal))
+	c.emit("global.set $shadow_stack_ptr")
+	c.emit("i32.const 0")
+	c.emit("return")
+	c.stackType = TypeVoid
+	return nil
+}
+
+// compileGeneratorReturn 结束生成器：返回值成为 done 为 true 的 IteratorResult，之后的 next() 都返回 done
+func (c *Compiler) compileGeneratorReturn(value ast.Expression, line int) error {
+	c.stackType = TypeVoid
+	if value != nil {
+		if err := c.Compile(value); err != nil {
+			return err
+		}
+	}
+	if _, err := c.emitFrameValue("return"); err != nil {
+		return err
+	}
+	c.emit("i32.const 1 ;; done")
+	c.emitIteratorResult(c.current.Async.ValueTypeName)
+	result := c.newTempLocal("result", TypeInt)
+	c.emit(fmt.Sprintf("local.set %d", result))
+	if err := c.emitPendingFinally(0, line); err != nil {
+		return err
+	}
+	c.emit("local.get 0")
+	c.emit("i32.const -1")
+	c.emit("i32.store offset=4 ;; state = finished")
+	c.emit(fmt.Sprintf("local.get %d", result))
+	c.emit(fmt.Sprintf("local.get %d", c.current.ShadowPtrLocal))
+	c.emit("global.set $shadow_stack_ptr")
+	c.emit("return")
+	c.stackType = TypeVoid
+	return nil
+}
+
+// compileYieldPoint 编译挂起点 id：第一次到达时把值包装为 IteratorResult，保存状态和局部变量后返回给 next() 的调用者；
+// 下一次 next() 从这里继续
+func (c *Compiler) compileYieldPoint(node *ast.YieldExpression, id int) error {
+	if node.Delegate {
+		return fmt.Errorf("line %d: yield* is only supported as a statement", node.Token.Line)
+	}
+	async := c.current.Async
+	c.emitNotResuming()
+	c.emit("if")
+	c.stackType = TypeVoid
+	if node.Argument != nil {
+		if err := c.Compile(node.Argument); err != nil {
+			return err
+		}
+	}
+	if _, err := c.emitFrameValue("yield"); err != nil {
+		return err
+	}
+	c.emit("i32.const 0 ;; done")
+	c.emitIteratorResult(async.ValueTypeName)
+	result := c.newTempLocal("result", TypeInt)
+	c.emit(fmt.Sprintf("local.set %d", result))
+	c.emit("local.get 0")
+	c.emit(fmt.Sprintf("i32.const %d", id))
+	c.emit(fmt.Sprintf("i32.store offset=4 ;; state = yield #%d", id))
+	c.emit(asyncSpillMarker)
+	c.emit(fmt.Sprintf("local.get %d", result))
+	c.emit(fmt.Sprintf("local.get %d", c.current.ShadowPtrLocal))
+	c.emit("global.set $shadow_stack_ptr")
+	c.emit("return")
+	c.emit("end")
+
+	c.emit("i32.const 0")
+	c.emit(fmt.Sprintf("local.set %d ;; resumed at yield #%d", async.ResumingLocal, id))
+	// Resumed by return(): finish here, running the enclosing finally blocks
+	c.emit("local.get 0")
+	c.emit("i32.load ;; return() requested")
+	c.emit("if")
+	if err := c.compileGeneratorReturn(nil, node.Token.Line); err != nil {
+		return err
+	}
+	c.emit("end")
+	c.stackType = TypeVoid
+	return nil
+}
+
+// emitIteratorResult 用栈顶的值和 done 创建 IteratorResult<T> [value (8 字节), done]
+// 值是堆上的引用时使用 TypeID_IterResultRef，由 GC 追踪
+func (c *Compiler) emitIteratorResult(valueTypeName string) {
+	valueType := c.resolveValueType(valueTypeName)
+	if valueType == TypeVoid {
+		valueType = TypeInt
+	}
+	typeID := TypeID_IterResult
+	if valueType != TypeHost && c.isPointerType(valueType, valueTypeName) {
+		typeID = TypeID_IterResultRef
+	}
+	done := c.newTempLocal("done", TypeInt)
+	c.emit(fmt.Sprintf("local.set %d", done))
+	value := c.newTempLocal("value", valueType)
+	c.emit(fmt.Sprintf("local.set %d", value))
+	c.emit(fmt.Sprintf("local.get %d", done))
+	c.emit(fmt.Sprintf("i32.const %d", typeID))
+	c.emit("call $iter_result_new")
+	result := c.newTempLocal("result", TypeInt)
+	c.emit(fmt.Sprintf("local.tee %d", result))
+	c.emit(fmt.Sprintf("local.get %d", value))
+	c.emit(wasmType(valueType) + ".store ;; value")
+	c.emit(fmt.Sprintf("local.get %d", result))
+	c.stackType = TypeInt
+	c.stackTypeName = "IteratorResult<" + valueTypeName + ">"
+}
+
+// isSymbolIterator 判断表达式是否为 Symbol.iterator
+func isSymbolIterator(e ast.Expression) bool {
+	member, ok := e.(*ast.MemberExpression)
+	if !ok {
+		return false
+	}
+	ident, ok := member.Object.(*ast.Identifier)
+	return ok && ident.Value == "Symbol" && member.Property.Value == "iterator"
+}
+
+// compileIteratorResultLiteral 编译期望类型为 IteratorResult<T> 的对象字面量 { value, done }
+// 两个键都可以省略：value 默认为零值，done 默认为 false
+func (c *Compiler) compileIteratorResultLiteral(node *ast.MapLiteral, valueTypeName string) error {
+	var value, done ast.Expression
+	for _, key := range node.Keys {
+		name, ok := key.(*ast.StringLiteral)
+		if !ok {
+			return fmt.Errorf("line %d: IteratorResult<%s> literal only has the keys value and done", node.Token.Line, valueTypeName)
+		}
+		switch name.Value {
+		case "value":
+			value = node.Pairs[key]
+		case "done":
+			done = node.Pairs[key]
+		default:
+			return fmt.Errorf("line %d: IteratorResult<%s> has no field %s", node.Token.Line, valueTypeName, name.Value)
+		}
+	}
+	valueType := c.resolveValueType(valueTypeName)
+	if value != nil {
+		if err := c.Compile(value); err != nil {
+			return err
+		}
+		if err := c.emitConvert(c.stackType, valueType, "IteratorResult value"); err != nil {
+			return err
+		}
+	} else {
+		c.emit(wasmType(valueType) + ".const 0")
+	}
+	if done != nil {
+		if err := c.Compile(done); err != nil {
+			return err
+		}
+		if c.stackType != TypeBool && c.stackType != TypeInt {
+			return fmt.Errorf("line %d: IteratorResult done must be a boolean, got %s", node.Token.Line, c.stackType)
+		}
+	} else {
+		c.emit("i32.const 0")
+	}
+	c.emitIteratorResult(valueTypeName)
+	return nil
+}
+
+// desugarYieldDelegates 把语句形式的 yield* iterable 改写为 for (const v of iterable) { yield v; }，
+// 因此 yield* 可以委托给 for...of 能遍历的任何值 (生成器、数组、字符串、可迭代的类实例)
+func desugarYieldDelegates(body *ast.BlockStatement) {
+	count := 0
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FunctionLiteral:
+			return false
+		case *ast.BlockStatement:
+			for i, stmt := range n.Statements {
+				exprStmt, ok := stmt.(*ast.ExpressionStatement)
+				if !ok {
+					continue
+				}
+				yield, ok := exprStmt.Expression.(*ast.YieldExpression)
+				if !ok || !yield.Delegate {
+					continue
+				}
+				count++
+				item := &ast.Identifier{Token: yield.Token, Value: fmt.Sprintf("$delegated_%d", count)}
+				n.Statements[i] = &ast.ForOfStatement{
+					Token:       yield.Token,
+					Declaration: &ast.LetStatement{Token: yield.Token, Name: item},
+					Iterable:    yield.Argument,
+					Body: &ast.BlockStatement{Token: yield.Token, Statements: []ast.Statement{
+						&ast.ExpressionStatement{Token: yield.Token, Expression: &ast.YieldExpression{Token: yield.Token, Argument: item}},
+					}},
+				}
+			}
+		}
+		return true
+	})
+}
+
+// compileLoopCondition 编译循环条件，不成立时跳出循环
+// async 函数中恢复执行时跳过条件，直接回到循环体中的挂起点
+func (c *Compiler) compileLoopCondition(cond ast.Expression, breakLabel string) error {
+	async := c.current.Async
+	if async != nil {
+		if err := c.hoistSuspends(cond); err != nil {
+			return err
+		}
+		c.emitNotResuming()
+		c.emit("if")
+	}
+	if err := c.Compile(cond); err != nil {
+		return err
+	}
+	c.emitTruthy(c.stackType)
+	c.emit("i32.eqz")
+	c.emit("br_if " + breakLabel)
+	if async != nil {
+		c.emit("end")
+	}
+	return nil
+}
+
+// runtimeFuncRef 返回运行时函数在函数表中的下标，用于把它包装为闭包 (例如交给 Promise 执行器的 resolve)
+func (c *Compiler) runtimeFuncRef(name string) int {
+	key := "$" + name // Source function names never start with $
+	if tableIndex, ok := c.funcRefs[key]; ok {
+		return tableIndex
+	}
+	tableIndex := c.nextFuncID
+	c.nextFuncID++
+	c.closureIDs[tableIndex] = name
+	c.funcRefs[key] = tableIndex
+	return tableIndex
+}
+
+// compileNewPromise 编译 new Promise<T>((resolve, reject) => ...)
+// 执行器立即以 resolve / reject 闭包调用 (它们的环境就是 Promise)，执行器抛出异常时 Promise 被拒绝
+func (c *Compiler) compileNewPromise(node *ast.NewExpression) error {
+	if len(node.TypeArgs) > 1 {
+		return fmt.Errorf("Promise expects 1 type argument, got %d", len(node.TypeArgs))
+	}
+	if len(node.Arguments) != 1 {
+		return fmt.Errorf("new Promise expects 1 argument (executor), got %d", len(node.Arguments))
+	}
+	valueTypeName := "void"
+	if len(node.TypeArgs) == 1 {
+		valueTypeName = node.TypeArgs[0]
+	}
+	valueType := c.resolveType(valueTypeName)
+
+	c.emit("call $promise_new")
+	promise := c.bindTemp("promise", TypeInt, "Promise<"+valueTypeName+">")
+	resolveType := fmt.Sprintf("(%s) => void", valueTypeName)
+	resolveFn := "promise_resolve_fn" + promiseSuffix(valueType)
+	if valueType == TypeVoid {
+		resolveType = "() => void"
+		resolveFn = "promise_resolve_fn_void"
+	}
+	c.emitClosureWithEnv(c.runtimeFuncRef(resolveFn), func() {
+		c.emit(fmt.Sprintf("local.get %d", c.tempLocal(promise)))
+	})
+	resolve := c.bindTemp("resolve", TypeFunc, resolveType)
+	c.emitClosureWithEnv(c.runtimeFuncRef("promise_reject_fn"), func() {
+		c.emit(fmt.Sprintf("local.get %d", c.tempLocal(promise)))
+	})
+	reject := c.bindTemp("reject", TypeFunc, "(unknown) => void")
+
+	c.emit("try")
+	c.expectedFuncType = fmt.Sprintf("(%s, (unknown) => void) => void", resolveType)
+	if err := c.Compile(node.Arguments[0]); err != nil {
+		return err
+	}
+	if c.stackType != TypeFunc {
+		return fmt.Errorf("Promise executor must be a function, got %s", typeNameOf(c.stackType, c.stackTypeName))
+	}
+	// The executor may leave out reject
+	args := []ast.Expression{resolve, reject}
+	if sig, ok := c.funcSignatureFromTypeName(c.stackTypeName); ok && len(sig.ParamTypes) < len(args) {
+		args = args[:len(sig.ParamTypes)]
+	}
+	if err := c.emitClosureCall(c.stackTypeName, args, "Promise executor"); err != nil {
+		return err
+	}
+	c.emit("drop")
+	c.emit("catch $exception")
+	reason := c.newTempLocal("reason", TypeInt)
+	c.emit(fmt.Sprintf("local.set %d", reason))
+	c.emit(fmt.Sprintf("local.get %d", c.tempLocal(promise)))
+	c.emit(fmt.Sprintf("local.get %d", reason))
+	c.emit("call $promise_reject")
+	c.emit("end")
+
+	c.emit(fmt.Sprintf("local.get %d", c.tempLocal(promise)))
+	c.stackType = TypeInt
+	c.stackTypeName = "Promise<" + valueTypeName + ">"
+	return nil
+}
+
+// compilePromiseStatic 编译 Promise.resolve(value) / Promise.reject(reason)
+func (c *Compiler) compilePromiseStatic(method string, args []ast.Expression) error {
+	switch method {
+	case "resolve":
+		if len(args) > 1 {
+			return fmt.Errorf("Promise.resolve expects at most 1 argument")
+		}
+		if len(args) == 0 {
+			c.emitToPromise(TypeVoid, "")
+			c.stackTypeName = "Promise<void>"
+		} else {
+			if err := c.Compile(args[0]); err != nil {
+				return err
+			}
+			c.stackTypeName = "Promise<" + c.emitToPromise(c.stackType, c.stackTypeName) + ">"
+		}
+	case "reject":
+		if len(args) != 1 {
+			return fmt.Errorf("Promise.reject expects 1 argument (reason)")
+		}
+		if err := c.Compile(args[0]); err != nil {
+			return err
+		}
+		if c.stackType == TypeBigInt {
+			return fmt.Errorf("Promise.reject reason cannot be %s", typeNameOf(c.stackType, ""))
+		}
+		// Rejection reasons are tagged like thrown values
+		if err := c.emitConvert(c.stackType, TypeUnion, "Promise.reject"); err != nil {
+			return err
+		}
+		reason := c.newTempLocal("reason", TypeInt)
+		promise := c.newTempLocal("promise", TypeInt)
+		c.emit(fmt.Sprintf("local.set %d", reason))
+		c.emit("call $promise_new")
+		c.emit(fmt.Sprintf("local.tee %d", promise))
+		c.emit(fmt.Sprintf("local.get %d", reason))
+		c.emit("call $promise_reject")
+		c.emit(fmt.Sprintf("local.get %d", promise))
+		c.stackTypeName = "Promise<void>"
+	default:
+		return fmt.Errorf("unknown method Promise.%s", method)
+	}
+	c.stackType = TypeInt
+	return nil
+}
+
+// compileSetTimeout 编译 setTimeout(callback, ms)：宿主在 ms 毫秒后调用导出的 run_callback
+func (c *Compiler) compileSetTimeout(args []ast.Expression) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("setTimeout expects 1 or 2 arguments (callback, ms), got %d", len(args))
+	}
+	c.expectedFuncType = "() => void"
+	if err := c.Compile(args[0]); err != nil {
+		return err
+	}
+	sig, ok := c.funcSignatureFromTypeName(c.stackTypeName)
+	if c.stackType != TypeFunc || !ok || len(sig.ParamTypes) > 0 || isWideType(sig.ReturnType) {
+		return fmt.Errorf("setTimeout callback must be a function without parameters, got %s", typeNameOf(c.stackType, c.stackTypeName))
+	}
+	if len(args) == 2 {
+		if err := c.Compile(args[1]); err != nil {
+			return err
+		}
+		if c.stackType != TypeInt {
+			return fmt.Errorf("setTimeout delay must be an int, got %s", typeNameOf(c.stackType, c.stackTypeName))
+		}
+	} else {
+		c.emit("i32.const 0")
+	}
+	c.emit("call $host_set_timeout")
+	c.stackType = TypeVoid
+	return nil
+}
+
+// emitFunctionRef 把具名函数作为值使用：通过忽略环境参数的跳板函数包装为闭包对象
+func (c *Compiler) emitFunctionRef(resolvedName string) {
+	sig := c.definedFuncs[resolvedName]
+	tableIndex, ok := c.funcRefs[resolvedName]
+	if !ok {
+		scope := NewFunctionScope(resolvedName + "_ref")
+		scope.ParamTypes = append([]DataType{TypeInt}, sig.ParamTypes...)
+		scope.ParamCount = len(scope.ParamTypes)
+		if isWideType(sig.ReturnType) {
+			scope.ReturnType = sig.ReturnType
+		}
+		for i := 1; i < scope.ParamCount; i++ {
+			scope.Instructions = append(scope.Instructions, fmt.Sprintf("local.get %d", i))
+		}
+		scope.Instructions = append(scope.Instructions, "call $"+resolvedName)
+		c.functions = append(c.functions, scope)
+
+		tableIndex = c.nextFuncID
+		c.nextFuncID++
+		c.closureIDs[tableIndex] = scope.Name
+		c.funcRefs[resolvedName] = tableIndex
+	}
+	c.emitClosureObject(tableIndex, false)
+	c.stackType = TypeFunc
+	c.stackTypeName = funcTypeName(sig)
+}
+
+// closureType 返回 call_indirect 使用的类型名，并登记对应的类型定义
+func (c *Compiler) closureType(sig FunctionSignature) string {
+	params := "i32" // env
+	name := "$closure_i32"
+	for _, t := range sig.ParamTypes {
+		params += " " + wasmType(t)
+		name += "_" + wasmType(t)
+	}
+	result := wasmType(sig.ReturnType)
+	name += "_r_" + result
+	c.closureTypes[name] = fmt.Sprintf("(func (param %s) (result %s))", params, result)
+	return name
+}
+
+// emitClosureCall 调用栈顶的闭包对象: call_indirect(env, args...)
+func (c *Compiler) emitClosureCall(typeName string, args []ast.Expression, context string) error {
+	sig, ok := c.funcSignatureFromTypeName(typeName)
+	if !ok {
+		return fmt.Errorf("%s is not callable (type %s)", context, typeName)
+	}
+	if err := checkArgCount(sig, args, context); err != nil {
+		return err
+	}
+
+	tempIndex := c.newTempLocal("closure", TypeFunc)
+	c.emit(fmt.Sprintf("local.tee %d", tempIndex))
+	c.emit("i32.load offset=4 ;; env")
+	if err := c.compileArgs(args, sig, context); err != nil {
+		return err
+	}
+	c.emit(fmt.Sprintf("local.get %d", tempIndex))
+	c.emit("i32.load ;; table index")
+	c.emit(fmt.Sprintf("call_indirect (type %s)", c.closureType(sig)))
+
+	c.stackType = sig.ReturnType
+	if c.stackType == TypeVoid || c.stackType == TypeUnknown {
+		c.stackType = TypeInt // Every function returns a value in our ABI
+	}
+	c.stackTypeName = sig.ReturnTypeName
+	return nil
+}
+
+// compileArrayIteration 编译 arr.forEach / arr.map / arr.filter，数组指针已在栈上
+func (c *Compiler) compileArrayIteration(method string, arrayTypeName string, args []ast.Expression) error {
+	if len(args) != 1 {
+		return fmt.Errorf("%s expects 1 argument (callback)", method)
+	}
+	elemTypeName := c.elementTypeName(arrayTypeName)
+	if elemTypeName == "" {
+		elemTypeName = "int"
+	}
+	elemType := c.resolveType(elemTypeName)
+
+	arrIndex := c.newTempLocal("arr", TypeArray)
+	c.emit(fmt.Sprintf("local.set %d", arrIndex))
+
+	// Callback: untyped arrow parameters get (element, index)
+	switch method {
+	case "forEach":
+		c.expectedFuncType = fmt.Sprintf("(%s, int) => void", elemTypeName)
+	case "filter":
+		c.expectedFuncType = fmt.Sprintf("(%s, int) => bool", elemTypeName)
+	default:
+		c.expectedFuncType = fmt.Sprintf("(%s, int)", elemTypeName)
+	}
+	if err := c.Compile(args[0]); err != nil {
+		return err
+	}
+	if c.stackType != TypeFunc {
+		return fmt.Errorf("%s expects a function, got %s", method, c.stackType)
+	}
+	sig, _ := c.funcSignatureFromTypeName(c.stackTypeName)
+	if len(sig.ParamTypes) > 2 {
+		return fmt.Errorf("%s callback takes at most 2 parameters (element, index)", method)
+	}
+	fnIndex := c.newTempLocal("fn", TypeFunc)
+	c.emit(fmt.Sprintf("local.set %d", fnIndex))
+
+	resultIndex := -1
+	if method != "forEach" {
+		resultIndex = c.newTempLocal("result", TypeArray)
+		c.emit("i32.const 0")
+		c.emit("call $array_new")
+		c.emit(fmt.Sprintf("local.set %d", resultIndex))
+		c.emitShadowPush(resultIndex)
+	}
+
+	iIndex := c.newTempLocal("i", TypeInt)
+	nIndex := c.newTempLocal("n", TypeInt)
+	slotIndex := c.newTempLocal("slot", TypeInt)
+	c.emit("i32.const 0")
+	c.emit(fmt.Sprintf("local.set %d", iIndex))
+	c.emit(fmt.Sprintf("local.get %d", arrIndex))
+	c.emit("call $array_length")
+	c.emit(fmt.Sprintf("local.set %d", nIndex))
+
+	c.emit("block")
+	c.emit("loop")
+	c.emit(fmt.Sprintf("local.get %d", iIndex))
+	c.emit(fmt.Sprintf("local.get %d", nIndex))
+	c.emit("i32.ge_s")
+	c.emit("br_if 1")
+
+	c.emit(fmt.Sprintf("local.get %d", arrIndex))
+	c.emit(fmt.Sprintf("local.get %d", iIndex))
+	c.emit("call $array_get")
+	c.emit(fmt.Sprintf("local.set %d", slotIndex))
+
+	// callback(element, index)
+	c.emit(fmt.Sprintf("local.get %d", fnIndex))
+	c.emit("i32.load offset=4 ;; env")
+	if len(sig.ParamTypes) > 0 {
+		c.emit(fmt.Sprintf("local.get %d", slotIndex))
+		if elemType == TypeFloat {
+			c.emit("call $unbox_f64")
+		}
+		if err := c.emitConvert(elemType, sig.ParamTypes[0], method+" callback"); err != nil {
+			return err
+		}
+	}
+	if len(sig.ParamTypes) > 1 {
+		c.emit(fmt.Sprintf("local.get %d", iIndex))
+		if err := c.emitConvert(TypeInt, sig.ParamTypes[1], method+" callback"); err != nil {
+			return err
+		}
+	}
+	c.emit(fmt.Sprintf("local.get %d", fnIndex))
+	c.emit("i32.load ;; table index")
+	c.emit(fmt.Sprintf("call_indirect (type %s)", c.closureType(sig)))
+
+	returnType := sig.ReturnType
+	switch method {
+	case "forEach":
+		c.emit("drop")
+	case "map":
+		valueIndex := c.newTempLocal("value", returnType)
+		c.emit(fmt.Sprintf("local.set %d", valueIndex))
+		c.emit(fmt.Sprintf("local.get %d", resultIndex))
+		c.emit(fmt.Sprintf("local.get %d", valueIndex))
+		if err := c.emitStoreElement(returnType, returnType); err != nil {
+			return err
+		}
+		c.emit("call $array_push")
+	case "filter":
+		c.emitTruthy(returnType)
+		c.emit("if")
+		c.emit(fmt.Sprintf("local.get %d", resultIndex))
+		c.emit(fmt.Sprintf("local.get %d", slotIndex))
+		c.emit("call $array_push")
+		c.emit("end")
+	}
+
+	c.emit(fmt.Sprintf("local.get %d", iIndex))
+	c.emit("i32.const 1")
+	c.emit("i32.add")
+	c.emit(fmt.Sprintf("local.set %d", iIndex))
+	c.emit("br 0")
+	c.emit("end")
+	c.emit("end")
+
+	switch method {
+	case "forEach":
+		c.stackType = TypeVoid
+	case "map":
+		c.emit(fmt.Sprintf("local.get %d", resultIndex))
+		c.stackType = TypeArray
+		c.stackTypeName = fmt.Sprintf("Array<%s>", typeNameOf(returnType, sig.ReturnTypeName))
+	case "filter":
+		c.emit(fmt.Sprintf("local.get %d", resultIndex))
+		c.stackType = TypeArray
+		c.stackTypeName = fmt.Sprintf("Array<%s>", elemTypeName)
+	}
+	return nil
+}
+
+// emitDefaultReturn 在函数末尾压入与返回类型匹配的默认值
+func (c *Compiler) emitDefaultReturn() {
+	c.emit(wasmType(c.current.ReturnType) + ".const 0")
+}
+
+func (c *Compiler) emit(instruction string) {
+	if c.current != nil {
+		c.current.Instructions = append(c.current.Instructions, instruction)
+	}
+}
+
+// GenerateWAT returns the final WAT string, or an error when the static data does not fit below the heap pointer
+func (c *Compiler) GenerateWAT() (string, error) {
+	var out bytes.Buffer
+	itables := c.buildItables()
+	out.WriteString("(module\n")
+
+	if c.target == "wasi" {
+		out.WriteString(`  (import "wasi_snapshot_preview1" "fd_write" (func $fd_write (param i32 i32 i32 i32) (result i32)))`)
+		out.WriteString("\n")
+		out.WriteString(`  (import "wasi_snapshot_preview1" "path_open" (func $path_open (param i32 i32 i32 i32 i32 i64 i64 i32 i32) (result i32)))`)
+		out.WriteString("\n")
+		out.WriteString(`  (import "wasi_snapshot_preview1" "fd_close" (func $fd_close (param i32) (result i32)))`)
+		out.WriteString("\n")
+		out.WriteString(`  (import "wasi_snapshot_preview1" "fd_read" (func $fd_read (param i32 i32 i32 i32) (result i32)))`)
+		out.WriteString("\n")
+		out.WriteString(`  (import "wasi_snapshot_preview1" "fd_filestat_get" (func $fd_filestat_get (param i32 i32) (result i32)))`)
+		out.WriteString("\n")
+		out.WriteString(`  (import "wasi_snapshot_preview1" "args_sizes_get" (func $args_sizes_get (param i32 i32) (result i32)))`)
+		out.WriteString("\n")
+		out.WriteString(`  (import "wasi_snapshot_preview1" "args_get" (func $args_get (param i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "environ_sizes_get" (func $environ_sizes_get (param i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "environ_get" (func $environ_get (param i32 i32) (result i32)))
+  (import "env" "thread_spawn" (func $thread_spawn (param i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "path_unlink_file" (func $path_unlink_file (param i32 i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "path_create_directory" (func $path_create_directory (param i32 i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "path_remove_directory" (func $path_remove_directory (param i32 i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "path_filestat_get" (func $path_filestat_get (param i32 i32 i32 i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "proc_exit" (func $proc_exit (param i32)))`)
+		out.WriteString("\n")
+
+		// User defined imports for WASI
+		for name, imported := range c.importedFuncs {
+			sig := c.importSignature(imported)
+			params := ""
+			for _, t := range sig.ParamTypes {
+				params += fmt.Sprintf(" (param %s)", wasmType(t))
+			}
+			result := ""
+			if sig.ReturnType != TypeVoid {
+				result = fmt.Sprintf(" (result %s)", wasmType(sig.ReturnType))
+			}
+			out.WriteString(fmt.Sprintf("  (import \"env\" \"%s\" (func $%s%s%s))\n", name, name, params, result))
+		}
+		
+		// Host interop for WASI (shared with browser)
+		out.WriteString("  (import \"env\" \"host_get_global\" (func $host_get_global (param i32) (result i32)))\n")
+		out.WriteString("  (import \"env\" \"host_get\" (func $host_get (param i32) (param i32) (result i32)))\n")
+		out.WriteString("  (import \"env\" \"host_set\" (func $host_set (param i32) (param i32) (param i32)))\n")
+		out.WriteString("  (import \"env\" \"host_call\" (func $host_call (param i32) (param i32) (param i32) (param i32) (result i32)))\n")
+		out.WriteString("  (import \"env\" \"host_from_int\" (func $host_from_int (param i32) (result i32)))\n")
+		out.WriteString("  (import \"env\" \"host_from_string\" (func $host_from_string (param i32) (result i32)))\n")
+		out.WriteString("  (import \"env\" \"host_to_int\" (func $host_to_int (param i32) (result i32)))\n")
+		out.WriteString("  (import \"env\" \"host_set_timeout\" (func $host_set_timeout (param i32 i32)))\n")
+		out.WriteString("  (import \"env\" \"host_await\" (func $host_await (param i32 i32)))\n")
+
+	} else {
+		// Browser imports
+		for name, imported := range c.importedFuncs {
+			sig := c.importSignature(imported)
+			params := ""
+			for _, t := range sig.ParamTypes {
+				params += fmt.Sprintf(" (param %s)", wasmType(t)) // number is f64, everything else i32
+			}
+			result := ""
+			if sig.ReturnType != TypeVoid {
+				result = fmt.Sprintf(" (result %s)", wasmType(sig.ReturnType))
+			}
+			out.WriteString(fmt.Sprintf("  (import \"env\" \"%s\" (func $%s%s%s))\n", name, name, params, result))
+		}
+		
+		// Host interop for browser
+		out.WriteString("  (import \"env\" \"host_get_global\" (func $host_get_global (param i32) (result i32)))\n")
+		out.WriteString("  (import \"env\" \"host_get\" (func $host_get (param i32) (param i32) (result i32)))\n")
+		out.WriteString("  (import \"env\" \"host_set\" (func $host_set (param i32) (param i32) (param i32)))\n")
+		out.WriteString("  (import \"env\" \"host_call\" (func $host_call (param i32) (param i32) (param i32) (param i32) (result i32)))\n")
+		out.WriteString("  (import \"env\" \"host_from_int\" (func $host_from_int (param i32) (result i32)))\n")
+		out.WriteString("  (import \"env\" \"host_from_string\" (func $host_from_string (param i32) (result i32)))\n")
+		out.WriteString("  (import \"env\" \"host_to_int\" (func $host_to_int (param i32) (result i32)))\n")
+		out.WriteString("  (import \"env\" \"host_set_timeout\" (func $host_set_timeout (param i32 i32)))\n")
+		out.WriteString("  (import \"env\" \"host_await\" (func $host_await (param i32 i32)))\n")
+		out.WriteString("  (import \"env\" \"thread_spawn\" (func $thread_spawn (param i32 i32) (result i32)))\n")
+	}
+
+	out.WriteString("  (import \"env\" \"memory\" (memory 1 1000 shared))\n")
+	out.WriteString("  (export \"memory\" (memory 0))\n")
+	out.WriteString("  (tag $exception (param i32))\n")
+	if c.target == "wasi" {
+		// Reactor model: export _initialize, do NOT export _start
+		out.WriteString("  (export \"_initialize\" (func $noop))\n")
+        out.WriteString("  (export \"_set_stack_pointer\" (func $set_stack_pointer))\n")
+	} else {
+		out.WriteString("  (export \"gc\" (func $gc_collect))\n")
+	}
+
+	// $typeof_name / $union_to_string intern their strings, so they are generated before the data segments
+	var nullRuntime bytes.Buffer
+	c.emitNullRuntime(&nullRuntime)
+	c.emitClassRuntime(&nullRuntime)
+	c.emitIteratorRuntime(&nullRuntime)
+	c.emitRejectionRuntime(&nullRuntime)
+	if c.nextDataOffset > staticDataLimit {
+		return "", fmt.Errorf("static data needs %d bytes but must end before the heap pointer at %d: too many string constants or classes", c.nextDataOffset, staticDataLimit)
+	}
+
+	// null / undefined: "null" at 0, the undefined sentinel's TypeID header followed by "undefined"
+	out.WriteString("  (data (i32.const 0) \"null\\00\")\n")
+	out.WriteString(fmt.Sprintf("  (data (i32.const %d) \"\\%02x\\00\\00\\00undefined\\00\")\n", undefinedPtr-4, TypeID_Undefined))
+
+	// Emit data segments for strings
+	for str, offset := range c.stringPool {
+		out.WriteString(fmt.Sprintf("  (data (i32.const %d) \"%s\\00\")\n", offset, escapeWATString(str)))
+	}
+
+	// Emit Standard Library
+	out.WriteString(stdLibWAT)
+	out.WriteString(stdLibExtraWAT)
+	out.WriteString(stdLibFloatWAT)
+	out.WriteString(stdLibBigIntWAT)
+	out.WriteString(stdLibNullWAT)
+	out.WriteString(stdLibAsyncWAT)
+	out.WriteString(stdLibIteratorWAT)
+
+	// Static fields (subclasses share their parent's globals)
+	var statics []string
+	seen := make(map[string]bool)
+	for _, cls := range c.classes {
+		for _, field := range cls.StaticFields {
+			if seen[field.Global] {
+				continue
+			}
+			seen[field.Global] = true
+			t := wasmType(field.Type)
+			statics = append(statics, fmt.Sprintf("  (global %s (mut %s) (%s.const 0))\n", field.Global, t, t))
+		}
+	}
+	sort.Strings(statics)
+	for _, g := range statics {
+		out.WriteString(g)
+	}
+	out.Write(nullRuntime.Bytes())
+	if c.target == "wasi" {
+		out.WriteString(wasiEnvWAT)
+	}
+	out.WriteString("\n(func $noop)\n")
+	
+	if c.target == "wasi" {
+		out.WriteString(`
+(func $wasi_print (param $str i32)
+  (local $len i32)
+  (local $iov i32)
+  (local $written i32)
+  
+  local.get $str
+  call $strlen
+  local.set $len
+  
+  ;; Allocate 8 bytes for iovec [ptr, len]
+  i32.const 8
+  i32.const 0
+  call $malloc
+  local.set $iov
+  
+  ;; Store ptr
+  local.get $iov
+  local.get $str
+  i32.store
+  
+  ;; Store len
+  local.get $iov
+  i32.const 4
+  i32.add
+  local.get $len
+  i32.store
+  
+  ;; call fd_write(1, iov, 1, written_ptr)
+  i32.const 1 ;; stdout
+  local.get $iov
+  i32.const 1 ;; iovs_len
+  local.get $iov ;; reuse iov ptr for written_ptr (dirty hack but safe if we don't read it)
+  call $fd_write
+  drop
+  
+  ;; Print newline
+  i32.const 8
+  i32.const 0
+  call $malloc
+  local.set $iov
+  
+  ;; Store ptr to newline (we need a newline string constant, but let's just make one on heap)
+  i32.const 2
+  i32.const 0
+  call $malloc
+  local.set $str
+  local.get $str
+  i32.const 10 ;; \n
+  i32.store8
+  local.get $str
+  i32.const 1
+  i32.add
+  i32.const 0
+  i32.store8
+  
+  local.get $iov
+  local.get $str
+  i32.store
+  
+  local.get $iov
+  i32.const 4
+  i32.add
+  i32.const 1
+  i32.store
+  
+  i32.const 1
+  local.get $iov
+  i32.const 1
+  local.get $iov
+  call $fd_write
+  drop
+)
+
+(func $fs_writeFile (param $path i32) (param $content i32)
+  (local $path_len i32)
+  (local $fd_ptr i32)
+  (local $fd i32)
+  (local $content_len i32)
+  (local $iovs i32)
+  (local $nwritten i32)
+
+  ;; Calculate path len
+  local.get $path
+  call $strlen
+  local.set $path_len
+
+  ;; Allocate fd_ptr (4 bytes)
+  i32.const 4
+  i32.const 0
+  call $malloc
+  local.set $fd_ptr
+
+  ;; Call path_open
+  ;; dirfd=3 (preopened .)
+  i32.const 3 
+  ;; dirflags=0
+  i32.const 0
+  ;; path
+  local.get $path
+  ;; path_len
+  local.get $path_len
+  ;; oflags=9 (CREAT|TRUNC)
+  i32.const 9
+  ;; rights_base=64 (WRITE) - Need i64
+  i64.const 64
+  ;; rights_inheriting=0 - Need i64
+  i64.const 0
+  ;; fd_flags=0
+  i32.const 0
+  ;; fd_ptr
+  local.get $fd_ptr
+  call $path_open
+  drop ;; result errno
+
+  ;; Get fd
+  local.get $fd_ptr
+  i32.load
+  local.set $fd
+
+  ;; Calculate content len
+  local.get $content
+  call $strlen
+  local.set $content_len
+
+  ;; Allocate iovs (8 bytes)
+  i32.const 8
+  i32.const 0
+  call $malloc
+  local.set $iovs
+
+  ;; Fill iovs
+  local.get $iovs
+  local.get $content
+  i32.store
+  local.get $iovs
+  i32.const 4
+  i32.add
+  local.get $content_len
+  i32.store
+
+  ;; Allocate nwritten (4 bytes)
+  i32.const 4
+  i32.const 0
+  call $malloc
+  local.set $nwritten
+
+  ;; Call fd_write
+  local.get $fd
+  local.get $iovs
+  i32.const 1
+  local.get $nwritten
+  call $fd_write
+  drop
+
+  ;; Call fd_close
+  local.get $fd
+  call $fd_close
+  drop
+)
+
+(func $fs_readFile (param $path i32) (result i32)
+  (local $path_len i32)
+  (local $fd_ptr i32)
+  (local $fd i32)
+  (local $stat_ptr i32)
+  (local $size i32)
+  (local $str i32)
+  (local $iovs i32)
+  (local $nread i32)
+
+  ;; Calculate path len
+  local.get $path
+  call $strlen
+  local.set $path_len
+
+  ;; Allocate fd_ptr
+  i32.const 4
+  i32.const 0
+  call $malloc
+  local.set $fd_ptr
+
+  ;; path_open
+  i32.const 3 ;; dirfd=.
+  i32.const 0 ;; dirflags
+  local.get $path
+  local.get $path_len
+  i32.const 0 ;; oflags
+  i64.const 2097154 ;; rights_base=READ(2)|FD_FILESTAT_GET(2097152)
+  i64.const 0 ;; rights_inheriting
+  i32.const 0 ;; fd_flags
+  local.get $fd_ptr
+  call $path_open
+  drop ;; result
+
+  ;; Get fd
+  local.get $fd_ptr
+  i32.load
+  local.set $fd
+
+  ;; Get file stats (size)
+  i32.const 64 ;; size of filestat
+  i32.const 0
+  call $malloc
+  local.set $stat_ptr
+
+  local.get $fd
+  local.get $stat_ptr
+  call $fd_filestat_get
+  drop
+
+  ;; Read size (offset 32, u64)
+  local.get $stat_ptr
+  i32.const 32
+  i32.add
+  i64.load
+  i32.wrap_i64 ;; Assume file size < 4GB
+  local.set $size
+
+  ;; Allocate string buffer (size + 1 for null terminator)
+  local.get $size
+  i32.const 1
+  i32.add
+  i32.const 0 ;; TypeID 0 (String)
+  call $malloc
+  local.set $str
+
+  ;; Allocate iovs
+  i32.const 8
+  i32.const 0
+  call $malloc
+  local.set $iovs
+
+  ;; Fill iovs
+  local.get $iovs
+  local.get $str
+  i32.store
+  local.get $iovs
+  i32.const 4
+  i32.add
+  local.get $size
+  i32.store
+
+  ;; Allocate nread
+  i32.const 4
+  i32.const 0
+  call $malloc
+  local.set $nread
+
+  ;; fd_read
+  local.get $fd
+  local.get $iovs
+  i32.const 1
+  local.get $nread
+  call $fd_read
+  drop
+
+  ;; Null terminate
+  local.get $str
+  local.get $size
+  i32.add
+  i32.const 0
+  i32.store8
+
+  ;; Close
+  local.get $fd
+  call $fd_close
+  drop
+
+  local.get $str
+)
+
+(func $fs_unlink (param $path i32) (result i32)
+  (local $path_len i32)
+  local.get $path
+  call $strlen
+  local.set $path_len
+  
+  i32.const 3 ;; dirfd
+  local.get $path
+  local.get $path_len
+  call $path_unlink_file
+)
+
+(func $fs_mkdir (param $path i32) (result i32)
+  (local $path_len i32)
+  local.get $path
+  call $strlen
+  local.set $path_len
+  
+  i32.const 3 ;; dirfd
+  local.get $path
+  local.get $path_len
+  call $path_create_directory
+)
+
+(func $fs_rmdir (param $path i32) (result i32)
+  (local $path_len i32)
+  local.get $path
+  call $strlen
+  local.set $path_len
+  
+  i32.const 3 ;; dirfd
+  local.get $path
+  local.get $path_len
+  call $path_remove_directory
+)
+
+(func $fs_existsSync (param $path i32) (result i32)
+  (local $path_len i32)
+  (local $stat_buf i32)
+  (local $res i32)
+  
+  local.get $path
+  call $strlen
+  local.set $path_len
+  
+  ;; Allocate stat buffer (64 bytes)
+  i32.const 64
+  i32.const 0
+  call $malloc
+  local.set $stat_buf
+  
+  i32.const 3 ;; dirfd
+  i32.const 0 ;; flags (follow symlinks)
+  local.get $path
+  local.get $path_len
+  local.get $stat_buf
+  call $path_filestat_get
+  local.set $res
+  
+  ;; 0 is success
+  local.get $res
+  i32.eqz
+)
+
+(func $std_args (result i32)
+  (local $argc i32)
+  (local $argv_buf_size i32)
+  (local $argv_ptr i32)
+  (local $argv_buf_ptr i32)
+  (local $arr i32)
+  (local $i i32)
+  (local $arg_ptr i32)
+  (local $arg_len i32)
+  (local $str i32)
+  (local $argc_ptr i32)
+  (local $argv_buf_size_ptr i32)
+  (local $dest i32)
+  (local $src i32)
+  (local $cnt i32)
+
+  ;; Allocate space for argc and argv_buf_size
+  i32.const 4
+  i32.const 0
+  call $malloc
+  local.set $argc_ptr
+  
+  i32.const 4
+  i32.const 0
+  call $malloc
+  local.set $argv_buf_size_ptr
+
+  ;; Call args_sizes_get
+  local.get $argc_ptr
+  local.get $argv_buf_size_ptr
+  call $args_sizes_get
+  drop
+
+  ;; Load argc and buf size
+  local.get $argc_ptr
+  i32.load
+  local.set $argc
+  
+  local.get $argv_buf_size_ptr
+  i32.load
+  local.set $argv_buf_size
+
+  ;; Allocate argv (array of pointers)
+  local.get $argc
+  i32.const 4
+  i32.mul
+  i32.const 0
+  call $malloc
+  local.set $argv_ptr
+
+  ;; Allocate argv_buf (char buffer)
+  local.get $argv_buf_size
+  i32.const 0
+  call $malloc
+  local.set $argv_buf_ptr
+
+  ;; Call args_get
+  local.get $argv_ptr
+  local.get $argv_buf_ptr
+  call $args_get
+  drop
+
+  ;; Create Array to return
+  local.get $argc
+  call $array_new
+  local.set $arr
+
+  ;; Loop through argv and push to array
+  i32.const 0
+  local.set $i
+  
+  (block $done
+    (loop $loop
+      local.get $i
+      local.get $argc
+      i32.ge_u
+      br_if $done
+
+      ;; Get pointer to current arg string
+      local.get $argv_ptr
+      local.get $i
+      i32.const 4
+      i32.mul
+      i32.add
+      i32.load
+      local.set $arg_ptr
 
-				for _, arg := range node.Arguments {
-					if err := c.Compile(arg); err != nil { return err }
-				}
-				c.emit(fmt.Sprintf("call $%s", resolvedName))
-				c.stackType = TypeInt // MVP assumption
-				return nil
-			}
-			
-			// 4. Implicit Global Host Call
-			// If not local, not imported, not defined -> Host Call
-			
-			if c.target == "wasi" {
-				if funcName == "print" {
-					// Handle print via WASI
-					arg := node.Arguments[0]
-					if err := c.Compile(arg); err != nil { return err }
-					
-					c.emit("call $wasi_print")
-					c.stackType = TypeVoid
-					return nil
-				}
-				if funcName == "int_to_string" {
-					if len(node.Arguments) != 1 {
-						return fmt.Errorf("int_to_string expects 1 argument")
-					}
-					if err := c.Compile(node.Arguments[0]); err != nil { return err }
-					c.emit("call $itos")
-					c.stackType = TypeString
-					return nil
-				}
-				return fmt.Errorf("unknown function or global in WASI mode: %s", funcName)
-			}
+      ;; We need to copy this to a managed string because argv_buf is a single block
+      ;; and we want distinct string objects (TypeID 0).
+      ;; Calculate length: iterate until null terminator
+      local.get $arg_ptr
+      call $strlen
+      local.set $arg_len
 
-			// Get Global Handle
-			offset, ok := c.stringPool[funcName]
-			if !ok {
-				offset = c.nextDataOffset
-				c.stringPool[funcName] = offset
-				c.nextDataOffset += len(funcName) + 1
-			}
-			c.emit(fmt.Sprintf("i32.const %d ;; \"%s\"", offset, funcName))
-			c.emit("call $host_get_global")
-			
-			handleTemp := c.current.NextLocalID
-			c.current.NextLocalID++
-			realHandleTemp := handleTemp + c.current.ParamCount
-			c.emit(fmt.Sprintf("local.set %d", realHandleTemp))
-			
-			argCount := len(node.Arguments)
-			argsTemp := c.current.NextLocalID
-			c.current.NextLocalID++
-			realArgsTemp := argsTemp + c.current.ParamCount
-			
-			if argCount > 0 {
-				c.emit(fmt.Sprintf("i32.const %d", argCount*4))
-				c.emit("i32.const 20")
-				c.emit("call $malloc")
-				c.emit(fmt.Sprintf("local.set %d", realArgsTemp))
-				
-				for i, arg := range node.Arguments {
-					c.emit(fmt.Sprintf("local.get %d", realArgsTemp))
-					c.emit(fmt.Sprintf("i32.const %d", i*4))
-					c.emit("i32.add")
-					if err := c.Compile(arg); err != nil { return err }
-					argType := c.stackType
-					if argType == TypeString {
-						c.emit("call $host_from_string")
-					} else if argType == TypeInt || argType == TypeBool {
-						c.emit("call $host_from_int")
-					}
-					c.emit("i32.store")
-				}
-			} else {
-				c.emit("i32.const 0")
-				c.emit(fmt.Sprintf("local.set %d", realArgsTemp))
-			}
-			
-			c.emit(fmt.Sprintf("local.get %d", realHandleTemp))
-			c.emit("i32.const 0")
-			c.emit(fmt.Sprintf("local.get %d", realArgsTemp))
-			c.emit(fmt.Sprintf("i32.const %d", argCount))
-			c.emit("call $host_call")
-			c.stackType = TypeHost
-			return nil
-		} else {
-			// Compile arguments for generic call (if we fall here)
-			for _, arg := range node.Arguments {
-				if err := c.Compile(arg); err != nil { return err }
-			}
-			return fmt.Errorf("complex function calls not supported yet")
-		}
+      ;; Allocate new string
+      local.get $arg_len
+      i32.const 1
+      i32.add
+      i32.const 0 ;; TypeID 0
+      call $malloc
+      local.set $str
 
-	case *ast.IntegerLiteral:
-		c.emit(fmt.Sprintf("i32.const %d", node.Value))
-		c.stackType = TypeInt
+      ;; Copy chars
+      ;; Inline memcpy
+      local.get $arg_len
+      i32.const 1
+      i32.add ;; copy null terminator too
+      local.set $cnt
+      
+      local.get $arg_ptr
+      local.set $src
+      
+      local.get $str
+      local.set $dest
+      
+      (block $cp_done
+        (loop $cp_loop
+           local.get $cnt
+           i32.eqz
+           br_if $cp_done
+           
+           local.get $dest
+           local.get $src
+           i32.load8_u
+           i32.store8
+           
+           local.get $dest
+           i32.const 1
+           i32.add
+           local.set $dest
+           
+           local.get $src
+           i32.const 1
+           i32.add
+           local.set $src
+           
+           local.get $cnt
+           i32.const 1
+           i32.sub
+           local.set $cnt
+           br $cp_loop
+        )
+      )
 
-	case *ast.StringLiteral:
-		offset, ok := c.stringPool[node.Value]
-		if !ok {
-			offset = c.nextDataOffset
-			c.stringPool[node.Value] = offset
-			c.nextDataOffset += len(node.Value) + 1
+      ;; Push to array
+      local.get $arr
+      local.get $str
+      call $array_push
+
+      local.get $i
+      i32.const 1
+      i32.add
+      local.set $i
+      br $loop
+    )
+  )
+
+  local.get $arr
+)
+`)
+	}
+
+	// Closure call signatures ($closure_i32_r_i32 runs event loop tasks)
+	c.closureType(FunctionSignature{ReturnType: TypeVoid})
+	closureTypeNames := make([]string, 0, len(c.closureTypes))
+	for name := range c.closureTypes {
+		closureTypeNames = append(closureTypeNames, name)
+	}
+	sort.Strings(closureTypeNames)
+	for _, name := range closureTypeNames {
+		out.WriteString(fmt.Sprintf("(type %s %s)\n", name, c.closureTypes[name]))
+	}
+
+	// --- Task Scheduler: Generate Function Table ---
+	// Emit Table (shared by scheduler wrappers and closures)
+	out.WriteString(fmt.Sprintf("(table %d funcref)\n", c.nextFuncID))
+
+	// Dummy task function for empty slots
+	out.WriteString("(func $scheduler_dummy_task (param i32))\n")
+
+	// Emit Elements
+	// Use string builder for elements to avoid many writes
+	var elemBuilder strings.Builder
+	elemBuilder.WriteString("(elem (i32.const 1)")
+	
+	// Create reverse map for efficient lookup
+	idToName := make(map[int]string)
+	for name, id := range c.funcIDs {
+		idToName[id] = name
+	}
+	
+	for i := 1; i < c.nextFuncID; i++ {
+		if name, ok := idToName[i]; ok {
+			 elemBuilder.WriteString(" $wrapper_" + name)
+		} else if name, ok := c.closureIDs[i]; ok {
+			 elemBuilder.WriteString(" $" + name)
+		} else {
+			 elemBuilder.WriteString(" $scheduler_dummy_task")
 		}
-		c.emit(fmt.Sprintf("i32.const %d ;; pointer to \"%s\"", offset, node.Value))
-		c.stackType = TypeString
+	}
+	elemBuilder.WriteString(")\n")
+	out.WriteString(elemBuilder.String())
+	out.WriteString(itables)
+	
+	// Dispatcher Function
+	out.WriteString(`(func $dispatch_task (param $id i32) (param $args i32)
+	  ;; Debug: Print dispatched ID
+	  ;; local.get $id
+	  ;; call $print_int
 
-	case *ast.ArrayLiteral:
-		length := len(node.Elements)
-		// Use dynamic array: $array_new(capacity)
-		// For literal, use length as initial capacity
-		
-		c.emit(fmt.Sprintf("i32.const %d", length))
-		c.emit("call $array_new")
-		
-		// Use a temporary local to store the array pointer so we can push elements
-		tempName := fmt.Sprintf("$arr_temp_%d", c.current.NextLocalID)
-		tempIndex := c.current.NextLocalID
-		// Note: TypeArray is used for tracking, but shadow stack treats everything as pointer (int)
-		c.current.Symbols[tempName] = Symbol{Index: tempIndex, Type: TypeArray, IsParam: false, ShadowIndex: c.current.ShadowStackSize}
-		c.current.NextLocalID++
-		c.current.ShadowStackSize++
+	  local.get $id
+	  i32.const 0
+	  i32.le_s
+	  if
+		return
+	  end
+	  
+	  local.get $args
+	  local.get $id
+	  call_indirect (type $task_sig)
+	)
+	`)
 
-		c.emit(fmt.Sprintf("local.set %d", tempIndex+c.current.ParamCount))
-		
-		// Push to shadow stack (it's a root!)
-		c.emit("global.get $shadow_stack_ptr")
-		c.emit(fmt.Sprintf("local.get %d", tempIndex+c.current.ParamCount))
-		c.emit("i32.store")
-		c.emit("global.get $shadow_stack_ptr")
-		c.emit("i32.const 4")
-		c.emit("i32.add")
-		c.emit("global.set $shadow_stack_ptr")
+	// Emit GC Trace Function
+	c.emitGCTrace(&out)
 
-		// Push elements
-		for _, el := range node.Elements {
-			// Prepare array ptr
-			c.emit(fmt.Sprintf("local.get %d", tempIndex+c.current.ParamCount))
-			
-			// Compile value
-			if err := c.Compile(el); err != nil {
-				return err
+	// Emit Wrapper Functions for Task Scheduler
+	for _, fn := range c.functions {
+		// Wrapper name: $wrapper_<Name>
+		out.WriteString(fmt.Sprintf("  (func $wrapper_%s (param $args i32)\n", fn.Name))
+		
+		// Unpack args
+		for i := 0; i < fn.ParamCount; i++ {
+			// local.get $args
+			// i32.const <index>
+			// call $array_get
+			out.WriteString("    local.get $args\n")
+			out.WriteString(fmt.Sprintf("    i32.const %d\n", i))
+			out.WriteString("    call $array_get\n")
+			if fn.ParamTypes[i] == TypeFloat {
+				out.WriteString("    call $unbox_f64\n")
+			} else if fn.ParamTypes[i] == TypeBigInt {
+				out.WriteString("    i64.extend_i32_s ;; bigint arguments cannot be spawned\n")
 			}
-			
-			// Call $array_push
-			c.emit("call $array_push")
 		}
-
-		// Return array pointer
-		c.emit(fmt.Sprintf("local.get %d", tempIndex+c.current.ParamCount))
-		c.stackType = TypeArray
-
-	case *ast.MapLiteral:
-		c.emit("call $map_new")
 		
-		// Use a temporary local to store the map pointer
-		tempName := fmt.Sprintf("$map_temp_%d", c.current.NextLocalID)
-		tempIndex := c.current.NextLocalID
-		c.current.Symbols[tempName] = Symbol{Index: tempIndex, Type: TypeMap, IsParam: false, ShadowIndex: c.current.ShadowStackSize}
-		c.current.NextLocalID++
-		c.current.ShadowStackSize++
-
-		c.emit(fmt.Sprintf("local.set %d", tempIndex+c.current.ParamCount))
+		// Call original function
+		out.WriteString(fmt.Sprintf("    call $%s\n", fn.Name))
 		
-		// Push to shadow stack
-		c.emit("global.get $shadow_stack_ptr")
-		c.emit(fmt.Sprintf("local.get %d", tempIndex+c.current.ParamCount))
-		c.emit("i32.store")
-		c.emit("global.get $shadow_stack_ptr")
-		c.emit("i32.const 4")
-		c.emit("i32.add")
-		c.emit("global.set $shadow_stack_ptr")
+		// Drop result if needed
+		// All functions return i32 in our current ABI (except void?)
+		// Wait, we defined FunctionSignature with ReturnType.
+		// If fn.ReturnType is TypeVoid, it returns nothing?
+		// BUT, in `c.functions` loop below, we emit `(result i32)` for ALL functions!
+		// Line 5250: `paramsStr += " (param i32)"`
+		// Line 5254: `out.WriteString(fmt.Sprintf("  (func $%s %s%s (result i32)\n", fn.Name, exportName, paramsStr))`
+		// So YES, all functions return i32.
+		// So we MUST drop.
 		
-		// Set elements
-		for key, val := range node.Pairs {
-			// Prepare map ptr
-			c.emit(fmt.Sprintf("local.get %d", tempIndex+c.current.ParamCount))
-			
-			// Compile Key
-			if err := c.Compile(key); err != nil {
-				return err
-			}
-			if c.stackType != TypeString {
-				return fmt.Errorf("map keys must be strings")
-			}
-			
-			// Compile Value
-			if err := c.Compile(val); err != nil {
-				return err
-			}
-			
-			// Call $map_set
-			c.emit("call $map_set")
-		}
+		out.WriteString("    drop\n")
 		
-		// Return map pointer
-		c.emit(fmt.Sprintf("local.get %d", tempIndex+c.current.ParamCount))
-		c.stackType = TypeMap
+		out.WriteString("  )\n")
+	}
 
-	case *ast.IndexExpression:
-		if err := c.Compile(node.Left); err != nil {
-			return err
-		}
-		
-		targetType := c.stackType
+	for _, fn := range c.functions {
+		// Export all functions for now (needed for spawn)
+		exportName := fmt.Sprintf("(export \"%s\")", fn.Name)
 		
-		if err := c.Compile(node.Index); err != nil {
-			return err
+		if fn.Name == "main" && c.target == "wasi" {
+			// For WASI, main is exported as _start via the export section above.
+			// We also export it as "main" via exportName above.
+			
+			// Inject call to start_workers (Auto-Parallelism)
+			// Start 4 workers
+			fn.Instructions = append([]string{"i32.const 4", "call $start_workers"}, fn.Instructions...)
 		}
-		indexType := c.stackType
 
-		if targetType == TypeArray {
-			c.emit("call $array_get")
-			c.stackType = TypeInt
-		} else if targetType == TypeMap || indexType == TypeString {
-			// If target is map OR index is string (duck typing for MVP)
-			c.emit("call $map_get")
-			c.stackType = TypeInt // Values are untyped int/ptr
-		} else {
-			// Fallback to array get if we don't know type (assume int index)
-			c.emit("call $array_get")
-			c.stackType = TypeInt
+		paramsStr := ""
+		for i := 0; i < fn.ParamCount; i++ {
+			paramsStr += fmt.Sprintf(" (param %s)", wasmType(fn.ParamTypes[i]))
 		}
 
-	case *ast.Boolean:
-		if node.Value {
-			c.emit("i32.const 1")
-		} else {
-			c.emit("i32.const 0")
-		}
-		c.stackType = TypeBool
+		out.WriteString(fmt.Sprintf("  (func $%s %s%s (result %s)\n", fn.Name, exportName, paramsStr, wasmType(fn.ReturnType)))
 
-	case *ast.IfExpression:
-		if err := c.Compile(node.Condition); err != nil {
-			return err
+		for i := 0; i < fn.NextLocalID; i++ {
+			out.WriteString(fmt.Sprintf("    (local %s)\n", wasmType(fn.LocalTypes[i])))
+		}
+		
+		for _, ins := range fn.Instructions {
+			out.WriteString("    " + ins + "\n")
 		}
 
-		c.emit("if (result i32)")
+		out.WriteString("  )\n")
+	}
 
-		if err := c.Compile(node.Consequence); err != nil {
-			return err
+	out.WriteString(")\n")
+	return asciiWATIdentifiers(out.String()), nil
+}
+
+// asciiWATIdentifiers 把 $标识符 中的非 ASCII 字节改写为 _uXX (WAT 标识符只允许 ASCII)
+// 字符串字面量已由 escapeWATString 转义为 ASCII，不受影响
+func asciiWATIdentifiers(wat string) string {
+	if !strings.ContainsFunc(wat, func(r rune) bool { return r >= 0x80 }) {
+		return wat
+	}
+	var out strings.Builder
+	inIdent := false
+	for i := 0; i < len(wat); i++ {
+		b := wat[i]
+		switch {
+		case b == '$':
+			inIdent = true
+		case b == ' ' || b == '\t' || b == '\n' || b == '(' || b == ')' || b == '"' || b == ';':
+			inIdent = false
 		}
-		// MVP: If blocks don't return values, push dummy 0
-		c.emit("i32.const 0")
+		if inIdent && b >= 0x80 {
+			fmt.Fprintf(&out, "_u%02x", b)
+			continue
+		}
+		out.WriteByte(b)
+	}
+	return out.String()
+}
 
-		c.emit("else")
-		if node.Alternative != nil {
-			if err := c.Compile(node.Alternative); err != nil {
-				return err
-			}
-			c.emit("i32.const 0")
+// escapeWATString 把任意字节序列转义为 WAT 字符串字面量的内容
+// 可打印 ASCII 原样输出，其余字节 (包括 UTF-8 多字节序列) 输出为 \hh，保证逐字节还原
+func escapeWATString(s string) string {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		if b >= 0x20 && b < 0x7f && b != '"' && b != '\\' {
+			out.WriteByte(b)
 		} else {
-			// Dummy return for else branch to satisfy type checker
-			c.emit("i32.const 0")
+			fmt.Fprintf(&out, "\\%02x", b)
 		}
+	}
+	return out.String()
+}
 
-		c.emit("end")
-		c.stackType = TypeInt
+// internString 返回字符串常量在数据段中的地址
+func (c *Compiler) internString(str string) int {
+	offset, ok := c.stringPool[str]
+	if !ok {
+		offset = c.nextDataOffset
+		c.stringPool[str] = offset
+		c.nextDataOffset += len(str) + 1
+	}
+	return offset
+}
 
-	case *ast.FunctionLiteral:
-		// Should be handled by compileFunction called from Program
-		return nil
+// emitNullRuntime 生成依赖字符串常量的运行时函数
+// $typeof_name: 联合类型值的 typeof 结果；$union_to_string: 联合类型值转字符串 (console.log / 模板)
+func (c *Compiler) emitNullRuntime(out *bytes.Buffer) {
+	typeNames := []struct {
+		typeID int
+		name   string
+	}{
+		{TypeID_Int, "number"},
+		{TypeID_Float, "number"},
+		{TypeID_String, "string"},
+		{TypeID_Bool, "boolean"},
+		{TypeID_Closure, "function"},
+		{TypeID_Undefined, "undefined"},
+	}
+	out.WriteString("\n(func $typeof_name (param $val i32) (result i32)\n")
+	out.WriteString("  (local $type_id i32)\n")
+	out.WriteString("  local.get $val\n")
+	out.WriteString("  call $typeof\n")
+	out.WriteString("  local.set $type_id\n")
+	for _, tn := range typeNames {
+		out.WriteString("  local.get $type_id\n")
+		out.WriteString(fmt.Sprintf("  i32.const %d\n", tn.typeID))
+		out.WriteString("  i32.eq\n")
+		out.WriteString("  if\n")
+		out.WriteString(fmt.Sprintf("    i32.const %d ;; %q\n", c.internString(tn.name), tn.name))
+		out.WriteString("    return\n")
+		out.WriteString("  end\n")
+	}
+	out.WriteString(fmt.Sprintf("  i32.const %d ;; \"object\"\n", c.internString("object")))
+	out.WriteString(")\n")
 
-	case *ast.BlockStatement:
-		for _, stmt := range node.Statements {
-			if err := c.Compile(stmt); err != nil {
-				return err
-			}
-		}
+	out.WriteString("\n(func $union_to_string (param $val i32) (result i32)\n")
+	out.WriteString("  (local $type_id i32)\n")
+	out.WriteString("  ;; null and undefined read as \"null\" / \"undefined\"\n")
+	out.WriteString("  local.get $val\n")
+	out.WriteString("  call $is_nullish\n")
+	out.WriteString("  if\n")
+	out.WriteString("    local.get $val\n")
+	out.WriteString("    return\n")
+	out.WriteString("  end\n")
+	out.WriteString("  local.get $val\n")
+	out.WriteString("  call $get_type_id\n")
+	out.WriteString("  local.set $type_id\n")
+	valueCases := []struct {
+		typeID int
+		code   string
+	}{
+		{TypeID_Int, "    local.get $val\n    i32.load\n    call $itos\n"},
+		{TypeID_Bool, "    local.get $val\n    i32.load\n    call $itos\n"},
+		{TypeID_String, "    local.get $val\n    i32.load\n"},
+		{TypeID_Float, "    local.get $val\n    f64.load\n    call $ftos\n"},
+		{TypeID_Closure, fmt.Sprintf("    i32.const %d ;; \"function\"\n", c.internString("function"))},
+	}
+	for _, vc := range valueCases {
+		out.WriteString("  local.get $type_id\n")
+		out.WriteString(fmt.Sprintf("  i32.const %d\n", vc.typeID))
+		out.WriteString("  i32.eq\n")
+		out.WriteString("  if\n")
+		out.WriteString(vc.code)
+		out.WriteString("    return\n")
+		out.WriteString("  end\n")
+	}
+	out.WriteString(fmt.Sprintf("  i32.const %d ;; \"[object]\"\n", c.internString("[object]")))
+	out.WriteString(")\n")
+}
 
-	case *ast.ReturnStatement:
-		if err := c.Compile(node.ReturnValue); err != nil {
-			return err
+// emitIteratorRuntime 生成 Iterator<T> 值的 $iterator_next / $iterator_return：
+// 实现了 next() 的类按 TypeID 直接调用自己的方法，其他值是生成器，交给 $generator_next / $generator_return
+// 没有 return() 的类关闭时什么也不做，返回 done 为 true 的结果
+func (c *Compiler) emitIteratorRuntime(out *bytes.Buffer) {
+	classes := make([]ClassSymbol, 0, len(c.classes))
+	for _, cls := range c.classes {
+		if sig, ok := cls.MethodSigs["next"]; ok && !cls.Abstract && isIteratorMethod(sig) {
+			classes = append(classes, cls)
 		}
-		c.emit("return")
-
-	case *ast.WhileStatement:
-		c.emit("block $break")
-		c.emit("loop $continue")
-
-		// Compile condition
-		if err := c.Compile(node.Condition); err != nil {
-			return err
+	}
+	sort.Slice(classes, func(i, j int) bool { return classes[i].TypeID < classes[j].TypeID })
+
+	for _, method := range []string{"next", "return"} {
+		out.WriteString(fmt.Sprintf("\n(func $iterator_%s (param $it i32) (result i32)\n", method))
+		if len(classes) > 0 {
+			out.WriteString("  (local $type_id i32)\n")
+			out.WriteString("  local.get $it\n")
+			out.WriteString("  call $get_type_id\n")
+			out.WriteString("  local.set $type_id\n")
 		}
-
-		// Check condition: if false (0), break
-		c.emit("i32.eqz")
-		c.emit("br_if $break")
-
-		// Compile body
-		if err := c.Compile(node.Body); err != nil {
-			return err
+		for _, cls := range classes {
+			out.WriteString("  local.get $type_id\n")
+			out.WriteString(fmt.Sprintf("  i32.const %d ;; %s\n", cls.TypeID, cls.Name))
+			out.WriteString("  i32.eq\n")
+			out.WriteString("  if\n")
+			if sig, ok := cls.MethodSigs[method]; ok && isIteratorMethod(sig) {
+				out.WriteString("    local.get $it\n")
+				out.WriteString(fmt.Sprintf("    call $%s\n", cls.Methods[method]))
+			} else {
+				out.WriteString("    i32.const 1 ;; done\n")
+				out.WriteString(fmt.Sprintf("    i32.const %d ;; TypeID_IterResult\n", TypeID_IterResult))
+				out.WriteString("    call $iter_result_new\n")
+			}
+			out.WriteString("    return\n")
+			out.WriteString("  end\n")
 		}
+		out.WriteString("  local.get $it\n")
+		out.WriteString(fmt.Sprintf("  call $generator_%s\n", method))
+		out.WriteString(")\n")
+	}
+}
 
-		// Jump back to start of loop
-		c.emit("br $continue")
+// isIteratorMethod 判断方法能否作为 Iterator<T> 的 next() / return() 调用：没有参数，返回 IteratorResult<T>
+func isIteratorMethod(sig FunctionSignature) bool {
+	_, ok := iteratorResultValueType(sig.ReturnTypeName)
+	return len(sig.ParamTypes) == 0 && ok
+}
 
-		c.emit("end") // end of loop
-		c.emit("end") // end of block
+// emitRejectionRuntime 生成导出的 $unhandled_rejection：宿主在任务队列清空后调用它，
+// 有未处理的拒绝 (没有被 await 的已拒绝 Promise) 时返回要报告的消息，否则返回 0
+func (c *Compiler) emitRejectionRuntime(out *bytes.Buffer) {
+	out.WriteString("\n(func $unhandled_rejection (result i32)\n")
+	out.WriteString("  (local $p i32)\n")
+	out.WriteString("  (local $reason i32)\n")
+	out.WriteString("  call $take_unhandled_rejection\n")
+	out.WriteString("  local.tee $p\n")
+	out.WriteString("  i32.eqz\n")
+	out.WriteString("  if\n")
+	out.WriteString("    i32.const 0\n")
+	out.WriteString("    return\n")
+	out.WriteString("  end\n")
+	out.WriteString("  local.get $p\n")
+	out.WriteString("  i32.load offset=8 ;; reason\n")
+	out.WriteString("  local.set $reason\n")
+	out.WriteString(fmt.Sprintf("  i32.const %d ;; \"Uncaught (in promise) \"\n", c.internString("Uncaught (in promise) ")))
+	out.WriteString("  ;; Class instances are reported by name, tagged values by their content\n")
+	out.WriteString("  local.get $reason\n")
+	out.WriteString("  if (result i32)\n")
+	out.WriteString("    local.get $reason\n")
+	out.WriteString("    call $get_type_id\n")
+	out.WriteString(fmt.Sprintf("    i32.const %d ;; first class TypeID\n", firstClassTypeID))
+	out.WriteString("    i32.ge_u\n")
+	out.WriteString("  else\n")
+	out.WriteString("    i32.const 0\n")
+	out.WriteString("  end\n")
+	out.WriteString("  if (result i32)\n")
+	out.WriteString("    local.get $reason\n")
+	out.WriteString("    call $class_name\n")
+	out.WriteString("  else\n")
+	out.WriteString("    local.get $reason\n")
+	out.WriteString("    call $union_to_string\n")
+	out.WriteString("  end\n")
+	out.WriteString("  call $str_concat\n")
+	out.WriteString(")\n")
+	out.WriteString("(export \"unhandled_rejection\" (func $unhandled_rejection))\n")
+}
 
-	case *ast.ForStatement:
-		// Init
-		if node.Init != nil {
-			if err := c.Compile(node.Init); err != nil {
-				return err
+// emitClassRuntime 生成运行时类型信息：父类链表 (数据段中按 TypeID - firstClassTypeID 索引的父类 TypeID，0 表示没有父类)，
+// 以及沿着它比较 TypeID 的 $instance_of 和返回类名的 $class_name
+func (c *Compiler) emitClassRuntime(out *bytes.Buffer) {
+	classes := make([]ClassSymbol, 0, len(c.classes))
+	for _, cls := range c.classes {
+		classes = append(classes, cls)
+	}
+	sort.Slice(classes, func(i, j int) bool { return classes[i].TypeID < classes[j].TypeID })
+
+	// Only class TypeIDs have entries; a program without classes needs no table
+	entries := 0
+	if len(classes) > 0 {
+		entries = classes[len(classes)-1].TypeID + 1 - firstClassTypeID
+	}
+	table := (c.nextDataOffset + 3) &^ 3
+	if entries > 0 {
+		c.nextDataOffset = table + 4*entries
+		parents := make([]byte, 4*entries)
+		for _, cls := range classes {
+			if parent, ok := c.classes[cls.Parent]; ok {
+				binary.LittleEndian.PutUint32(parents[4*(cls.TypeID-firstClassTypeID):], uint32(parent.TypeID))
 			}
 		}
+		out.WriteString(fmt.Sprintf("  (data (i32.const %d) \"%s\") ;; parent TypeID by class TypeID\n", table, escapeWATString(string(parents))))
+	}
 
-		c.emit("block $break")
-		c.emit("loop $top")
+	// Values are class instances or tagged (union values, caught exceptions); only null has no header
+	guard := "  local.get $obj\n  i32.eqz\n  if\n    %s\n    return\n  end\n  local.get $obj\n  call $get_type_id\n  local.set $type_id\n"
+
+	out.WriteString("\n(func $instance_of (param $obj i32) (param $class i32) (result i32)\n")
+	out.WriteString("  (local $type_id i32)\n")
+	out.WriteString(fmt.Sprintf(guard, "i32.const 0"))
+	out.WriteString("  (block $done\n")
+	out.WriteString("    (loop $walk\n")
+	out.WriteString("      local.get $type_id\n")
+	out.WriteString("      local.get $class\n")
+	out.WriteString("      i32.eq\n")
+	out.WriteString("      if\n")
+	out.WriteString("        i32.const 1\n")
+	out.WriteString("        return\n")
+	out.WriteString("      end\n")
+	out.WriteString("      ;; Built-in TypeIDs wrap around to large indexes and have no parent\n")
+	out.WriteString("      local.get $type_id\n")
+	out.WriteString(fmt.Sprintf("      i32.const %d\n", firstClassTypeID))
+	out.WriteString("      i32.sub\n")
+	out.WriteString("      local.tee $type_id\n")
+	out.WriteString(fmt.Sprintf("      i32.const %d\n", entries))
+	out.WriteString("      i32.ge_u\n")
+	out.WriteString("      br_if $done\n")
+	out.WriteString("      local.get $type_id\n")
+	out.WriteString("      i32.const 4\n")
+	out.WriteString("      i32.mul\n")
+	out.WriteString(fmt.Sprintf("      i32.load offset=%d ;; parent\n", table))
+	out.WriteString("      local.tee $type_id\n")
+	out.WriteString("      br_if $walk\n")
+	out.WriteString("    )\n")
+	out.WriteString("  )\n")
+	out.WriteString("  i32.const 0\n")
+	out.WriteString(")\n")
 
-		// Condition
-		if node.Condition != nil {
-			if err := c.Compile(node.Condition); err != nil {
-				return err
-			}
-			c.emit("i32.eqz")
-			c.emit("br_if $break")
+	// Boxed values name the type of their content (a caught `throw 42` is a Number)
+	object := c.internString("Object")
+	out.WriteString("\n(func $class_name (param $obj i32) (result i32)\n")
+	out.WriteString("  (local $type_id i32)\n")
+	out.WriteString(fmt.Sprintf(guard, fmt.Sprintf("i32.const %d ;; \"Object\"", object)))
+	type typeName struct {
+		typeID int
+		name   string
+	}
+	names := []typeName{
+		{TypeID_Int, "Number"},
+		{TypeID_Float, "Number"},
+		{TypeID_String, "String"},
+		{TypeID_Bool, "Boolean"},
+		{TypeID_Array, "Array"},
+		{TypeID_Map, "Map"},
+		{TypeID_Closure, "Function"},
+		{TypeID_Promise, "Promise"},
+	}
+	for _, cls := range classes {
+		name := displayClassName(cls.Name)
+		if inst, ok := c.genericInstances[cls.Name]; ok {
+			name = displayClassName(inst.Template.Name) // Box<int> and Box<string> are both Box
 		}
+		names = append(names, typeName{cls.TypeID, name})
+	}
+	for _, tn := range names {
+		out.WriteString("  local.get $type_id\n")
+		out.WriteString(fmt.Sprintf("  i32.const %d\n", tn.typeID))
+		out.WriteString("  i32.eq\n")
+		out.WriteString("  if\n")
+		out.WriteString(fmt.Sprintf("    i32.const %d ;; %q\n", c.internString(tn.name), tn.name))
+		out.WriteString("    return\n")
+		out.WriteString("  end\n")
+	}
+	out.WriteString(fmt.Sprintf("  i32.const %d ;; \"Object\"\n", object))
+	out.WriteString(")\n")
+}
 
-		// Body
-		if err := c.Compile(node.Body); err != nil {
-			return err
+// compileInstanceOf 编译 value instanceof Class：$instance_of 从对象头中的 TypeID 开始沿父类链表查找 Class
+func (c *Compiler) compileInstanceOf(node *ast.InfixExpression) error {
+	ident, ok := node.Right.(*ast.Identifier)
+	if !ok {
+		return fmt.Errorf("line %d: the right side of instanceof must be a class name", node.Token.Line)
+	}
+	cls, ok := c.classes[c.resolveClassName(ident.Value)]
+	if !ok {
+		if _, isInterface := c.interfaces[ident.Value]; isInterface {
+			return fmt.Errorf("line %d: instanceof cannot test interface %s: interfaces have no runtime representation", node.Token.Line, ident.Value)
 		}
+		if _, isGeneric := c.lookupGeneric(c.genericClasses, ident.Value); isGeneric {
+			return fmt.Errorf("line %d: instanceof cannot test generic class %s: each instantiation is a separate class", node.Token.Line, ident.Value)
+		}
+		return fmt.Errorf("line %d: undefined class: %s", node.Token.Line, ident.Value)
+	}
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+	switch {
+	case c.stackType == TypeUnion, c.stackType == TypeUnknown, c.stackType == TypeInt && c.isObjectTypeName(c.stackTypeName):
+		// Class instances and tagged values carry their TypeID
+		c.emit(fmt.Sprintf("i32.const %d ;; %s", cls.TypeID, cls.Name))
+		c.emit("call $instance_of")
+	case c.stackType == TypeNull, c.stackType == TypeUndefined, c.stackType == TypeHost:
+		c.emit("drop")
+		c.emit("i32.const 0")
+	default:
+		return fmt.Errorf("line %d: the left side of instanceof must be an object, got %s", node.Token.Line, typeNameOf(c.stackType, c.stackTypeName))
+	}
+	c.stackType = TypeBool
+	c.stackTypeName = ""
+	return nil
+}
 
-		// Update
-		if node.Update != nil {
-			if err := c.Compile(node.Update); err != nil {
-				return err
-			}
+// compileConstructorName 编译 obj.constructor.name：内置类型在编译期确定，对象由 $class_name 按 TypeID 查找
+func (c *Compiler) compileConstructorName(object ast.Expression) error {
+	if err := c.Compile(object); err != nil {
+		return err
+	}
+	name := ""
+	switch c.stackType {
+	case TypeString:
+		name = "String"
+	case TypeBool:
+		name = "Boolean"
+	case TypeFloat:
+		name = "Number"
+	case TypeBigInt:
+		name = "BigInt"
+	case TypeArray:
+		name = "Array"
+	case TypeMap:
+		name = "Map"
+	case TypeFunc:
+		name = "Function"
+	case TypeInt:
+		if !c.isObjectTypeName(c.stackTypeName) {
+			name = "Number"
 		}
-
-		c.emit("br $top")
-		c.emit("end")
-		c.emit("end")
-
+	case TypeHost:
+		name = "Object"
 	}
+	if name != "" {
+		c.emit("drop")
+		c.emit(fmt.Sprintf("i32.const %d ;; %q", c.internString(name), name))
+	} else {
+		c.emit("call $class_name")
+	}
+	c.stackType = TypeString
+	c.stackTypeName = ""
 	return nil
 }
 
-func (c *Compiler) compileFunction(fn *ast.FunctionLiteral) error {
-	funcName := fn.Name
-	if c.currentModule != nil && c.currentModule.Prefix != "" {
-		funcName = c.currentModule.Prefix + fn.Name
-	}
-	scope := NewFunctionScope(funcName)
-	c.current = scope
-	c.functions = append(c.functions, scope)
-
-	// Save previous shadow stack pointer
-	// We need a local for this
-	// 1. Register parameters
-	for i, param := range fn.Parameters {
-		t := c.resolveType(param.Type)
-		scope.Symbols[param.Name.Value] = Symbol{
-			Index: i, 
-			Type: t, 
-			IsParam: true,
-			ShadowIndex: scope.ShadowStackSize,
+// displayClassName 去掉模块中类名的前缀 (mod_<hash>_)，得到源码中的类名
+func displayClassName(name string) string {
+	if strings.HasPrefix(name, "mod_") {
+		if i := strings.Index(name[len("mod_"):], "_"); i >= 0 {
+			return name[len("mod_")+i+1:]
 		}
-		scope.ParamTypes = append(scope.ParamTypes, t)
-		scope.ParamCount++
-		scope.ShadowStackSize++
 	}
+	return name
+}
 
-	// 2. Save previous shadow stack pointer
-	shadowPtrLocal := scope.NextLocalID
-	scope.NextLocalID++
-	realShadowPtrLocal := shadowPtrLocal + scope.ParamCount
+func (c *Compiler) emitGCTrace(out *bytes.Buffer) {
+	out.WriteString("(func $gc_trace (param $ptr i32) (param $type_id i32)\n")
+	out.WriteString("  (local $i i32)\n")
+	out.WriteString("  (local $cnt i32)\n")
 	
-	c.emit("global.get $shadow_stack_ptr")
-	c.emit(fmt.Sprintf("local.set %d ;; save previous shadow_stack_ptr", realShadowPtrLocal))
+	// Create block for each class + default
+	// br_table [default, array, class1, class2...]
+	// TypeID: 0=String(ignore), 1=Array, 20=ArrayData, 2+=Classes
+	
+	// TypeID 1: Array (has data pointer at offset 8)
+	out.WriteString("  local.get $type_id\n")
+	out.WriteString("  i32.const 1\n")
+	out.WriteString("  i32.eq\n")
+	out.WriteString("  if\n")
+	out.WriteString("    local.get $ptr\n")
+	out.WriteString("    i32.const 8\n")
+	out.WriteString("    i32.add\n")
+	out.WriteString("    i32.load\n")
+	out.WriteString("    call $gc_mark\n")
+	out.WriteString("    return\n")
+	out.WriteString("  end\n")
 
-	// 3. Push params to shadow stack
-	for i := 0; i < scope.ParamCount; i++ {
-		c.emit("global.get $shadow_stack_ptr")
-		c.emit(fmt.Sprintf("local.get %d", i))
-		c.emit("i32.store")
-		
-		c.emit("global.get $shadow_stack_ptr")
-		c.emit("i32.const 4")
-		c.emit("i32.add")
-		c.emit("global.set $shadow_stack_ptr")
-	}
+	// TypeID 20: ArrayData (buffer of elements)
+	out.WriteString("  local.get $type_id\n")
+	out.WriteString("  i32.const 20\n")
+	out.WriteString("  i32.eq\n")
+	out.WriteString("  if\n")
+	// Scan elements. Size is in header (ptr-12). But header size is bytes.
+	// We need element count = size / 4.
+	out.WriteString("    local.get $ptr\n")
+	out.WriteString("    i32.const 12\n")
+	out.WriteString("    i32.sub\n")
+	out.WriteString("    i32.load\n")
+	out.WriteString("    i32.const 4\n")
+	out.WriteString("    i32.div_u\n")
+	out.WriteString("    local.set $cnt\n")
+	
+	out.WriteString("    i32.const 0\n")
+	out.WriteString("    local.set $i\n")
+	
+	out.WriteString("    (block $done_trace\n")
+	out.WriteString("      (loop $trace\n")
+	out.WriteString("        local.get $i\n")
+	out.WriteString("        local.get $cnt\n")
+	out.WriteString("        i32.ge_u\n")
+	out.WriteString("        br_if $done_trace\n")
+	
+	out.WriteString("        local.get $ptr\n")
+	out.WriteString("        local.get $i\n")
+	out.WriteString("        i32.const 4\n")
+	out.WriteString("        i32.mul\n")
+	out.WriteString("        i32.add\n")
+	out.WriteString("        i32.load\n")
+	out.WriteString("        call $gc_mark\n")
+	
+	out.WriteString("        local.get $i\n")
+	out.WriteString("        i32.const 1\n")
+	out.WriteString("        i32.add\n")
+	out.WriteString("        local.set $i\n")
+	out.WriteString("        br $trace\n")
+	out.WriteString("      )\n")
+	out.WriteString("    )\n")
+	out.WriteString("    return\n")
+	out.WriteString("  end\n")
 
-	if err := c.Compile(fn.Body); err != nil {
-		return err
-	}
+	// TypeID 2: Map (capacity, count, buckets)
+	out.WriteString("  local.get $type_id\n")
+	out.WriteString("  i32.const 2\n")
+	out.WriteString("  i32.eq\n")
+	out.WriteString("  if\n")
+	out.WriteString("    local.get $ptr\n")
+	out.WriteString("    i32.const 8\n")
+	out.WriteString("    i32.add\n")
+	out.WriteString("    i32.load\n")
+	out.WriteString("    call $gc_mark\n")
+	out.WriteString("    return\n")
+	out.WriteString("  end\n")
 
-	// Restore shadow stack pointer
-	c.emit(fmt.Sprintf("local.get %d", realShadowPtrLocal))
-	c.emit("global.set $shadow_stack_ptr")
+	// TypeID 21: MapBuckets (array of pointers) -> Same logic as ArrayData basically
+	// Size is fixed 64 (16 buckets * 4 bytes) currently, but better to read size from header
+	out.WriteString("  local.get $type_id\n")
+	out.WriteString("  i32.const 21\n")
+	out.WriteString("  i32.eq\n")
+	out.WriteString("  if\n")
+	out.WriteString("    local.get $ptr\n")
+	out.WriteString("    i32.const 12\n")
+	out.WriteString("    i32.sub\n")
+	out.WriteString("    i32.load\n")
+	out.WriteString("    i32.const 4\n")
+	out.WriteString("    i32.div_u\n")
+	out.WriteString("    local.set $cnt\n")
+	
+	out.WriteString("    i32.const 0\n")
+	out.WriteString("    local.set $i\n")
+	
+	out.WriteString("    (block $done_trace\n")
+	out.WriteString("      (loop $trace\n")
+	out.WriteString("        local.get $i\n")
+	out.WriteString("        local.get $cnt\n")
+	out.WriteString("        i32.ge_u\n")
+	out.WriteString("        br_if $done_trace\n")
+	
+	out.WriteString("        local.get $ptr\n")
+	out.WriteString("        local.get $i\n")
+	out.WriteString("        i32.const 4\n")
+	out.WriteString("        i32.mul\n")
+	out.WriteString("        i32.add\n")
+	out.WriteString("        i32.load\n")
+	out.WriteString("        call $gc_mark\n")
+	
+	out.WriteString("        local.get $i\n")
+	out.WriteString("        i32.const 1\n")
+	out.WriteString("        i32.add\n")
+	out.WriteString("        local.set $i\n")
+	out.WriteString("        br $trace\n")
+	out.WriteString("      )\n")
+	out.WriteString("    )\n")
+	out.WriteString("    return\n")
+	out.WriteString("  end\n")
 
-	// Implicit return 0 if no return statement (for void functions or just safety)
-	c.emit("i32.const 0")
-	return nil
-}
+	// TypeID 22: MapEntry (key, value, next)
+	out.WriteString("  local.get $type_id\n")
+	out.WriteString("  i32.const 22\n")
+	out.WriteString("  i32.eq\n")
+	out.WriteString("  if\n")
+	out.WriteString("    local.get $ptr\n")
+	out.WriteString("    i32.load\n")
+	out.WriteString("    call $gc_mark\n") // key
+	
+	out.WriteString("    local.get $ptr\n")
+	out.WriteString("    i32.const 4\n")
+	out.WriteString("    i32.add\n")
+	out.WriteString("    i32.load\n")
+	out.WriteString("    call $gc_mark\n") // value
+	
+	out.WriteString("    local.get $ptr\n")
+	out.WriteString("    i32.const 8\n")
+	out.WriteString("    i32.add\n")
+	out.WriteString("    i32.load\n")
+	out.WriteString("    call $gc_mark\n") // next
+	out.WriteString("    return\n")
+	out.WriteString("  end\n")
 
-func (c *Compiler) emit(instruction string) {
-	if c.current != nil {
-		c.current.Instructions = append(c.current.Instructions, instruction)
-	}
-}
+	// TypeID 9: Closure (table index, env)
+	out.WriteString("  local.get $type_id\n")
+	out.WriteString(fmt.Sprintf("  i32.const %d\n", TypeID_Closure))
+	out.WriteString("  i32.eq\n")
+	out.WriteString("  if\n")
+	out.WriteString("    local.get $ptr\n")
+	out.WriteString("    i32.load offset=4\n")
+	out.WriteString("    call $gc_mark\n")
+	out.WriteString("    return\n")
+	out.WriteString("  end\n")
 
-// GenerateWAT returns the final WAT string
-func (c *Compiler) GenerateWAT() string {
-	var out bytes.Buffer
-	out.WriteString("(module\n")
+	// TypeID 12: Promise (reactions at offset 4)
+	out.WriteString("  local.get $type_id\n")
+	out.WriteString(fmt.Sprintf("  i32.const %d\n", TypeID_Promise))
+	out.WriteString("  i32.eq\n")
+	out.WriteString("  if\n")
+	out.WriteString("    local.get $ptr\n")
+	out.WriteString("    i32.load offset=4\n")
+	out.WriteString("    call $gc_mark\n")
+	out.WriteString("    return\n")
+	out.WriteString("  end\n")
 
-	if c.target == "wasi" {
-		out.WriteString(`  (import "wasi_snapshot_preview1" "fd_write" (func $fd_write (param i32 i32 i32 i32) (result i32)))`)
-		out.WriteString("\n")
-		out.WriteString(`  (import "wasi_snapshot_preview1" "path_open" (func $path_open (param i32 i32 i32 i32 i32 i64 i64 i32 i32) (result i32)))`)
-		out.WriteString("\n")
-		out.WriteString(`  (import "wasi_snapshot_preview1" "fd_close" (func $fd_close (param i32) (result i32)))`)
-		out.WriteString("\n")
-		out.WriteString(`  (import "wasi_snapshot_preview1" "fd_read" (func $fd_read (param i32 i32 i32 i32) (result i32)))`)
-		out.WriteString("\n")
-		out.WriteString(`  (import "wasi_snapshot_preview1" "fd_filestat_get" (func $fd_filestat_get (param i32 i32) (result i32)))`)
-		out.WriteString("\n")
-		out.WriteString(`  (import "wasi_snapshot_preview1" "args_sizes_get" (func $args_sizes_get (param i32 i32) (result i32)))`)
-		out.WriteString("\n")
-		out.WriteString(`  (import "wasi_snapshot_preview1" "args_get" (func $args_get (param i32 i32) (result i32)))
-  (import "wasi_snapshot_preview1" "environ_sizes_get" (func $environ_sizes_get (param i32 i32) (result i32)))
-  (import "wasi_snapshot_preview1" "environ_get" (func $environ_get (param i32 i32) (result i32)))
-  (import "env" "thread_spawn" (func $thread_spawn (param i32 i32) (result i32)))
-  (import "wasi_snapshot_preview1" "path_unlink_file" (func $path_unlink_file (param i32 i32 i32) (result i32)))
-  (import "wasi_snapshot_preview1" "path_create_directory" (func $path_create_directory (param i32 i32 i32) (result i32)))
-  (import "wasi_snapshot_preview1" "path_remove_directory" (func $path_remove_directory (param i32 i32 i32) (result i32)))
-  (import "wasi_snapshot_preview1" "path_filestat_get" (func $path_filestat_get (param i32 i32 i32 i32 i32) (result i32)))
-  (import "wasi_snapshot_preview1" "proc_exit" (func $proc_exit (param i32)))`)
-		out.WriteString("\n")
+	// TypeID 14: IteratorResult with a reference value (value at offset 0)
+	out.WriteString("  local.get $type_id\n")
+	out.WriteString(fmt.Sprintf("  i32.const %d\n", TypeID_IterResultRef))
+	out.WriteString("  i32.eq\n")
+	out.WriteString("  if\n")
+	out.WriteString("    local.get $ptr\n")
+	out.WriteString("    i32.load\n")
+	out.WriteString("    call $gc_mark\n")
+	out.WriteString("    return\n")
+	out.WriteString("  end\n")
 
-		// User defined imports for WASI
-		for name, imported := range c.importedFuncs {
-			params := ""
-			for range imported.Parameters {
-				params += " (param i32)"
-			}
-			result := ""
-			if imported.ReturnType != "void" {
-				result = " (result i32)"
+	// Closure environments and async frames (enclosing env + captured variables)
+	for _, env := range c.envs {
+		out.WriteString(fmt.Sprintf("  ;; Env (TypeID %d)\n", env.TypeID))
+		out.WriteString("  local.get $type_id\n")
+		out.WriteString(fmt.Sprintf("  i32.const %d\n", env.TypeID))
+		out.WriteString("  i32.eq\n")
+		out.WriteString("  if\n")
+		out.WriteString("    local.get $ptr\n")
+		out.WriteString("    i32.load\n")
+		out.WriteString("    call $gc_mark\n")
+		names := make([]string, 0, len(env.Offsets))
+		for name := range env.Offsets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			t := env.Types[name]
+			if t != TypeInt && t != TypeBool && t != TypeString && t != TypeVoid && !isWideType(t) {
+				out.WriteString(fmt.Sprintf("    ;; %s\n", name))
+				out.WriteString("    local.get $ptr\n")
+				out.WriteString(fmt.Sprintf("    i32.load offset=%d\n", env.Offsets[name]))
+				out.WriteString("    call $gc_mark\n")
 			}
-			out.WriteString(fmt.Sprintf("  (import \"env\" \"%s\" (func $%s%s%s))\n", name, name, params, result))
 		}
-		
-		// Host interop for WASI (shared with browser)
-		out.WriteString("  (import \"env\" \"host_get_global\" (func $host_get_global (param i32) (result i32)))\n")
-		out.WriteString("  (import \"env\" \"host_get\" (func $host_get (param i32) (param i32) (result i32)))\n")
-		out.WriteString("  (import \"env\" \"host_set\" (func $host_set (param i32) (param i32) (param i32)))\n")
-		out.WriteString("  (import \"env\" \"host_call\" (func $host_call (param i32) (param i32) (param i32) (param i32) (result i32)))\n")
-		out.WriteString("  (import \"env\" \"host_from_int\" (func $host_from_int (param i32) (result i32)))\n")
-		out.WriteString("  (import \"env\" \"host_from_string\" (func $host_from_string (param i32) (result i32)))\n")
-		out.WriteString("  (import \"env\" \"host_to_int\" (func $host_to_int (param i32) (result i32)))\n")
+		out.WriteString("    return\n")
+		out.WriteString("  end\n")
+	}
 
-	} else {
-		// Browser imports
-		for name, imported := range c.importedFuncs {
-			params := ""
-			for range imported.Parameters {
-				params += " (param i32)" // All types are i32 for MVP
-			}
-			result := ""
-			if imported.ReturnType != "void" {
-				result = " (result i32)"
+	for _, cls := range c.classes {
+		out.WriteString(fmt.Sprintf("  ;; Class %s (TypeID %d)\n", cls.Name, cls.TypeID))
+		out.WriteString("  local.get $type_id\n")
+		out.WriteString(fmt.Sprintf("  i32.const %d\n", cls.TypeID))
+		out.WriteString("  i32.eq\n")
+		out.WriteString("  if\n")
+		
+		// Scan fields
+		for name, offset := range cls.Fields {
+			fieldType := cls.FieldTypes[name]
+			// Only trace pointer types
+			// Assuming Int/Bool are values. String is pointer but leaf (no trace inside).
+			// Array and Class types need tracing.
+			// How do we know if a field is a Class type?
+			// DataType is string. If it's not int/bool/string/void, it's a class or array.
+			
+			if fieldType != TypeInt && fieldType != TypeBool && fieldType != TypeString && fieldType != TypeVoid && !isWideType(fieldType) {
+				out.WriteString(fmt.Sprintf("    ;; Field %s (offset %d)\n", name, offset))
+				out.WriteString("    local.get $ptr\n")
+				out.WriteString(fmt.Sprintf("    i32.const %d\n", offset))
+				out.WriteString("    i32.add\n")
+				out.WriteString("    i32.load\n")
+				out.WriteString("    call $gc_mark\n")
 			}
-			out.WriteString(fmt.Sprintf("  (import \"env\" \"%s\" (func $%s%s%s))\n", name, name, params, result))
 		}
 		
-		// Host interop for browser
-		out.WriteString("  (import \"env\" \"host_get_global\" (func $host_get_global (param i32) (result i32)))\n")
-		out.WriteString("  (import \"env\" \"host_get\" (func $host_get (param i32) (param i32) (result i32)))\n")
-		out.WriteString("  (import \"env\" \"host_set\" (func $host_set (param i32) (param i32) (param i32)))\n")
-		out.WriteString("  (import \"env\" \"host_call\" (func $host_call (param i32) (param i32) (param i32) (param i32) (result i32)))\n")
-		out.WriteString("  (import \"env\" \"host_from_int\" (func $host_from_int (param i32) (result i32)))\n")
-		out.WriteString("  (import \"env\" \"host_from_string\" (func $host_from_string (param i32) (result i32)))\n")
-		out.WriteString("  (import \"env\" \"host_to_int\" (func $host_to_int (param i32) (result i32)))\n")
-		out.WriteString("  (import \"env\" \"thread_spawn\" (func $thread_spawn (param i32 i32) (result i32)))\n")
+		out.WriteString("    return\n")
+		out.WriteString("  end\n")
 	}
+	
+	out.WriteString(")\n")
+}
 
-	out.WriteString("  (import \"env\" \"memory\" (memory 1 1000 shared))\n")
-	out.WriteString("  (export \"memory\" (memory 0))\n")
-	out.WriteString("  (tag $exception (param i32))\n")
-	if c.target == "wasi" {
-		// Reactor model: export _initialize, do NOT export _start
-		out.WriteString("  (export \"_initialize\" (func $noop))\n")
-        out.WriteString("  (export \"_set_stack_pointer\" (func $set_stack_pointer))\n")
-	} else {
-		out.WriteString("  (export \"gc\" (func $gc_collect))\n")
+func (c *Compiler) defineEnum(node *ast.EnumStatement) error {
+	enumName := node.Name.Value
+	if c.currentModule != nil && c.currentModule.Prefix != "" {
+		enumName = c.currentModule.Prefix + node.Name.Value
 	}
 
-	// Emit data segments for strings
-	for str, offset := range c.stringPool {
-		// Basic escaping for WAT
-		escapedStr := fmt.Sprintf("%q", str)
-		escapedStr = escapedStr[1 : len(escapedStr)-1] // Remove Go quotes
-		out.WriteString(fmt.Sprintf("  (data (i32.const %d) \"%s\\00\")\n", offset, escapedStr))
+	if _, exists := c.enums[enumName]; exists {
+		return fmt.Errorf("enum %s already defined", enumName)
 	}
 
-	// Emit Standard Library
-	out.WriteString(stdLibWAT)
-	out.WriteString(stdLibExtraWAT)
-	if c.target == "wasi" {
-		out.WriteString(wasiEnvWAT)
+	members := make(map[string]int)
+	currentValue := 0
+
+	for _, member := range node.Members {
+		if member.Value != nil {
+			// Evaluate constant value
+			// For now, only support integer literals
+			if intLit, ok := member.Value.(*ast.IntegerLiteral); ok {
+				currentValue = int(intLit.Value)
+			} else {
+				return fmt.Errorf("enum member value must be integer literal")
+			}
+		}
+		
+		members[member.Name.Value] = currentValue
+		currentValue++
 	}
-	out.WriteString("\n(func $noop)\n")
-	
-	if c.target == "wasi" {
-		out.WriteString(`
-(func $wasi_print (param $str i32)
-  (local $len i32)
-  (local $iov i32)
-  (local $written i32)
-  
-  local.get $str
-  call $strlen
-  local.set $len
-  
-  ;; Allocate 8 bytes for iovec [ptr, len]
-  i32.const 8
-  i32.const 0
-  call $malloc
-  local.set $iov
-  
-  ;; Store ptr
-  local.get $iov
-  local.get $str
-  i32.store
-  
-  ;; Store len
-  local.get $iov
-  i32.const 4
-  i32.add
-  local.get $len
-  i32.store
-  
-  ;; call fd_write(1, iov, 1, written_ptr)
-  i32.const 1 ;; stdout
-  local.get $iov
-  i32.const 1 ;; iovs_len
-  local.get $iov ;; reuse iov ptr for written_ptr (dirty hack but safe if we don't read it)
-  call $fd_write
-  drop
-  
-  ;; Print newline
-  i32.const 8
-  i32.const 0
-  call $malloc
-  local.set $iov
-  
-  ;; Store ptr to newline (we need a newline string constant, but let's just make one on heap)
-  i32.const 2
-  i32.const 0
-  call $malloc
-  local.set $str
-  local.get $str
-  i32.const 10 ;; \n
-  i32.store8
-  local.get $str
-  i32.const 1
-  i32.add
-  i32.const 0
-  i32.store8
-  
-  local.get $iov
-  local.get $str
-  i32.store
-  
-  local.get $iov
-  i32.const 4
-  i32.add
-  i32.const 1
-  i32.store
-  
-  i32.const 1
-  local.get $iov
-  i32.const 1
-  local.get $iov
-  call $fd_write
-  drop
-)
 
-(func $fs_writeFile (param $path i32) (param $content i32)
-  (local $path_len i32)
-  (local $fd_ptr i32)
-  (local $fd i32)
-  (local $content_len i32)
-  (local $iovs i32)
-  (local $nwritten i32)
+	c.enums[enumName] = members
+	return nil
+}
 
-  ;; Calculate path len
-  local.get $path
-  call $strlen
-  local.set $path_len
+func (c *Compiler) defineClass(node *ast.ClassStatement) error {
+	className := node.Name.Value
+	if c.currentModule != nil && c.currentModule.Prefix != "" {
+		className = c.currentModule.Prefix + node.Name.Value
+	}
 
-  ;; Allocate fd_ptr (4 bytes)
-  i32.const 4
-  i32.const 0
-  call $malloc
-  local.set $fd_ptr
+	classSymbol := ClassSymbol{
+		Name:       className,
+		Fields:     make(map[string]int),
+		FieldTypes: make(map[string]DataType),
+		FieldTypeNames: make(map[string]string),
+		Methods:    make(map[string]string),
+		MethodSigs: make(map[string]FunctionSignature),
+		Members:    make(map[string]MemberModifiers),
+		StaticFields:     make(map[string]StaticField),
+		StaticMethods:    make(map[string]string),
+		StaticMethodSigs: make(map[string]FunctionSignature),
+		StaticMembers:    make(map[string]MemberModifiers),
+		Abstract:         node.Abstract,
+		AbstractMethods:  make(map[string]string),
+		VTableSlots:      make(map[string]int),
+		TypeID:     c.allocTypeID(),
+	}
 
-  ;; Call path_open
-  ;; dirfd=3 (preopened .)
-  i32.const 3 
-  ;; dirflags=0
-  i32.const 0
-  ;; path
-  local.get $path
-  ;; path_len
-  local.get $path_len
-  ;; oflags=9 (CREAT|TRUNC)
-  i32.const 9
-  ;; rights_base=64 (WRITE) - Need i64
-  i64.const 64
-  ;; rights_inheriting=0 - Need i64
-  i64.const 0
-  ;; fd_flags=0
-  i32.const 0
-  ;; fd_ptr
-  local.get $fd_ptr
-  call $path_open
-  drop ;; result errno
+	offset := 0
+
+	// Inherit from parent
+	if node.Parent != nil {
+		parentName := node.Parent.Value
+		parentSym, ok := c.classes[parentName]
+		if !ok {
+			return fmt.Errorf("undefined parent class: %s", parentName)
+		}
+		classSymbol.Parent = parentName
+		
+		// Copy fields
+		for k, v := range parentSym.Fields {
+			classSymbol.Fields[k] = v
+		}
+		for k, v := range parentSym.FieldTypes {
+			classSymbol.FieldTypes[k] = v
+		}
+		for k, v := range parentSym.FieldTypeNames {
+			classSymbol.FieldTypeNames[k] = v
+		}
+		offset = parentSym.Size
+		
+		// Copy methods
+		for k, v := range parentSym.Methods {
+			classSymbol.Methods[k] = v
+		}
+		for k, v := range parentSym.MethodSigs {
+			classSymbol.MethodSigs[k] = v
+		}
+		for k, v := range parentSym.Members {
+			classSymbol.Members[k] = v
+		}
+		for k, v := range parentSym.AbstractMethods {
+			classSymbol.AbstractMethods[k] = v
+		}
+		classSymbol.VTable = append([]string{}, parentSym.VTable...)
+		classSymbol.VTableSigs = append([]FunctionSignature{}, parentSym.VTableSigs...)
+		for k, v := range parentSym.VTableSlots {
+			classSymbol.VTableSlots[k] = v
+		}
+		// Statics are inherited too: Child.create() reaches Parent.create
+		for k, v := range parentSym.StaticFields {
+			classSymbol.StaticFields[k] = v
+		}
+		for k, v := range parentSym.StaticMethods {
+			classSymbol.StaticMethods[k] = v
+		}
+		for k, v := range parentSym.StaticMethodSigs {
+			classSymbol.StaticMethodSigs[k] = v
+		}
+		for k, v := range parentSym.StaticMembers {
+			classSymbol.StaticMembers[k] = v
+		}
+		if parentSym.Members["init"].Access == "private" {
+			return fmt.Errorf("class %s cannot extend %s: its constructor is private", className, parentName)
+		}
+		delete(classSymbol.Members, "init") // The implicit constructor is public
+	}
 
-  ;; Get fd
-  local.get $fd_ptr
-  i32.load
-  local.set $fd
+	// Registered early so overrides may return this class where the parent returns its own
+	c.classes[className] = classSymbol
 
-  ;; Calculate content len
-  local.get $content
-  call $strlen
-  local.set $content_len
+	fields, err := c.parameterProperties(node)
+	if err != nil {
+		return err
+	}
+	own := make(map[string]string) // Static and instance members declared by this class -> kind
+	declare := func(name, kind, access string, readonly, static bool) error {
+		if access == "public" {
+			access = ""
+		}
+		key := name
+		members := classSymbol.Members
+		if static {
+			key = "static " + name
+			members = classSymbol.StaticMembers
+		}
+		if prev, ok := own[key]; ok && !(prev != kind && strings.HasSuffix(prev, "accessor") && strings.HasSuffix(kind, "accessor")) {
+			return fmt.Errorf("duplicate member %s in class %s", name, className)
+		}
+		own[key] = kind
+		if inherited, ok := members[name]; ok && inherited.Owner != className && accessRank(access) > accessRank(inherited.Access) {
+			was := inherited.Access
+			if was == "" {
+				was = "public"
+			}
+			return fmt.Errorf("%s %s of class %s cannot be %s: it is %s in %s", kind, name, className, access, was, inherited.Owner)
+		}
+		members[name] = MemberModifiers{Access: access, Readonly: readonly, Owner: className}
+		return nil
+	}
 
-  ;; Allocate iovs (8 bytes)
-  i32.const 8
-  i32.const 0
-  call $malloc
-  local.set $iovs
+	// Add new fields
+	var initializers []ast.Statement
+	for _, field := range fields {
+		if err := declare(field.Name.Value, "field", field.Access, field.Readonly, field.Static); err != nil {
+			return err
+		}
+		if field.Static {
+			if err := c.defineStaticField(&classSymbol, field); err != nil {
+				return err
+			}
+			continue
+		}
+		if field.Value != nil {
+			// this.name = value, in the hidden field initializer method
+			member := &ast.MemberExpression{Token: field.Token, Object: &ast.ThisExpression{Token: field.Token}, Property: field.Name}
+			initializers = append(initializers, &ast.ExpressionStatement{Token: field.Token, Expression: &ast.AssignmentExpression{Token: field.Token, Left: member, Value: field.Value}})
+		}
+		classSymbol.Fields[field.Name.Value] = offset
+		
+		// Parse type
+		dataType := c.resolveType(field.Type)
+		classSymbol.FieldTypes[field.Name.Value] = dataType
+		classSymbol.FieldTypeNames[field.Name.Value] = field.Type
+		
+		if isWideType(dataType) {
+			offset += 8
+		} else {
+			offset += 4
+		}
+	}
+	classSymbol.Size = offset
+	if len(initializers) > 0 {
+		if _, ok := classSymbol.Methods[fieldInitMethod]; ok {
+			// The parent's initializers run first
+			call := &ast.CallExpression{Token: node.Token, Function: &ast.MemberExpression{Token: node.Token, Object: &ast.SuperExpression{Token: node.Token}, Property: &ast.Identifier{Token: node.Token, Value: fieldInitMethod}}}
+			initializers = append([]ast.Statement{&ast.ExpressionStatement{Token: node.Token, Expression: call}}, initializers...)
+		}
+		node.Methods = append(node.Methods, &ast.FunctionLiteral{Token: node.Token, Name: fieldInitMethod, Parameters: []*ast.FieldDefinition{}, Body: &ast.BlockStatement{Token: node.Token, Statements: initializers}})
+	}
 
-  ;; Fill iovs
-  local.get $iovs
-  local.get $content
-  i32.store
-  local.get $iovs
-  i32.const 4
-  i32.add
-  local.get $content_len
-  i32.store
+	// Add/Override methods
+	for _, method := range node.Methods {
+		key := methodKey(method)
+		switch method.Accessor {
+		case "get":
+			if len(method.Parameters) != 0 {
+				return fmt.Errorf("getter %s of class %s cannot have parameters", method.Name, className)
+			}
+		case "set":
+			if len(method.Parameters) != 1 || method.Parameters[0].Rest || method.Parameters[0].Optional {
+				return fmt.Errorf("setter %s of class %s must have exactly one parameter", method.Name, className)
+			}
+		}
+		if method.Accessor != "" && (method.Async || method.Generator) {
+			return fmt.Errorf("accessor %s of class %s cannot be async or a generator", method.Name, className)
+		}
+		if method.Abstract {
+			switch {
+			case !node.Abstract:
+				return fmt.Errorf("abstract method %s can only appear in an abstract class, %s is not abstract", method.Name, className)
+			case method.Static:
+				return fmt.Errorf("static method %s of class %s cannot be abstract", method.Name, className)
+			case method.Name == "init" && method.Accessor == "":
+				return fmt.Errorf("constructor of class %s cannot be abstract", className)
+			case method.Access == "private":
+				return fmt.Errorf("abstract method %s of class %s cannot be private", method.Name, className)
+			}
+			classSymbol.AbstractMethods[key] = className
+		} else if !method.Static {
+			delete(classSymbol.AbstractMethods, key)
+		}
+		kind := "method"
+		if method.Accessor != "" {
+			kind = method.Accessor + " accessor"
+		}
+		if method.Static {
+			if method.Name == "init" && method.Accessor == "" {
+				return fmt.Errorf("constructor of class %s cannot be static", className)
+			}
+			if err := declare(method.Name, kind, method.Access, false, true); err != nil {
+				return err
+			}
+			classSymbol.StaticMethods[key] = fmt.Sprintf("%s_static_%s", className, key)
+			classSymbol.StaticMethodSigs[key] = c.functionSignature(method)
+			continue
+		}
 
-  ;; Allocate nwritten (4 bytes)
-  i32.const 4
-  i32.const 0
-  call $malloc
-  local.set $nwritten
+		mangledName := fmt.Sprintf("%s_%s", className, key)
+		classSymbol.Methods[key] = mangledName
+		if method.Name == fieldInitMethod {
+			// Hidden, not a member
+		} else if method.Name == "init" && method.Accessor == "" {
+			// Constructors are not inherited members: only new is checked against their access
+			access := method.Access
+			if access == "public" {
+				access = ""
+			}
+			classSymbol.Members["init"] = MemberModifiers{Access: access, Owner: className}
+		} else if err := declare(method.Name, kind, method.Access, false, false); err != nil {
+			return err
+		}
 
-  ;; Call fd_write
-  local.get $fd
-  local.get $iovs
-  i32.const 1
-  local.get $nwritten
-  call $fd_write
-  drop
+		sig := c.functionSignature(method)
+		if method.ReturnType == "" {
+			sig.ReturnType = TypeInt
+		}
+		if slot, virtual := classSymbol.VTableSlots[key]; virtual {
+			if reason := c.overrideMismatch(method.Name, classSymbol.MethodSigs[key], sig); reason != "" {
+				return fmt.Errorf("method %s of class %s overrides %s.%s with an incompatible signature: %s", method.Name, className, classSymbol.Parent, method.Name, reason)
+			}
+			// New parameters, or defaults where the slot has none, need a slot of their own;
+			// the parent's slots reach this override through a thunk
+			slotSig := classSymbol.VTableSigs[slot]
+			if !sameParamTypes(sig, slotSig) || hasDefaults(sig) && !hasDefaults(slotSig) {
+				delete(classSymbol.VTableSlots, key)
+			}
+		}
+		classSymbol.MethodSigs[key] = sig
+		if method.Name != fieldInitMethod && !(method.Name == "init" && method.Accessor == "") {
+			if _, ok := classSymbol.VTableSlots[key]; !ok {
+				classSymbol.VTableSlots[key] = len(classSymbol.VTable)
+				classSymbol.VTable = append(classSymbol.VTable, key)
+				classSymbol.VTableSigs = append(classSymbol.VTableSigs, sig)
+			}
+		}
+	}
 
-  ;; Call fd_close
-  local.get $fd
-  call $fd_close
-  drop
-)
+	// vtable: one funcref table entry per slot, pointing at this class's implementation or its thunk
+	if len(classSymbol.VTable) > 0 {
+		classSymbol.VTableBase = c.nextFuncID
+		for slot := range classSymbol.VTable {
+			c.closureIDs[c.nextFuncID] = slotFunction(classSymbol, slot)
+			c.nextFuncID++
+		}
+	}
 
-(func $fs_readFile (param $path i32) (result i32)
-  (local $path_len i32)
-  (local $fd_ptr i32)
-  (local $fd i32)
-  (local $stat_ptr i32)
-  (local $size i32)
-  (local $str i32)
-  (local $iovs i32)
-  (local $nread i32)
+	c.classes[className] = classSymbol
+	return nil
+}
 
-  ;; Calculate path len
-  local.get $path
-  call $strlen
-  local.set $path_len
+// compileNew 创建 className 的实例：分配对象、写入 vtable、运行字段初始化器和构造函数；emitArgs 负责压入构造函数的参数
+func (c *Compiler) compileNew(node *ast.NewExpression, className string, emitArgs func(sig FunctionSignature, context string) error) error {
+	classSym, ok := c.classes[className]
+	if !ok {
+		return fmt.Errorf("undefined class: %s", className)
+	}
+	if classSym.Abstract {
+		return fmt.Errorf("line %d: cannot create an instance of abstract class %s", node.Token.Line, className)
+	}
+	if ctor := classSym.Members["init"]; ctor.Access != "" && c.currentClass != className &&
+		(ctor.Access == "private" || !c.isSubclassOf(c.currentClass, className)) {
+		return fmt.Errorf("line %d: constructor of class %s is %s and only accessible within the class declaration", node.Token.Line, className, ctor.Access)
+	}
 
-  ;; Allocate fd_ptr
-  i32.const 4
-  i32.const 0
-  call $malloc
-  local.set $fd_ptr
+	// malloc(size)
+	c.emit(fmt.Sprintf("i32.const %d", classSym.Size))
+	c.emit(fmt.Sprintf("i32.const %d", classSym.TypeID))
+	c.emit("call $malloc")
+
+	// vtable base in the header, next to the TypeID
+	if len(classSym.VTable) > 0 {
+		instance := c.newTempLocal("instance", TypeInt)
+		c.emit(fmt.Sprintf("local.tee %d", instance))
+		c.emit("i32.const 16")
+		c.emit("i32.sub")
+		c.emit(fmt.Sprintf("i32.const %d ;; vtable of %s", classSym.VTableBase, className))
+		c.emit("i32.store")
+		c.emit(fmt.Sprintf("local.get %d", instance))
+	}
 
-  ;; path_open
-  i32.const 3 ;; dirfd=.
-  i32.const 0 ;; dirflags
-  local.get $path
-  local.get $path_len
-  i32.const 0 ;; oflags
-  i64.const 2097154 ;; rights_base=READ(2)|FD_FILESTAT_GET(2097152)
-  i64.const 0 ;; rights_inheriting
-  i32.const 0 ;; fd_flags
-  local.get $fd_ptr
-  call $path_open
-  drop ;; result
+	// Field initializers run before the constructor body
+	if fieldInit, ok := classSym.Methods[fieldInitMethod]; ok {
+		instance := c.newTempLocal("instance", TypeInt)
+		c.emit(fmt.Sprintf("local.tee %d", instance))
+		c.emit(fmt.Sprintf("call $%s", fieldInit))
+		c.emit("drop")
+		c.emit(fmt.Sprintf("local.get %d", instance))
+	}
 
-  ;; Get fd
-  local.get $fd_ptr
-  i32.load
-  local.set $fd
+	// Check for constructor "init"
+	if mangledName, ok := classSym.Methods["init"]; ok {
+		// Store ptr in temp to use it multiple times
+		tempIndex := c.current.NextLocalID
+		c.current.NextLocalID++
+		realTempIndex := tempIndex + c.current.ParamCount
 
-  ;; Get file stats (size)
-  i32.const 64 ;; size of filestat
-  i32.const 0
-  call $malloc
-  local.set $stat_ptr
+		c.emit(fmt.Sprintf("local.set %d", realTempIndex))
 
-  local.get $fd
-  local.get $stat_ptr
-  call $fd_filestat_get
-  drop
+		// Prepare 'this'
+		c.emit(fmt.Sprintf("local.get %d", realTempIndex))
 
-  ;; Read size (offset 32, u64)
-  local.get $stat_ptr
-  i32.const 32
-  i32.add
-  i64.load
-  i32.wrap_i64 ;; Assume file size < 4GB
-  local.set $size
+		// Args
+		if err := emitArgs(classSym.MethodSigs["init"], className+".init"); err != nil {
+			return err
+		}
 
-  ;; Allocate string buffer (size + 1 for null terminator)
-  local.get $size
-  i32.const 1
-  i32.add
-  i32.const 0 ;; TypeID 0 (String)
-  call $malloc
-  local.set $str
+		c.emit(fmt.Sprintf("call $%s", mangledName))
+		c.emit("drop") // Ignore init return value
 
-  ;; Allocate iovs
-  i32.const 8
-  i32.const 0
-  call $malloc
-  local.set $iovs
+		// Return instance
+		c.emit(fmt.Sprintf("local.get %d", realTempIndex))
+	} else if len(node.Arguments) > 0 {
+		return fmt.Errorf("arguments provided for class %s but no 'init' method found", className)
+	}
 
-  ;; Fill iovs
-  local.get $iovs
-  local.get $str
-  i32.store
-  local.get $iovs
-  i32.const 4
-  i32.add
-  local.get $size
-  i32.store
+	c.stackType = TypeInt
+	c.stackTypeName = className
+	return nil
+}
 
-  ;; Allocate nread
-  i32.const 4
-  i32.const 0
-  call $malloc
-  local.set $nread
+// sameWasmSignature 判断两个签名编译后的 WASM 函数类型是否相同
+func sameWasmSignature(a, b FunctionSignature) bool {
+	if len(a.ParamTypes) != len(b.ParamTypes) || wasmType(a.ReturnType) != wasmType(b.ReturnType) {
+		return false
+	}
+	for i := range a.ParamTypes {
+		if wasmType(a.ParamTypes[i]) != wasmType(b.ParamTypes[i]) {
+			return false
+		}
+	}
+	return true
+}
 
-  ;; fd_read
-  local.get $fd
-  local.get $iovs
-  i32.const 1
-  local.get $nread
-  call $fd_read
-  drop
+// overrideMismatch 返回重写方法 override 不能替换父类方法 base 的原因：参数可以比父类少 (多余的实参被忽略)，
+// 新增的参数必须可以省略，已有参数的类型相同或一方可以赋给另一方，返回值可以赋给父类的返回类型；
+// 父类的槽位用 call_indirect 调用，WASM 类型还必须相同
+func (c *Compiler) overrideMismatch(name string, base, override FunctionSignature) string {
+	if override.Variadic != base.Variadic || base.Variadic && len(override.ParamTypes) != len(base.ParamTypes) {
+		return fmt.Sprintf("method %s must keep the parent's rest parameter", name)
+	}
+	for i, t := range override.ParamTypes {
+		if i >= len(base.ParamTypes) {
+			if !hasDefault(override, i) {
+				return fmt.Sprintf("parameter %d of method %s is not in the parent and must be optional", i+1, name)
+			}
+			continue
+		}
+		g, w := override.ParamTypeNames[i], base.ParamTypeNames[i]
+		if wasmType(t) != wasmType(base.ParamTypes[i]) || g != "" && w != "" && !c.typeNameAssignable(g, w) && !c.typeNameAssignable(w, g) {
+			return fmt.Sprintf("parameter %d of method %s is %s, expected %s", i+1, name, typeNameOf(t, g), typeNameOf(base.ParamTypes[i], w))
+		}
+		if hasDefault(base, i) && !hasDefault(override, i) {
+			return fmt.Sprintf("parameter %d of method %s is optional in the parent and must stay optional", i+1, name)
+		}
+	}
+	g, w := override.ReturnTypeName, base.ReturnTypeName
+	if wasmType(override.ReturnType) != wasmType(base.ReturnType) || g != "" && w != "" && !c.typeNameAssignable(g, w) {
+		return fmt.Sprintf("method %s returns %s, expected %s", name, typeNameOf(override.ReturnType, g), typeNameOf(base.ReturnType, w))
+	}
+	return ""
+}
 
-  ;; Null terminate
-  local.get $str
-  local.get $size
-  i32.add
-  i32.const 0
-  i32.store8
+// hasDefault 判断第 i 个参数是否可以省略 (可选参数或带默认值)
+func hasDefault(sig FunctionSignature, i int) bool {
+	return i < len(sig.Defaults) && sig.Defaults[i] != nil
+}
 
-  ;; Close
-  local.get $fd
-  call $fd_close
-  drop
+// hasDefaults 判断签名中是否有可以省略的参数；这样的 vtable 槽位由调用方传入实参个数，
+// 省略的参数由实际调用的方法填入它自己的默认值
+func hasDefaults(sig FunctionSignature) bool {
+	for i := range sig.Defaults {
+		if hasDefault(sig, i) {
+			return true
+		}
+	}
+	return false
+}
 
-  local.get $str
-)
+// sameParamTypes 判断两个签名的参数类型是否完全相同
+func sameParamTypes(a, b FunctionSignature) bool {
+	if len(a.ParamTypes) != len(b.ParamTypes) {
+		return false
+	}
+	for i := range a.ParamTypes {
+		if a.ParamTypes[i] != b.ParamTypes[i] {
+			return false
+		}
+	}
+	return true
+}
 
-(func $fs_unlink (param $path i32) (result i32)
-  (local $path_len i32)
-  local.get $path
-  call $strlen
-  local.set $path_len
-  
-  i32.const 3 ;; dirfd
-  local.get $path
-  local.get $path_len
-  call $path_unlink_file
-)
+// slotFunction 返回类的 vtable 槽位中的函数：方法本身，或者在槽位签名与方法不同时的 thunk
+func slotFunction(cls ClassSymbol, slot int) string {
+	impl := cls.Methods[cls.VTable[slot]]
+	slotSig := cls.VTableSigs[slot]
+	if !hasDefaults(slotSig) && sameParamTypes(slotSig, cls.MethodSigs[cls.VTable[slot]]) {
+		return impl
+	}
+	return fmt.Sprintf("%s@slot%d", impl, slot)
+}
+
+// slotCallSignature 返回通过 vtable 槽位调用时的签名：有可省略参数的槽位在最后多一个实参个数
+func slotCallSignature(slotSig FunctionSignature) FunctionSignature {
+	if hasDefaults(slotSig) {
+		slotSig.ParamTypes = append(append([]DataType{}, slotSig.ParamTypes...), TypeInt)
+	}
+	return slotSig
+}
+
+// isVirtualMethod 判断对 className 类型对象的方法调用是否要经过 vtable：抽象方法和被子类重写的方法
+// (所有类在编译方法体之前定义，子类都是已知的)
+func (c *Compiler) isVirtualMethod(className, key string) bool {
+	cls, ok := c.classes[className]
+	if !ok {
+		return false
+	}
+	if _, ok := cls.VTableSlots[key]; !ok {
+		return false
+	}
+	if _, ok := cls.AbstractMethods[key]; ok {
+		return true
+	}
+	// Generic subclasses are instantiated on use, possibly after this call is compiled
+	for _, tmpl := range c.genericClasses {
+		if tmpl.Class.Parent != nil && declaresMethod(tmpl.Class, key) {
+			return true
+		}
+	}
+	for _, other := range c.classes {
+		if other.Methods[key] != cls.Methods[key] && c.isSubclassOf(other.Name, className) {
+			return true
+		}
+	}
+	return false
+}
+
+// emitMethodCall 调用实例方法，对象已在栈上，emitArgs 负责压入其余参数；需要时通过对象头中的 vtable 间接调用
+// emitArgs 的参数为 true 时不填入默认值，而是传入零值和实参个数 (见 compileSlotArgs)
+func (c *Compiler) emitMethodCall(className, key, mangledName string, sig FunctionSignature, emitArgs func(argc bool) error) error {
+	if !c.isVirtualMethod(className, key) {
+		if err := emitArgs(false); err != nil {
+			return err
+		}
+		c.emit(fmt.Sprintf("call $%s", mangledName))
+		return nil
+	}
+	cls := c.classes[className]
+	slot := cls.VTableSlots[key]
+	object := c.newTempLocal("receiver", TypeInt)
+	c.emit(fmt.Sprintf("local.tee %d", object))
+	if err := emitArgs(hasDefaults(cls.VTableSigs[slot])); err != nil {
+		return err
+	}
+	c.emit(fmt.Sprintf("local.get %d", object))
+	c.emit("i32.const 16")
+	c.emit("i32.sub")
+	c.emit("i32.load ;; vtable")
+	c.emit(fmt.Sprintf("i32.const %d ;; %s", slot, key))
+	c.emit("i32.add")
+	c.emit(fmt.Sprintf("call_indirect (type %s)", c.closureType(slotCallSignature(cls.VTableSigs[slot]))))
+	return nil
+}
 
-(func $fs_mkdir (param $path i32) (result i32)
-  (local $path_len i32)
-  local.get $path
-  call $strlen
-  local.set $path_len
-  
-  i32.const 3 ;; dirfd
-  local.get $path
-  local.get $path_len
-  call $path_create_directory
-)
+// compileSlotThunks 为类自己的方法生成 vtable thunk：槽位由参数较少的父类方法引入，或者调用方只传入实参个数时，
+// thunk 用方法自己的默认值填入省略的参数再调用方法，所以 virtual 调用不使用声明类型中的默认值
+func (c *Compiler) compileSlotThunks(className string) error {
+	cls := c.classes[className]
+	for slot, key := range cls.VTable {
+		impl := cls.Methods[key]
+		name := slotFunction(cls, slot)
+		if impl != className+"_"+key || name == impl {
+			continue
+		}
+		if err := c.compileSlotThunk(name, impl, cls.VTableSigs[slot], cls.MethodSigs[key], key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-(func $fs_rmdir (param $path i32) (result i32)
-  (local $path_len i32)
-  local.get $path
-  call $strlen
-  local.set $path_len
-  
-  i32.const 3 ;; dirfd
-  local.get $path
-  local.get $path_len
-  call $path_remove_directory
-)
+// compileSlotThunk 生成 thunk：参数为 this、槽位的参数和 (有可省略参数时) 实参个数；
+// 方法的每个参数放在同名的局部变量中，由槽位参数转换得到或取方法的默认值
+func (c *Compiler) compileSlotThunk(name, impl string, slotSig, sig FunctionSignature, context string) error {
+	scope := NewFunctionScope(name)
+	c.current = scope
+	c.functions = append(c.functions, scope)
+	scope.Symbols["this"] = Symbol{Index: 0, Type: TypeInt, IsParam: true, ShadowIndex: 0}
+	scope.ParamTypes = append(scope.ParamTypes, TypeInt)
+	for _, t := range slotSig.ParamTypes {
+		scope.ParamTypes = append(scope.ParamTypes, t)
+	}
+	if hasDefaults(slotSig) {
+		scope.ParamTypes = append(scope.ParamTypes, TypeInt) // argc
+	}
+	scope.ParamCount = len(scope.ParamTypes)
+	scope.ShadowStackSize = scope.ParamCount
+	argc := scope.ParamCount - 1
+	if isWideType(sig.ReturnType) {
+		scope.ReturnType = sig.ReturnType
+	}
+	realShadowPtrLocal := c.emitShadowPrologue()
 
-(func $fs_existsSync (param $path i32) (result i32)
-  (local $path_len i32)
-  (local $stat_buf i32)
-  (local $res i32)
-  
-  local.get $path
-  call $strlen
-  local.set $path_len
-  
-  ;; Allocate stat buffer (64 bytes)
-  i32.const 64
-  i32.const 0
-  call $malloc
-  local.set $stat_buf
-  
-  i32.const 3 ;; dirfd
-  i32.const 0 ;; flags (follow symlinks)
-  local.get $path
-  local.get $path_len
-  local.get $stat_buf
-  call $path_filestat_get
-  local.set $res
-  
-  ;; 0 is success
-  local.get $res
-  i32.eqz
-)
+	fixed := len(sig.ParamTypes)
+	if sig.Variadic {
+		fixed--
+	}
+	what := func(i int) string { return fmt.Sprintf("parameter %s of %s", sig.ParamNames[i], context) }
+	for i, t := range sig.ParamTypes {
+		switch {
+		case i >= len(slotSig.ParamTypes):
+			// Parameters the slot does not have always take their defaults
+			if err := c.compileDefaultArg(sig, i, nil, context); err != nil {
+				return err
+			}
+		case i < fixed && hasDefault(slotSig, i):
+			// Omitted by the caller when argc <= i
+			c.emit(fmt.Sprintf("local.get %d", argc))
+			c.emit(fmt.Sprintf("i32.const %d", i))
+			c.emit("i32.le_s")
+			c.emit(fmt.Sprintf("if (result %s)", wasmType(t)))
+			if err := c.compileDefaultArg(sig, i, nil, context); err != nil {
+				return err
+			}
+			c.emit("else")
+			c.emit(fmt.Sprintf("local.get %d", i+1))
+			if err := c.emitConvert(slotSig.ParamTypes[i], t, what(i)); err != nil {
+				return err
+			}
+			c.emit("end")
+		default:
+			c.emit(fmt.Sprintf("local.get %d", i+1))
+			if err := c.emitConvert(slotSig.ParamTypes[i], t, what(i)); err != nil {
+				return err
+			}
+		}
+		index := scope.NextLocalID
+		scope.NextLocalID++
+		sym := Symbol{Index: index, Type: t, ShadowIndex: -1, TypeName: sig.ParamTypeNames[i]}
+		if isWideType(t) {
+			scope.LocalTypes[index] = t
+		} else {
+			sym.ShadowIndex = scope.ShadowStackSize
+		}
+		scope.Symbols[sig.ParamNames[i]] = sym
+		c.emit(fmt.Sprintf("local.set %d ;; %s", index+scope.ParamCount, sig.ParamNames[i]))
+		if sym.ShadowIndex >= 0 {
+			c.emitShadowPush(index + scope.ParamCount)
+		}
+	}
 
-(func $std_args (result i32)
-  (local $argc i32)
-  (local $argv_buf_size i32)
-  (local $argv_ptr i32)
-  (local $argv_buf_ptr i32)
-  (local $arr i32)
-  (local $i i32)
-  (local $arg_ptr i32)
-  (local $arg_len i32)
-  (local $str i32)
-  (local $argc_ptr i32)
-  (local $argv_buf_size_ptr i32)
-  (local $dest i32)
-  (local $src i32)
-  (local $cnt i32)
+	c.emit("local.get 0 ;; this")
+	for _, param := range sig.ParamNames {
+		c.emit(fmt.Sprintf("local.get %d ;; %s", scope.Symbols[param].Index+scope.ParamCount, param))
+	}
+	c.emit(fmt.Sprintf("call $%s", impl))
+	c.emit(fmt.Sprintf("local.get %d", realShadowPtrLocal))
+	c.emit("global.set $shadow_stack_ptr")
+	return nil
+}
 
-  ;; Allocate space for argc and argv_buf_size
-  i32.const 4
-  i32.const 0
-  call $malloc
-  local.set $argc_ptr
-  
-  i32.const 4
-  i32.const 0
-  call $malloc
-  local.set $argv_buf_size_ptr
+// parameterProperties 返回类声明的字段：类体中的字段，加上构造函数的参数属性 init(private x: int)
+// 参数属性在构造函数开头赋值，就像写了 this.x = x
+func (c *Compiler) parameterProperties(node *ast.ClassStatement) ([]*ast.FieldDefinition, error) {
+	fields := node.Fields
+	for _, method := range node.Methods {
+		if method.Name != "init" {
+			continue
+		}
+		var assigns []ast.Statement
+		for _, param := range method.Parameters {
+			if param.Access == "" && !param.Readonly {
+				continue
+			}
+			for _, field := range fields {
+				if field.Name.Value == param.Name.Value {
+					return nil, fmt.Errorf("line %d: duplicate field %s in class %s", param.Token.Line, param.Name.Value, node.Name.Value)
+				}
+			}
+			fields = append(fields, &ast.FieldDefinition{Token: param.Token, Name: param.Name, Type: param.Type, Access: param.Access, Readonly: param.Readonly})
+			member := &ast.MemberExpression{Token: param.Token, Object: &ast.ThisExpression{Token: param.Token}, Property: param.Name}
+			assigns = append(assigns, &ast.ExpressionStatement{Token: param.Token, Expression: &ast.AssignmentExpression{Token: param.Token, Left: member, Value: param.Name}})
+		}
+		if len(assigns) > 0 {
+			method.Body.Statements = append(assigns, method.Body.Statements...)
+		}
+	}
+	return fields, nil
+}
 
-  ;; Call args_sizes_get
-  local.get $argc_ptr
-  local.get $argv_buf_size_ptr
-  call $args_sizes_get
-  drop
+// checkMemberAccess 检查当前位置能否访问 className 的成员 name：private 成员只能在声明它的类中访问，
+// protected 成员还可以在子类中访问；类型未知时与 lookupField 一样在所有类中查找，任何一个允许即可
+func (c *Compiler) checkMemberAccess(className, name string, line int) error {
+	candidates := []ClassSymbol{}
+	if cls, ok := c.classes[className]; ok {
+		candidates = append(candidates, cls)
+	} else {
+		for _, cls := range c.classes {
+			candidates = append(candidates, cls)
+		}
+	}
+	var denied error
+	for _, cls := range candidates {
+		mods, ok := cls.Members[name]
+		if !ok {
+			continue
+		}
+		if denied = c.accessError(mods, name, line); denied == nil {
+			return nil
+		}
+	}
+	return denied
+}
 
-  ;; Load argc and buf size
-  local.get $argc_ptr
-  i32.load
-  local.set $argc
-  
-  local.get $argv_buf_size_ptr
-  i32.load
-  local.set $argv_buf_size
+// accessError 当前类不能访问 mods 描述的成员时返回错误
+func (c *Compiler) accessError(mods MemberModifiers, name string, line int) error {
+	if mods.Access == "" || c.currentClass == mods.Owner || (mods.Access == "protected" && c.isSubclassOf(c.currentClass, mods.Owner)) {
+		return nil
+	}
+	if mods.Access == "private" {
+		return fmt.Errorf("line %d: property %s is private and only accessible within class %s", line, name, mods.Owner)
+	}
+	return fmt.Errorf("line %d: property %s is protected and only accessible within class %s and its subclasses", line, name, mods.Owner)
+}
 
-  ;; Allocate argv (array of pointers)
-  local.get $argc
-  i32.const 4
-  i32.mul
-  i32.const 0
-  call $malloc
-  local.set $argv_ptr
+// checkReadonlyAssignment 检查对 className 的字段 name 的赋值：readonly 字段只能在声明它的类的构造函数中赋值
+func (c *Compiler) checkReadonlyAssignment(className, name string, line int) error {
+	candidates := []ClassSymbol{}
+	if cls, ok := c.classes[className]; ok {
+		candidates = append(candidates, cls)
+	} else {
+		for _, cls := range c.classes {
+			candidates = append(candidates, cls)
+		}
+	}
+	readonly := false
+	for _, cls := range candidates {
+		mods, ok := cls.Members[name]
+		if !ok {
+			continue
+		}
+		if !mods.Readonly || c.current.Name == mods.Owner+"_init" || c.current.Name == mods.Owner+"_"+fieldInitMethod {
+			return nil
+		}
+		readonly = true
+	}
+	if readonly {
+		return fmt.Errorf("line %d: cannot assign to %s because it is a read-only property", line, name)
+	}
+	return nil
+}
 
-  ;; Allocate argv_buf (char buffer)
-  local.get $argv_buf_size
-  i32.const 0
-  call $malloc
-  local.set $argv_buf_ptr
+// resolveClassName 返回源码中的类名在当前模块中对应的类：先找模块前缀的类，再找导入的别名
+func (c *Compiler) resolveClassName(name string) string {
+	if c.currentModule != nil && c.currentModule.Prefix != "" {
+		if _, ok := c.classes[c.currentModule.Prefix+name]; ok {
+			return c.currentModule.Prefix + name
+		}
+	}
+	if c.currentModule != nil {
+		if alias, ok := c.currentModule.SymbolAliases[name]; ok {
+			if _, ok := c.classes[alias]; ok {
+				return alias
+			}
+		}
+	}
+	return name
+}
 
-  ;; Call args_get
-  local.get $argv_ptr
-  local.get $argv_buf_ptr
-  call $args_get
-  drop
+// isSubclassOf 判断 className 是否直接或间接继承自 ancestor
+func (c *Compiler) isSubclassOf(className, ancestor string) bool {
+	for className != "" {
+		cls, ok := c.classes[className]
+		if !ok {
+			return false
+		}
+		if cls.Parent == ancestor {
+			return true
+		}
+		className = cls.Parent
+	}
+	return false
+}
 
-  ;; Create Array to return
-  local.get $argc
-  call $array_new
-  local.set $arr
+func (c *Compiler) compileClassMethods(node *ast.ClassStatement) error {
+	className := node.Name.Value
+	if c.currentModule != nil && c.currentModule.Prefix != "" {
+		className = c.currentModule.Prefix + node.Name.Value
+	}
 
-  ;; Loop through argv and push to array
-  i32.const 0
-  local.set $i
-  
-  (block $done
-    (loop $loop
-      local.get $i
-      local.get $argc
-      i32.ge_u
-      br_if $done
+	c.currentClass = className
+	defer func() { c.currentClass = "" }()
 
-      ;; Get pointer to current arg string
-      local.get $argv_ptr
-      local.get $i
-      i32.const 4
-      i32.mul
-      i32.add
-      i32.load
-      local.set $arg_ptr
+	for _, method := range node.Methods {
+		mangledName := fmt.Sprintf("%s_%s", className, methodKey(method))
+		if method.Static {
+			mangledName = fmt.Sprintf("%s_static_%s", className, methodKey(method))
+		}
+		c.inStatic = method.Static
 
-      ;; We need to copy this to a managed string because argv_buf is a single block
-      ;; and we want distinct string objects (TypeID 0).
-      ;; Calculate length: iterate until null terminator
-      local.get $arg_ptr
-      call $strlen
-      local.set $arg_len
+		scope := NewFunctionScope(mangledName)
+		c.current = scope
+		c.functions = append(c.functions, scope)
 
-      ;; Allocate new string
-      local.get $arg_len
-      i32.const 1
-      i32.add
-      i32.const 0 ;; TypeID 0
-      call $malloc
-      local.set $str
+		// Add 'this' parameter as first parameter
+		if !method.Static {
+			scope.Symbols["this"] = Symbol{Index: 0, Type: TypeInt, IsParam: true, ShadowIndex: 0}
+			scope.ParamTypes = append(scope.ParamTypes, TypeInt)
+			scope.ParamCount++
+			scope.ShadowStackSize++
+		}
+
+		for _, param := range method.Parameters {
+			t := c.resolveType(param.Type)
+			shadowIndex := scope.ShadowStackSize
+			if isWideType(t) {
+				shadowIndex = -1
+			}
+			scope.Symbols[param.Name.Value] = Symbol{Index: scope.ParamCount, Type: t, IsParam: true, ShadowIndex: shadowIndex, TypeName: param.Type}
+			scope.ParamTypes = append(scope.ParamTypes, t)
+			scope.ParamCount++
+			scope.ShadowStackSize++
+		}
+		scope.ReturnTypeName = method.ReturnType
+		if method.ReturnType != "" && isWideType(c.resolveType(method.ReturnType)) {
+			scope.ReturnType = c.resolveType(method.ReturnType)
+		}
+		if method.Abstract {
+			// Only reachable through the vtable of an abstract class, which new rejects
+			c.emit(fmt.Sprintf("unreachable ;; abstract %s.%s", className, methodKey(method)))
+			continue
+		}
+		if method.Async {
+			if method.Name == "init" {
+				return fmt.Errorf("constructor of class %s cannot be async", className)
+			}
+			if _, err := c.compileAsyncFunction(method, method.ReturnType, false, !method.Static); err != nil {
+				return err
+			}
+			continue
+		}
+		if method.Generator {
+			if method.Name == "init" {
+				return fmt.Errorf("constructor of class %s cannot be a generator", className)
+			}
+			if _, err := c.compileGeneratorFunction(method, method.ReturnType, false, !method.Static); err != nil {
+				return err
+			}
+			continue
+		}
 
-      ;; Copy chars
-      ;; Inline memcpy
-      local.get $arg_len
-      i32.const 1
-      i32.add ;; copy null terminator too
-      local.set $cnt
-      
-      local.get $arg_ptr
-      local.set $src
-      
-      local.get $str
-      local.set $dest
-      
-      (block $cp_done
-        (loop $cp_loop
-           local.get $cnt
-           i32.eqz
-           br_if $cp_done
-           
-           local.get $dest
-           local.get $src
-           i32.load8_u
-           i32.store8
-           
-           local.get $dest
-           i32.const 1
-           i32.add
-           local.set $dest
-           
-           local.get $src
-           i32.const 1
-           i32.add
-           local.set $src
-           
-           local.get $cnt
-           i32.const 1
-           i32.sub
-           local.set $cnt
-           br $cp_loop
-        )
-      )
+		realShadowPtrLocal := c.emitShadowPrologue()
+		c.setupEnv(capturedNames(method.Parameters, method.Body, !method.Static))
 
-      ;; Push to array
-      local.get $arr
-      local.get $str
-      call $array_push
+		if err := c.Compile(method.Body); err != nil {
+			return err
+		}
 
-      local.get $i
-      i32.const 1
-      i32.add
-      local.set $i
-      br $loop
-    )
-  )
+		c.emit(fmt.Sprintf("local.get %d", realShadowPtrLocal))
+		c.emit("global.set $shadow_stack_ptr")
+		c.emitDefaultReturn()
+	}
+	c.inStatic = false
+	return c.compileSlotThunks(className)
+}
 
-  local.get $arr
-)
-`)
+// checkAbstractImplementation 检查具体类是否实现了继承来的所有抽象方法
+func (c *Compiler) checkAbstractImplementation(node *ast.ClassStatement) error {
+	className := c.resolveClassName(node.Name.Value)
+	classSym := c.classes[className]
+	if classSym.Abstract {
+		return nil
+	}
+	keys := make([]string, 0, len(classSym.AbstractMethods))
+	for key := range classSym.AbstractMethods {
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil
 	}
+	sort.Strings(keys)
+	key := keys[0]
+	name := key
+	if strings.HasPrefix(key, "@get_") || strings.HasPrefix(key, "@set_") {
+		name = key[1:4] + " " + key[5:] // get name / set name
+	}
+	return fmt.Errorf("class %s does not implement abstract member %s from class %s", className, name, classSym.AbstractMethods[key])
+}
 
-	// --- Task Scheduler: Generate Function Table ---
-	// Emit Table
-	out.WriteString(fmt.Sprintf("(table %d funcref)\n", c.nextFuncID))
+// defineStaticField 为静态字段分配全局变量；有初始化器时登记到 $init_statics 中
+// 初始化器在所有函数之后才编译，所以没有类型注解时只能从字面量推断类型
+func (c *Compiler) defineStaticField(cls *ClassSymbol, field *ast.FieldDefinition) error {
+	typeName := field.Type
+	if typeName == "" {
+		switch v := field.Value.(type) {
+		case *ast.IntegerLiteral:
+			typeName = "int"
+			if _, fits := intLiteralValue(v, false); !fits {
+				typeName = "number"
+			}
+		case *ast.FloatLiteral:
+			typeName = "number"
+		case *ast.BigIntLiteral:
+			typeName = "bigint"
+		case *ast.StringLiteral, *ast.TemplateLiteral:
+			typeName = "string"
+		case *ast.Boolean:
+			typeName = "bool"
+		case *ast.NewExpression:
+			typeName = v.Class.Value
+		default:
+			return fmt.Errorf("static field %s.%s needs a type annotation", cls.Name, field.Name.Value)
+		}
+	}
+	global := StaticField{Global: fmt.Sprintf("$%s.%s", cls.Name, field.Name.Value), Type: c.resolveType(typeName), TypeName: typeName}
+	cls.StaticFields[field.Name.Value] = global
+	if field.Value != nil {
+		c.staticInits = append(c.staticInits, staticInit{Class: cls.Name, Field: field})
+	}
+	return nil
+}
 
-	// Dummy task function for empty slots
-	out.WriteString("(func $scheduler_dummy_task (param i32))\n")
+// compileStaticInits 把所有模块中静态字段的初始化器编译进 $init_statics，main 开始时按声明顺序运行它们
+func (c *Compiler) compileStaticInits() error {
+	if len(c.staticInits) == 0 {
+		return nil
+	}
+	scope := NewFunctionScope("init_statics")
+	c.current = scope
+	c.functions = append(c.functions, scope)
+	c.inStatic = true
+	defer func() { c.inStatic, c.currentClass = false, "" }()
+	shadowPtr := c.emitShadowPrologue()
+	for _, init := range c.staticInits {
+		c.currentClass = init.Class
+		field := c.classes[init.Class].StaticFields[init.Field.Name.Value]
+		if err := c.Compile(init.Field.Value); err != nil {
+			return err
+		}
+		if err := c.emitConvert(c.stackType, field.Type, "static field "+init.Field.Name.Value); err != nil {
+			return err
+		}
+		c.emit("global.set " + field.Global)
+	}
+	c.emit(fmt.Sprintf("local.get %d", shadowPtr))
+	c.emit("global.set $shadow_stack_ptr")
+	c.emitDefaultReturn()
+	c.staticInits = nil
+	return nil
+}
 
-	// Emit Elements
-	// Use string builder for elements to avoid many writes
-	var elemBuilder strings.Builder
-	elemBuilder.WriteString("(elem (i32.const 1)")
+func (c *Compiler) defineInterface(node *ast.InterfaceStatement) error {
+	if _, ok := c.interfaces[node.Name.Value]; ok {
+		return fmt.Errorf("interface %s already defined", node.Name.Value)
+	}
 	
-	// Create reverse map for efficient lookup
-	idToName := make(map[int]string)
-	for name, id := range c.funcIDs {
-		idToName[id] = name
+	sym := InterfaceSymbol{
+		Name:    node.Name.Value,
+		Methods: make(map[string]*ast.MethodSignature),
 	}
 	
-	for i := 1; i < c.nextFuncID; i++ {
-		if name, ok := idToName[i]; ok {
-			 elemBuilder.WriteString(" $wrapper_" + name)
-		} else {
-			 elemBuilder.WriteString(" $scheduler_dummy_task")
+	for _, m := range node.Methods {
+		if _, ok := sym.Methods[m.Name]; ok {
+			return fmt.Errorf("duplicate method %s in interface %s", m.Name, node.Name.Value)
 		}
+		sym.Methods[m.Name] = m
+		sym.Slots = append(sym.Slots, m.Name)
 	}
-	elemBuilder.WriteString(")\n")
-	out.WriteString(elemBuilder.String())
 	
-	// Dispatcher Function
-	out.WriteString(`(func $dispatch_task (param $id i32) (param $args i32)
-	  ;; Debug: Print dispatched ID
-	  ;; local.get $id
-	  ;; call $print_int
+	c.interfaces[node.Name.Value] = sym
+	return nil
+}
 
-	  local.get $id
-	  i32.const 0
-	  i32.le_s
-	  if
-		return
-	  end
-	  
-	  local.get $args
-	  local.get $id
-	  call_indirect (type $task_sig)
-	)
-	`)
+// interfaceMethodSig 返回接口方法的签名 (不含 this)
+func (c *Compiler) interfaceMethodSig(m *ast.MethodSignature) FunctionSignature {
+	return c.functionSignature(&ast.FunctionLiteral{Token: m.Token, Name: m.Name, Parameters: m.Parameters, ReturnType: m.ReturnType})
+}
 
-	// Emit GC Trace Function
-	c.emitGCTrace(&out)
+// conformsTo 判断类是否在结构上满足接口 (不要求写 implements)，见 interfaceMismatch
+func (c *Compiler) conformsTo(cls ClassSymbol, iface InterfaceSymbol) bool {
+	if cls.Abstract {
+		return false // Never instantiated
+	}
+	return c.interfaceMismatch(cls, iface) == ""
+}
 
-	// Emit Wrapper Functions for Task Scheduler
-	for _, fn := range c.functions {
-		// Wrapper name: $wrapper_<Name>
-		out.WriteString(fmt.Sprintf("  (func $wrapper_%s (param $args i32)\n", fn.Name))
-		
-		// Unpack args
-		for i := 0; i < fn.ParamCount; i++ {
-			// local.get $args
-			// i32.const <index>
-			// call $array_get
-			out.WriteString("    local.get $args\n")
-			out.WriteString(fmt.Sprintf("    i32.const %d\n", i))
-			out.WriteString("    call $array_get\n")
+// interfaceMismatch 返回类不满足接口的原因，满足时为空：每个接口方法都要有同名的公开实例方法，
+// 参数和返回值的类型与接口中写的一致 (见 methodMismatch)
+func (c *Compiler) interfaceMismatch(cls ClassSymbol, iface InterfaceSymbol) string {
+	key := cls.Name + "/" + iface.Name
+	if c.conforming[key] {
+		return "" // compareTo(other: Comparable) while checking Comparable
+	}
+	c.conforming[key] = true
+	defer delete(c.conforming, key)
+	for _, name := range iface.Slots {
+		if _, ok := cls.Methods[name]; !ok {
+			return fmt.Sprintf("missing method %s", name)
+		}
+		if access := cls.Members[name].Access; access != "" {
+			return fmt.Sprintf("method %s is %s", name, access)
+		}
+		if reason := c.methodMismatch(name, cls.MethodSigs[name], c.interfaceMethodSig(iface.Methods[name])); reason != "" {
+			return reason
 		}
-		
-		// Call original function
-		out.WriteString(fmt.Sprintf("    call $%s\n", fn.Name))
-		
-		// Drop result if needed
-		// All functions return i32 in our current ABI (except void?)
-		// Wait, we defined FunctionSignature with ReturnType.
-		// If fn.ReturnType is TypeVoid, it returns nothing?
-		// BUT, in `c.functions` loop below, we emit `(result i32)` for ALL functions!
-		// Line 5250: `paramsStr += " (param i32)"`
-		// Line 5254: `out.WriteString(fmt.Sprintf("  (func $%s %s%s (result i32)\n", fn.Name, exportName, paramsStr))`
-		// So YES, all functions return i32.
-		// So we MUST drop.
-		
-		out.WriteString("    drop\n")
-		
-		out.WriteString("  )\n")
 	}
+	return ""
+}
 
-	for _, fn := range c.functions {
-		// Export all functions for now (needed for spawn)
-		exportName := fmt.Sprintf("(export \"%s\")", fn.Name)
-		
-		if fn.Name == "main" && c.target == "wasi" {
-			// For WASI, main is exported as _start via the export section above.
-			// We also export it as "main" via exportName above.
-			
-			// Inject call to start_workers (Auto-Parallelism)
-			// Start 4 workers
-			fn.Instructions = append([]string{"i32.const 4", "call $start_workers"}, fn.Instructions...)
+// methodMismatch 比较实现的方法签名 got 和接口中的签名 want：参数个数相同，参数类型相同或一方可以赋给另一方，
+// 返回值可以赋给接口的返回类型 (没写返回类型的方法不检查)；itable 用 call_indirect 调用，WASM 签名还必须相同
+func (c *Compiler) methodMismatch(name string, got, want FunctionSignature) string {
+	if len(got.ParamTypes) != len(want.ParamTypes) || got.Variadic != want.Variadic {
+		return fmt.Sprintf("method %s has %d parameter(s), expected %d", name, len(got.ParamTypes), len(want.ParamTypes))
+	}
+	for i := range want.ParamTypes {
+		g, w := got.ParamTypeNames[i], want.ParamTypeNames[i]
+		if !c.typeNameAssignable(g, w) && !c.typeNameAssignable(w, g) || wasmType(got.ParamTypes[i]) != wasmType(want.ParamTypes[i]) {
+			return fmt.Sprintf("parameter %d of method %s is %s, expected %s", i+1, name, g, w)
 		}
+	}
+	if got.ReturnTypeName != "" && !c.typeNameAssignable(got.ReturnTypeName, want.ReturnTypeName) || wasmType(got.ReturnType) != wasmType(want.ReturnType) {
+		return fmt.Sprintf("method %s returns %s, expected %s", name, typeNameOf(got.ReturnType, got.ReturnTypeName), typeNameOf(want.ReturnType, want.ReturnTypeName))
+	}
+	return ""
+}
+
+// canonicalTypeName 返回类型名的规范写法，用于比较：展开类型别名和泛型实例，去掉空格，类名带上模块前缀
+func (c *Compiler) canonicalTypeName(typeName string) string {
+	typeName = strings.ReplaceAll(typeName, " ", "")
+	for i := 0; i < 10; i++ { // Aliases may refer to other aliases
+		alias, ok := c.typeAliases[typeName]
+		if !ok {
+			break
+		}
+		typeName = strings.ReplaceAll(alias, " ", "")
+	}
+	if expanded, err := c.expandTypeName(typeName, nil); err == nil {
+		typeName = expanded
+	}
+	if typeName == "" {
+		return "void"
+	}
+	return c.resolveClassName(typeName)
+}
+
+// typeNameAssignable 判断源码中类型为 from 的值能否当作 to 使用：类型相同、子类当作父类、类当作它满足的接口、成员当作联合类型
+func (c *Compiler) typeNameAssignable(from, to string) bool {
+	from, to = c.canonicalTypeName(from), c.canonicalTypeName(to)
+	if from == to {
+		return true
+	}
+	if members := unionMembers(to); len(members) > 1 {
+		// Every member of from must fit some member of to
+		for _, f := range unionMembers(from) {
+			fits := false
+			for _, m := range members {
+				if c.typeNameAssignable(f, m) {
+					fits = true
+					break
+				}
+			}
+			if !fits {
+				return false
+			}
+		}
+		return true
+	}
+	if iface, ok := c.interfaces[to]; ok {
+		if other, ok := c.interfaces[from]; ok {
+			return c.interfaceExtends(other, iface) == ""
+		}
+		cls, ok := c.classes[from]
+		return ok && c.interfaceMismatch(cls, iface) == ""
+	}
+	_, isClass := c.classes[to]
+	return isClass && c.isSubclassOf(from, to)
+}
+
+// interfaceExtends 返回接口 from 的值不能当作接口 to 使用的原因：to 的每个方法 from 中都要有相同签名的方法
+func (c *Compiler) interfaceExtends(from, to InterfaceSymbol) string {
+	key := from.Name + "/" + to.Name
+	if c.conforming[key] {
+		return ""
+	}
+	c.conforming[key] = true
+	defer delete(c.conforming, key)
+	for _, name := range to.Slots {
+		m, ok := from.Methods[name]
+		if !ok {
+			return fmt.Sprintf("missing method %s", name)
+		}
+		if reason := c.methodMismatch(name, c.interfaceMethodSig(m), c.interfaceMethodSig(to.Methods[name])); reason != "" {
+			return reason
+		}
+	}
+	return ""
+}
+
+// checkInterfaceAssignable 在值转换为接口类型时检查它在结构上满足接口：静态类型和它已知的子类都要满足，
+// 否则通过 itable 调用时会在运行时陷入 unreachable
+func (c *Compiler) checkInterfaceAssignable(valueType DataType, valueTypeName, targetTypeName, context string) error {
+	iface, ok := c.interfaces[targetTypeName]
+	if !ok || valueTypeName == targetTypeName {
+		return nil
+	}
+	switch valueType {
+	case TypeNull, TypeUndefined, TypeUnknown:
+		return nil
+	}
+	mismatch := func(reason string) error {
+		return fmt.Errorf("cannot use %s as %s in %s: %s", typeNameOf(valueType, valueTypeName), targetTypeName, context, reason)
+	}
+	if other, ok := c.interfaces[valueTypeName]; ok {
+		if reason := c.interfaceExtends(other, iface); reason != "" {
+			return mismatch(reason)
+		}
+		return nil
+	}
+	className := c.resolveClassName(valueTypeName)
+	cls, ok := c.classes[className]
+	if !ok {
+		return mismatch("not a class instance")
+	}
+	if reason := c.interfaceMismatch(cls, iface); reason != "" {
+		return mismatch(reason)
+	}
+	var subclasses []string
+	for name := range c.classes {
+		if name != className && c.isSubclassOf(name, className) {
+			subclasses = append(subclasses, name)
+		}
+	}
+	sort.Strings(subclasses)
+	for _, name := range subclasses {
+		if reason := c.interfaceMismatch(c.classes[name], iface); reason != "" {
+			return mismatch(fmt.Sprintf("subclass %s: %s", displayClassName(name), reason))
+		}
+	}
+	return nil
+}
+
+// compileInterfaceCall 通过 itable 调用接口类型的值 (已在栈上) 的方法：
+// $itable_<接口>(TypeID) 在运行时找到 (类, 接口) 对应的函数表区段，再用 call_indirect 调用其中的方法
+func (c *Compiler) compileInterfaceCall(iface InterfaceSymbol, methodName string, node *ast.CallExpression) error {
+	m, ok := iface.Methods[methodName]
+	if !ok {
+		return fmt.Errorf("line %d: interface %s has no method %s", node.Token.Line, iface.Name, methodName)
+	}
+	slot := 0
+	for i, name := range iface.Slots {
+		if name == methodName {
+			slot = i
+		}
+	}
+	sig := c.interfaceMethodSig(m)
+	context := iface.Name + "." + methodName
+	if err := checkArgCount(sig, node.Arguments, context); err != nil {
+		return err
+	}
+	object := c.newTempLocal("receiver", TypeInt)
+	c.emit(fmt.Sprintf("local.tee %d", object))
+	if err := c.compileArgs(node.Arguments, sig, context); err != nil {
+		return err
+	}
+	c.emit(fmt.Sprintf("local.get %d", object))
+	c.emit("call $get_type_id")
+	c.emit(fmt.Sprintf("call $itable_%s", iface.Name))
+	c.emit(fmt.Sprintf("i32.const %d ;; %s", slot, methodName))
+	c.emit("i32.add")
+	c.emit(fmt.Sprintf("call_indirect (type %s)", c.closureType(sig)))
+	c.usedItables[iface.Name] = true
+	c.setCallResult(sig)
+	return nil
+}
+
+// buildItables 为用到动态分派的接口分配 itable (每个满足接口的类一段函数表)，返回 TypeID -> itable 的查找函数
+// 必须在输出函数表之前调用
+func (c *Compiler) buildItables() string {
+	names := make([]string, 0, len(c.usedItables))
+	for name := range c.usedItables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	classes := make([]ClassSymbol, 0, len(c.classes))
+	for _, cls := range c.classes {
+		classes = append(classes, cls)
+	}
+	sort.Slice(classes, func(i, j int) bool { return classes[i].TypeID < classes[j].TypeID })
+
+	var out strings.Builder
+	for _, name := range names {
+		iface := c.interfaces[name]
+		out.WriteString(fmt.Sprintf("(func $itable_%s (param $type_id i32) (result i32)\n", name))
+		for _, cls := range classes {
+			if !c.conformsTo(cls, iface) {
+				continue
+			}
+			base := c.nextFuncID
+			for _, method := range iface.Slots {
+				c.closureIDs[c.nextFuncID] = cls.Methods[method]
+				c.nextFuncID++
+			}
+			out.WriteString(fmt.Sprintf("  local.get $type_id\n  i32.const %d ;; %s\n  i32.eq\n  if\n    i32.const %d\n    return\n  end\n", cls.TypeID, cls.Name, base))
+		}
+		out.WriteString(fmt.Sprintf("  unreachable ;; does not implement %s\n)\n", name))
+	}
+	return out.String()
+}
 
-		paramsStr := ""
-		for i := 0; i < fn.ParamCount; i++ {
-			paramsStr += " (param i32)"
+// checkIteratorInterface 检查内置接口 Iterator<T> (next(): IteratorResult<T>) 和 Iterable<T> ([Symbol.iterator](): Iterator<T>)
+func (c *Compiler) checkIteratorInterface(classSym ClassSymbol, ifaceName string) error {
+	var methodName, want string
+	switch {
+	case strings.HasPrefix(ifaceName, "Iterator<") && strings.HasSuffix(ifaceName, ">"):
+		methodName = "next"
+		want = "IteratorResult<" + ifaceName[len("Iterator<"):len(ifaceName)-1] + ">"
+	case strings.HasPrefix(ifaceName, "Iterable<") && strings.HasSuffix(ifaceName, ">"):
+		methodName = ast.SymbolIteratorMethod
+		want = "Iterator<" + ifaceName[len("Iterable<"):len(ifaceName)-1] + ">"
+	default:
+		return nil
+	}
+	displayName := methodName
+	if methodName == ast.SymbolIteratorMethod {
+		displayName = "[Symbol.iterator]"
+	}
+	if _, ok := classSym.Methods[methodName]; !ok {
+		return fmt.Errorf("class %s does not implement method %s from interface %s", classSym.Name, displayName, ifaceName)
+	}
+	got := classSym.MethodSigs[methodName].ReturnTypeName
+	if methodName == ast.SymbolIteratorMethod {
+		if inner, ok := iteratorValueType(got); ok {
+			got = "Iterator<" + inner + ">"
 		}
+	}
+	if got != want {
+		return fmt.Errorf("method %s of class %s must return %s to implement %s, not %s", displayName, classSym.Name, want, ifaceName, typeNameOf(classSym.MethodSigs[methodName].ReturnType, got))
+	}
+	return nil
+}
+
+func (c *Compiler) checkInterfaceImplementation(node *ast.ClassStatement) error {
+	className := node.Name.Value
+	if c.currentModule != nil && c.currentModule.Prefix != "" {
+		className = c.currentModule.Prefix + node.Name.Value
+	}
 
-		out.WriteString(fmt.Sprintf("  (func $%s %s%s (result i32)\n", fn.Name, exportName, paramsStr))
+	classSym, ok := c.classes[className]
+	if !ok { return fmt.Errorf("internal error: class %s not found", className) }
 
-		for i := 0; i < fn.NextLocalID; i++ {
-			out.WriteString("    (local i32)\n")
+	for _, impl := range node.Implements {
+		ifaceName := impl.Value
+		if err := c.checkIteratorInterface(classSym, ifaceName); err != nil {
+			return err
+		} else if strings.HasPrefix(ifaceName, "Iterator<") || strings.HasPrefix(ifaceName, "Iterable<") {
+			continue
+		}
+		ifaceSym, ok := c.interfaces[ifaceName]
+		if !ok {
+			return fmt.Errorf("class %s implements undefined interface %s", className, ifaceName)
 		}
 		
-		for _, ins := range fn.Instructions {
-			out.WriteString("    " + ins + "\n")
+		for _, methodName := range ifaceSym.Slots {
+			if _, hasMethod := classSym.Methods[methodName]; !hasMethod {
+				return fmt.Errorf("class %s does not implement method %s from interface %s", className, methodName, ifaceName)
+			}
+			if access := classSym.Members[methodName].Access; access != "" {
+				return fmt.Errorf("method %s of class %s implements interface %s and cannot be %s", methodName, className, ifaceName, access)
+			}
+			if reason := c.methodMismatch(methodName, classSym.MethodSigs[methodName], c.interfaceMethodSig(ifaceSym.Methods[methodName])); reason != "" {
+				return fmt.Errorf("class %s does not implement interface %s: %s", className, ifaceName, reason)
+			}
 		}
+	}
+	return nil
+}
 
-		out.WriteString("  )\n")
+// defineGeneric 记录泛型函数 / 泛型类的声明，返回 s 是否为泛型声明
+// 泛型声明本身不生成代码，每组类型实参在第一次用到时实例化为独立的函数 / 类
+func (c *Compiler) defineGeneric(s ast.Statement) (bool, error) {
+	tmpl := &GenericTemplate{Module: c.currentModule}
+	name, kind := "", ""
+	var params []*ast.FieldDefinition // Parameters that type arguments are inferred from
+	switch s := s.(type) {
+	case *ast.ClassStatement:
+		if len(s.TypeParams) == 0 {
+			return false, nil
+		}
+		name, kind = s.Name.Value, "class"
+		for _, field := range s.Fields {
+			if field.Static {
+				return false, fmt.Errorf("line %d: static field %s is not supported in generic class %s", field.Token.Line, field.Name.Value, name)
+			}
+		}
+		for _, method := range s.Methods {
+			if method.Static {
+				return false, fmt.Errorf("line %d: static method %s is not supported in generic class %s", method.Token.Line, method.Name, name)
+			}
+			if method.Name == "init" && method.Accessor == "" {
+				params = method.Parameters
+			}
+		}
+		tmpl.Class, tmpl.TypeParams = s, s.TypeParams
+	case *ast.ExpressionStatement:
+		fn, ok := s.Expression.(*ast.FunctionLiteral)
+		if !ok || len(fn.TypeParams) == 0 {
+			return false, nil
+		}
+		name, kind, params = fn.Name, "function", fn.Parameters
+		tmpl.Function, tmpl.TypeParams = fn, fn.TypeParams
+	default:
+		return false, nil
+	}
+	// Arguments are compiled before the instance is chosen, so they cannot be collected into a rest array
+	for _, p := range params {
+		if p.Rest {
+			return false, fmt.Errorf("line %d: rest parameter %s is not supported in generic %s %s", p.Token.Line, p.Name.Value, kind, name)
+		}
+	}
+	tmpl.Name = c.currentModule.Prefix + name
+	_, isClass := c.genericClasses[tmpl.Name]
+	if _, isFunc := c.genericFuncs[tmpl.Name]; isClass || isFunc {
+		return false, fmt.Errorf("generic %s %s already defined", kind, name)
+	}
+	if tmpl.Class != nil {
+		c.genericClasses[tmpl.Name] = tmpl
+	} else {
+		c.genericFuncs[tmpl.Name] = tmpl
 	}
+	return true, nil
+}
 
-	out.WriteString(")\n")
-	return out.String()
+// lookupGeneric 按 resolveClassName 的规则查找泛型声明：本模块、导入的名字、全局
+func (c *Compiler) lookupGeneric(templates map[string]*GenericTemplate, name string) (*GenericTemplate, bool) {
+	if c.currentModule != nil {
+		if tmpl, ok := templates[c.currentModule.Prefix+name]; ok {
+			return tmpl, true
+		}
+		if alias, ok := c.currentModule.SymbolAliases[name]; ok {
+			if tmpl, ok := templates[alias]; ok {
+				return tmpl, true
+			}
+		}
+	}
+	tmpl, ok := templates[name]
+	return tmpl, ok
 }
 
-func (c *Compiler) emitGCTrace(out *bytes.Buffer) {
-	out.WriteString("(func $gc_trace (param $ptr i32) (param $type_id i32)\n")
-	out.WriteString("  (local $i i32)\n")
-	out.WriteString("  (local $cnt i32)\n")
-	
-	// Create block for each class + default
-	// br_table [default, array, class1, class2...]
-	// TypeID: 0=String(ignore), 1=Array, 20=ArrayData, 2+=Classes
-	
-	// TypeID 1: Array (has data pointer at offset 8)
-	out.WriteString("  local.get $type_id\n")
-	out.WriteString("  i32.const 1\n")
-	out.WriteString("  i32.eq\n")
-	out.WriteString("  if\n")
-	out.WriteString("    local.get $ptr\n")
-	out.WriteString("    i32.const 8\n")
-	out.WriteString("    i32.add\n")
-	out.WriteString("    i32.load\n")
-	out.WriteString("    call $gc_mark\n")
-	out.WriteString("    return\n")
-	out.WriteString("  end\n")
+// inModule 在 module 中运行 f：泛型声明中的名字在声明它的模块中解析
+func (c *Compiler) inModule(module *ModuleScope, f func() error) error {
+	saved := c.currentModule
+	c.currentModule = module
+	defer func() { c.currentModule = saved }()
+	return f()
+}
 
-	// TypeID 20: ArrayData (buffer of elements)
-	out.WriteString("  local.get $type_id\n")
-	out.WriteString("  i32.const 20\n")
-	out.WriteString("  i32.eq\n")
-	out.WriteString("  if\n")
-	// Scan elements. Size is in header (ptr-12). But header size is bytes.
-	// We need element count = size / 4.
-	out.WriteString("    local.get $ptr\n")
-	out.WriteString("    i32.const 12\n")
-	out.WriteString("    i32.sub\n")
-	out.WriteString("    i32.load\n")
-	out.WriteString("    i32.const 4\n")
-	out.WriteString("    i32.div_u\n")
-	out.WriteString("    local.set $cnt\n")
-	
-	out.WriteString("    i32.const 0\n")
-	out.WriteString("    local.set $i\n")
-	
-	out.WriteString("    (block $done_trace\n")
-	out.WriteString("      (loop $trace\n")
-	out.WriteString("        local.get $i\n")
-	out.WriteString("        local.get $cnt\n")
-	out.WriteString("        i32.ge_u\n")
-	out.WriteString("        br_if $done_trace\n")
-	
-	out.WriteString("        local.get $ptr\n")
-	out.WriteString("        local.get $i\n")
-	out.WriteString("        i32.const 4\n")
-	out.WriteString("        i32.mul\n")
-	out.WriteString("        i32.add\n")
-	out.WriteString("        i32.load\n")
-	out.WriteString("        call $gc_mark\n")
-	
-	out.WriteString("        local.get $i\n")
-	out.WriteString("        i32.const 1\n")
-	out.WriteString("        i32.add\n")
-	out.WriteString("        local.set $i\n")
-	out.WriteString("        br $trace\n")
-	out.WriteString("      )\n")
-	out.WriteString("    )\n")
-	out.WriteString("    return\n")
-	out.WriteString("  end\n")
+// declaresMethod 判断类声明中是否有键为 key 的实例方法
+func declaresMethod(node *ast.ClassStatement, key string) bool {
+	for _, method := range node.Methods {
+		if !method.Static && methodKey(method) == key {
+			return true
+		}
+	}
+	return false
+}
 
-	// TypeID 2: Map (capacity, count, buckets)
-	out.WriteString("  local.get $type_id\n")
-	out.WriteString("  i32.const 2\n")
-	out.WriteString("  i32.eq\n")
-	out.WriteString("  if\n")
-	out.WriteString("    local.get $ptr\n")
-	out.WriteString("    i32.const 8\n")
-	out.WriteString("    i32.add\n")
-	out.WriteString("    i32.load\n")
-	out.WriteString("    call $gc_mark\n")
-	out.WriteString("    return\n")
-	out.WriteString("  end\n")
+// typeNameSlot 是节点中写有类型名的一个位置，line 用于报错
+type typeNameSlot struct {
+	name *string
+	line int
+}
 
-	// TypeID 21: MapBuckets (array of pointers) -> Same logic as ArrayData basically
-	// Size is fixed 64 (16 buckets * 4 bytes) currently, but better to read size from header
-	out.WriteString("  local.get $type_id\n")
-	out.WriteString("  i32.const 21\n")
-	out.WriteString("  i32.eq\n")
-	out.WriteString("  if\n")
-	out.WriteString("    local.get $ptr\n")
-	out.WriteString("    i32.const 12\n")
-	out.WriteString("    i32.sub\n")
-	out.WriteString("    i32.load\n")
-	out.WriteString("    i32.const 4\n")
-	out.WriteString("    i32.div_u\n")
-	out.WriteString("    local.set $cnt\n")
-	
-	out.WriteString("    i32.const 0\n")
-	out.WriteString("    local.set $i\n")
-	
-	out.WriteString("    (block $done_trace\n")
-	out.WriteString("      (loop $trace\n")
-	out.WriteString("        local.get $i\n")
-	out.WriteString("        local.get $cnt\n")
-	out.WriteString("        i32.ge_u\n")
-	out.WriteString("        br_if $done_trace\n")
-	
-	out.WriteString("        local.get $ptr\n")
-	out.WriteString("        local.get $i\n")
-	out.WriteString("        i32.const 4\n")
-	out.WriteString("        i32.mul\n")
-	out.WriteString("        i32.add\n")
-	out.WriteString("        i32.load\n")
-	out.WriteString("        call $gc_mark\n")
-	
-	out.WriteString("        local.get $i\n")
-	out.WriteString("        i32.const 1\n")
-	out.WriteString("        i32.add\n")
-	out.WriteString("        local.set $i\n")
-	out.WriteString("        br $trace\n")
-	out.WriteString("      )\n")
-	out.WriteString("    )\n")
-	out.WriteString("    return\n")
-	out.WriteString("  end\n")
+// typeNameSlots 返回节点中所有写有类型名的位置：参数、返回值、变量和字段的类型，父类，new 的类型实参
+func typeNameSlots(node ast.Node) []typeNameSlot {
+	var slots []typeNameSlot
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FunctionLiteral:
+			for _, p := range n.Parameters {
+				slots = append(slots, typeNameSlot{&p.Type, n.Token.Line})
+			}
+			slots = append(slots, typeNameSlot{&n.ReturnType, n.Token.Line})
+		case *ast.LetStatement:
+			slots = append(slots, typeNameSlot{&n.Type, n.Token.Line})
+		case *ast.NewExpression:
+			for i := range n.TypeArgs {
+				slots = append(slots, typeNameSlot{&n.TypeArgs[i], n.Token.Line})
+			}
+		case *ast.CallExpression:
+			for i := range n.TypeArgs {
+				slots = append(slots, typeNameSlot{&n.TypeArgs[i], n.Token.Line})
+			}
+		case *ast.ClassStatement:
+			for _, field := range n.Fields {
+				slots = append(slots, typeNameSlot{&field.Type, n.Token.Line})
+			}
+			if n.Parent != nil {
+				slots = append(slots, typeNameSlot{&n.Parent.Value, n.Token.Line})
+			}
+		}
+		return true
+	})
+	return slots
+}
 
-	// TypeID 22: MapEntry (key, value, next)
-	out.WriteString("  local.get $type_id\n")
-	out.WriteString("  i32.const 22\n")
-	out.WriteString("  i32.eq\n")
-	out.WriteString("  if\n")
-	out.WriteString("    local.get $ptr\n")
-	out.WriteString("    i32.load\n")
-	out.WriteString("    call $gc_mark\n") // key
-	
-	out.WriteString("    local.get $ptr\n")
-	out.WriteString("    i32.const 4\n")
-	out.WriteString("    i32.add\n")
-	out.WriteString("    i32.load\n")
-	out.WriteString("    call $gc_mark\n") // value
-	
-	out.WriteString("    local.get $ptr\n")
-	out.WriteString("    i32.const 8\n")
-	out.WriteString("    i32.add\n")
-	out.WriteString("    i32.load\n")
-	out.WriteString("    call $gc_mark\n") // next
-	out.WriteString("    return\n")
-	out.WriteString("  end\n")
+// expandTypeNames 展开节点中的所有类型名 (见 expandTypeName)
+func (c *Compiler) expandTypeNames(node ast.Node, subst map[string]string) error {
+	for _, slot := range typeNameSlots(node) {
+		expanded, err := c.expandTypeName(*slot.name, subst)
+		if err != nil {
+			if strings.HasPrefix(err.Error(), "line ") {
+				return err
+			}
+			return fmt.Errorf("line %d: %v", slot.line, err)
+		}
+		*slot.name = expanded
+	}
+	return nil
+}
 
-	for _, cls := range c.classes {
-		out.WriteString(fmt.Sprintf("  ;; Class %s (TypeID %d)\n", cls.Name, cls.TypeID))
-		out.WriteString("  local.get $type_id\n")
-		out.WriteString(fmt.Sprintf("  i32.const %d\n", cls.TypeID))
-		out.WriteString("  i32.eq\n")
-		out.WriteString("  if\n")
-		
-		// Scan fields
-		for name, offset := range cls.Fields {
-			fieldType := cls.FieldTypes[name]
-			// Only trace pointer types
-			// Assuming Int/Bool are values. String is pointer but leaf (no trace inside).
-			// Array and Class types need tracing.
-			// How do we know if a field is a Class type?
-			// DataType is string. If it's not int/bool/string/void, it's a class or array.
-			
-			if fieldType != TypeInt && fieldType != TypeBool && fieldType != TypeString && fieldType != TypeVoid {
-				out.WriteString(fmt.Sprintf("    ;; Field %s (offset %d)\n", name, offset))
-				out.WriteString("    local.get $ptr\n")
-				out.WriteString(fmt.Sprintf("    i32.const %d\n", offset))
-				out.WriteString("    i32.add\n")
-				out.WriteString("    i32.load\n")
-				out.WriteString("    call $gc_mark\n")
+// expandTypeName 把类型名中的类型参数替换为 subst 中的类型实参，并把泛型类的引用实例化：
+// "Array<Box<T>>" 在 T = int 时展开为 "Array<Box__int>"
+func (c *Compiler) expandTypeName(typeName string, subst map[string]string) (string, error) {
+	var out strings.Builder
+	for i := 0; i < len(typeName); {
+		start := i
+		i = typeNameEnd(typeName, i)
+		if i == start {
+			out.WriteByte(typeName[i])
+			i++
+			continue
+		}
+		name := typeName[start:i]
+		if arg, ok := subst[name]; ok {
+			out.WriteString(arg)
+			continue
+		}
+		tmpl, ok := c.lookupGeneric(c.genericClasses, name)
+		if !ok {
+			out.WriteString(name)
+			continue
+		}
+		end := -1
+		if i < len(typeName) && typeName[i] == '<' {
+			end = closingAngle(typeName, i)
+		}
+		if end < 0 {
+			return "", fmt.Errorf("generic class %s needs type arguments, e.g. %s<int>", name, name)
+		}
+		args := splitTypeList(typeName[i+1 : end])
+		for k, arg := range args {
+			expanded, err := c.expandTypeName(arg, subst)
+			if err != nil {
+				return "", err
 			}
+			args[k] = expanded
 		}
-		
-		out.WriteString("    return\n")
-		out.WriteString("  end\n")
+		instance, err := c.instantiateClass(tmpl, args)
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(instance)
+		i = end + 1
 	}
-	
-	out.WriteString(")\n")
+	return out.String(), nil
 }
 
-func (c *Compiler) defineEnum(node *ast.EnumStatement) error {
-	enumName := node.Name.Value
-	if c.currentModule != nil && c.currentModule.Prefix != "" {
-		enumName = c.currentModule.Prefix + node.Name.Value
+// substituteTypeParams 只替换类型名中的类型参数，不实例化泛型类
+func substituteTypeParams(typeName string, subst map[string]string) string {
+	var out strings.Builder
+	for i := 0; i < len(typeName); {
+		start := i
+		i = typeNameEnd(typeName, i)
+		if i == start {
+			out.WriteByte(typeName[i])
+			i++
+		} else if arg, ok := subst[typeName[start:i]]; ok {
+			out.WriteString(arg)
+		} else {
+			out.WriteString(typeName[start:i])
+		}
 	}
+	return out.String()
+}
 
-	if _, exists := c.enums[enumName]; exists {
-		return fmt.Errorf("enum %s already defined", enumName)
-	}
+// isTypeNameChar 判断字符能否出现在类型名中 (与标识符一致，包括 Unicode 字母和数字)
+func isTypeNameChar(ch rune) bool {
+	return ch == '_' || ('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z') || ('0' <= ch && ch <= '9') ||
+		ch >= utf8.RuneSelf && (unicode.IsLetter(ch) || unicode.IsDigit(ch) || unicode.Is(unicode.Mn, ch) || unicode.Is(unicode.Mc, ch))
+}
 
-	members := make(map[string]int)
-	currentValue := 0
+// typeNameEnd 返回 s[i:] 开头的类型名之后的位置；s[i] 不是类型名字符时返回 i
+func typeNameEnd(s string, i int) int {
+	for i < len(s) {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if !isTypeNameChar(r) {
+			break
+		}
+		i += size
+	}
+	return i
+}
 
-	for _, member := range node.Members {
-		if member.Value != nil {
-			// Evaluate constant value
-			// For now, only support integer literals
-			if intLit, ok := member.Value.(*ast.IntegerLiteral); ok {
-				currentValue = int(intLit.Value)
-			} else {
-				return fmt.Errorf("enum member value must be integer literal")
+// closingAngle 返回与 s[open] 处的 '<' 匹配的 '>' 的位置 (函数类型中的 "=>" 不算)，没有时返回 -1
+func closingAngle(s string, open int) int {
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch {
+		case s[i] == '<':
+			depth++
+		case s[i] == '>' && s[i-1] != '=':
+			depth--
+			if depth == 0 {
+				return i
 			}
 		}
-		
-		members[member.Name.Value] = currentValue
-		currentValue++
 	}
+	return -1
+}
 
-	c.enums[enumName] = members
-	return nil
+// splitGenericType 把 "Map<string, int>" 分为 "Map" 和 ["string", "int"]；不是泛型类型时 args 为 nil
+func splitGenericType(typeName string) (string, []string) {
+	open := strings.Index(typeName, "<")
+	if open <= 0 || closingAngle(typeName, open) != len(typeName)-1 {
+		return typeName, nil
+	}
+	return typeName[:open], splitTypeList(typeName[open+1 : len(typeName)-1])
 }
 
-func (c *Compiler) defineClass(node *ast.ClassStatement) error {
-	className := node.Name.Value
-	if c.currentModule != nil && c.currentModule.Prefix != "" {
-		className = c.currentModule.Prefix + node.Name.Value
+// instanceName 返回泛型实例的名字：Box<int> -> Box__int，Pair<string, Array<int>> -> Pair__string__Array_int
+// 非 ASCII 字符按 UTF-8 字节写成 _uXX (与 asciiWATIdentifiers 相同)，Box<Größe> 和 Box<Grüße> 不会得到相同的名字
+func instanceName(name string, args []string) string {
+	parts := []string{name}
+	for _, arg := range args {
+		words := strings.FieldsFunc(arg, func(r rune) bool { return !isTypeNameChar(r) })
+		for i, word := range words {
+			var escaped strings.Builder
+			for k := 0; k < len(word); k++ {
+				if word[k] >= utf8.RuneSelf {
+					fmt.Fprintf(&escaped, "_u%02x", word[k])
+				} else {
+					escaped.WriteByte(word[k])
+				}
+			}
+			words[i] = escaped.String()
+		}
+		parts = append(parts, strings.Join(words, "_"))
 	}
+	return strings.Join(parts, "__")
+}
 
-	classSymbol := ClassSymbol{
-		Name:       className,
-		Fields:     make(map[string]int),
-		FieldTypes: make(map[string]DataType),
-		Methods:    make(map[string]string),
-		TypeID:     c.nextTypeID,
+// displayTypeArgs 返回类型实参在错误信息中的写法，例如 "<int, Animal>"
+func displayTypeArgs(args []string) string {
+	names := make([]string, len(args))
+	for i, arg := range args {
+		names[i] = displayClassName(arg)
 	}
-	c.nextTypeID++
+	return "<" + strings.Join(names, ", ") + ">"
+}
 
-	offset := 0
+// displayInstanceName 返回泛型实例在错误信息中的写法：Box__Box__int -> Box<Box<int>>
+func (c *Compiler) displayInstanceName(name string) string {
+	inst, ok := c.genericInstances[name]
+	if !ok {
+		return displayClassName(name)
+	}
+	args := make([]string, len(inst.TypeArgs))
+	for i, arg := range inst.TypeArgs {
+		args[i] = c.displayInstanceName(arg)
+	}
+	return displayClassName(inst.Template.Name) + "<" + strings.Join(args, ", ") + ">"
+}
 
-	// Inherit from parent
-	if node.Parent != nil {
-		parentName := node.Parent.Value
-		parentSym, ok := c.classes[parentName]
-		if !ok {
-			return fmt.Errorf("undefined parent class: %s", parentName)
+// bindTypeArgs 检查类型实参的个数和 extends 约束，返回类型参数到类型实参的映射
+func (c *Compiler) bindTypeArgs(tmpl *GenericTemplate, args []string) (map[string]string, error) {
+	name := displayClassName(tmpl.Name)
+	if len(args) != len(tmpl.TypeParams) {
+		return nil, fmt.Errorf("%s expects %d type arguments, got %d", name, len(tmpl.TypeParams), len(args))
+	}
+	subst := make(map[string]string)
+	for i, tp := range tmpl.TypeParams {
+		subst[tp.Name] = args[i]
+	}
+	err := c.inModule(tmpl.Module, func() error {
+		for i, tp := range tmpl.TypeParams {
+			if tp.Constraint == "" {
+				continue
+			}
+			constraint, err := c.expandTypeName(tp.Constraint, subst)
+			if err != nil {
+				return err
+			}
+			if !c.satisfiesConstraint(args[i], constraint) {
+				return fmt.Errorf("type %s does not satisfy the constraint %s of type parameter %s of %s", displayClassName(args[i]), tp.Constraint, tp.Name, name)
+			}
 		}
-		classSymbol.Parent = parentName
-		
-		// Copy fields
-		for k, v := range parentSym.Fields {
-			classSymbol.Fields[k] = v
+		return nil
+	})
+	return subst, err
+}
+
+// satisfiesConstraint 判断类型实参是否满足 extends 约束：接口要求类在结构上实现它，类要求是它或它的子类，
+// 联合类型要求满足其中一个成员
+func (c *Compiler) satisfiesConstraint(arg, constraint string) bool {
+	for _, member := range unionMembers(constraint) {
+		if member == arg || (member == "number" && arg == "int") {
+			return true
 		}
-		for k, v := range parentSym.FieldTypes {
-			classSymbol.FieldTypes[k] = v
+		if iface, ok := c.interfaces[member]; ok {
+			if cls, ok := c.classes[arg]; ok && c.conformsTo(cls, iface) {
+				return true
+			}
+			continue
 		}
-		offset = parentSym.Size
-		
-		// Copy methods
-		for k, v := range parentSym.Methods {
-			classSymbol.Methods[k] = v
+		if class := c.resolveClassName(member); class == arg || c.isSubclassOf(arg, class) {
+			return true
 		}
 	}
+	return false
+}
 
-	// Add new fields
-	for _, field := range node.Fields {
-		classSymbol.Fields[field.Name.Value] = offset
-		
-		// Parse type
-		dataType := c.resolveType(field.Type)
-		classSymbol.FieldTypes[field.Name.Value] = dataType
-		
-		offset += 4 
+// canonicalTypeArgs 把类型实参中的类名换成带模块前缀的全名，使其在声明泛型的模块中也能解析
+func (c *Compiler) canonicalTypeArgs(args []string) []string {
+	canonical := make([]string, len(args))
+	for i, arg := range args {
+		canonical[i] = arg
+		if class := c.resolveClassName(arg); class != arg {
+			if _, ok := c.classes[class]; ok {
+				canonical[i] = class
+			}
+		}
 	}
-	classSymbol.Size = offset
+	return canonical
+}
 
-	// Add/Override methods
-	for _, method := range node.Methods {
-		mangledName := fmt.Sprintf("%s_%s", className, method.Name)
-		classSymbol.Methods[method.Name] = mangledName
+// instantiateClass 返回泛型类对于类型实参 args 的实例类名，第一次用到时定义该实例
+// 实例的方法由 compileGenericInstances 稍后编译
+func (c *Compiler) instantiateClass(tmpl *GenericTemplate, args []string) (string, error) {
+	args = c.canonicalTypeArgs(args)
+	local := instanceName(tmpl.Class.Name.Value, args)
+	name := tmpl.Module.Prefix + local
+	if _, ok := c.genericInstances[name]; ok {
+		return name, nil
+	}
+	subst, err := c.bindTypeArgs(tmpl, args)
+	if err != nil {
+		return "", err
+	}
+	node := ast.Clone(tmpl.Class).(*ast.ClassStatement)
+	node.Name.Value = local
+	node.TypeParams = nil
+	inst := &GenericInstance{Template: tmpl, TypeArgs: args, Class: node}
+	// Registered first: fields such as next: Node<T> refer to the instance being defined
+	c.genericInstances[name] = inst
+	err = c.inModule(tmpl.Module, func() error {
+		if err := c.expandTypeNames(node, subst); err != nil {
+			return err
+		}
+		return c.defineClass(node)
+	})
+	if err != nil {
+		return "", err
 	}
+	c.pendingInstances = append(c.pendingInstances, inst)
+	return name, nil
+}
 
-	c.classes[className] = classSymbol
-	return nil
+// instantiateFunction 返回泛型函数对于类型实参 args 的实例名和签名，第一次用到时登记该实例
+// 函数体由 compileGenericInstances 稍后编译
+func (c *Compiler) instantiateFunction(tmpl *GenericTemplate, args []string) (string, FunctionSignature, error) {
+	args = c.canonicalTypeArgs(args)
+	local := instanceName(tmpl.Function.Name, args)
+	name := tmpl.Module.Prefix + local
+	if _, ok := c.genericInstances[name]; ok {
+		return name, c.definedFuncs[name], nil
+	}
+	subst, err := c.bindTypeArgs(tmpl, args)
+	if err != nil {
+		return "", FunctionSignature{}, err
+	}
+	fn := ast.Clone(tmpl.Function).(*ast.FunctionLiteral)
+	fn.Name = local
+	fn.TypeParams = nil
+	var sig FunctionSignature
+	err = c.inModule(tmpl.Module, func() error {
+		if err := c.expandTypeNames(fn, subst); err != nil {
+			return err
+		}
+		sig = c.functionSignature(fn)
+		return nil
+	})
+	if err != nil {
+		return "", FunctionSignature{}, err
+	}
+	c.definedFuncs[name] = sig
+	c.funcIDs[name] = c.nextFuncID
+	c.nextFuncID++
+	inst := &GenericInstance{Template: tmpl, TypeArgs: args, Function: fn}
+	c.genericInstances[name] = inst
+	c.pendingInstances = append(c.pendingInstances, inst)
+	return name, sig, nil
 }
 
-func (c *Compiler) compileClassMethods(node *ast.ClassStatement) error {
-	className := node.Name.Value
-	if c.currentModule != nil && c.currentModule.Prefix != "" {
-		className = c.currentModule.Prefix + node.Name.Value
+// compileGenericInstances 编译已实例化的泛型函数体和泛型类的方法；编译中用到的新实例也在这里编译
+func (c *Compiler) compileGenericInstances() error {
+	for len(c.pendingInstances) > 0 {
+		inst := c.pendingInstances[0]
+		c.pendingInstances = c.pendingInstances[1:]
+		err := c.inModule(inst.Template.Module, func() error {
+			if inst.Function != nil {
+				return c.compileFunction(inst.Function)
+			}
+			if err := c.compileClassMethods(inst.Class); err != nil {
+				return err
+			}
+			if err := c.checkAbstractImplementation(inst.Class); err != nil {
+				return err
+			}
+			return c.checkInterfaceImplementation(inst.Class)
+		})
+		if err != nil {
+			return fmt.Errorf("%v (in %s%s)", err, displayClassName(inst.Template.Name), displayTypeArgs(inst.TypeArgs))
+		}
 	}
+	return nil
+}
 
-	c.currentClass = className
-	defer func() { c.currentClass = "" }()
+// compiledArg 已编译但尚未输出的实参
+type compiledArg struct {
+	Code     []string
+	Type     DataType
+	TypeName string
+}
 
-	for _, method := range node.Methods {
-		mangledName := fmt.Sprintf("%s_%s", className, method.Name)
+// compileInferredArgs 编译泛型调用的实参 (先不输出)，并用实参类型与参数的声明类型推断类型参数，结果存入 bound
+// 函数字面量最后编译：它的参数类型来自其他实参推断出的类型，返回类型再由它推断
+func (c *Compiler) compileInferredArgs(tmpl *GenericTemplate, params []*ast.FieldDefinition, args []ast.Expression, bound map[string]string, line int) ([]compiledArg, error) {
+	compiled := make([]compiledArg, len(args))
+	compile := func(i int) error {
+		pattern := ""
+		if i < len(params) {
+			pattern = params[i].Type
+		}
+		if _, ok := args[i].(*ast.FunctionLiteral); ok && pattern != "" {
+			c.expectedFuncType = c.expectedArgType(tmpl, pattern, bound)
+		}
+		code, t, typeName, err := c.compileAside(args[i])
+		c.expectedFuncType = ""
+		if err != nil {
+			return err
+		}
+		compiled[i] = compiledArg{Code: code, Type: t, TypeName: typeName}
+		if err := c.unifyTypeArgs(tmpl, pattern, typeNameOf(t, typeName), bound); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		return nil
+	}
+	var literals []int
+	for i, arg := range args {
+		switch arg.(type) {
+		case *ast.SpreadElement:
+			return nil, fmt.Errorf("line %d: spread arguments are not supported in calls to generic %s", line, displayClassName(tmpl.Name))
+		case *ast.FunctionLiteral:
+			literals = append(literals, i)
+			continue
+		}
+		if err := compile(i); err != nil {
+			return nil, err
+		}
+	}
+	for _, i := range literals {
+		if err := compile(i); err != nil {
+			return nil, err
+		}
+	}
+	return compiled, nil
+}
 
-		scope := NewFunctionScope(mangledName)
-		c.current = scope
-		c.functions = append(c.functions, scope)
+// expectedArgType 返回函数字面量实参期望的函数类型：已推断的类型参数替换为实参类型，
+// 其余的为 void，使函数字面量自己推断返回类型
+func (c *Compiler) expectedArgType(tmpl *GenericTemplate, pattern string, bound map[string]string) string {
+	subst := make(map[string]string)
+	complete := true
+	for _, tp := range tmpl.TypeParams {
+		if arg, ok := bound[tp.Name]; ok {
+			subst[tp.Name] = arg
+		} else {
+			subst[tp.Name] = "void"
+			complete = false
+		}
+	}
+	if !complete {
+		return substituteTypeParams(pattern, subst)
+	}
+	expected := pattern
+	err := c.inModule(tmpl.Module, func() error {
+		var err error
+		expected, err = c.expandTypeName(pattern, subst)
+		return err
+	})
+	if err != nil {
+		return substituteTypeParams(pattern, subst)
+	}
+	return expected
+}
 
-		// Add 'this' parameter as first parameter
-		scope.Symbols["this"] = Symbol{Index: 0, Type: TypeInt, IsParam: true}
-		scope.ParamTypes = append(scope.ParamTypes, TypeInt)
-		scope.ParamCount++
+// unifyTypeArgs 对齐参数的声明类型 pattern 与实参类型 actual，把 pattern 中的类型参数绑定到 actual 中对应的部分
+// int 和 number 合并为 number；其他冲突是错误
+func (c *Compiler) unifyTypeArgs(tmpl *GenericTemplate, pattern, actual string, bound map[string]string) error {
+	if pattern == "" {
+		return nil
+	}
+	for _, tp := range tmpl.TypeParams {
+		if tp.Name != pattern {
+			continue
+		}
+		prev, ok := bound[pattern]
+		switch {
+		case !ok || prev == actual:
+			bound[pattern] = actual
+		case (prev == "int" && actual == "number") || (prev == "number" && actual == "int"):
+			bound[pattern] = "number"
+		default:
+			return fmt.Errorf("type parameter %s of %s is inferred as both %s and %s", pattern, displayClassName(tmpl.Name), displayClassName(prev), displayClassName(actual))
+		}
+		return nil
+	}
 
-		for i, param := range method.Parameters {
-			t := c.resolveType(param.Type)
-			scope.Symbols[param.Name.Value] = Symbol{Index: i + 1, Type: t, IsParam: true}
-			scope.ParamTypes = append(scope.ParamTypes, t)
-			scope.ParamCount++
+	if strings.HasPrefix(pattern, "(") {
+		// Function types: parameters and return type in order
+		want, ok := c.funcSignatureFromTypeName(pattern)
+		got, ok2 := c.funcSignatureFromTypeName(actual)
+		if !ok || !ok2 || len(want.ParamTypeNames) != len(got.ParamTypeNames) {
+			return nil
+		}
+		for i := range want.ParamTypeNames {
+			if err := c.unifyTypeArgs(tmpl, want.ParamTypeNames[i], got.ParamTypeNames[i], bound); err != nil {
+				return err
+			}
 		}
+		return c.unifyTypeArgs(tmpl, want.ReturnTypeName, got.ReturnTypeName, bound)
+	}
 
-		if err := c.Compile(method.Body); err != nil {
+	name, args := splitGenericType(pattern)
+	if args == nil {
+		return nil
+	}
+	if actual == "array" {
+		actual = "Array<int>" // Arrays without an element type hold ints
+	}
+	actualName, actualArgs := splitGenericType(actual)
+	if inst, ok := c.genericInstances[actual]; ok && inst.Class != nil {
+		// Box<T> against Box__int
+		actualName, actualArgs = inst.Template.Class.Name.Value, inst.TypeArgs
+	}
+	if name != actualName || len(args) != len(actualArgs) {
+		return nil
+	}
+	for i := range args {
+		if err := c.unifyTypeArgs(tmpl, args[i], actualArgs[i], bound); err != nil {
 			return err
 		}
-
-		c.emit("i32.const 0")
 	}
 	return nil
 }
 
-func (c *Compiler) defineInterface(node *ast.InterfaceStatement) error {
-	if _, ok := c.interfaces[node.Name.Value]; ok {
-		return fmt.Errorf("interface %s already defined", node.Name.Value)
+// inferredTypeArgs 按声明顺序返回推断出的类型实参
+func (c *Compiler) inferredTypeArgs(tmpl *GenericTemplate, bound map[string]string, line int) ([]string, error) {
+	args := make([]string, len(tmpl.TypeParams))
+	for i, tp := range tmpl.TypeParams {
+		arg, ok := bound[tp.Name]
+		if !ok {
+			if tmpl.Class != nil {
+				return nil, fmt.Errorf("line %d: cannot infer type argument %s of %s from the constructor arguments, write new %s<...>(...)", line, tp.Name, displayClassName(tmpl.Name), displayClassName(tmpl.Name))
+			}
+			return nil, fmt.Errorf("line %d: cannot infer type argument %s of %s from the arguments", line, tp.Name, displayClassName(tmpl.Name))
+		}
+		args[i] = arg
 	}
-	
-	sym := InterfaceSymbol{
-		Name:    node.Name.Value,
-		Methods: make(map[string]*ast.MethodSignature),
+	return args, nil
+}
+
+// emitCompiledArgs 按原来的顺序输出已编译的实参并转换为参数类型，省略的参数使用默认值
+func (c *Compiler) emitCompiledArgs(args []compiledArg, sig FunctionSignature, context string) error {
+	var bound map[string]Symbol
+	if len(args) < len(sig.ParamTypes) && defaultsUseParams(sig) {
+		bound = make(map[string]Symbol)
 	}
-	
-	for _, m := range node.Methods {
-		sym.Methods[m.Name] = m
+	for i, arg := range args {
+		c.current.Instructions = append(c.current.Instructions, arg.Code...)
+		c.stackTypeName = arg.TypeName
+		if err := c.emitConvert(arg.Type, sig.ParamTypes[i], fmt.Sprintf("argument %d of %s", i+1, context)); err != nil {
+			return err
+		}
+		if bound != nil {
+			bound[sig.ParamNames[i]] = c.keepArg(sig.ParamTypes[i], sig.ParamTypeNames[i])
+		}
+	}
+	for i := len(args); i < len(sig.ParamTypes) && i < len(sig.Defaults); i++ {
+		if err := c.compileDefaultArg(sig, i, bound, context); err != nil {
+			return err
+		}
 	}
-	
-	c.interfaces[node.Name.Value] = sym
 	return nil
 }
 
-func (c *Compiler) checkInterfaceImplementation(node *ast.ClassStatement) error {
-	className := node.Name.Value
-	if c.currentModule != nil && c.currentModule.Prefix != "" {
-		className = c.currentModule.Prefix + node.Name.Value
+// compileGenericCall 调用泛型函数：使用写出的类型实参或从实参推断，调用对应的实例
+func (c *Compiler) compileGenericCall(tmpl *GenericTemplate, node *ast.CallExpression) error {
+	context := tmpl.Function.Name
+	if len(node.TypeArgs) > 0 {
+		// Written type arguments: the arguments are checked against the instance like any call
+		name, sig, err := c.instantiateFunction(tmpl, node.TypeArgs)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", node.Token.Line, err)
+		}
+		if err := checkArgCount(sig, node.Arguments, context); err != nil {
+			return err
+		}
+		if err := c.compileArgs(node.Arguments, sig, context); err != nil {
+			return err
+		}
+		c.emit(fmt.Sprintf("call $%s", name))
+		c.setCallResult(sig)
+		return nil
 	}
+	bound := make(map[string]string)
+	args, err := c.compileInferredArgs(tmpl, tmpl.Function.Parameters, node.Arguments, bound, node.Token.Line)
+	if err != nil {
+		return err
+	}
+	typeArgs, err := c.inferredTypeArgs(tmpl, bound, node.Token.Line)
+	if err != nil {
+		return err
+	}
+	name, sig, err := c.instantiateFunction(tmpl, typeArgs)
+	if err != nil {
+		return fmt.Errorf("line %d: %v", node.Token.Line, err)
+	}
+	if err := checkArgCount(sig, node.Arguments, context); err != nil {
+		return err
+	}
+	if err := c.emitCompiledArgs(args, sig, context); err != nil {
+		return err
+	}
+	c.emit(fmt.Sprintf("call $%s", name))
+	c.setCallResult(sig)
+	return nil
+}
 
-	classSym, ok := c.classes[className]
-	if !ok { return fmt.Errorf("internal error: class %s not found", className) }
-
-	for _, impl := range node.Implements {
-		ifaceName := impl.Value
-		ifaceSym, ok := c.interfaces[ifaceName]
-		if !ok {
-			return fmt.Errorf("class %s implements undefined interface %s", className, ifaceName)
-		}
-		
-		for methodName := range ifaceSym.Methods {
-			if _, hasMethod := classSym.Methods[methodName]; !hasMethod {
-				return fmt.Errorf("class %s does not implement method %s from interface %s", className, methodName, ifaceName)
+// compileGenericNew 创建泛型类的实例：类型实参写在 new Box<int>(...) 中，或从构造函数的实参推断
+func (c *Compiler) compileGenericNew(tmpl *GenericTemplate, node *ast.NewExpression) error {
+	typeArgs := node.TypeArgs
+	var args []compiledArg
+	inferred := len(typeArgs) == 0
+	if inferred {
+		var params []*ast.FieldDefinition
+		for _, method := range tmpl.Class.Methods {
+			if method.Name == "init" && method.Accessor == "" {
+				params = method.Parameters
 			}
 		}
+		bound := make(map[string]string)
+		var err error
+		if args, err = c.compileInferredArgs(tmpl, params, node.Arguments, bound, node.Token.Line); err != nil {
+			return err
+		}
+		if typeArgs, err = c.inferredTypeArgs(tmpl, bound, node.Token.Line); err != nil {
+			return err
+		}
 	}
-	return nil
+	className, err := c.instantiateClass(tmpl, typeArgs)
+	if err != nil {
+		return fmt.Errorf("line %d: %v", node.Token.Line, err)
+	}
+	return c.compileNew(node, className, func(sig FunctionSignature, context string) error {
+		if err := checkArgCount(sig, node.Arguments, context); err != nil {
+			return err
+		}
+		if inferred {
+			return c.emitCompiledArgs(args, sig, context)
+		}
+		return c.compileArgs(node.Arguments, sig, context)
+	})
 }
@@ -1,6 +1,11 @@
 package lexer
 
 import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
 	"omniScript/pkg/token"
 )
 
@@ -8,9 +13,10 @@ type Lexer struct {
 	input        string
 	position     int  // 当前字符位置
 	readPosition int  // 当前读取位置（当前字符之后）
-	ch           byte // 当前正在查看的字符
+	ch           rune // 当前正在查看的字符 (按 UTF-8 解码)
 	line         int
 	column       int
+	pendingDoc   string // Last /** */ comment, attached to the next token
 }
 
 func New(input string) *Lexer {
@@ -20,17 +26,27 @@ func New(input string) *Lexer {
 }
 
 func (l *Lexer) readChar() {
+	size := 1
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
-		l.ch = l.input[l.readPosition]
+		l.ch, size = utf8.DecodeRuneInString(l.input[l.readPosition:])
 	}
 	l.position = l.readPosition
-	l.readPosition += 1
+	l.readPosition += size
 	l.column++
 }
 
 func (l *Lexer) NextToken() token.Token {
+	tok := l.nextToken()
+	if l.pendingDoc != "" {
+		tok.Doc = l.pendingDoc
+		l.pendingDoc = ""
+	}
+	return tok
+}
+
+func (l *Lexer) nextToken() token.Token {
 	var tok token.Token
 
 	l.skipWhitespace()
@@ -38,38 +54,129 @@ func (l *Lexer) NextToken() token.Token {
 	switch l.ch {
 	case '=':
 		if l.peekChar() == '=' {
-			ch := l.ch
-			l.readChar()
-			literal := string(ch) + string(l.ch)
-			tok = token.Token{Type: token.EQ, Literal: literal, Line: l.line, Column: l.column}
+			if l.peekCharAt(1) == '=' {
+				tok = l.readOperator(token.STRICT_EQ, 3)
+			} else {
+				tok = l.readOperator(token.EQ, 2)
+			}
+		} else if l.peekChar() == '>' {
+			tok = l.readOperator(token.ARROW, 2)
 		} else {
 			tok = newToken(token.ASSIGN, l.ch, l.line, l.column)
 		}
 	case '+':
-		tok = newToken(token.PLUS, l.ch, l.line, l.column)
+		switch l.peekChar() {
+		case '+':
+			tok = l.readOperator(token.INCREMENT, 2)
+		case '=':
+			tok = l.readOperator(token.PLUS_ASSIGN, 2)
+		default:
+			tok = newToken(token.PLUS, l.ch, l.line, l.column)
+		}
 	case '-':
-		tok = newToken(token.MINUS, l.ch, l.line, l.column)
+		switch l.peekChar() {
+		case '-':
+			tok = l.readOperator(token.DECREMENT, 2)
+		case '=':
+			tok = l.readOperator(token.MINUS_ASSIGN, 2)
+		default:
+			tok = newToken(token.MINUS, l.ch, l.line, l.column)
+		}
 	case '!':
 		if l.peekChar() == '=' {
-			ch := l.ch
-			l.readChar()
-			literal := string(ch) + string(l.ch)
-			tok = token.Token{Type: token.NOT_EQ, Literal: literal, Line: l.line, Column: l.column}
+			if l.peekCharAt(1) == '=' {
+				tok = l.readOperator(token.STRICT_NOT_EQ, 3)
+			} else {
+				tok = l.readOperator(token.NOT_EQ, 2)
+			}
 		} else {
 			tok = newToken(token.BANG, l.ch, l.line, l.column)
 		}
 	case '/':
 		if l.peekChar() == '/' {
 			l.skipComment()
-			return l.NextToken()
+			return l.nextToken()
+		}
+		if l.peekChar() == '*' {
+			line, col := l.line, l.column
+			if !l.skipBlockComment() {
+				return token.Token{Type: token.ILLEGAL, Literal: "unterminated comment", Line: line, Column: col}
+			}
+			return l.nextToken()
+		}
+		if l.peekChar() == '=' {
+			tok = l.readOperator(token.SLASH_ASSIGN, 2)
+		} else {
+			tok = newToken(token.SLASH, l.ch, l.line, l.column)
 		}
-		tok = newToken(token.SLASH, l.ch, l.line, l.column)
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch, l.line, l.column)
+		if l.peekChar() == '=' {
+			tok = l.readOperator(token.ASTERISK_ASSIGN, 2)
+		} else {
+			tok = newToken(token.ASTERISK, l.ch, l.line, l.column)
+		}
+	case '%':
+		if l.peekChar() == '=' {
+			tok = l.readOperator(token.PERCENT_ASSIGN, 2)
+		} else {
+			tok = newToken(token.PERCENT, l.ch, l.line, l.column)
+		}
 	case '<':
-		tok = newToken(token.LT, l.ch, l.line, l.column)
+		switch {
+		case l.peekChar() == '=':
+			tok = l.readOperator(token.LT_EQ, 2)
+		case l.peekChar() == '<' && l.peekCharAt(1) == '=':
+			tok = l.readOperator(token.SHIFT_LEFT_ASSIGN, 3)
+		case l.peekChar() == '<':
+			tok = l.readOperator(token.SHIFT_LEFT, 2)
+		default:
+			tok = newToken(token.LT, l.ch, l.line, l.column)
+		}
 	case '>':
-		tok = newToken(token.GT, l.ch, l.line, l.column)
+		// '>>' in nested generics (Array<Array<int>>) is split again by the parser
+		switch {
+		case l.peekChar() == '=':
+			tok = l.readOperator(token.GT_EQ, 2)
+		case l.peekChar() == '>' && l.peekCharAt(1) == '>' && l.peekCharAt(2) == '=':
+			tok = l.readOperator(token.SHIFT_RIGHT_UNSIGNED_ASSIGN, 4)
+		case l.peekChar() == '>' && l.peekCharAt(1) == '>':
+			tok = l.readOperator(token.SHIFT_RIGHT_UNSIGNED, 3)
+		case l.peekChar() == '>' && l.peekCharAt(1) == '=':
+			tok = l.readOperator(token.SHIFT_RIGHT_ASSIGN, 3)
+		case l.peekChar() == '>':
+			tok = l.readOperator(token.SHIFT_RIGHT, 2)
+		default:
+			tok = newToken(token.GT, l.ch, l.line, l.column)
+		}
+	case '&':
+		switch l.peekChar() {
+		case '&':
+			tok = l.readOperator(token.AND, 2)
+		case '=':
+			tok = l.readOperator(token.AMPERSAND_ASSIGN, 2)
+		default:
+			tok = newToken(token.AMPERSAND, l.ch, l.line, l.column)
+		}
+	case '^':
+		if l.peekChar() == '=' {
+			tok = l.readOperator(token.CARET_ASSIGN, 2)
+		} else {
+			tok = newToken(token.CARET, l.ch, l.line, l.column)
+		}
+	case '~':
+		tok = newToken(token.TILDE, l.ch, l.line, l.column)
+	case '?':
+		switch {
+		case l.peekChar() == '?' && l.peekCharAt(1) == '=':
+			tok = l.readOperator(token.NULLISH_ASSIGN, 3)
+		case l.peekChar() == '?':
+			tok = l.readOperator(token.NULLISH, 2)
+		case l.peekChar() == '.' && !isDigit(l.peekCharAt(1)):
+			// a?.5:0 is a conditional with a number, not optional chaining
+			tok = l.readOperator(token.OPTIONAL_CHAIN, 2)
+		default:
+			tok = newToken(token.QUESTION, l.ch, l.line, l.column)
+		}
 	case ';':
 		tok = newToken(token.SEMICOLON, l.ch, l.line, l.column)
 	case ':':
@@ -89,14 +196,44 @@ func (l *Lexer) NextToken() token.Token {
 	case ']':
 		tok = newToken(token.RBRACKET, l.ch, l.line, l.column)
 	case '.':
+		if isDigit(l.peekChar()) {
+			// .5 形式的浮点数
+			tok.Literal, tok.Type = l.readNumber()
+			tok.Line = l.line
+			tok.Column = l.column
+			return tok
+		}
+		if l.peekChar() == '.' && l.peekCharAt(1) == '.' {
+			tok = l.readOperator(token.ELLIPSIS, 3)
+			break
+		}
 		tok = newToken(token.DOT, l.ch, l.line, l.column)
 	case '|':
-		tok = newToken(token.PIPE, l.ch, l.line, l.column)
-	case '"':
-		tok.Type = token.STRING
-		tok.Literal = l.readString()
+		switch l.peekChar() {
+		case '|':
+			tok = l.readOperator(token.OR, 2)
+		case '=':
+			tok = l.readOperator(token.PIPE_ASSIGN, 2)
+		default:
+			tok = newToken(token.PIPE, l.ch, l.line, l.column)
+		}
+	case '`':
+		tok.Type = token.TEMPLATE
 		tok.Line = l.line
 		tok.Column = l.column
+		tok.Literal = l.readTemplate()
+		l.readChar() // Skip the closing backtick
+		return tok
+	case '"', '\'':
+		tok.Line = l.line
+		tok.Column = l.column
+		if value, problem := l.readString(l.ch); problem == "" {
+			tok.Type = token.STRING
+			tok.Literal = value
+		} else {
+			tok.Type = token.ILLEGAL
+			tok.Literal = problem
+		}
 		l.readChar() // Skip the closing quote
 		return tok
 	case 0:
@@ -112,8 +249,7 @@ func (l *Lexer) NextToken() token.Token {
 			tok.Column = l.column // 注意：这里 column 指向的是标识符结束的位置，如果需要精确位置，需要在 readIdentifier 前记录
 			return tok
 		} else if isDigit(l.ch) {
-			tok.Type = token.INT
-			tok.Literal = l.readNumber()
+			tok.Literal, tok.Type = l.readNumber()
 			tok.Line = l.line
 			tok.Column = l.column
 			return tok
@@ -126,35 +262,354 @@ func (l *Lexer) NextToken() token.Token {
 	return tok
 }
 
-func newToken(tokenType token.TokenType, ch byte, line, col int) token.Token {
+func newToken(tokenType token.TokenType, ch rune, line, col int) token.Token {
 	return token.Token{Type: tokenType, Literal: string(ch), Line: line, Column: col}
 }
 
+// readOperator 读取一个由 n 个字符组成的运算符，结束时停在最后一个字符上
+func (l *Lexer) readOperator(tokenType token.TokenType, n int) token.Token {
+	line, col := l.line, l.column
+	position := l.position
+	for i := 1; i < n; i++ {
+		l.readChar()
+	}
+	return token.Token{Type: tokenType, Literal: l.input[position:l.readPosition], Line: line, Column: col}
+}
+
 func (l *Lexer) readIdentifier() string {
 	position := l.position
-	for isLetter(l.ch) || isDigit(l.ch) {
+	for isIdentifierPart(l.ch) {
 		l.readChar()
 	}
 	return l.input[position:l.position]
 }
 
-func (l *Lexer) readNumber() string {
+// readNumber 读取数字字面量：整数 (0xFF, 0b1010, 0o755, 1_000)、浮点数 (3.14, .5, 1e10, 2.5e-3) 和 BigInt (123n)
+// 字面量保留源码形式 (含 '_' 与后缀)，格式错误时返回 ILLEGAL
+func (l *Lexer) readNumber() (string, token.TokenType) {
 	position := l.position
-	for isDigit(l.ch) {
+	tokType := token.TokenType(token.INT)
+
+	if l.ch == '0' && strings.ContainsRune("xXbBoO", l.peekChar()) {
+		isDigitOf := map[rune]func(rune) bool{'x': isHexDigit, 'b': isBinaryDigit, 'o': isOctalDigit}[unicode.ToLower(l.peekChar())]
+		l.readChar()
 		l.readChar()
+		if !l.readDigits(isDigitOf) {
+			return l.invalidNumber(position)
+		}
+	} else {
+		// .5 形式从 '.' 开始，没有整数部分
+		if l.ch != '.' && !l.readDigits(isDigit) {
+			return l.invalidNumber(position)
+		}
+		// 小数部分：要求 '.' 后面是数字，避免吞掉 1.toString() 之类的成员访问
+		if l.ch == '.' && isDigit(l.peekChar()) {
+			tokType = token.FLOAT
+			l.readChar()
+			if !l.readDigits(isDigit) {
+				return l.invalidNumber(position)
+			}
+		}
+		// 指数部分
+		if l.ch == 'e' || l.ch == 'E' {
+			next := l.peekChar()
+			if isDigit(next) || ((next == '+' || next == '-') && isDigit(l.peekCharAt(1))) {
+				tokType = token.FLOAT
+				l.readChar()
+				if l.ch == '+' || l.ch == '-' {
+					l.readChar()
+				}
+				if !l.readDigits(isDigit) {
+					return l.invalidNumber(position)
+				}
+			}
+		}
 	}
-	return l.input[position:l.position]
+
+	if l.ch == 'n' && tokType == token.INT {
+		tokType = token.BIGINT
+		l.readChar()
+	}
+	// 数字后面不能紧跟标识符字符 (例如 0x1G, 12px, 1.5n)
+	if isIdentifierPart(l.ch) {
+		return l.invalidNumber(position)
+	}
+	return l.input[position:l.position], tokType
 }
 
-func (l *Lexer) readString() string {
+// readDigits 读取一串数字，'_' 只能出现在两个数字之间；至少需要一位数字
+func (l *Lexer) readDigits(isDigitOf func(rune) bool) bool {
+	if !isDigitOf(l.ch) {
+		return false
+	}
+	for isDigitOf(l.ch) || l.ch == '_' {
+		if l.ch == '_' && !isDigitOf(l.peekChar()) {
+			return false
+		}
+		l.readChar()
+	}
+	return true
+}
+
+// invalidNumber 跳过字面量剩余部分，返回 ILLEGAL
+func (l *Lexer) invalidNumber(position int) (string, token.TokenType) {
+	for isIdentifierPart(l.ch) || l.ch == '.' && isDigit(l.peekChar()) {
+		l.readChar()
+	}
+	return "invalid numeric literal " + l.input[position:l.position], token.ILLEGAL
+}
+
+// readString 读取 quote 包围的字符串并解码转义序列，结束时停在结尾引号上
+// 字符串未闭合或含无效转义时返回错误描述
+func (l *Lexer) readString(quote rune) (string, string) {
 	position := l.position + 1
 	for {
 		l.readChar()
-		if l.ch == '"' || l.ch == 0 {
-			break
+		if l.ch == '\\' && l.peekChar() != 0 {
+			l.readChar() // The escaped character can never end the string
+			if l.ch == '\n' {
+				l.line++
+				l.column = 0
+			}
+			continue
+		}
+		if l.ch == '\n' {
+			l.line++
+			l.column = 0
+		}
+		if l.ch == quote {
+			raw := l.input[position:l.position]
+			if problem := EscapeError(raw); problem != "" {
+				return "", problem
+			}
+			return Unescape(raw), ""
+		}
+		if l.ch == 0 && l.position >= len(l.input) {
+			return "", "unterminated string"
 		}
 	}
-	return l.input[position:l.position]
+}
+
+// Unescape 解码 TS 字符串中的转义序列:
+// \n \t \r \b \f \v \0, \xHH, \uHHHH (含代理对), \u{H...}, 行尾续行；其他字符按原样保留 (\" \' \\ \`)
+func Unescape(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			out.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			out.WriteByte('\n')
+		case 't':
+			out.WriteByte('\t')
+		case 'r':
+			out.WriteByte('\r')
+		case 'b':
+			out.WriteByte('\b')
+		case 'f':
+			out.WriteByte('\f')
+		case 'v':
+			out.WriteByte('\v')
+		case '0':
+			out.WriteByte(0)
+		case '\r':
+			// Line continuation (CRLF)
+			if i+1 < len(s) && s[i+1] == '\n' {
+				i++
+			}
+		case '\n':
+			// Line continuation
+		case 'x':
+			if v, err := strconv.ParseUint(safeSlice(s, i+1, i+3), 16, 8); err == nil {
+				out.WriteRune(rune(v))
+				i += 2
+			} else {
+				out.WriteByte('x')
+			}
+		case 'u':
+			r, n := decodeUnicodeEscape(s[i+1:])
+			if n == 0 {
+				out.WriteByte('u')
+				break
+			}
+			i += n
+			// Surrogate pair: \uD83D\uDE00
+			if utf16IsHighSurrogate(r) && strings.HasPrefix(s[i+1:], "\\u") {
+				if low, m := decodeUnicodeEscape(s[i+3:]); m == 4 && utf16IsLowSurrogate(low) {
+					r = (r-0xD800)<<10 + (low - 0xDC00) + 0x10000
+					i += 2 + m
+				}
+			}
+			out.WriteRune(r)
+		default:
+			// Copy the whole (possibly multi-byte) character
+			_, size := utf8.DecodeRuneInString(s[i:])
+			out.WriteString(s[i : i+size])
+			i += size - 1
+		}
+	}
+	return out.String()
+}
+
+// EscapeError 检查 \xHH 与 \u 转义是否有效，返回第一个无效转义的描述 (全部有效时为 "")
+// 字符串在运行时以 NUL 结尾，所以 \0、\x00 和 \u0000 会截断字符串，也作为错误报告
+func EscapeError(s string) string {
+	const nul = "strings cannot contain the NUL character (\\0): they are NUL-terminated at runtime"
+	for i := 0; i+1 < len(s); i++ {
+		if s[i] != '\\' {
+			continue
+		}
+		i++
+		switch s[i] {
+		case '0':
+			return nul
+		case 'x':
+			hex := safeSlice(s, i+1, i+3)
+			v, err := strconv.ParseUint(hex, 16, 8)
+			if err != nil || len(hex) < 2 {
+				return "invalid hexadecimal escape sequence \\x" + hex
+			}
+			if v == 0 {
+				return nul
+			}
+		case 'u':
+			if r, n := decodeUnicodeEscape(s[i+1:]); n > 0 {
+				if r == 0 {
+					return nul
+				}
+				break
+			}
+			if end := strings.IndexByte(s[i:], '}'); strings.HasPrefix(s[i+1:], "{") && end > 2 {
+				escape := s[i : i+end+1]
+				if v, err := strconv.ParseUint(escape[2:len(escape)-1], 16, 64); err == nil && v > unicode.MaxRune {
+					return "extended Unicode escape \\" + escape + " must be between 0x0 and 0x10FFFF"
+				}
+				return "invalid Unicode escape sequence \\" + escape
+			}
+			return "invalid Unicode escape sequence \\u" + safeSlice(s, i+1, i+5)
+		}
+	}
+	return ""
+}
+
+// decodeUnicodeEscape 解析 \u 之后的 HHHH 或 {H...}，返回码点和消耗的字节数 (失败时为 0)
+func decodeUnicodeEscape(s string) (rune, int) {
+	if strings.HasPrefix(s, "{") {
+		end := strings.IndexByte(s, '}')
+		if end < 2 {
+			return 0, 0
+		}
+		v, err := strconv.ParseUint(s[1:end], 16, 32)
+		if err != nil || v > unicode.MaxRune {
+			return 0, 0
+		}
+		return rune(v), end + 1
+	}
+	v, err := strconv.ParseUint(safeSlice(s, 0, 4), 16, 16)
+	if err != nil || len(s) < 4 {
+		return 0, 0
+	}
+	return rune(v), 4
+}
+
+func utf16IsHighSurrogate(r rune) bool { return r >= 0xD800 && r < 0xDC00 }
+func utf16IsLowSurrogate(r rune) bool  { return r >= 0xDC00 && r < 0xE000 }
+
+func safeSlice(s string, start, end int) string {
+	if start > len(s) {
+		return ""
+	}
+	if end > len(s) {
+		end = len(s)
+	}
+	return s[start:end]
+}
+
+// readTemplate 读取模板字符串的原始内容 (不含反引号)，模板可以跨行
+func (l *Lexer) readTemplate() string {
+	position := l.position + 1
+	end, _, _ := scanTemplate(l.input, position)
+	if end < 0 {
+		end = len(l.input)
+	}
+	for l.position < end && l.ch != 0 {
+		l.readChar()
+		if l.ch == '\n' {
+			l.line++
+			l.column = 0
+		}
+	}
+	return l.input[position:end]
+}
+
+// SplitTemplate 把模板的原始内容拆分为字符串片段和 ${} 中的表达式源码
+// len(quasis) 总是 len(exprs) + 1
+func SplitTemplate(raw string) (quasis []string, exprs []string, ok bool) {
+	end, quasis, exprs := scanTemplate(raw+"`", 0)
+	return quasis, exprs, end == len(raw)
+}
+
+// scanTemplate 从 start (开头反引号之后) 扫描模板，返回结尾反引号的位置 (未闭合时为 -1)
+func scanTemplate(s string, start int) (int, []string, []string) {
+	var quasis, exprs []string
+	partStart := start
+	for i := start; i < len(s); {
+		switch {
+		case s[i] == '\\':
+			i += 2
+		case s[i] == '`':
+			quasis = append(quasis, s[partStart:i])
+			return i, quasis, exprs
+		case s[i] == '$' && i+1 < len(s) && s[i+1] == '{':
+			quasis = append(quasis, s[partStart:i])
+			close := scanTemplateExpr(s, i+2)
+			if close < 0 {
+				return -1, quasis, exprs
+			}
+			exprs = append(exprs, s[i+2:close])
+			i = close + 1
+			partStart = i
+		default:
+			i++
+		}
+	}
+	return -1, quasis, exprs
+}
+
+// scanTemplateExpr 返回与 ${ 匹配的 } 的位置，跳过嵌套的括号、字符串和模板
+func scanTemplateExpr(s string, start int) int {
+	depth := 0
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		case '"', '\'':
+			quote := s[i]
+			for i++; i < len(s) && s[i] != quote; i++ {
+				if s[i] == '\\' {
+					i++
+				}
+			}
+		case '`':
+			end, _, _ := scanTemplate(s, i+1)
+			if end < 0 {
+				return -1
+			}
+			i = end
+		}
+	}
+	return -1
 }
 
 func (l *Lexer) skipComment() {
@@ -164,6 +619,52 @@ func (l *Lexer) skipComment() {
 	l.skipWhitespace()
 }
 
+// skipBlockComment 跳过 /* ... */；/** ... */ 作为文档注释保存，附加到下一个 token 上
+func (l *Lexer) skipBlockComment() bool {
+	start := l.position
+	l.readChar() // '*'
+	for {
+		l.readChar()
+		if l.ch == 0 && l.position >= len(l.input) {
+			return false
+		}
+		if l.ch == '\n' {
+			l.line++
+			l.column = 0
+		}
+		if l.ch == '*' && l.peekChar() == '/' {
+			l.readChar()
+			break
+		}
+	}
+	text := l.input[start : l.position+1]
+	if strings.HasPrefix(text, "/**") && text != "/**/" {
+		l.pendingDoc = cleanDocComment(text[3 : len(text)-2])
+	}
+	l.readChar()
+	l.skipWhitespace()
+	return true
+}
+
+// cleanDocComment 去掉文档注释每行开头的 " * "，以及首尾空行
+func cleanDocComment(body string) string {
+	lines := strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "*") {
+			line = strings.TrimPrefix(line[1:], " ")
+		}
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	for len(lines) > 0 && lines[0] == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
 func (l *Lexer) skipWhitespace() {
 	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
 		if l.ch == '\n' {
@@ -174,17 +675,48 @@ func (l *Lexer) skipWhitespace() {
 	}
 }
 
-func (l *Lexer) peekChar() byte {
-	if l.readPosition >= len(l.input) {
+func (l *Lexer) peekChar() rune {
+	return l.peekCharAt(0)
+}
+
+// peekCharAt 查看当前字符之后第 offset+1 个字符
+func (l *Lexer) peekCharAt(offset int) rune {
+	pos := l.readPosition
+	for ; offset > 0 && pos < len(l.input); offset-- {
+		_, size := utf8.DecodeRuneInString(l.input[pos:])
+		pos += size
+	}
+	if pos >= len(l.input) {
 		return 0
 	}
-	return l.input[l.readPosition]
+	r, _ := utf8.DecodeRuneInString(l.input[pos:])
+	return r
+}
+
+// isLetter 判断字符能否作为标识符的开头 (Unicode 字母、_ 和 $)
+func isLetter(ch rune) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_' || ch == '$' ||
+		ch >= utf8.RuneSelf && unicode.IsLetter(ch)
 }
 
-func isLetter(ch byte) bool {
-	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+// isIdentifierPart 判断字符能否出现在标识符中间
+func isIdentifierPart(ch rune) bool {
+	return isLetter(ch) || isDigit(ch) ||
+		ch >= utf8.RuneSelf && (unicode.IsDigit(ch) || unicode.Is(unicode.Mn, ch) || unicode.Is(unicode.Mc, ch))
 }
 
-func isDigit(ch byte) bool {
+func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
+}
+
+func isHexDigit(ch rune) bool {
+	return isDigit(ch) || 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
+}
+
+func isOctalDigit(ch rune) bool {
+	return '0' <= ch && ch <= '7'
+}
+
+func isBinaryDigit(ch rune) bool {
+	return ch == '0' || ch == '1'
 }
\ No newline at end of file
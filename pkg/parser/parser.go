@@ -1,7 +1,9 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
 	"strings"
 	"omniScript/pkg/ast"
 	"omniScript/pkg/lexer"
@@ -13,30 +15,74 @@ import (
 const (
 	_ int = iota
 	LOWEST
-	ASSIGN      // =
-	EQUALS      // ==
-	LESSGREATER // > or <
+	ASSIGN      // =, +=, -=
+	TERNARY     // ? :
+	LOGICAL_OR  // ||, ??
+	LOGICAL_AND // &&
+	BIT_OR      // |
+	BIT_XOR     // ^
+	BIT_AND     // &
+	EQUALS      // ==, ===
+	LESSGREATER // > or <, >=, <=
+	SHIFT       // <<, >>, >>>
 	SUM         // +
-	PRODUCT     // *
-	PREFIX      // -X or !X
+	PRODUCT     // *, /, %
+	PREFIX      // -X or !X or ++X
+	POSTFIX     // X++ or X--
 	CALL        // myFunction(X)
 	INDEX       // array[index]
 	MEMBER      // obj.prop
 )
 
 var precedences = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.ASSIGN:   ASSIGN,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.SLASH:    PRODUCT,
-	token.ASTERISK: PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
-	token.DOT:      MEMBER,
+	token.EQ:              EQUALS,
+	token.NOT_EQ:          EQUALS,
+	token.STRICT_EQ:       EQUALS,
+	token.STRICT_NOT_EQ:   EQUALS,
+	token.LT:              LESSGREATER,
+	token.INSTANCEOF:      LESSGREATER,
+	token.GT:              LESSGREATER,
+	token.LT_EQ:           LESSGREATER,
+	token.GT_EQ:           LESSGREATER,
+	token.AND:             LOGICAL_AND,
+	token.OR:              LOGICAL_OR,
+	token.NULLISH:         LOGICAL_OR,
+	token.QUESTION:        TERNARY,
+	token.PIPE:            BIT_OR,
+	token.CARET:           BIT_XOR,
+	token.AMPERSAND:       BIT_AND,
+
+	token.SHIFT_LEFT:           SHIFT,
+	token.SHIFT_RIGHT:          SHIFT,
+	token.SHIFT_RIGHT_UNSIGNED: SHIFT,
+
+	token.ASSIGN:          ASSIGN,
+	token.PLUS_ASSIGN:     ASSIGN,
+	token.MINUS_ASSIGN:    ASSIGN,
+	token.ASTERISK_ASSIGN: ASSIGN,
+	token.SLASH_ASSIGN:    ASSIGN,
+	token.PERCENT_ASSIGN:  ASSIGN,
+
+	token.AMPERSAND_ASSIGN:            ASSIGN,
+	token.PIPE_ASSIGN:                 ASSIGN,
+	token.CARET_ASSIGN:                ASSIGN,
+	token.SHIFT_LEFT_ASSIGN:           ASSIGN,
+	token.SHIFT_RIGHT_ASSIGN:          ASSIGN,
+	token.SHIFT_RIGHT_UNSIGNED_ASSIGN: ASSIGN,
+	token.NULLISH_ASSIGN:              ASSIGN,
+
+	token.PLUS:            SUM,
+	token.MINUS:           SUM,
+	token.SLASH:           PRODUCT,
+	token.ASTERISK:        PRODUCT,
+	token.PERCENT:         PRODUCT,
+	token.INCREMENT:       POSTFIX,
+	token.DECREMENT:       POSTFIX,
+	token.LPAREN:          CALL,
+	token.TEMPLATE:        CALL,
+	token.LBRACKET:        INDEX,
+	token.DOT:             MEMBER,
+	token.OPTIONAL_CHAIN:  MEMBER,
 }
 
 type (
@@ -53,6 +99,8 @@ type Parser struct {
 
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
+
+	constructorParams bool // 正在解析构造函数的参数列表，允许参数属性 (private x: int)
 }
 
 func New(l *lexer.Lexer) *Parser {
@@ -65,7 +113,10 @@ func New(l *lexer.Lexer) *Parser {
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
+	p.registerPrefix(token.BIGINT, p.parseBigIntLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
+	p.registerPrefix(token.TEMPLATE, p.parseTemplateLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
@@ -73,12 +124,21 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
+	p.registerPrefix(token.ASYNC, p.parseAsyncFunction)
+	p.registerPrefix(token.AWAIT, p.parseAwaitExpression)
+	p.registerPrefix(token.YIELD, p.parseYieldExpression)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
+	p.registerPrefix(token.ELLIPSIS, p.parseSpreadElement)
 	p.registerPrefix(token.LBRACE, p.parseMapLiteral)
 	p.registerPrefix(token.NEW, p.parseNewExpression)
 	p.registerPrefix(token.THIS, p.parseThisExpression)
 	p.registerPrefix(token.SUPER, p.parseSuperExpression)
 	p.registerPrefix(token.TYPEOF, p.parsePrefixExpression)
+	p.registerPrefix(token.TILDE, p.parsePrefixExpression)
+	p.registerPrefix(token.NULL, p.parseNullLiteral)
+	p.registerPrefix(token.UNDEFINED, p.parseUndefinedLiteral)
+	p.registerPrefix(token.INCREMENT, p.parsePrefixExpression)
+	p.registerPrefix(token.DECREMENT, p.parsePrefixExpression)
 
 	// 注册中缀解析函数
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
@@ -89,11 +149,44 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.EQ, p.parseInfixExpression)
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
+	p.registerInfix(token.INSTANCEOF, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.STRICT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.STRICT_NOT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
+	p.registerInfix(token.NULLISH, p.parseInfixExpression)
+	p.registerInfix(token.QUESTION, p.parseConditionalExpression)
+	// '|' is a bitwise operator here; in type annotations parseType consumes it as a union
+	p.registerInfix(token.PIPE, p.parseInfixExpression)
+	p.registerInfix(token.CARET, p.parseInfixExpression)
+	p.registerInfix(token.AMPERSAND, p.parseInfixExpression)
+	p.registerInfix(token.SHIFT_LEFT, p.parseInfixExpression)
+	p.registerInfix(token.SHIFT_RIGHT, p.parseInfixExpression)
+	p.registerInfix(token.SHIFT_RIGHT_UNSIGNED, p.parseInfixExpression)
 	p.registerInfix(token.ASSIGN, p.parseAssignmentExpression)
+	p.registerInfix(token.PLUS_ASSIGN, p.parseCompoundAssignmentExpression)
+	p.registerInfix(token.MINUS_ASSIGN, p.parseCompoundAssignmentExpression)
+	p.registerInfix(token.ASTERISK_ASSIGN, p.parseCompoundAssignmentExpression)
+	p.registerInfix(token.SLASH_ASSIGN, p.parseCompoundAssignmentExpression)
+	p.registerInfix(token.PERCENT_ASSIGN, p.parseCompoundAssignmentExpression)
+	p.registerInfix(token.AMPERSAND_ASSIGN, p.parseCompoundAssignmentExpression)
+	p.registerInfix(token.PIPE_ASSIGN, p.parseCompoundAssignmentExpression)
+	p.registerInfix(token.CARET_ASSIGN, p.parseCompoundAssignmentExpression)
+	p.registerInfix(token.SHIFT_LEFT_ASSIGN, p.parseCompoundAssignmentExpression)
+	p.registerInfix(token.SHIFT_RIGHT_ASSIGN, p.parseCompoundAssignmentExpression)
+	p.registerInfix(token.SHIFT_RIGHT_UNSIGNED_ASSIGN, p.parseCompoundAssignmentExpression)
+	p.registerInfix(token.NULLISH_ASSIGN, p.parseCompoundAssignmentExpression)
+	p.registerInfix(token.INCREMENT, p.parsePostfixExpression)
+	p.registerInfix(token.DECREMENT, p.parsePostfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
+	p.registerInfix(token.TEMPLATE, p.parseTaggedTemplate)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
 	p.registerInfix(token.DOT, p.parseMemberExpression)
+	p.registerInfix(token.OPTIONAL_CHAIN, p.parseOptionalChain)
 
 	// 读取两个 token，以初始化 curToken 和 peekToken
 	p.nextToken()
@@ -136,6 +229,8 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseClassStatement()
 	case token.WHILE:
 		return p.parseWhileStatement()
+	case token.DO:
+		return p.parseDoWhileStatement()
 	case token.FOR:
 		return p.parseForStatement()
 	case token.SPAWN:
@@ -156,14 +251,45 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseTryStatement()
 	case token.THROW:
 		return p.parseThrowStatement()
+	case token.BREAK, token.CONTINUE:
+		return p.parseJumpStatement()
+	case token.SWITCH:
+		return p.parseSwitchStatement()
+	case token.IDENT:
+		if p.peekToken.Type == token.COLON {
+			return p.parseLabeledStatement()
+		}
+		if p.curToken.Literal == "abstract" && p.peekToken.Type == token.CLASS {
+			// abstract class Name { ... }
+			doc := p.curToken.Doc
+			p.nextToken()
+			stmt := p.parseClassStatement()
+			if stmt == nil {
+				return nil
+			}
+			stmt.Abstract = true
+			if stmt.Doc == "" {
+				stmt.Doc = doc
+			}
+			return stmt
+		}
+		return p.parseExpressionStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
 }
 
 func (p *Parser) parseType() string {
-	// Parse base type (identifier or primitive)
-	if !p.expectPeek(token.IDENT) {
+	// Function type: (a: int, b: string) => number
+	if p.peekToken.Type == token.LPAREN {
+		p.nextToken() // (
+		return p.parseFunctionType()
+	}
+
+	// Parse base type (identifier or primitive; null/undefined are keywords)
+	if p.peekToken.Type == token.NULL || p.peekToken.Type == token.UNDEFINED {
+		p.nextToken()
+	} else if !p.expectPeek(token.IDENT) {
 		return ""
 	}
 	typeName := p.curToken.Literal
@@ -191,7 +317,7 @@ func (p *Parser) parseType() string {
 			generics = append(generics, innerType)
 		}
 		
-		if !p.expectPeek(token.GT) {
+		if !p.expectGenericClose() {
 			return ""
 		}
 		
@@ -209,12 +335,99 @@ func (p *Parser) parseType() string {
 			return ""
 		}
 		
-		typeName = fmt.Sprintf("%s|%s", typeName, nextType)
+		typeName = simplifyNullable(fmt.Sprintf("%s|%s", typeName, nextType))
 	}
 	
 	return typeName
 }
 
+// simplifyNullable 把 T | null / T | undefined 化简为 T：引用类型的 null 就是 0 指针，不需要装箱
+// 值类型 (int | null 等) 保持联合类型，由装箱区分 null
+func simplifyNullable(union string) string {
+	var rest []string
+	hasNull := false
+	depth := 0
+	start := 0
+	for i := 0; i <= len(union); i++ {
+		if i < len(union) {
+			switch union[i] {
+			case '<', '(':
+				depth++
+			case '>', ')':
+				depth--
+			}
+			if union[i] != '|' || depth != 0 {
+				continue
+			}
+		}
+		member := union[start:i]
+		start = i + 1
+		if member == "null" || member == "undefined" {
+			hasNull = true
+		} else {
+			rest = append(rest, member)
+		}
+	}
+	if !hasNull || len(rest) != 1 {
+		return union
+	}
+	switch rest[0] {
+	case "int", "number", "float", "bool", "bigint":
+		return union
+	}
+	return rest[0]
+}
+
+// expectGenericClose 消费类型参数结尾的 '>'
+// 嵌套泛型 Array<Array<int>> 中的 '>>' / '>>>' 由词法分析器作为移位运算符产生，这里拆开，剩余部分留作下一个 token
+func (p *Parser) expectGenericClose() bool {
+	rest := map[token.TokenType]token.TokenType{token.SHIFT_RIGHT: token.GT, token.SHIFT_RIGHT_UNSIGNED: token.SHIFT_RIGHT}
+	if remaining, ok := rest[p.peekToken.Type]; ok {
+		p.curToken = token.Token{Type: token.GT, Literal: ">", Line: p.peekToken.Line, Column: p.peekToken.Column}
+		p.peekToken = token.Token{Type: remaining, Literal: p.peekToken.Literal[1:], Line: p.peekToken.Line, Column: p.peekToken.Column + 1}
+		return true
+	}
+	return p.expectPeek(token.GT)
+}
+
+// parseFunctionType 解析函数类型，参数名会被丢弃: (x: int, y: number) => string -> "(int, number) => string"
+func (p *Parser) parseFunctionType() string {
+	var params []string
+	for p.peekToken.Type != token.RPAREN {
+		if len(params) > 0 && !p.expectPeek(token.COMMA) {
+			return ""
+		}
+		// Parameter name is optional in our type syntax: (int) => int
+		rest := ""
+		if p.peekToken.Type == token.ELLIPSIS {
+			p.nextToken()
+			rest = "..."
+		}
+		paramType := ""
+		if scan := *p.l; p.peekToken.Type == token.IDENT && scan.NextToken().Type == token.COLON {
+			p.nextToken() // name
+			p.nextToken() // :
+			paramType = p.parseType()
+		} else {
+			paramType = p.parseType()
+		}
+		if paramType == "" {
+			return ""
+		}
+		params = append(params, rest+paramType)
+	}
+	p.nextToken() // )
+
+	if !p.expectPeek(token.ARROW) {
+		return ""
+	}
+	returnType := p.parseType()
+	if returnType == "" {
+		return ""
+	}
+	return fmt.Sprintf("(%s) => %s", strings.Join(params, ", "), returnType)
+}
+
 func (p *Parser) parseTypeAliasStatement() *ast.TypeAliasStatement {
 	stmt := &ast.TypeAliasStatement{Token: p.curToken}
 
@@ -241,7 +454,7 @@ func (p *Parser) parseTypeAliasStatement() *ast.TypeAliasStatement {
 }
 
 func (p *Parser) parseInterfaceStatement() *ast.InterfaceStatement {
-	stmt := &ast.InterfaceStatement{Token: p.curToken}
+	stmt := &ast.InterfaceStatement{Token: p.curToken, Doc: p.curToken.Doc}
 
 	if !p.expectPeek(token.IDENT) {
 		return nil
@@ -288,7 +501,7 @@ func (p *Parser) parseInterfaceStatement() *ast.InterfaceStatement {
 }
 
 func (p *Parser) parseEnumStatement() *ast.EnumStatement {
-	stmt := &ast.EnumStatement{Token: p.curToken}
+	stmt := &ast.EnumStatement{Token: p.curToken, Doc: p.curToken.Doc}
 
 	if !p.expectPeek(token.IDENT) {
 		return nil
@@ -433,7 +646,34 @@ func (p *Parser) parseWhileStatement() *ast.WhileStatement {
 	return stmt
 }
 
-func (p *Parser) parseForStatement() *ast.ForStatement {
+// parseDoWhileStatement 解析 do { ... } while (cond);
+func (p *Parser) parseDoWhileStatement() ast.Statement {
+	stmt := &ast.DoWhileStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseBlockStatement()
+
+	if !p.expectPeek(token.WHILE) {
+		return nil
+	}
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if p.peekToken.Type == token.SEMICOLON {
+		p.nextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseForStatement() ast.Statement {
 	stmt := &ast.ForStatement{Token: p.curToken}
 
 	if !p.expectPeek(token.LPAREN) {
@@ -442,10 +682,16 @@ func (p *Parser) parseForStatement() *ast.ForStatement {
 
 	p.nextToken() // consume (
 
+	if (p.curToken.Type == token.LET || p.curToken.Type == token.CONST) && p.isForOfAhead() {
+		return p.parseForOfStatement(stmt.Token)
+	}
+
 	// Init
 	if p.curToken.Type != token.SEMICOLON {
-		stmt.Init = p.parseStatement()
-		p.nextToken() // consume ; from Init statement (parseStatement leaves curToken at ;)
+		if stmt.Init = p.parseForInit(); stmt.Init == nil {
+			return nil
+		}
+		p.nextToken() // consume ; from Init statement (parseForInit leaves curToken at ;)
 	} else {
 		p.nextToken() // consume ;
 	}
@@ -463,7 +709,7 @@ func (p *Parser) parseForStatement() *ast.ForStatement {
 	// Update
 	if p.curToken.Type != token.RPAREN {
 		// Update is usually an expression, but we wrap in ExpressionStatement
-		exp := p.parseExpression(LOWEST)
+		exp := p.parseSequenceExpression()
 		stmt.Update = &ast.ExpressionStatement{Token: p.curToken, Expression: exp}
 		p.nextToken()
 	}
@@ -484,6 +730,112 @@ func (p *Parser) parseForStatement() *ast.ForStatement {
 	return stmt
 }
 
+// parseForInit 解析 for 的初始化部分，允许逗号：let i = 0, j = n 或 i = 0, j = n
+// 多个 let 声明组成一个 BlockStatement；结束时 curToken 为 ';'
+func (p *Parser) parseForInit() ast.Statement {
+	if p.curToken.Type != token.LET {
+		stmt := &ast.ExpressionStatement{Token: p.curToken}
+		stmt.Expression = p.parseSequenceExpression()
+		if !p.expectPeek(token.SEMICOLON) {
+			return nil
+		}
+		return stmt
+	}
+
+	let := p.parseLetStatement()
+	if let == nil {
+		return nil
+	}
+	if p.peekToken.Type != token.COMMA {
+		return let
+	}
+	block := &ast.BlockStatement{Token: let.Token, Statements: []ast.Statement{let}}
+	for p.peekToken.Type == token.COMMA {
+		p.nextToken() // the ',' stands in for 'let'
+		next := p.parseLetStatement()
+		if next == nil {
+			return nil
+		}
+		next.Token = let.Token
+		block.Statements = append(block.Statements, next)
+	}
+	if p.curToken.Type != token.SEMICOLON {
+		p.peekError(token.SEMICOLON)
+		return nil
+	}
+	return block
+}
+
+// parseSequenceExpression 解析逗号分隔的表达式 a, b, c；只有一个表达式时直接返回它
+func (p *Parser) parseSequenceExpression() ast.Expression {
+	first := p.parseExpression(LOWEST)
+	if p.peekToken.Type != token.COMMA {
+		return first
+	}
+	seq := &ast.SequenceExpression{Token: p.peekToken, Expressions: []ast.Expression{first}}
+	for p.peekToken.Type == token.COMMA {
+		p.nextToken()
+		p.nextToken()
+		seq.Expressions = append(seq.Expressions, p.parseExpression(LOWEST))
+	}
+	return seq
+}
+
+// isForOfAhead 判断 for ( 之后的 let/const 声明是否跟着 of / in，curToken 为 let/const
+func (p *Parser) isForOfAhead() bool {
+	scan := *p.l
+	tok := p.peekToken
+	if tok.Type == token.LBRACE || tok.Type == token.LBRACKET {
+		// Skip the destructuring pattern
+		for depth := 0; ; tok = scan.NextToken() {
+			switch tok.Type {
+			case token.LBRACE, token.LBRACKET:
+				depth++
+			case token.RBRACE, token.RBRACKET:
+				depth--
+			case token.EOF:
+				return false
+			}
+			if depth == 0 {
+				break
+			}
+		}
+	} else if tok.Type != token.IDENT {
+		return false
+	}
+	tok = scan.NextToken()
+	return tok.Type == token.IDENT && (tok.Literal == "of" || tok.Literal == "in")
+}
+
+// parseForOfStatement 解析 for (const x of iterable) { ... } 与 for (const k in map) { ... }
+// curToken 为 let/const
+func (p *Parser) parseForOfStatement(forToken token.Token) ast.Statement {
+	stmt := &ast.ForOfStatement{Token: forToken}
+	stmt.Declaration = &ast.LetStatement{Token: p.curToken}
+
+	p.nextToken()
+	if p.curToken.Type == token.IDENT {
+		stmt.Declaration.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	} else if stmt.Declaration.Pattern = p.parsePattern(); stmt.Declaration.Pattern == nil {
+		return nil
+	}
+
+	p.nextToken() // of / in
+	stmt.In = p.curToken.Literal == "in"
+
+	p.nextToken()
+	stmt.Iterable = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseBlockStatement()
+	return stmt
+}
+
 func (p *Parser) parseSpawnStatement() *ast.SpawnStatement {
 	stmt := &ast.SpawnStatement{Token: p.curToken}
 
@@ -559,6 +911,28 @@ func (p *Parser) parseExportStatement() *ast.ExportStatement {
 	
 	// Export can precede: let, function, class, interface, enum, type
 	stmt.Statement = p.parseStatement()
+
+	// A doc comment before `export` belongs to the exported declaration
+	if doc := stmt.Token.Doc; doc != "" {
+		switch inner := stmt.Statement.(type) {
+		case *ast.ClassStatement:
+			if inner.Doc == "" {
+				inner.Doc = doc
+			}
+		case *ast.InterfaceStatement:
+			if inner.Doc == "" {
+				inner.Doc = doc
+			}
+		case *ast.EnumStatement:
+			if inner.Doc == "" {
+				inner.Doc = doc
+			}
+		case *ast.ExpressionStatement:
+			if fn, ok := inner.Expression.(*ast.FunctionLiteral); ok && fn.Doc == "" {
+				fn.Doc = doc
+			}
+		}
+	}
 	
 	return stmt
 }
@@ -566,12 +940,18 @@ func (p *Parser) parseExportStatement() *ast.ExportStatement {
 func (p *Parser) parseLetStatement() *ast.LetStatement {
 	stmt := &ast.LetStatement{Token: p.curToken}
 
-	if !p.expectPeek(token.IDENT) {
+	if p.peekToken.Type == token.LBRACE || p.peekToken.Type == token.LBRACKET {
+		p.nextToken()
+		stmt.Pattern = p.parsePattern()
+		if stmt.Pattern == nil {
+			return nil
+		}
+	} else if !p.expectPeek(token.IDENT) {
 		return nil
+	} else {
+		stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 	}
 
-	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-
 	if p.peekToken.Type == token.COLON {
 		p.nextToken() // :
 		stmt.Type = p.parseType()
@@ -640,15 +1020,92 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 }
 
 func (p *Parser) parseIdentifier() ast.Expression {
-	return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	// x => x * 2
+	if p.peekToken.Type == token.ARROW {
+		lit := &ast.FunctionLiteral{Token: p.curToken, IsArrow: true}
+		lit.Parameters = []*ast.FieldDefinition{{Token: p.curToken, Name: ident}}
+		p.nextToken() // =>
+		lit.Body = p.parseArrowBody()
+		return lit
+	}
+
+	// pick<string>(a, b): explicit type arguments of a generic function
+	if p.peekToken.Type == token.LT && p.isTypeArgsCallAhead() {
+		p.nextToken() // <
+		typeArgs := p.parseTypeArgs()
+		p.nextToken() // (
+		call := p.parseCallExpression(ident).(*ast.CallExpression)
+		call.TypeArgs = typeArgs
+		return call
+	}
+	return ident
 }
 
 func (p *Parser) parseIntegerLiteral() ast.Expression {
-	lit := &ast.IntegerLiteral{Token: p.curToken}
+	digits, base := intLiteralDigits(p.curToken.Literal)
+	if n, ok := new(big.Int).SetString(digits, base); ok && !n.IsInt64() {
+		// Too large for 64 bits: an ordinary number
+		value, _ := new(big.Float).SetInt(n).Float64()
+		return &ast.FloatLiteral{Token: p.curToken, Value: value}
+	}
+	value, ok := p.parseInt64(p.curToken.Literal)
+	if !ok {
+		return nil
+	}
+	return &ast.IntegerLiteral{Token: p.curToken, Value: value}
+}
+
+func (p *Parser) parseBigIntLiteral() ast.Expression {
+	value, ok := p.parseInt64(strings.TrimSuffix(p.curToken.Literal, "n"))
+	if !ok {
+		return nil
+	}
+	return &ast.BigIntLiteral{Token: p.curToken, Value: value}
+}
+
+// parseInt64 解析整数字面量 (十进制 / 0x / 0b / 0o，可含 '_' 分隔符)
+func (p *Parser) parseInt64(literal string) (int64, bool) {
+	digits, base := intLiteralDigits(literal)
+	value, err := strconv.ParseInt(digits, base, 64)
+	if err != nil {
+		msg := fmt.Sprintf("line %d: could not parse %q as integer", p.curToken.Line, p.curToken.Literal)
+		if errors.Is(err, strconv.ErrRange) {
+			msg = fmt.Sprintf("line %d: integer literal %s is out of range (64-bit)", p.curToken.Line, p.curToken.Literal)
+		}
+		p.errors = append(p.errors, msg)
+		return 0, false
+	}
+	return value, true
+}
+
+// intLiteralDigits 去掉 '_' 分隔符和进制前缀，返回数字部分和进制
+func intLiteralDigits(literal string) (string, int) {
+	digits := strings.ReplaceAll(literal, "_", "")
+	base := 10
+	if len(digits) > 2 && digits[0] == '0' {
+		switch digits[1] {
+		case 'x', 'X':
+			base = 16
+		case 'b', 'B':
+			base = 2
+		case 'o', 'O':
+			base = 8
+		}
+		if base != 10 {
+			digits = digits[2:]
+		}
+	}
+	return digits, base
+}
 
-	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.curToken}
+
+	value, err := strconv.ParseFloat(strings.ReplaceAll(p.curToken.Literal, "_", ""), 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
+		msg := fmt.Sprintf("could not parse %q as float", p.curToken.Literal)
 		p.errors = append(p.errors, msg)
 		return nil
 	}
@@ -661,6 +1118,45 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
 
+// parseTemplateLiteral 解析模板字符串，${} 中的表达式用子解析器解析
+func (p *Parser) parseTemplateLiteral() ast.Expression {
+	lit := &ast.TemplateLiteral{Token: p.curToken}
+	quasis, exprs, ok := lexer.SplitTemplate(p.curToken.Literal)
+	if !ok {
+		p.errors = append(p.errors, "unterminated template literal")
+		return nil
+	}
+	for _, q := range quasis {
+		if problem := lexer.EscapeError(q); problem != "" {
+			p.errors = append(p.errors, fmt.Sprintf("line %d: %s", p.curToken.Line, problem))
+			return nil
+		}
+		lit.Quasis = append(lit.Quasis, lexer.Unescape(strings.ReplaceAll(q, "\r\n", "\n")))
+	}
+	for _, src := range exprs {
+		sub := New(lexer.New(src))
+		expr := sub.parseExpression(LOWEST)
+		if sub.peekToken.Type != token.EOF && sub.peekToken.Type != token.SEMICOLON {
+			sub.errors = append(sub.errors, fmt.Sprintf("unexpected %s in template expression ${%s}", sub.peekToken.Literal, src))
+		}
+		if len(sub.errors) > 0 || expr == nil {
+			p.errors = append(p.errors, sub.errors...)
+			return nil
+		}
+		lit.Expressions = append(lit.Expressions, expr)
+	}
+	return lit
+}
+
+// parseTaggedTemplate 解析 tag`...`
+func (p *Parser) parseTaggedTemplate(tag ast.Expression) ast.Expression {
+	quasi, ok := p.parseTemplateLiteral().(*ast.TemplateLiteral)
+	if !ok || quasi == nil {
+		return nil
+	}
+	return &ast.TaggedTemplateExpression{Token: p.curToken, Tag: tag, Quasi: quasi}
+}
+
 func (p *Parser) parsePrefixExpression() ast.Expression {
 	expression := &ast.PrefixExpression{
 		Token:    p.curToken,
@@ -692,6 +1188,23 @@ func (p *Parser) parseBoolean() ast.Expression {
 }
 
 func (p *Parser) parseGroupedExpression() ast.Expression {
+	if p.isArrowFunctionAhead() {
+		return p.parseArrowFunction()
+	}
+	if p.peekToken.Type == token.LBRACE && p.isPatternAssignmentAhead() {
+		// ({a, b} = obj): a bare { at statement start would be a block
+		p.nextToken()
+		pattern := p.parseObjectPattern()
+		if pattern == nil || !p.expectPeek(token.ASSIGN) {
+			return nil
+		}
+		exp := p.parseAssignmentExpression(pattern)
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+		return exp
+	}
+
 	p.nextToken()
 	exp := p.parseExpression(LOWEST)
 
@@ -753,13 +1266,24 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 }
 
 func (p *Parser) parseFunctionLiteral() ast.Expression {
-	lit := &ast.FunctionLiteral{Token: p.curToken}
+	lit := &ast.FunctionLiteral{Token: p.curToken, Doc: p.curToken.Doc}
+
+	if p.peekToken.Type == token.ASTERISK {
+		p.nextToken() // function*
+		lit.Generator = true
+	}
 
 	if p.peekToken.Type == token.IDENT {
 		p.nextToken()
 		lit.Name = p.curToken.Literal
 	}
 
+	if lit.Name != "" && p.peekToken.Type == token.LT {
+		if lit.TypeParams = p.parseTypeParameters(); lit.TypeParams == nil {
+			return nil
+		}
+	}
+
 	if !p.expectPeek(token.LPAREN) {
 		return nil
 	}
@@ -777,59 +1301,467 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	}
 
 	lit.Body = p.parseBlockStatement()
+	bindPatternParameters(lit.Parameters, lit.Body)
 
 	return lit
 }
 
-func (p *Parser) parseFunctionParameters() []*ast.FieldDefinition {
-	identifiers := []*ast.FieldDefinition{}
+// isArrowFunctionAhead 在 curToken 为 '(' 时向前扫描，判断是否为箭头函数的参数列表
+// 使用词法分析器的副本，不消耗 token
+func (p *Parser) isArrowFunctionAhead() bool {
+	scan := *p.l
+	tok := p.peekToken
+	next := func() { tok = scan.NextToken() }
+
+	// Skip to the matching ')'
+	for depth := 1; ; next() {
+		switch tok.Type {
+		case token.LPAREN:
+			depth++
+		case token.RPAREN:
+			depth--
+		case token.EOF:
+			return false
+		}
+		if depth == 0 {
+			break
+		}
+	}
+	next()
+	if tok.Type == token.ARROW {
+		return true
+	}
+	if tok.Type != token.COLON {
+		return false
+	}
 
-	if p.peekToken.Type == token.RPAREN {
-		p.nextToken()
-		return identifiers
+	// Return type annotation: (x): int => ...
+	for depth := 0; ; {
+		next()
+		switch tok.Type {
+		case token.ARROW:
+			if depth == 0 {
+				return true
+			}
+		case token.LT, token.LPAREN:
+			depth++
+		case token.GT, token.RPAREN:
+			depth--
+			if depth < 0 {
+				return false
+			}
+		case token.SHIFT_RIGHT, token.SHIFT_RIGHT_UNSIGNED:
+			depth -= len(tok.Literal) // Closes nested generics
+			if depth < 0 {
+				return false
+			}
+		case token.SEMICOLON, token.COMMA, token.LBRACE, token.RBRACE, token.EOF:
+			if depth == 0 || tok.Type != token.COMMA {
+				return false
+			}
+		}
 	}
+}
 
-	p.nextToken()
+// isPatternAssignmentAhead 在 peekToken 为 '{' 时向前扫描，判断匹配的 '}' 之后是否为 '='
+func (p *Parser) isPatternAssignmentAhead() bool {
+	scan := *p.l
+	tok := p.peekToken
+	for depth := 0; ; tok = scan.NextToken() {
+		switch tok.Type {
+		case token.LBRACE:
+			depth++
+		case token.RBRACE:
+			depth--
+		case token.EOF:
+			return false
+		}
+		if depth == 0 {
+			break
+		}
+	}
+	return scan.NextToken().Type == token.ASSIGN
+}
 
-	ident := &ast.FieldDefinition{
-		Token: p.curToken,
-		Name:  &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal},
-		Type:  "int", // Default type
+// parsePattern 解析解构模式，curToken 为 '{' 或 '['
+func (p *Parser) parsePattern() ast.Expression {
+	if p.curToken.Type == token.LBRACKET {
+		return p.parseArrayPattern()
 	}
+	return p.parseObjectPattern()
+}
 
-	// Optional Type Annotation
-	if p.peekToken.Type == token.COLON {
-		p.nextToken() // :
-		ident.Type = p.parseType()
+// parsePatternTarget 解析模式中的绑定目标：标识符或嵌套模式
+func (p *Parser) parsePatternTarget() ast.Expression {
+	switch p.curToken.Type {
+	case token.IDENT:
+		return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	case token.LBRACE, token.LBRACKET:
+		return p.parsePattern()
 	}
+	p.errors = append(p.errors, fmt.Sprintf("line %d: invalid destructuring target %s", p.curToken.Line, p.curToken.Literal))
+	return nil
+}
 
-	identifiers = append(identifiers, ident)
+// parsePatternDefault 解析可选的默认值 (= expr)
+func (p *Parser) parsePatternDefault() ast.Expression {
+	if p.peekToken.Type != token.ASSIGN {
+		return nil
+	}
+	p.nextToken()
+	p.nextToken()
+	return p.parseExpression(LOWEST)
+}
 
-	for p.peekToken.Type == token.COMMA {
-		p.nextToken()
-		p.nextToken()
-		
-		ident := &ast.FieldDefinition{
-			Token: p.curToken,
-			Name:  &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal},
-			Type:  "int",
+// parseObjectPattern 解析 {a, b: renamed, c = 1, d: {e}}，curToken 为 '{'
+func (p *Parser) parseObjectPattern() ast.Expression {
+	pattern := &ast.ObjectPattern{Token: p.curToken}
+
+	for p.peekToken.Type != token.RBRACE {
+		if !p.expectPeek(token.IDENT) {
+			return nil
 		}
+		key := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		prop := &ast.PatternProperty{Key: key, Target: key}
 
 		if p.peekToken.Type == token.COLON {
 			p.nextToken()
-			ident.Type = p.parseType()
+			p.nextToken()
+			if prop.Target = p.parsePatternTarget(); prop.Target == nil {
+				return nil
+			}
+		}
+		prop.Default = p.parsePatternDefault()
+		pattern.Properties = append(pattern.Properties, prop)
+
+		if p.peekToken.Type != token.RBRACE && !p.expectPeek(token.COMMA) {
+			return nil
 		}
-		
-		identifiers = append(identifiers, ident)
 	}
 
-	if !p.expectPeek(token.RPAREN) {
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	return pattern
+}
+
+// parseArrayPattern 解析 [x, , y = 2, [z], ...rest]，curToken 为 '['
+func (p *Parser) parseArrayPattern() ast.Expression {
+	pattern := &ast.ArrayPattern{Token: p.curToken}
+
+	for p.peekToken.Type != token.RBRACKET {
+		if p.peekToken.Type == token.COMMA {
+			// Hole: [, b]
+			p.nextToken()
+			pattern.Elements = append(pattern.Elements, nil)
+			continue
+		}
+		p.nextToken()
+
+		if p.curToken.Type == token.ELLIPSIS {
+			p.nextToken()
+			if pattern.Rest = p.parsePatternTarget(); pattern.Rest == nil {
+				return nil
+			}
+			if p.peekToken.Type != token.RBRACKET {
+				p.errors = append(p.errors, fmt.Sprintf("line %d: rest element must be last in a destructuring pattern", p.curToken.Line))
+				return nil
+			}
+			break
+		}
+
+		target := p.parsePatternTarget()
+		if target == nil {
+			return nil
+		}
+		pattern.Elements = append(pattern.Elements, &ast.PatternElement{Target: target, Default: p.parsePatternDefault()})
+
+		if p.peekToken.Type != token.RBRACKET && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+	return pattern
+}
+
+// arrayLiteralToPattern 把赋值左侧的数组字面量 [a, b] 转换为数组模式，用于 [a, b] = [b, a]
+// 赋值中的目标还可以是成员或下标表达式
+func (p *Parser) arrayLiteralToPattern(lit *ast.ArrayLiteral) ast.Expression {
+	pattern := &ast.ArrayPattern{Token: lit.Token}
+	for i, el := range lit.Elements {
+		if spread, ok := el.(*ast.SpreadElement); ok && i == len(lit.Elements)-1 {
+			// [first, ...others] = arr
+			pattern.Rest = spread.Argument
+			if inner, ok := spread.Argument.(*ast.ArrayLiteral); ok {
+				pattern.Rest = p.arrayLiteralToPattern(inner)
+			}
+			break
+		}
+		element := &ast.PatternElement{Target: el}
+		if assign, ok := el.(*ast.AssignmentExpression); ok && assign.Token.Type == token.ASSIGN {
+			element.Target, element.Default = assign.Left, assign.Value
+		}
+		switch target := element.Target.(type) {
+		case *ast.Identifier, *ast.MemberExpression, *ast.IndexExpression, *ast.ArrayPattern:
+		case *ast.ArrayLiteral:
+			element.Target = p.arrayLiteralToPattern(target)
+		default:
+			p.errors = append(p.errors, fmt.Sprintf("line %d: invalid destructuring target %s", lit.Token.Line, el.String()))
+			return nil
+		}
+		pattern.Elements = append(pattern.Elements, element)
+	}
+	return pattern
+}
+
+// bindPatternParameters 把解构参数展开为函数体开头的 let 声明
+func bindPatternParameters(params []*ast.FieldDefinition, body *ast.BlockStatement) {
+	var lets []ast.Statement
+	for _, param := range params {
+		if param.Pattern != nil {
+			lets = append(lets, &ast.LetStatement{Token: param.Token, Pattern: param.Pattern, Value: param.Name})
+		}
+	}
+	if len(lets) > 0 && body != nil {
+		body.Statements = append(lets, body.Statements...)
+	}
+}
+
+// parseArrowFunction 解析 (a: int, b) => expr 或 (a) => { ... }，curToken 为 '('
+func (p *Parser) parseArrowFunction() ast.Expression {
+	lit := &ast.FunctionLiteral{Token: p.curToken, IsArrow: true}
+
+	// Untyped arrow parameters take their type from the context (e.g. a callback parameter)
+	lit.Parameters = p.parseParameterList("")
+
+	if p.peekToken.Type == token.COLON {
+		p.nextToken()
+		lit.ReturnType = p.parseType()
+	}
+
+	if !p.expectPeek(token.ARROW) {
+		return nil
+	}
+	lit.Body = p.parseArrowBody()
+	bindPatternParameters(lit.Parameters, lit.Body)
+	return lit
+}
+
+// parseArrowBody 解析 => 之后的函数体；表达式体被包装为 return 语句
+func (p *Parser) parseArrowBody() *ast.BlockStatement {
+	if p.peekToken.Type == token.LBRACE {
+		p.nextToken()
+		return p.parseBlockStatement()
+	}
+
+	p.nextToken()
+	ret := &ast.ReturnStatement{Token: p.curToken}
+	ret.ReturnValue = p.parseExpression(LOWEST)
+	return &ast.BlockStatement{Token: p.curToken, Statements: []ast.Statement{ret}}
+}
+
+func (p *Parser) parseFunctionParameters() []*ast.FieldDefinition {
+	return p.parseParameterList("int")
+}
+
+// memberModifiers 类成员前的修饰符
+type memberModifiers struct {
+	access   string // public / private / protected
+	readonly bool
+	static   bool
+	abstract bool
+}
+
+// parseModifiers 解析类成员和构造函数参数前的修饰符 public / private / protected / readonly / static / abstract
+// 它们是上下文关键字：只有后面还跟着成员名时才是修饰符，因此仍然可以用作普通的名字
+func (p *Parser) parseModifiers() memberModifiers {
+	var mods memberModifiers
+	for p.curToken.Type == token.IDENT {
+		switch p.peekToken.Type {
+		case token.IDENT, token.ASYNC, token.ASTERISK, token.LBRACKET:
+		default:
+			return mods
+		}
+		switch p.curToken.Literal {
+		case "public", "private", "protected":
+			if mods.access != "" {
+				p.errors = append(p.errors, fmt.Sprintf("line %d: duplicate access modifier %s", p.curToken.Line, p.curToken.Literal))
+			}
+			mods.access = p.curToken.Literal
+		case "readonly":
+			mods.readonly = true
+		case "static":
+			mods.static = true
+		case "abstract":
+			mods.abstract = true
+		default:
+			return mods
+		}
+		p.nextToken()
+	}
+	return mods
+}
+
+// parseParameterList 解析参数列表，未标注类型的参数使用 defaultType
+func (p *Parser) parseParameterList(defaultType string) []*ast.FieldDefinition {
+	identifiers := []*ast.FieldDefinition{}
+
+	if p.peekToken.Type == token.RPAREN {
+		p.nextToken()
+		return identifiers
+	}
+
+	p.nextToken()
+	ident := p.parseParameter(defaultType, identifiers)
+	if ident == nil {
+		return nil
+	}
+	identifiers = append(identifiers, ident)
+
+	for p.peekToken.Type == token.COMMA {
+		p.nextToken()
+		p.nextToken()
+
+		if identifiers[len(identifiers)-1].Rest {
+			p.errors = append(p.errors, fmt.Sprintf("line %d: rest parameter must be last", p.curToken.Line))
+			return nil
+		}
+		ident := p.parseParameter(defaultType, identifiers)
+		if ident == nil {
+			return nil
+		}
+		if prev := identifiers[len(identifiers)-1]; !ident.Rest && !ident.Optional && ident.Value == nil && (prev.Optional || prev.Value != nil) {
+			// Optional and default parameters can only be followed by other optional ones (or a rest parameter)
+			p.errors = append(p.errors, fmt.Sprintf("line %d: required parameter %s cannot follow an optional parameter", ident.Token.Line, ident.Name.Value))
+			return nil
+		}
+		identifiers = append(identifiers, ident)
+	}
+
+	if !p.expectPeek(token.RPAREN) {
 		return nil
 	}
 
 	return identifiers
 }
 
+// parseParameter 解析单个参数 (name 或 name: Type)；解构参数使用隐藏的参数名 $paramN
+// 剩余参数 ...name 的类型是数组，未标注时为 Array<defaultType>；previous 为之前已解析的参数
+func (p *Parser) parseParameter(defaultType string, previous []*ast.FieldDefinition) *ast.FieldDefinition {
+	// Parameter properties: init(private x: int) declares and assigns the field x
+	modifierTok := p.curToken
+	mods := p.parseModifiers()
+	access, readonly := mods.access, mods.readonly
+	if mods.static || mods.abstract {
+		keyword := "static"
+		if mods.abstract {
+			keyword = "abstract"
+		}
+		p.errors = append(p.errors, fmt.Sprintf("line %d: %s is not allowed on a parameter", modifierTok.Line, keyword))
+		return nil
+	}
+	if (access != "" || readonly) && !p.constructorParams {
+		p.errors = append(p.errors, fmt.Sprintf("line %d: parameter properties are only allowed in a constructor", modifierTok.Line))
+		return nil
+	}
+	rest := p.curToken.Type == token.ELLIPSIS
+	if rest {
+		p.nextToken()
+	}
+	ident := &ast.FieldDefinition{
+		Token:    p.curToken,
+		Name:     &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal},
+		Type:     defaultType,
+		Access:   access,
+		Readonly: readonly,
+	}
+	if (access != "" || readonly) && (rest || p.curToken.Type != token.IDENT) {
+		p.errors = append(p.errors, fmt.Sprintf("line %d: a parameter property must be a plain identifier", modifierTok.Line))
+		return nil
+	}
+
+	if p.curToken.Type == token.LBRACE || p.curToken.Type == token.LBRACKET {
+		if ident.Pattern = p.parsePattern(); ident.Pattern == nil {
+			return nil
+		}
+		ident.Name.Value = fmt.Sprintf("$param%d", len(previous))
+		if defaultType != "" {
+			// Untyped patterns take a map / array
+			if _, ok := ident.Pattern.(*ast.ArrayPattern); ok {
+				ident.Type = "array"
+			} else {
+				ident.Type = "map"
+			}
+		}
+	}
+
+	if rest {
+		ident.Rest = true
+		if defaultType != "" {
+			ident.Type = "Array<" + defaultType + ">"
+		}
+	} else if p.peekToken.Type == token.QUESTION {
+		p.nextToken() // name?: T
+		ident.Optional = true
+	}
+
+	// Optional Type Annotation
+	annotated := p.peekToken.Type == token.COLON
+	if annotated {
+		p.nextToken() // :
+		ident.Type = p.parseType()
+	}
+
+	// Default value: c = 10; an unannotated parameter takes the type of a literal default
+	// or of the earlier parameter it copies (height = width)
+	if p.peekToken.Type == token.ASSIGN {
+		if rest || ident.Optional {
+			p.errors = append(p.errors, fmt.Sprintf("line %d: rest and optional parameters cannot have a default value", p.curToken.Line))
+			return nil
+		}
+		p.nextToken()
+		p.nextToken()
+		ident.Value = p.parseExpression(LOWEST)
+		if t := defaultValueType(ident.Value, previous); !annotated && t != "" {
+			ident.Type = t
+		}
+	}
+	if ident.Optional && ident.Type != "" {
+		ident.Type = simplifyNullable(ident.Type + "|undefined")
+	}
+	return ident
+}
+
+// defaultValueType 返回默认值的类型名 (字面量或之前的参数)，用于推断未标注类型的参数
+func defaultValueType(exp ast.Expression, previous []*ast.FieldDefinition) string {
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		for _, param := range previous {
+			if param.Name.Value == exp.Value {
+				return param.Type
+			}
+		}
+	case *ast.IntegerLiteral:
+		return "int"
+	case *ast.FloatLiteral:
+		return "number"
+	case *ast.BigIntLiteral:
+		return "bigint"
+	case *ast.StringLiteral, *ast.TemplateLiteral:
+		return "string"
+	case *ast.Boolean:
+		return "bool"
+	case *ast.PrefixExpression:
+		if exp.Operator == "-" {
+			return defaultValueType(exp.Right, nil)
+		}
+	}
+	return ""
+}
+
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	exp := &ast.CallExpression{Token: p.curToken, Function: function}
 	exp.Arguments = p.parseCallArguments()
@@ -860,6 +1792,84 @@ func (p *Parser) parseCallArguments() []ast.Expression {
 	return args
 }
 
+// parseAsyncFunction 解析 async function / async (a) => ... / async x => ...，curToken 为 async
+func (p *Parser) parseAsyncFunction() ast.Expression {
+	tok := p.curToken
+	var exp ast.Expression
+	switch {
+	case p.peekToken.Type == token.FUNCTION:
+		p.nextToken()
+		exp = p.parseFunctionLiteral()
+	case p.peekToken.Type == token.LPAREN:
+		p.nextToken()
+		if !p.isArrowFunctionAhead() {
+			p.errors = append(p.errors, fmt.Sprintf("line %d: expected an arrow function after async", tok.Line))
+			return nil
+		}
+		exp = p.parseArrowFunction()
+	case p.peekToken.Type == token.IDENT:
+		p.nextToken()
+		exp = p.parseIdentifier()
+	}
+	fn, ok := exp.(*ast.FunctionLiteral)
+	if !ok {
+		p.errors = append(p.errors, fmt.Sprintf("line %d: expected a function after async", tok.Line))
+		return nil
+	}
+	fn.Async = true
+	if fn.Doc == "" {
+		fn.Doc = tok.Doc
+	}
+	return fn
+}
+
+// parseAwaitExpression 解析 await expr，curToken 为 await
+func (p *Parser) parseAwaitExpression() ast.Expression {
+	exp := &ast.AwaitExpression{Token: p.curToken}
+	p.nextToken()
+	exp.Argument = p.parseExpression(PREFIX)
+	return exp
+}
+
+// parseYieldExpression 解析 yield [expr] / yield* expr，curToken 为 yield
+func (p *Parser) parseYieldExpression() ast.Expression {
+	exp := &ast.YieldExpression{Token: p.curToken}
+	if p.peekToken.Type == token.ASTERISK {
+		p.nextToken()
+		exp.Delegate = true
+	}
+	switch p.peekToken.Type {
+	case token.SEMICOLON, token.RPAREN, token.RBRACE, token.RBRACKET, token.COMMA, token.COLON, token.EOF:
+		if exp.Delegate {
+			p.errors = append(p.errors, fmt.Sprintf("line %d: expected an iterable after yield*", exp.Token.Line))
+			return nil
+		}
+		return exp
+	}
+	p.nextToken()
+	exp.Argument = p.parseExpression(LOWEST)
+	return exp
+}
+
+// parseSymbolIterator 解析计算属性名 [Symbol.iterator]，curToken 为 '['；目前只支持这一个 Symbol
+func (p *Parser) parseSymbolIterator() bool {
+	line := p.curToken.Line
+	if p.expectPeek(token.IDENT) && p.curToken.Literal == "Symbol" && p.expectPeek(token.DOT) &&
+		p.expectPeek(token.IDENT) && p.curToken.Literal == "iterator" && p.expectPeek(token.RBRACKET) {
+		return true
+	}
+	p.errors = append(p.errors, fmt.Sprintf("line %d: only [Symbol.iterator] is supported as a computed method name", line))
+	return false
+}
+
+// parseSpreadElement 解析 ...expr，curToken 为 '...'
+func (p *Parser) parseSpreadElement() ast.Expression {
+	spread := &ast.SpreadElement{Token: p.curToken}
+	p.nextToken()
+	spread.Argument = p.parseExpression(LOWEST)
+	return spread
+}
+
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: p.curToken}
 	array.Elements = p.parseExpressionList(token.RBRACKET)
@@ -872,6 +1882,15 @@ func (p *Parser) parseMapLiteral() ast.Expression {
 
 	for p.peekToken.Type != token.RBRACE {
 		p.nextToken()
+
+		if p.curToken.Type == token.ELLIPSIS {
+			// { ...defaults, key: val }
+			mapLit.Keys = append(mapLit.Keys, p.parseSpreadElement())
+			if p.peekToken.Type != token.RBRACE && !p.expectPeek(token.COMMA) {
+				return nil
+			}
+			continue
+		}
 		
 		var key ast.Expression
 		if p.curToken.Type == token.IDENT {
@@ -889,6 +1908,7 @@ func (p *Parser) parseMapLiteral() ast.Expression {
 		value := p.parseExpression(LOWEST)
 
 		mapLit.Pairs[key] = value
+		mapLit.Keys = append(mapLit.Keys, key)
 
 		if p.peekToken.Type != token.RBRACE && !p.expectPeek(token.COMMA) {
 			return nil
@@ -978,11 +1998,15 @@ func (p *Parser) peekError(t token.TokenType) {
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
 	msg := fmt.Sprintf("no prefix parse function for %s found", t)
+	if t == token.ILLEGAL {
+		// The lexer describes the problem in the literal (bad number, unterminated string, ...)
+		msg = fmt.Sprintf("line %d: %s", p.curToken.Line, p.curToken.Literal)
+	}
 	p.errors = append(p.errors, msg)
 }
 
 func (p *Parser) parseClassStatement() *ast.ClassStatement {
-	stmt := &ast.ClassStatement{Token: p.curToken}
+	stmt := &ast.ClassStatement{Token: p.curToken, Doc: p.curToken.Doc}
 
 	if !p.expectPeek(token.IDENT) {
 		return nil
@@ -990,20 +2014,34 @@ func (p *Parser) parseClassStatement() *ast.ClassStatement {
 
 	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 
+	if p.peekToken.Type == token.LT {
+		if stmt.TypeParams = p.parseTypeParameters(); stmt.TypeParams == nil {
+			return nil
+		}
+	}
+
 	if p.peekToken.Type == token.EXTENDS {
 		p.nextToken() // consume extends
-		p.nextToken() // consume parent name
-		stmt.Parent = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		// Generic parents carry their type arguments: extends Box<int>
+		tok := p.peekToken
+		parent := p.parseType()
+		if parent == "" {
+			return nil
+		}
+		stmt.Parent = &ast.Identifier{Token: tok, Value: parent}
 	}
 
 	if p.peekToken.Type == token.IMPLEMENTS {
 		p.nextToken() // consume implements
 
 		for {
-			if !p.expectPeek(token.IDENT) {
+			// Built-in generic interfaces: implements Iterator<int>
+			tok := p.peekToken
+			name := p.parseType()
+			if name == "" {
 				return nil
 			}
-			stmt.Implements = append(stmt.Implements, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+			stmt.Implements = append(stmt.Implements, &ast.Identifier{Token: tok, Value: name})
 
 			if p.peekToken.Type == token.COMMA {
 				p.nextToken() // consume ,
@@ -1020,25 +2058,81 @@ func (p *Parser) parseClassStatement() *ast.ClassStatement {
 	p.nextToken() // consume {
 
 	for p.curToken.Type != token.RBRACE && p.curToken.Type != token.EOF {
+		doc := p.curToken.Doc
+		mods := p.parseModifiers()
+		access, readonly := mods.access, mods.readonly
+		// Accessors: get name() / set name(value)
+		accessor := ""
+		if p.curToken.Type == token.IDENT && (p.curToken.Literal == "get" || p.curToken.Literal == "set") && p.peekToken.Type == token.IDENT {
+			accessor = p.curToken.Literal
+			p.nextToken()
+		}
+		async := p.curToken.Type == token.ASYNC && p.peekToken.Type == token.IDENT
+		if async {
+			p.nextToken() // consume async
+		}
+		generator := p.curToken.Type == token.ASTERISK
+		if generator {
+			p.nextToken() // consume *
+		}
+		nameTok := p.curToken
+		if p.curToken.Type == token.LBRACKET {
+			if !p.parseSymbolIterator() {
+				return nil
+			}
+			nameTok.Literal = ast.SymbolIteratorMethod
+		}
+		if p.peekToken.Type == token.LT {
+			p.errors = append(p.errors, fmt.Sprintf("line %d: method %s cannot declare type parameters, declare them on the class", nameTok.Line, nameTok.Literal))
+			return nil
+		}
 		if p.peekToken.Type == token.LPAREN {
 			// Method
-			method := &ast.FunctionLiteral{Token: p.curToken, Name: p.curToken.Literal}
+			if readonly {
+				p.errors = append(p.errors, fmt.Sprintf("line %d: readonly is only allowed on fields, not method %s", nameTok.Line, nameTok.Literal))
+				return nil
+			}
+			if nameTok.Literal == "constructor" && accessor == "" {
+				nameTok.Literal = "init" // TypeScript spelling of the constructor
+			}
+			method := &ast.FunctionLiteral{Token: nameTok, Name: nameTok.Literal, Doc: doc, Async: async, Generator: generator, Access: access, Static: mods.static, Accessor: accessor, Abstract: mods.abstract}
 			
 			p.nextToken() // consume name, now at (
 			
+			p.constructorParams = method.Name == "init" && !method.Static
 			method.Parameters = p.parseFunctionParameters()
+			p.constructorParams = false
+			if method.Parameters == nil {
+				return nil
+			}
 			
 			// Parse optional return type
 			if p.peekToken.Type == token.COLON {
 				p.nextToken()
 				method.ReturnType = p.parseType()
 			}
+
+			if method.Abstract {
+				// abstract name(params): T; has no body
+				if p.peekToken.Type == token.LBRACE {
+					p.errors = append(p.errors, fmt.Sprintf("line %d: abstract method %s cannot have a body", nameTok.Line, nameTok.Literal))
+					return nil
+				}
+				method.Body = &ast.BlockStatement{Token: nameTok}
+				p.nextToken()
+				if p.curToken.Type == token.SEMICOLON {
+					p.nextToken()
+				}
+				stmt.Methods = append(stmt.Methods, method)
+				continue
+			}
 			
 			if !p.expectPeek(token.LBRACE) {
 				return nil
 			}
 			
 			method.Body = p.parseBlockStatement()
+			bindPatternParameters(method.Parameters, method.Body)
 			stmt.Methods = append(stmt.Methods, method)
 			
 			// consume the closing brace of the method body
@@ -1046,8 +2140,16 @@ func (p *Parser) parseClassStatement() *ast.ClassStatement {
 				p.nextToken()
 			}
 		} else {
+			if generator || accessor != "" || nameTok.Literal == ast.SymbolIteratorMethod {
+				p.errors = append(p.errors, fmt.Sprintf("line %d: expected ( after method name %s", nameTok.Line, nameTok.Literal))
+				return nil
+			}
+			if mods.abstract {
+				p.errors = append(p.errors, fmt.Sprintf("line %d: abstract is only allowed on methods, not field %s", nameTok.Line, nameTok.Literal))
+				return nil
+			}
 			// Field
-			field := &ast.FieldDefinition{Token: p.curToken}
+			field := &ast.FieldDefinition{Token: p.curToken, Access: access, Readonly: readonly, Static: mods.static}
 			field.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 			
 			p.nextToken() // consume name
@@ -1074,6 +2176,39 @@ func (p *Parser) parseClassStatement() *ast.ClassStatement {
 	return stmt
 }
 
+// parseTypeParameters 解析泛型声明的类型参数列表 <T, U extends Comparable>，peekToken 为 '<'
+func (p *Parser) parseTypeParameters() []*ast.TypeParameter {
+	p.nextToken() // <
+	var params []*ast.TypeParameter
+	for {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		param := &ast.TypeParameter{Name: p.curToken.Literal}
+		for _, prev := range params {
+			if prev.Name == param.Name {
+				p.errors = append(p.errors, fmt.Sprintf("line %d: duplicate type parameter %s", p.curToken.Line, param.Name))
+				return nil
+			}
+		}
+		if p.peekToken.Type == token.EXTENDS {
+			p.nextToken() // extends
+			if param.Constraint = p.parseType(); param.Constraint == "" {
+				return nil
+			}
+		}
+		params = append(params, param)
+		if p.peekToken.Type != token.COMMA {
+			break
+		}
+		p.nextToken() // ,
+	}
+	if !p.expectGenericClose() {
+		return nil
+	}
+	return params
+}
+
 func (p *Parser) parseNewExpression() ast.Expression {
 	exp := &ast.NewExpression{Token: p.curToken}
 
@@ -1083,6 +2218,14 @@ func (p *Parser) parseNewExpression() ast.Expression {
 
 	exp.Class = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 
+	// Type arguments: new Promise<int>(...)
+	if p.peekToken.Type == token.LT {
+		p.nextToken()
+		if exp.TypeArgs = p.parseTypeArgs(); exp.TypeArgs == nil {
+			return nil
+		}
+	}
+
 	if !p.expectPeek(token.LPAREN) {
 		return nil
 	}
@@ -1092,10 +2235,43 @@ func (p *Parser) parseNewExpression() ast.Expression {
 	return exp
 }
 
+// parseTypeArgs 解析类型实参列表，curToken 为 '<'；出错时返回 nil
+func (p *Parser) parseTypeArgs() []string {
+	var args []string
+	for {
+		typeArg := p.parseType()
+		if typeArg == "" {
+			return nil
+		}
+		args = append(args, typeArg)
+		if p.peekToken.Type != token.COMMA {
+			break
+		}
+		p.nextToken()
+	}
+	if !p.expectGenericClose() {
+		return nil
+	}
+	return args
+}
+
+// isTypeArgsCallAhead 在 peekToken 为 '<' 时判断是否为带类型实参的调用 pick<string>(...)：
+// 用解析器的副本试着解析类型实参，成功且后面紧跟 '(' 才是调用，否则 '<' 是比较运算符
+func (p *Parser) isTypeArgsCallAhead() bool {
+	scan := *p.l
+	probe := *p
+	probe.l = &scan
+	probe.errors = nil
+	probe.nextToken() // <
+	return probe.parseTypeArgs() != nil && len(probe.errors) == 0 && probe.peekToken.Type == token.LPAREN
+}
+
 func (p *Parser) parseMemberExpression(left ast.Expression) ast.Expression {
 	exp := &ast.MemberExpression{Token: p.curToken, Object: left}
 
-	if !p.expectPeek(token.IDENT) {
+	if p.peekToken.Type != token.IDENT && token.LookupIdent(p.peekToken.Literal) == p.peekToken.Type {
+		p.nextToken() // Keywords are valid property names: gen.return()
+	} else if !p.expectPeek(token.IDENT) {
 		return nil
 	}
 
@@ -1104,6 +2280,55 @@ func (p *Parser) parseMemberExpression(left ast.Expression) ast.Expression {
 	return exp
 }
 
+// parseOptionalChain 解析 obj?.prop / fn?.(args) / arr?.[i]，curToken 为 '?.'
+func (p *Parser) parseOptionalChain(left ast.Expression) ast.Expression {
+	switch p.peekToken.Type {
+	case token.LPAREN:
+		p.nextToken()
+		call := p.parseCallExpression(left).(*ast.CallExpression)
+		call.Optional = true
+		return call
+	case token.LBRACKET:
+		p.nextToken()
+		index, ok := p.parseIndexExpression(left).(*ast.IndexExpression)
+		if !ok {
+			return nil
+		}
+		index.Optional = true
+		return index
+	}
+	member, ok := p.parseMemberExpression(left).(*ast.MemberExpression)
+	if !ok {
+		return nil
+	}
+	member.Optional = true
+	return member
+}
+
+// parseConditionalExpression 解析 cond ? a : b (右结合)
+func (p *Parser) parseConditionalExpression(condition ast.Expression) ast.Expression {
+	exp := &ast.ConditionalExpression{Token: p.curToken, Condition: condition}
+
+	p.nextToken()
+	exp.Consequence = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.COLON) {
+		return nil
+	}
+
+	p.nextToken()
+	exp.Alternative = p.parseExpression(TERNARY - 1)
+	return exp
+}
+
+func (p *Parser) parseNullLiteral() ast.Expression {
+	return &ast.NullLiteral{Token: p.curToken}
+}
+
+func (p *Parser) parseUndefinedLiteral() ast.Expression {
+	return &ast.UndefinedLiteral{Token: p.curToken}
+}
+
 func (p *Parser) parseThisExpression() ast.Expression {
 	return &ast.ThisExpression{Token: p.curToken}
 }
@@ -1113,6 +2338,11 @@ func (p *Parser) parseSuperExpression() ast.Expression {
 }
 
 func (p *Parser) parseAssignmentExpression(left ast.Expression) ast.Expression {
+	if lit, ok := left.(*ast.ArrayLiteral); ok {
+		if left = p.arrayLiteralToPattern(lit); left == nil {
+			return nil
+		}
+	}
 	exp := &ast.AssignmentExpression{Token: p.curToken, Left: left}
 
 	p.nextToken()
@@ -1121,6 +2351,30 @@ func (p *Parser) parseAssignmentExpression(left ast.Expression) ast.Expression {
 	return exp
 }
 
+// parseCompoundAssignmentExpression 将 a op= b 展开为 a = a op b
+func (p *Parser) parseCompoundAssignmentExpression(left ast.Expression) ast.Expression {
+	opToken := p.curToken
+	operator := strings.TrimSuffix(opToken.Literal, "=")
+
+	exp := &ast.AssignmentExpression{Token: opToken, Left: left, Compound: true}
+
+	p.nextToken()
+	right := p.parseExpression(LOWEST)
+
+	exp.Value = &ast.InfixExpression{
+		Token:    token.Token{Type: token.TokenType(operator), Literal: operator, Line: opToken.Line, Column: opToken.Column},
+		Left:     left,
+		Operator: operator,
+		Right:    right,
+	}
+
+	return exp
+}
+
+func (p *Parser) parsePostfixExpression(left ast.Expression) ast.Expression {
+	return &ast.PostfixExpression{Token: p.curToken, Left: left, Operator: p.curToken.Literal}
+}
+
 func (p *Parser) parseTryStatement() *ast.TryStatement {
 	stmt := &ast.TryStatement{Token: p.curToken}
 
@@ -1167,6 +2421,96 @@ func (p *Parser) parseTryStatement() *ast.TryStatement {
 	return stmt
 }
 
+// parseJumpStatement 解析 break / continue，可带标签 (标签必须与关键字在同一行)
+func (p *Parser) parseJumpStatement() ast.Statement {
+	tok := p.curToken
+	label := ""
+	if p.peekToken.Type == token.IDENT && p.peekToken.Line == tok.Line {
+		p.nextToken()
+		label = p.curToken.Literal
+	}
+
+	if p.peekToken.Type == token.SEMICOLON {
+		p.nextToken()
+	}
+
+	if tok.Type == token.BREAK {
+		return &ast.BreakStatement{Token: tok, Label: label}
+	}
+	return &ast.ContinueStatement{Token: tok, Label: label}
+}
+
+// parseSwitchStatement 解析 switch (expr) { case a: ... default: ... }
+func (p *Parser) parseSwitchStatement() ast.Statement {
+	stmt := &ast.SwitchStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Discriminant = p.parseExpression(LOWEST)
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	p.nextToken()
+
+	hasDefault := false
+	for p.curToken.Type != token.RBRACE && p.curToken.Type != token.EOF {
+		sc := &ast.SwitchCase{Token: p.curToken}
+		switch p.curToken.Type {
+		case token.CASE:
+			p.nextToken()
+			sc.Test = p.parseExpression(LOWEST)
+		case token.DEFAULT:
+			if hasDefault {
+				p.errors = append(p.errors, "multiple default clauses in switch")
+			}
+			hasDefault = true
+		default:
+			p.errors = append(p.errors, fmt.Sprintf("expected case or default in switch, got %s", p.curToken.Literal))
+			return nil
+		}
+		if !p.expectPeek(token.COLON) {
+			return nil
+		}
+		p.nextToken()
+
+		// Statements up to the next clause
+		sc.Body = &ast.BlockStatement{Token: sc.Token, Statements: []ast.Statement{}}
+		for p.curToken.Type != token.CASE && p.curToken.Type != token.DEFAULT &&
+			p.curToken.Type != token.RBRACE && p.curToken.Type != token.EOF {
+			if s := p.parseStatement(); s != nil {
+				sc.Body.Statements = append(sc.Body.Statements, s)
+			}
+			p.nextToken()
+		}
+		stmt.Cases = append(stmt.Cases, sc)
+	}
+	return stmt
+}
+
+// parseLabeledStatement 解析 label: statement
+func (p *Parser) parseLabeledStatement() ast.Statement {
+	stmt := &ast.LabeledStatement{Token: p.curToken, Label: p.curToken.Literal}
+
+	p.nextToken() // ':'
+	p.nextToken()
+
+	if p.curToken.Type == token.LBRACE {
+		// Labeled block, not a map literal
+		stmt.Statement = p.parseBlockStatement()
+		return stmt
+	}
+	stmt.Statement = p.parseStatement()
+	if stmt.Statement == nil {
+		return nil
+	}
+	return stmt
+}
+
 func (p *Parser) parseThrowStatement() *ast.ThrowStatement {
 	stmt := &ast.ThrowStatement{Token: p.curToken}
 
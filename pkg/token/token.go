@@ -11,7 +11,10 @@ const (
 	// 标识符 + 字面量
 	IDENT  = "IDENT"  // add, foobar, x, y, ...
 	INT    = "INT"    // 1343456
-	STRING = "STRING" // "foobar"
+	FLOAT  = "FLOAT"  // 3.14, 1e-9
+	BIGINT = "BIGINT" // 123n
+	STRING   = "STRING"   // "foobar"
+	TEMPLATE = "TEMPLATE" // `a ${b} c` (raw content between the backticks)
 
 	// 运算符
 	ASSIGN   = "="
@@ -20,20 +23,59 @@ const (
 	BANG     = "!"
 	ASTERISK = "*"
 	SLASH    = "/"
+	PERCENT  = "%"
+
+	LT    = "<"
+	GT    = ">"
+	LT_EQ = "<="
+	GT_EQ = ">="
 
-	LT = "<"
-	GT = ">"
-	
 	PIPE   = "|"
 
-	EQ     = "=="
-	NOT_EQ = "!="
+	AMPERSAND            = "&"
+	CARET                = "^"
+	TILDE                = "~"
+	SHIFT_LEFT           = "<<"
+	SHIFT_RIGHT          = ">>"
+	SHIFT_RIGHT_UNSIGNED = ">>>"
+
+	EQ            = "=="
+	NOT_EQ        = "!="
+	STRICT_EQ     = "==="
+	STRICT_NOT_EQ = "!=="
+
+	AND = "&&"
+	OR  = "||"
+
+	INCREMENT = "++"
+	DECREMENT = "--"
+
+	PLUS_ASSIGN     = "+="
+	MINUS_ASSIGN    = "-="
+	ASTERISK_ASSIGN = "*="
+	SLASH_ASSIGN    = "/="
+	PERCENT_ASSIGN  = "%="
+
+	AMPERSAND_ASSIGN            = "&="
+	PIPE_ASSIGN                 = "|="
+	CARET_ASSIGN                = "^="
+	SHIFT_LEFT_ASSIGN           = "<<="
+	SHIFT_RIGHT_ASSIGN          = ">>="
+	SHIFT_RIGHT_UNSIGNED_ASSIGN = ">>>="
+
+	ARROW = "=>"
+
+	QUESTION       = "?"
+	OPTIONAL_CHAIN = "?."
+	NULLISH        = "??"
+	NULLISH_ASSIGN = "??="
 
 	// 分隔符
 	COMMA     = ","
 	SEMICOLON = ";"
 	COLON     = ":"
 	DOT       = "."
+	ELLIPSIS  = "..." // 解构中的剩余元素 [a, ...rest]
 
 	LPAREN   = "("
 	RPAREN   = ")"
@@ -52,11 +94,13 @@ const (
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
 	WHILE    = "WHILE"
+	DO       = "DO"
 	DECLARE  = "DECLARE"
 	CLASS    = "CLASS"
 	NEW      = "NEW"
 	THIS     = "THIS"
 	TYPEOF   = "TYPEOF"
+	INSTANCEOF = "INSTANCEOF"
 	EXTENDS  = "EXTENDS"
 	SUPER    = "SUPER"
 	INTERFACE  = "INTERFACE"
@@ -72,6 +116,16 @@ const (
 	CATCH      = "CATCH"
 	FINALLY    = "FINALLY"
 	THROW      = "THROW"
+	BREAK      = "BREAK"
+	CONTINUE   = "CONTINUE"
+	SWITCH     = "SWITCH"
+	CASE       = "CASE"
+	DEFAULT    = "DEFAULT"
+	NULL       = "NULL"
+	UNDEFINED  = "UNDEFINED"
+	ASYNC      = "ASYNC"
+	AWAIT      = "AWAIT"
+	YIELD      = "YIELD"
 )
 
 type Token struct {
@@ -79,6 +133,7 @@ type Token struct {
 	Literal string
 	Line    int
 	Column  int
+	Doc     string // /** ... */ comment immediately before this token
 }
 
 func (t Token) String() string {
@@ -95,11 +150,13 @@ var keywords = map[string]TokenType{
 	"else":     ELSE,
 	"return":   RETURN,
 	"while":    WHILE,
+	"do":       DO,
 	"declare":  DECLARE,
 	"class":    CLASS,
 	"new":      NEW,
 	"this":     THIS,
 	"typeof":   TYPEOF,
+	"instanceof": INSTANCEOF,
 	"extends":  EXTENDS,
 	"super":    SUPER,
 	"interface": INTERFACE,
@@ -115,6 +172,16 @@ var keywords = map[string]TokenType{
 	"import":     IMPORT,
 	"export":     EXPORT,
 	"from":       FROM,
+	"break":      BREAK,
+	"continue":   CONTINUE,
+	"switch":     SWITCH,
+	"case":       CASE,
+	"default":    DEFAULT,
+	"null":       NULL,
+	"undefined":  UNDEFINED,
+	"async":      ASYNC,
+	"await":      AWAIT,
+	"yield":      YIELD,
 }
 
 func LookupIdent(ident string) TokenType {
@@ -1,6 +1,7 @@
 class OmniRuntime {
     constructor() {
         this.memory = null;
+        this.exports = null;
         this.heap = new Map();
         this.nextHandle = 1; // 0 is null
         this.textDecoder = new TextDecoder('utf-8');
@@ -13,6 +14,29 @@ class OmniRuntime {
         this.memory = memory;
     }
 
+    // Runs main, then the async tasks it queued; later tasks run from host callbacks
+    start(instance) {
+        this.exports = instance.exports;
+        if (!this.exports.main) {
+            console.error("No main function exported!");
+            return;
+        }
+        this.exports.main();
+        this.drainTasks();
+    }
+
+    // Runs the queued async tasks; a rejected promise nobody awaited is reported like an uncaught error
+    drainTasks() {
+        this.exports.run_event_loop();
+        const message = this.exports.unhandled_rejection ? this.exports.unhandled_rejection() : 0;
+        if (message) {
+            console.error(this.readString(message));
+            if (typeof process !== 'undefined' && process.exit) {
+                process.exit(1);
+            }
+        }
+    }
+
     readString(ptr) {
         if (!this.memory) return "";
         const buffer = new Uint8Array(this.memory.buffer);
@@ -125,6 +149,24 @@ class OmniRuntime {
                 host_to_int: (handle) => {
                     const val = this.getObject(handle);
                     return typeof val === 'number' ? val : 0;
+                },
+
+                // Async
+                
+                // $host_set_timeout(closure, ms): run the closure later, then drain the task queue
+                host_set_timeout: (closure, ms) => {
+                    setTimeout(() => {
+                        this.exports.run_callback(closure);
+                        this.drainTasks();
+                    }, ms);
+                },
+                
+                // $host_await(handle, promise): settle a Promise from a host thenable
+                host_await: (handle, promise) => {
+                    Promise.resolve(this.getObject(handle)).then(
+                        (val) => this.exports.promise_resolve(promise, this.storeObject(val)),
+                        (err) => this.exports.promise_reject(promise, this.storeObject(err))
+                    ).finally(() => this.drainTasks());
                 }
             }
         };
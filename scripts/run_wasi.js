@@ -3,6 +3,7 @@ const path = require('path');
 const http = require('http');
 const net = require('net');
 const dgram = require('dgram');
+const timers = require('timers/promises');
 const WabtModule = require('wabt');
 const { WASI } = require('wasi');
 const { Worker, isMainThread, parentPort, workerData } = require('worker_threads');
@@ -18,6 +19,7 @@ class HandleManager {
         this.register(http, "http");
         this.register(net, "net");
         this.register(dgram, "dgram");
+        this.register(timers, "timers");
     }
 
     register(obj, id = null) {
@@ -46,18 +48,32 @@ const handleMgr = new HandleManager();
 const globalModules = {
     "http": http,
     "net": net,
-    "dgram": dgram
+    "dgram": dgram,
+    "timers": timers
 };
 
+// Convert a JS value to the i32 passed to WASM: objects and strings become handles
+function toWasmValue(val) {
+    if (typeof val === 'object' && val !== null) {
+        return handleMgr.register(val);
+    }
+    if (typeof val === 'string') {
+        return handleMgr.register(new String(val));
+    }
+    if (typeof val === 'boolean') {
+        return val ? 1 : 0;
+    }
+    return val;
+}
+
 function readString(memory, ptr) {
     const memView = new Uint8Array(memory.buffer);
-    let str = "";
-    let i = ptr;
-    while (memView[i] !== 0) {
-        str += String.fromCharCode(memView[i]);
-        i++;
+    let end = ptr;
+    while (memView[end] !== 0) {
+        end++;
     }
-    return str;
+    // Strings are UTF-8; copy first since the memory may be shared
+    return Buffer.from(memView.slice(ptr, end)).toString("utf8");
 }
 
 function writeString(memory, ptr, str) {
@@ -102,21 +118,32 @@ if (isMainThread) {
         // Function map to be filled after instantiation
         let instanceExports = null;
 
+        // Pending host callbacks; exit is delayed until they have all run
+        let pendingHostTasks = 0;
+        let exitWhenIdle = null;
+        const hostTaskDone = () => {
+            pendingHostTasks--;
+            if (pendingHostTasks === 0 && exitWhenIdle) {
+                exitWhenIdle();
+            }
+        };
+
+        // After the task queue drains: a rejected promise nobody awaited fails the run
+        const checkUnhandledRejection = () => {
+            const message = instanceExports.unhandled_rejection ? instanceExports.unhandled_rejection() : 0;
+            if (message) {
+                console.error(readString(sharedMemory, message));
+                process.exit(1);
+            }
+        };
+
         const importObject = {
             wasi_snapshot_preview1: wasi.wasiImport,
             env: {
                 memory: sharedMemory,
                 thread_spawn: (funcNamePtr, argsArrPtr) => {
                     // Read function name from shared memory
-                    const memBuffer = sharedMemory.buffer;
-                    const memView = new Uint8Array(memBuffer);
-                    
-                    let name = "";
-                    let ptr = funcNamePtr;
-                    while (memView[ptr] !== 0) {
-                        name += String.fromCharCode(memView[ptr]);
-                        ptr++;
-                    }
+                    const name = readString(sharedMemory, funcNamePtr);
                     
                     // console.log(`[Host] Spawning thread for function: ${name}`);
 
@@ -242,17 +269,7 @@ if (isMainThread) {
                         // console.log("Calling host function:", func.name || "anonymous", "Args:", args);
                         const result = func.apply(thisArg, args);
                         // console.log("Result:", result);
-                        if (typeof result === 'object' && result !== null) {
-                            return handleMgr.register(result);
-                        }
-                        if (typeof result === 'string') {
-                             // String return needs to be handled.
-                             // For now, return handle to string object?
-                             // Or we need host_to_string?
-                             // Compiler expects i32.
-                             return handleMgr.register(new String(result)); 
-                        }
-                        return result;
+                        return toWasmValue(result);
                     } catch (e) {
                         console.error("Host call error:", e);
                         return 0;
@@ -270,6 +287,26 @@ if (isMainThread) {
                     if (val instanceof String) return parseInt(val.toString());
                     return Number(val);
                 },
+                // Timers and host promises keep the process alive until they settle
+                host_set_timeout: (closure, ms) => {
+                    pendingHostTasks++;
+                    setTimeout(() => {
+                        instanceExports.run_callback(closure);
+                        checkUnhandledRejection();
+                        hostTaskDone();
+                    }, ms);
+                },
+                host_await: (handle, promise) => {
+                    pendingHostTasks++;
+                    Promise.resolve(handleMgr.get(handle)).then(
+                        (val) => instanceExports.promise_resolve(promise, toWasmValue(val)),
+                        (err) => instanceExports.promise_reject(promise, toWasmValue(err))
+                    ).finally(() => {
+                        instanceExports.run_event_loop();
+                        checkUnhandledRejection();
+                        hostTaskDone();
+                    });
+                },
             }
         };
 
@@ -291,16 +328,28 @@ if (isMainThread) {
          } else {
              // console.log("No entry point found.");
          }
+         // Run the async tasks queued by main
+         if (instance.exports.run_event_loop) {
+             instance.exports.run_event_loop();
+             checkUnhandledRejection();
+         }
          
          // Wait a bit for workers to finish tasks, then exit
          // In a real app, we might wait for explicit shutdown.
          // For tests, we assume main spawns and we wait a bit.
          setTimeout(() => {
-             console.log("Terminating workers... count:", activeWorkers.length);
-             for (const w of activeWorkers) {
-                 w.terminate();
+             const terminate = () => {
+                 console.log("Terminating workers... count:", activeWorkers.length);
+                 for (const w of activeWorkers) {
+                     w.terminate();
+                 }
+                 process.exit(0);
+             };
+             if (pendingHostTasks > 0) {
+                 exitWhenIdle = terminate;
+                 return;
              }
-             process.exit(0);
+             terminate();
          }, 2000); // Wait 2 seconds
     }
 
@@ -367,6 +416,8 @@ if (isMainThread) {
                 host_call: () => 0,
                 host_from_int: () => 0,
                 host_from_string: () => 0,
+                host_set_timeout: () => {},
+                host_await: () => {},
             }
         };
         